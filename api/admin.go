@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/google/uuid"
 	"github.com/tigrisdata/gotrue/models"
 	"github.com/tigrisdata/tigris-client-go/filter"
 	"github.com/tigrisdata/tigris-client-go/tigris"
@@ -103,6 +105,16 @@ func (a *API) adminUserUpdate(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	if params.Password != "" {
+		if err := a.checkPassword(ctx, user.Email, params.Password); err != nil {
+			return err
+		}
+		if err := a.checkPasswordReuse(ctx, user, params.Password); err != nil {
+			return err
+		}
+	}
+
+	oldEmail := user.Email
 	err = a.db.Tx(ctx, func(ctx context.Context) error {
 		if params.Role != "" {
 			if terr := user.SetRole(ctx, a.db, params.Role); terr != nil {
@@ -117,7 +129,10 @@ func (a *API) adminUserUpdate(w http.ResponseWriter, r *http.Request) error {
 		}
 
 		if params.Password != "" {
-			if terr := user.UpdatePassword(ctx, a.db, a.encrypter, params.Password); terr != nil {
+			if terr := a.updatePassword(ctx, user, params.Password); terr != nil {
+				return terr
+			}
+			if terr := a.revokeUserTokens(ctx, user); terr != nil {
 				return terr
 			}
 		}
@@ -154,6 +169,13 @@ func (a *API) adminUserUpdate(w http.ResponseWriter, r *http.Request) error {
 		return internalServerError("Error updating user").WithInternalError(err)
 	}
 
+	if params.Password != "" {
+		a.notifyPasswordChanged(ctx, user)
+	}
+	if user.Email != oldEmail {
+		a.notifyEmailChanged(ctx, user, oldEmail)
+	}
+
 	return sendJSON(w, http.StatusOK, user)
 }
 
@@ -170,6 +192,11 @@ func (a *API) adminUserCreate(w http.ResponseWriter, r *http.Request) error {
 	if err := a.validateEmail(ctx, params.Email); err != nil {
 		return err
 	}
+	if params.Password != "" {
+		if err := a.checkPassword(ctx, params.Email, params.Password); err != nil {
+			return err
+		}
+	}
 
 	aud := a.requestAud(ctx, r)
 	if params.Aud != "" {
@@ -214,6 +241,12 @@ func (a *API) adminUserCreate(w http.ResponseWriter, r *http.Request) error {
 			return terr
 		}
 
+		if params.Password != "" {
+			if terr := a.recordPassword(ctx, user, params.Password); terr != nil {
+				return terr
+			}
+		}
+
 		if params.Confirm {
 			if terr := user.Confirm(ctx, a.db); terr != nil {
 				return terr
@@ -249,6 +282,22 @@ func (a *API) adminUserDelete(w http.ResponseWriter, r *http.Request) error {
 		if terr != nil {
 			return internalServerError("Database error deleting user").WithInternalError(terr)
 		}
+
+		if terr := models.DeleteIdentitiesByUser(ctx, a.db, user); terr != nil {
+			return internalServerError("Database error deleting user identities").WithInternalError(terr)
+		}
+
+		if terr := models.DeletePasswordHistory(ctx, a.db, user); terr != nil {
+			return internalServerError("Database error deleting password history").WithInternalError(terr)
+		}
+
+		if terr := models.DeleteKnownDevices(ctx, a.db, user); terr != nil {
+			return internalServerError("Database error deleting known devices").WithInternalError(terr)
+		}
+
+		if terr := a.revokeUserTokens(ctx, user); terr != nil {
+			return internalServerError("Error revoking user sessions").WithInternalError(terr)
+		}
 		return nil
 	})
 	if err != nil {
@@ -257,3 +306,158 @@ func (a *API) adminUserDelete(w http.ResponseWriter, r *http.Request) error {
 
 	return sendJSON(w, http.StatusOK, map[string]interface{}{})
 }
+
+// adminUserImpersonate issues a short lived access token for a user to an admin,
+// naming the admin in the act claim. No refresh token is issued.
+func (a *API) adminUserImpersonate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	config := a.getConfig(ctx)
+	user := getUser(ctx)
+	instanceID := getInstanceID(ctx)
+	adminUser := getAdminUser(ctx)
+
+	// operator requests are not made on behalf of a user who could be named as actor
+	if adminUser.ID == uuid.Nil {
+		return forbiddenError("Impersonation requires an admin user token")
+	}
+	if !a.canImpersonate(ctx, adminUser) {
+		return forbiddenError("User not allowed to impersonate")
+	}
+	if user.IsSuperAdmin || a.isAdmin(ctx, user, user.Aud) {
+		return forbiddenError("Admins cannot be impersonated")
+	}
+
+	expiresIn := time.Second * time.Duration(config.Impersonation.Exp)
+	claims := newAccessTokenClaims(user, uuid.Nil, expiresIn, config)
+	claims.Act = &ActorClaim{Subject: "gt|" + adminUser.ID.String()}
+
+	var tokenString string
+	err := a.db.Tx(ctx, func(ctx context.Context) error {
+		if terr := models.NewAuditLogEntry(ctx, a.db, instanceID, adminUser, models.UserImpersonatedAction, map[string]interface{}{
+			"user_id":    user.ID,
+			"user_email": user.Email,
+			"jti":        claims.Id,
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+
+		var terr error
+		tokenString, terr = signAccessToken(claims, config, a.tokenSigner)
+		if terr != nil {
+			return internalServerError("error generating jwt token").WithInternalError(terr)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, &AccessTokenResponse{
+		Token:     tokenString,
+		TokenType: "bearer",
+		ExpiresIn: config.Impersonation.Exp,
+	})
+}
+
+func (a *API) canImpersonate(ctx context.Context, adminUser *models.User) bool {
+	config := a.getConfig(ctx)
+	if adminUser.IsSuperAdmin {
+		return true
+	}
+	if config.Impersonation.Role == "" || adminUser.AppMetaData == nil {
+		return false
+	}
+	for _, role := range adminUser.AppMetaData.Roles {
+		if role == config.Impersonation.Role {
+			return true
+		}
+	}
+	return false
+}
+
+type adminUserBanParams struct {
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+// adminUserBan bans a user, for the given duration or indefinitely, and signs
+// them out of every session.
+func (a *API) adminUserBan(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user := getUser(ctx)
+	instanceID := getInstanceID(ctx)
+	adminUser := getAdminUser(ctx)
+
+	if adminUser.ID == user.ID {
+		return badRequestError("Users cannot ban themselves")
+	}
+	if (user.IsSuperAdmin || a.isAdmin(ctx, user, user.Aud)) && !adminUser.IsSuperAdmin {
+		return forbiddenError("Only super admins can ban admins")
+	}
+
+	params := &adminUserBanParams{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read ban params: %v", err)
+	}
+
+	until := models.BannedForever
+	if params.Duration != "" {
+		d, err := time.ParseDuration(params.Duration)
+		if err != nil || d <= 0 {
+			return badRequestError("Invalid ban duration: %q", params.Duration)
+		}
+		until = time.Now().Add(d)
+	}
+
+	err := a.db.Tx(ctx, func(ctx context.Context) error {
+		if terr := user.Ban(ctx, a.db, until, params.Reason); terr != nil {
+			return internalServerError("Database error banning user").WithInternalError(terr)
+		}
+
+		if terr := a.revokeUserTokens(ctx, user); terr != nil {
+			return internalServerError("Error revoking user sessions").WithInternalError(terr)
+		}
+
+		if terr := models.NewAuditLogEntry(ctx, a.db, instanceID, adminUser, models.UserBannedAction, map[string]interface{}{
+			"user_id":      user.ID,
+			"user_email":   user.Email,
+			"banned_until": user.BannedUntil,
+			"reason":       params.Reason,
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, user)
+}
+
+// adminUserUnban lifts the ban of a user.
+func (a *API) adminUserUnban(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user := getUser(ctx)
+	instanceID := getInstanceID(ctx)
+	adminUser := getAdminUser(ctx)
+
+	err := a.db.Tx(ctx, func(ctx context.Context) error {
+		if terr := user.Unban(ctx, a.db); terr != nil {
+			return internalServerError("Database error unbanning user").WithInternalError(terr)
+		}
+
+		if terr := models.NewAuditLogEntry(ctx, a.db, instanceID, adminUser, models.UserUnbannedAction, map[string]interface{}{
+			"user_id":    user.ID,
+			"user_email": user.Email,
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, user)
+}
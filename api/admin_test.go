@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -586,3 +588,192 @@ func (ts *AdminTestSuite) TestAdminUserCreateWithDisabledEmailLogin() {
 	ts.API.handler.ServeHTTP(w, req)
 	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
 }
+
+// TestAdminUserImpersonate tests API /admin/users/{email}/impersonate route (POST)
+func (ts *AdminTestSuite) TestAdminUserImpersonate() {
+	u, err := models.NewUser(ts.instanceID, "test-impersonate@example.com", "test", ts.Config.JWT.Aud, nil, ts.Encrypter)
+	require.NoError(ts.T(), err, "Error making new user")
+
+	_, err = tigris.GetCollection[models.User](ts.API.db).Insert(context.TODO(), u)
+	require.NoError(ts.T(), err, "Error creating user")
+
+	// Setup request
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/admin/users/%s/impersonate", u.Email), nil)
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	token := AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&token))
+	assert.Empty(ts.T(), token.RefreshToken)
+	assert.Equal(ts.T(), ts.Config.Impersonation.Exp, token.ExpiresIn)
+
+	claims := &GoTrueClaims{}
+	_, _, err = new(jwt.Parser).ParseUnverified(token.Token, claims)
+	require.NoError(ts.T(), err)
+	assert.Equal(ts.T(), "gt|"+u.ID.String(), claims.Subject)
+
+	admin, err := models.FindUserByInstanceIDAndEmail(context.TODO(), ts.API.db, ts.instanceID, "test@example.com")
+	require.NoError(ts.T(), err)
+	require.NotNil(ts.T(), claims.Act)
+	assert.Equal(ts.T(), "gt|"+admin.ID.String(), claims.Act.Subject)
+}
+
+// TestAdminUserImpersonateAdmin tests that admins cannot be impersonated
+func (ts *AdminTestSuite) TestAdminUserImpersonateAdmin() {
+	ts.makeSuperAdmin("test-admin@example.com")
+
+	// Setup request
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/admin/users/test-admin@example.com/impersonate", nil)
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+}
+
+// TestAdminUserBan tests that banned users cannot sign in until they are unbanned
+func (ts *AdminTestSuite) TestAdminUserBan() {
+	u, err := models.NewUser(ts.instanceID, "test-ban@example.com", "test", ts.Config.JWT.Aud, nil, ts.Encrypter)
+	require.NoError(ts.T(), err, "Error making new user")
+	_, err = tigris.GetCollection[models.User](ts.API.db).Insert(context.TODO(), u)
+	require.NoError(ts.T(), err, "Error creating user")
+	require.NoError(ts.T(), u.Confirm(context.TODO(), ts.API.db))
+
+	token := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+	signIn := url.Values{"grant_type": {"password"}, "username": {u.Email}, "password": {"test"}}
+	ban := func(method string, body map[string]interface{}) *httptest.ResponseRecorder {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+		req := httptest.NewRequest(method, fmt.Sprintf("/admin/users/%s/ban", u.Email), &buffer)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := token(signIn)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	session := AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&session))
+
+	w = ban(http.MethodPost, map[string]interface{}{"reason": "spam"})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	banned := models.User{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&banned))
+	require.NotNil(ts.T(), banned.BannedUntil)
+	assert.True(ts.T(), banned.BannedUntil.Equal(models.BannedForever))
+	assert.Equal(ts.T(), "spam", banned.BanReason)
+
+	w = token(signIn)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	oauthErr := OAuthError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&oauthErr))
+	assert.Equal(ts.T(), "User is banned", oauthErr.Description)
+
+	// existing sessions are signed out
+	w = token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {session.RefreshToken}})
+	assert.Equal(ts.T(), http.StatusBadRequest, w.Code)
+
+	w = ban(http.MethodDelete, nil)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	w = token(signIn)
+	assert.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	// bans with a duration expire on their own
+	w = ban(http.MethodPost, map[string]interface{}{"duration": "1s"})
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	w = token(signIn)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	time.Sleep(time.Second)
+	w = token(signIn)
+	assert.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	w = ban(http.MethodPost, map[string]interface{}{"duration": "forever"})
+	assert.Equal(ts.T(), http.StatusBadRequest, w.Code)
+}
+
+// TestAdminUserBanSelf tests that admins cannot ban themselves
+func (ts *AdminTestSuite) TestAdminUserBanSelf() {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/admin/users/test@example.com/ban", strings.NewReader(`{"duration":"forever"}`))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+
+	// refused before the params are looked at
+	httpErr := HTTPError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&httpErr))
+	assert.Equal(ts.T(), "Users cannot ban themselves", httpErr.Message)
+}
+
+// TestAdminUserBanAdmin tests that only super admins can ban admins
+func (ts *AdminTestSuite) TestAdminUserBanAdmin() {
+	ts.makeSuperAdmin("test-super@example.com")
+	admin, err := models.NewUser(ts.instanceID, "test-admin@example.com", "test", ts.Config.JWT.Aud, nil, ts.Encrypter)
+	require.NoError(ts.T(), err, "Error making new user")
+	admin.AppMetaData = &models.UserAppMetadata{Roles: []string{ts.Config.JWT.AdminGroupName}}
+	_, err = tigris.GetCollection[models.User](ts.API.db).Insert(context.TODO(), admin)
+	require.NoError(ts.T(), err, "Error creating user")
+	adminToken, err := generateAccessToken(admin, time.Second*time.Duration(ts.Config.JWT.Exp), ts.Config, NewTokenSigner(ts.Config))
+	require.NoError(ts.T(), err, "Error generating access token")
+
+	ban := func(email string, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/admin/users/%s/ban", email), strings.NewReader(`{}`))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(ts.T(), http.StatusForbidden, ban("test-super@example.com", adminToken).Code)
+	assert.Equal(ts.T(), http.StatusOK, ban("test-admin@example.com", ts.token).Code)
+}
+
+// TestAdminUserLockout tests that admins can see and lift the lockout of a user
+func (ts *AdminTestSuite) TestAdminUserLockout() {
+	_, locked, err := models.RecordLoginFailure(context.TODO(), ts.API.db, ts.instanceID, models.LoginFailureEmailKind, "test@example.com", 1, time.Minute)
+	require.NoError(ts.T(), err)
+	require.True(ts.T(), locked)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/admin/users/test@example.com/lockout", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	failure := models.LoginFailure{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&failure))
+	assert.Equal(ts.T(), 1, failure.Count)
+	assert.True(ts.T(), failure.IsLocked())
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/admin/lockouts", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	data := struct {
+		Lockouts []*models.LoginFailure `json:"lockouts"`
+	}{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	require.Len(ts.T(), data.Lockouts, 1)
+	assert.Equal(ts.T(), "test@example.com", data.Lockouts[0].Value)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodDelete, "/admin/users/test@example.com/lockout", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	_, err = models.FindLoginFailure(context.TODO(), ts.API.db, ts.instanceID, models.LoginFailureEmailKind, "test@example.com")
+	assert.True(ts.T(), models.IsNotFoundError(err))
+}
@@ -14,8 +14,6 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/didip/tollbooth/v5"
-	"github.com/didip/tollbooth/v5/limiter"
 	"github.com/go-chi/chi"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
@@ -26,7 +24,7 @@ import (
 	"github.com/tigrisdata/gotrue/crypto"
 	"github.com/tigrisdata/gotrue/mailer"
 	"github.com/tigrisdata/gotrue/models"
-	"github.com/rs/cors"
+	"github.com/tigrisdata/gotrue/ratelimit"
 	"github.com/rs/zerolog/log"
 	"github.com/sebest/xff"
 	"github.com/tigrisdata/tigris-client-go/tigris"
@@ -48,6 +46,9 @@ type API struct {
 	tokenSigner *TokenSigner
 	version     string
 	tokenCache  *lru.Cache
+	limiter     ratelimit.Store
+	// mailer replaces the mailer of instances, e.g. to record emails in tests
+	mailer mailer.Mailer
 }
 
 // TokenSigner is responsible to sign token, it supports HS256, RS256 algo
@@ -164,7 +165,12 @@ func NewAPIWithVersion(ctx context.Context, globalConfig *conf.GlobalConfigurati
 		log.Fatal().Msgf("Couldn't construct token cache %v", err)
 		return nil
 	}
-	api := &API{config: globalConfig, db: db, version: version, tokenSigner: NewTokenSigner(config), encrypter: &crypto.AESBlockEncrypter{Key: globalConfig.DB.EncryptionKey}, tokenCache: cache}
+	limiter, err := ratelimit.NewStore(globalConfig.RateLimitStore, globalConfig.RateLimitRedisURL)
+	if err != nil {
+		log.Fatal().Msgf("Couldn't construct rate limit store %v", err)
+		return nil
+	}
+	api := &API{config: globalConfig, db: db, version: version, tokenSigner: NewTokenSigner(config), encrypter: &crypto.AESBlockEncrypter{Key: globalConfig.DB.EncryptionKey}, tokenCache: cache, limiter: limiter}
 	jwks, err := NewJKWS(globalConfig, config, version)
 	if err != nil {
 		log.Fatal().Msgf("Couldn't construct JWKS %v", err)
@@ -199,6 +205,8 @@ func NewAPIWithVersion(ctx context.Context, globalConfig *conf.GlobalConfigurati
 			r.Use(api.loadInstanceConfig)
 		}
 		r.Get("/", api.ExternalProviderCallback)
+		// Apple posts the callback (response_mode=form_post)
+		r.Post("/", api.ExternalProviderCallback)
 	})
 
 	r.Route("/", func(r *router) {
@@ -218,25 +226,34 @@ func NewAPIWithVersion(ctx context.Context, globalConfig *conf.GlobalConfigurati
 			r.Get("/", api.ListInvitations)
 			r.Delete("/", api.DeleteInvitation)
 			r.Post("/", api.CreateInvitation)
-			r.Post("/verify", api.VerifyInvitation)
+			r.With(api.limitHandler("invitation_verify")).Post("/verify", api.VerifyInvitation)
 		})
 
-		r.With(api.requireEmailProvider).Post("/signup", api.Signup)
-		r.With(api.requireEmailProvider).Post("/recover", api.Recover)
-		r.With(api.requireEmailProvider).With(api.limitHandler(
-			// Allow requests at a rate of 30 per 5 minutes.
-			tollbooth.NewLimiter(30.0/(60*5), &limiter.ExpirableOptions{
-				DefaultExpirationTTL: time.Hour,
-			}).SetBurst(30),
-		)).Post("/token", api.Token)
-		r.Post("/verify", api.Verify)
+		r.With(api.requireEmailProvider).With(api.limitHandler("signup")).With(api.requireCaptcha).Post("/signup", api.Signup)
+		r.With(api.requireEmailProvider).With(api.limitHandler("recover")).With(api.requireCaptcha).Post("/recover", api.Recover)
+		r.With(api.requireEmailProvider).With(api.limitHandler("token")).With(api.requirePasswordGrantCaptcha).Post("/token", api.Token)
+		r.With(api.limitHandler("verify")).Post("/verify", api.Verify)
+		r.With(api.limitHandler("verify")).Post("/devices/reject", api.RejectDevice)
+
+		r.Route("/device", func(r *router) {
+			r.Post("/code", api.DeviceCode)
+			r.With(api.requireAuthentication).Get("/", api.DeviceGet)
+			r.With(api.requireAuthentication).With(api.requireUserSession).Post("/verify", api.DeviceVerify)
+		})
 
 		r.With(api.requireAuthentication).Post("/logout", api.Logout)
 
 		r.Route("/user", func(r *router) {
 			r.Use(api.requireAuthentication)
 			r.Get("/", api.UserGet)
-			r.Put("/", api.UserUpdate)
+			r.With(api.requireUserSession).Put("/", api.UserUpdate)
+			r.With(api.requireUserSession).Delete("/sessions/{session_id}", api.UserRevokeSession)
+			r.Route("/identities", func(r *router) {
+				r.Get("/", api.UserIdentities)
+				r.With(api.requireUserSession).Post("/", api.UserIdentityLink)
+				r.With(api.requireUserSession).Delete("/{provider}", api.UserIdentityUnlink)
+				r.With(api.requireUserSession).Get("/{provider}/token", api.UserIdentityToken)
+			})
 		})
 
 		r.Route("/.well-known", func(r *router) {
@@ -261,8 +278,38 @@ func NewAPIWithVersion(ctx context.Context, globalConfig *conf.GlobalConfigurati
 					r.Get("/", api.adminUserGet)
 					r.Put("/", api.adminUserUpdate)
 					r.Delete("/", api.adminUserDelete)
+					r.Post("/impersonate", api.adminUserImpersonate)
+					r.Get("/identities", api.adminUserIdentities)
+					r.Post("/ban", api.adminUserBan)
+					r.Delete("/ban", api.adminUserUnban)
+					r.Get("/lockout", api.adminUserLockout)
+					r.Delete("/lockout", api.adminUserUnlock)
 				})
 			})
+
+			r.Route("/lockouts", func(r *router) {
+				r.Get("/", api.adminLockouts)
+				r.Delete("/{lockout_id}", api.adminLockoutDelete)
+			})
+
+			r.Route("/sso/providers", func(r *router) {
+				r.Get("/", api.adminSSOProviders)
+				r.Post("/", api.adminSSOProviderCreate)
+
+				r.Route("/{provider_id}", func(r *router) {
+					r.Use(api.loadSSOProvider)
+
+					r.Get("/", api.adminSSOProviderGet)
+					r.Put("/", api.adminSSOProviderUpdate)
+					r.Delete("/", api.adminSSOProviderDelete)
+				})
+			})
+
+			r.Route("/scim/tokens", func(r *router) {
+				r.Get("/", api.adminSCIMTokens)
+				r.Post("/", api.adminSCIMTokenCreate)
+				r.Delete("/{token_id}", api.adminSCIMTokenDelete)
+			})
 		})
 
 		r.Route("/saml", func(r *router) {
@@ -271,8 +318,37 @@ func NewAPIWithVersion(ctx context.Context, globalConfig *conf.GlobalConfigurati
 				r.Post("/", api.ExternalProviderCallback)
 			})
 
+			r.Post("/slo", api.SAMLSingleLogout)
+			r.With(api.requireAuthentication).Post("/logout", api.SAMLLogout)
 			r.Get("/metadata", api.SAMLMetadata)
 		})
+
+		r.Route("/scim/v2", func(r *router) {
+			r.Use(api.requireSCIMToken)
+
+			r.Get("/ServiceProviderConfig", api.scim(api.SCIMServiceProviderConfig))
+			r.Get("/ResourceTypes", api.scim(api.SCIMResourceTypes))
+			r.Get("/Schemas", api.scim(api.SCIMSchemas))
+			r.Get("/Schemas/{schema_id}", api.scim(api.SCIMSchema))
+
+			r.Route("/Users", func(r *router) {
+				r.Get("/", api.scim(api.SCIMUsers))
+				r.Post("/", api.scim(api.SCIMUserCreate))
+				r.Get("/{user_id}", api.scim(api.SCIMUserGet))
+				r.Put("/{user_id}", api.scim(api.SCIMUserReplace))
+				r.Patch("/{user_id}", api.scim(api.SCIMUserPatch))
+				r.Delete("/{user_id}", api.scim(api.SCIMUserDelete))
+			})
+
+			r.Route("/Groups", func(r *router) {
+				r.Get("/", api.scim(api.SCIMGroups))
+				r.Post("/", api.scim(api.SCIMGroupCreate))
+				r.Get("/{group_id}", api.scim(api.SCIMGroupGet))
+				r.Put("/{group_id}", api.scim(api.SCIMGroupReplace))
+				r.Patch("/{group_id}", api.scim(api.SCIMGroupPatch))
+				r.Delete("/{group_id}", api.scim(api.SCIMGroupDelete))
+			})
+		})
 	})
 
 	if globalConfig.MultiInstanceMode {
@@ -293,13 +369,7 @@ func NewAPIWithVersion(ctx context.Context, globalConfig *conf.GlobalConfigurati
 		})
 	}
 
-	corsHandler := cors.New(cors.Options{
-		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", audHeaderName, useCookieHeader},
-		AllowCredentials: true,
-	})
-
-	api.handler = corsHandler.Handler(chi.ServerBaseContext(ctx, r))
+	api.handler = api.corsHandler(ctx, chi.ServerBaseContext(ctx, r))
 	return api
 }
 
@@ -346,6 +416,9 @@ func WithInstanceConfig(ctx context.Context, config *conf.Configuration, instanc
 
 // Mailer ...
 func (a *API) Mailer(ctx context.Context) mailer.Mailer {
+	if a.mailer != nil {
+		return a.mailer
+	}
 	config := a.getConfig(ctx)
 	return mailer.NewMailer(config)
 }
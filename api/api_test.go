@@ -3,11 +3,13 @@ package api
 import (
 	"context"
 	"math/rand"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/tigrisdata/gotrue/conf"
+	"github.com/tigrisdata/gotrue/mailer"
 	"github.com/tigrisdata/gotrue/models"
 	"github.com/tigrisdata/gotrue/storage/test"
 	"github.com/stretchr/testify/require"
@@ -74,7 +76,7 @@ func setupAPIForTestWithCallback(cb func(*conf.GlobalConfiguration, *conf.Config
 		return nil, nil, nil, err
 	}
 
-	database, err := tigrisClient.OpenDatabase(context.TODO(), &models.AuditLogEntry{}, &models.User{}, &models.RefreshToken{}, &models.Instance{}, &models.Invitation{})
+	database, err := tigrisClient.OpenDatabase(context.TODO(), &models.AuditLogEntry{}, &models.User{}, &models.RefreshToken{}, &models.Instance{}, &models.Invitation{}, &models.RevokedToken{}, &models.DeviceCode{}, &models.OAuthState{}, &models.Identity{}, &models.ProviderToken{}, &models.SSOProvider{}, &models.SAMLAssertion{}, &models.SCIMToken{}, &models.SCIMGroup{}, &models.LoginFailure{}, &models.PasswordHistoryEntry{}, &models.KnownDevice{})
 	if err != nil {
 		tigrisClient.Close()
 		return nil, nil, nil, err
@@ -114,3 +116,77 @@ func randStringBytes(n int) string {
 	}
 	return string(b)
 }
+
+// impersonationToken signs an access token for the user that names another
+// user as its actor, like admin impersonation tokens.
+func impersonationToken(t *testing.T, api *API, config *conf.Configuration, user *models.User) string {
+	claims := newAccessTokenClaims(user, uuid.Nil, time.Minute, config)
+	claims.Act = &ActorClaim{Subject: "gt|" + uuid.New().String()}
+	token, err := signAccessToken(claims, config, api.tokenSigner)
+	require.NoError(t, err)
+	return token
+}
+
+// recordedMail is an email a recordingMailer was asked to send
+type recordedMail struct {
+	Kind string
+	To   string
+}
+
+// recordingMailer records the notifications sent through it instead of
+// sending them. Other emails are not sent.
+type recordingMailer struct {
+	mailer.Mailer
+
+	mu           sync.Mutex
+	mails        []recordedMail
+	rejectTokens []string
+}
+
+// recordMails makes the API send emails through a recordingMailer until the
+// test ends.
+func recordMails(t *testing.T, api *API) *recordingMailer {
+	m := &recordingMailer{Mailer: mailer.NewMailer(&conf.Configuration{})}
+	api.mailer = m
+	t.Cleanup(func() { api.mailer = nil })
+	return m
+}
+
+func (m *recordingMailer) record(kind, to string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mails = append(m.mails, recordedMail{Kind: kind, To: to})
+	return nil
+}
+
+// Mails returns the emails sent so far.
+func (m *recordingMailer) Mails() []recordedMail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]recordedMail{}, m.mails...)
+}
+
+// RejectToken returns the token of the last new device email.
+func (m *recordingMailer) RejectToken() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.rejectTokens) == 0 {
+		return ""
+	}
+	return m.rejectTokens[len(m.rejectTokens)-1]
+}
+
+func (m *recordingMailer) NewDeviceMail(user *models.User, device *models.KnownDevice, rejectToken, referrerURL string) error {
+	m.mu.Lock()
+	m.rejectTokens = append(m.rejectTokens, rejectToken)
+	m.mu.Unlock()
+	return m.record("new_device", user.Email)
+}
+
+func (m *recordingMailer) PasswordChangedMail(user *models.User) error {
+	return m.record("password_changed", user.Email)
+}
+
+func (m *recordingMailer) EmailChangedMail(user *models.User, oldEmail string) error {
+	return m.record("email_changed", oldEmail)
+}
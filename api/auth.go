@@ -2,22 +2,53 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"net/http"
 
 	jwt "github.com/golang-jwt/jwt/v4"
 	"github.com/pkg/errors"
+	"github.com/tigrisdata/gotrue/conf"
 )
 
-// requireAuthentication checks incoming requests for tokens presented using the Authorization header
+// requireAuthentication checks incoming requests for tokens presented using the Authorization header,
+// and that the user they were issued to is not banned
 func (a *API) requireAuthentication(w http.ResponseWriter, r *http.Request) (context.Context, error) {
-	token, err := a.extractBearerToken(w, r)
+	token, fromCookie, err := a.extractSessionToken(w, r)
 	if err != nil {
 		a.clearCookieToken(r.Context(), w)
 		return nil, err
 	}
+	if fromCookie {
+		if err := checkCSRFToken(a.getConfig(r.Context()), r); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, err := a.parseJWTClaims(token, r, w)
+	if err != nil {
+		return nil, err
+	}
 
-	return a.parseJWTClaims(token, r, w)
+	// users who no longer exist are handled by the endpoints
+	if user, err := getUserFromClaims(ctx, a.db); err == nil {
+		if reason := signInDenied(user); reason != "" {
+			a.clearCookieToken(ctx, w)
+			return nil, forbiddenError(reason)
+		}
+	}
+	return ctx, nil
+}
+
+// requireUserSession refuses tokens acting on behalf of the user, like
+// impersonation and delegated tokens, on endpoints that start sessions or
+// change how the user signs in, which only the user can do.
+func (a *API) requireUserSession(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	ctx := r.Context()
+	if claims := getClaims(ctx); claims != nil && claims.Act != nil {
+		return nil, forbiddenError("Tokens acting on behalf of a user are not allowed")
+	}
+	return ctx, nil
 }
 
 type adminCheckParams struct {
@@ -74,8 +105,52 @@ func (a *API) extractBearerToken(w http.ResponseWriter, r *http.Request) (string
 	return matches[1], nil
 }
 
+// extractSessionToken returns the access token of the Authorization header,
+// or else of the session cookie, reporting whether it came from the cookie.
+func (a *API) extractSessionToken(w http.ResponseWriter, r *http.Request) (string, bool, error) {
+	if r.Header.Get("Authorization") == "" {
+		config := a.getConfig(r.Context())
+		if c, err := r.Cookie(config.Cookie.Key); err == nil && c.Value != "" {
+			return c.Value, true, nil
+		}
+	}
+	token, err := a.extractBearerToken(w, r)
+	return token, false, err
+}
+
+// checkCSRFToken requires state changing requests authenticated by cookie to
+// echo the CSRF cookie in the X-CSRF-Token header, which other sites cannot
+// read to forge the request.
+func checkCSRFToken(config *conf.Configuration, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+
+	c, err := r.Cookie(config.Cookie.CSRFKey)
+	header := r.Header.Get(csrfHeaderName)
+	if err != nil || c.Value == "" || header == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(header)) != 1 {
+		return forbiddenError("Missing or invalid CSRF token")
+	}
+	return nil
+}
+
 func (a *API) parseJWTClaims(bearer string, r *http.Request, w http.ResponseWriter) (context.Context, error) {
 	ctx := r.Context()
+
+	token, err := a.verifyAccessToken(ctx, bearer)
+	if err != nil {
+		if err.Code == http.StatusUnauthorized {
+			a.clearCookieToken(ctx, w)
+		}
+		return nil, err
+	}
+
+	return withToken(ctx, token), nil
+}
+
+// verifyAccessToken checks the signature, expiry and revocation of an access token
+func (a *API) verifyAccessToken(ctx context.Context, bearer string) (*jwt.Token, *HTTPError) {
 	config := a.getConfig(ctx)
 
 	p := jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Name, jwt.SigningMethodRS256.Name}}
@@ -88,9 +163,16 @@ func (a *API) parseJWTClaims(bearer string, r *http.Request, w http.ResponseWrit
 		return nil, errors.New("Unsupported token signature algorithm")
 	})
 	if err != nil {
-		a.clearCookieToken(ctx, w)
 		return nil, unauthorizedError("Invalid token: %v", err)
 	}
 
-	return withToken(ctx, token), nil
+	revoked, err := a.isAccessTokenRevoked(ctx, token.Claims.(*GoTrueClaims))
+	if err != nil {
+		return nil, internalServerError("Database error checking token revocation").WithInternalError(err)
+	}
+	if revoked {
+		return nil, unauthorizedError("Invalid token: token has been revoked")
+	}
+
+	return token, nil
 }
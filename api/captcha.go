@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/tigrisdata/gotrue/captcha"
+)
+
+const (
+	// captchaTokenParam is the field of the request body holding the captcha
+	// response token
+	captchaTokenParam = "captcha_token"
+	// captchaTokenHeader holds the captcha response token of requests that do
+	// not send it in the body
+	captchaTokenHeader = "X-Captcha-Token"
+)
+
+// requireCaptcha refuses requests without a solved captcha if the instance
+// requires one.
+func (a *API) requireCaptcha(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	ctx := r.Context()
+	config := a.getConfig(ctx)
+	if !config.Captcha.Enabled {
+		return ctx, nil
+	}
+
+	verifier, err := captcha.NewVerifier(config.Captcha.Provider, config.Captcha.Secret, config.Captcha.SiteKey, config.Captcha.VerifyURL)
+	if err != nil {
+		return nil, internalServerError("Error verifying captcha").WithInternalError(err)
+	}
+
+	token := r.Header.Get(captchaTokenHeader)
+	if token == "" {
+		token = requestBodyValue(r, captchaTokenParam)
+	}
+	if token == "" {
+		return nil, badRequestError("Captcha token required")
+	}
+
+	if err := verifier.Verify(ctx, token, a.clientIP(r)); err != nil {
+		if errors.Is(err, captcha.ErrVerificationFailed) {
+			return nil, badRequestError("Captcha verification failed").WithInternalError(err)
+		}
+		return nil, internalServerError("Error verifying captcha").WithInternalError(err)
+	}
+	return ctx, nil
+}
+
+// requirePasswordGrantCaptcha requires a solved captcha for password grants
+// only, so that refreshing tokens keeps working without one.
+func (a *API) requirePasswordGrantCaptcha(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	if r.FormValue("grant_type") != "password" {
+		return r.Context(), nil
+	}
+	return a.requireCaptcha(w, r)
+}
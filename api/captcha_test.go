@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/tigrisdata/gotrue/conf"
+	"github.com/tigrisdata/gotrue/crypto"
+	"github.com/tigrisdata/gotrue/models"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+type CaptchaTestSuite struct {
+	suite.Suite
+	API        *API
+	Config     *conf.Configuration
+	Encrypter  *crypto.AESBlockEncrypter
+	instanceID uuid.UUID
+
+	siteverify *httptest.Server
+}
+
+func TestCaptcha(t *testing.T) {
+	api, config, globalConf, instanceID, err := setupAPIForTestForInstance()
+	require.NoError(t, err)
+
+	ts := &CaptchaTestSuite{
+		API:        api,
+		Config:     config,
+		Encrypter:  &crypto.AESBlockEncrypter{Key: globalConf.DB.EncryptionKey},
+		instanceID: instanceID,
+	}
+
+	suite.Run(t, ts)
+}
+
+func (ts *CaptchaTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	u, err := models.NewUser(ts.instanceID, "test@example.com", "password", ts.Config.JWT.Aud, nil, ts.Encrypter)
+	require.NoError(ts.T(), err, "Error creating test user model")
+	_, err = tigris.GetCollection[models.User](ts.API.db).Insert(context.TODO(), u)
+	require.NoError(ts.T(), err, "Error saving new test user")
+	require.NoError(ts.T(), u.Confirm(context.TODO(), ts.API.db))
+
+	// a stand-in for the siteverify endpoint accepting the token "solved"
+	ts.siteverify = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(ts.T(), r.ParseForm())
+		success := r.PostForm.Get("secret") == "secret" && r.PostForm.Get("response") == "solved"
+		require.NoError(ts.T(), json.NewEncoder(w).Encode(map[string]interface{}{"success": success}))
+	}))
+
+	ts.Config.Captcha = conf.CaptchaConfiguration{
+		Enabled:   true,
+		Provider:  "turnstile",
+		SiteKey:   "site-key",
+		Secret:    "secret",
+		VerifyURL: ts.siteverify.URL,
+	}
+}
+
+func (ts *CaptchaTestSuite) TearDownTest() {
+	ts.siteverify.Close()
+	ts.Config.Captcha = conf.CaptchaConfiguration{Provider: "hcaptcha"}
+}
+
+func (ts *CaptchaTestSuite) recoverRequest(body map[string]interface{}, header string) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(body))
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/recover", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	if header != "" {
+		req.Header.Set(captchaTokenHeader, header)
+	}
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *CaptchaTestSuite) TestRecover() {
+	w := ts.recoverRequest(map[string]interface{}{"email": "test@example.com"}, "")
+	assert.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	assert.Contains(ts.T(), w.Body.String(), "Captcha token required")
+
+	w = ts.recoverRequest(map[string]interface{}{"email": "test@example.com", "captcha_token": "guessed"}, "")
+	assert.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	assert.Contains(ts.T(), w.Body.String(), "Captcha verification failed")
+
+	w = ts.recoverRequest(map[string]interface{}{"email": "test@example.com", "captcha_token": "solved"}, "")
+	assert.Equal(ts.T(), http.StatusOK, w.Code)
+
+	w = ts.recoverRequest(map[string]interface{}{"email": "test@example.com"}, "solved")
+	assert.Equal(ts.T(), http.StatusOK, w.Code)
+}
+
+func (ts *CaptchaTestSuite) TestSignup() {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"email":    "new@example.com",
+		"password": "test",
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/signup", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	assert.Equal(ts.T(), http.StatusBadRequest, w.Code)
+
+	_, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "new@example.com", ts.Config.JWT.Aud)
+	assert.True(ts.T(), models.IsNotFoundError(err))
+}
+
+func (ts *CaptchaTestSuite) TestToken() {
+	token := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	form := url.Values{
+		"grant_type": {"password"},
+		"username":   {"test@example.com"},
+		"password":   {"password"},
+	}
+	w := token(form)
+	assert.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	assert.Contains(ts.T(), w.Body.String(), "Captcha token required")
+
+	form.Set("captcha_token", "solved")
+	w = token(form)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	// refreshing needs no captcha
+	var grant AccessTokenResponse
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&grant))
+	w = token(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {grant.RefreshToken},
+	})
+	assert.Equal(ts.T(), http.StatusOK, w.Code)
+}
+
+func (ts *CaptchaTestSuite) TestSettings() {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/settings", nil)
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	resp := Settings{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(ts.T(), CaptchaSettings{Enabled: true, Provider: "turnstile", SiteKey: "site-key"}, resp.Captcha)
+}
@@ -29,6 +29,11 @@ const (
 	externalReferrerKey     = contextKey("external_referrer")
 	functionHooksKey        = contextKey("function_hooks")
 	adminUserKey            = contextKey("admin_user")
+	externalNonceKey        = contextKey("external_nonce")
+	externalCodeVerifierKey = contextKey("external_code_verifier")
+	linkUserIDKey           = contextKey("link_user_id")
+	ssoProviderKey          = contextKey("sso_provider")
+	scimTokenKey            = contextKey("scim_token")
 )
 
 // withToken adds the JWT token to the context.
@@ -195,6 +200,76 @@ func getExternalReferrer(ctx context.Context) string {
 	return obj.(string)
 }
 
+// withExternalNonce adds the nonce expected in ID tokens of the external provider to the context.
+func withExternalNonce(ctx context.Context, nonce string) context.Context {
+	return context.WithValue(ctx, externalNonceKey, nonce)
+}
+
+func getExternalNonce(ctx context.Context) string {
+	obj := ctx.Value(externalNonceKey)
+	if obj == nil {
+		return ""
+	}
+
+	return obj.(string)
+}
+
+// withExternalCodeVerifier adds the PKCE verifier of the external provider redirect to the context.
+func withExternalCodeVerifier(ctx context.Context, verifier string) context.Context {
+	return context.WithValue(ctx, externalCodeVerifierKey, verifier)
+}
+
+func getExternalCodeVerifier(ctx context.Context) string {
+	obj := ctx.Value(externalCodeVerifierKey)
+	if obj == nil {
+		return ""
+	}
+
+	return obj.(string)
+}
+
+// withLinkUserID adds the user an external provider account is linked to to the context.
+func withLinkUserID(ctx context.Context, id uuid.UUID) context.Context {
+	return context.WithValue(ctx, linkUserIDKey, id)
+}
+
+func getLinkUserID(ctx context.Context) uuid.UUID {
+	obj := ctx.Value(linkUserIDKey)
+	if obj == nil {
+		return uuid.Nil
+	}
+
+	return obj.(uuid.UUID)
+}
+
+// withSSOProvider adds the SAML identity provider users sign in with to the context.
+func withSSOProvider(ctx context.Context, p *models.SSOProvider) context.Context {
+	return context.WithValue(ctx, ssoProviderKey, p)
+}
+
+// getSSOProvider reads the SAML identity provider from the context, if any.
+func getSSOProvider(ctx context.Context) *models.SSOProvider {
+	obj := ctx.Value(ssoProviderKey)
+	if obj == nil {
+		return nil
+	}
+	return obj.(*models.SSOProvider)
+}
+
+// withSCIMToken adds the SCIM token the request is authenticated with to the context.
+func withSCIMToken(ctx context.Context, t *models.SCIMToken) context.Context {
+	return context.WithValue(ctx, scimTokenKey, t)
+}
+
+// getSCIMToken reads the SCIM token from the context, if any.
+func getSCIMToken(ctx context.Context) *models.SCIMToken {
+	obj := ctx.Value(scimTokenKey)
+	if obj == nil {
+		return nil
+	}
+	return obj.(*models.SCIMToken)
+}
+
 // withFunctionHooks adds the provided function hooks to the context.
 func withFunctionHooks(ctx context.Context, hooks map[string][]string) context.Context {
 	return context.WithValue(ctx, functionHooksKey, hooks)
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/tigrisdata/gotrue/conf"
+	"github.com/tigrisdata/gotrue/crypto"
+	"github.com/tigrisdata/gotrue/models"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+type CookieTestSuite struct {
+	suite.Suite
+	API        *API
+	Config     *conf.Configuration
+	Encrypter  *crypto.AESBlockEncrypter
+	instanceID uuid.UUID
+}
+
+func TestCookie(t *testing.T) {
+	api, config, globalConf, instanceID, err := setupAPIForTestForInstance()
+	require.NoError(t, err)
+
+	ts := &CookieTestSuite{
+		API:        api,
+		Config:     config,
+		Encrypter:  &crypto.AESBlockEncrypter{Key: globalConf.DB.EncryptionKey},
+		instanceID: instanceID,
+	}
+
+	suite.Run(t, ts)
+}
+
+func (ts *CookieTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	u, err := models.NewUser(ts.instanceID, "test@example.com", "password", ts.Config.JWT.Aud, nil, ts.Encrypter)
+	require.NoError(ts.T(), err, "Error creating test user model")
+	_, err = tigris.GetCollection[models.User](ts.API.db).Insert(context.TODO(), u)
+	require.NoError(ts.T(), err, "Error saving new test user")
+	require.NoError(ts.T(), u.Confirm(context.TODO(), ts.API.db))
+
+	ts.Config.Cookie.SameSite = "strict"
+	ts.Config.Cookie.Domain = "example.com"
+	ts.Config.Cookie.RefreshTokenKey = "nf_refresh"
+}
+
+func (ts *CookieTestSuite) TearDownTest() {
+	ts.Config.Cookie.SameSite = "lax"
+	ts.Config.Cookie.Domain = ""
+	ts.Config.Cookie.RefreshTokenKey = ""
+}
+
+// signIn signs in with a password, keeping the session in cookies.
+func (ts *CookieTestSuite) signIn() map[string]*http.Cookie {
+	form := url.Values{
+		"grant_type": {"password"},
+		"username":   {"test@example.com"},
+		"password":   {"password"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(useCookieHeader, "1")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	cookies := map[string]*http.Cookie{}
+	for _, c := range w.Result().Cookies() {
+		cookies[c.Name] = c
+	}
+	return cookies
+}
+
+func (ts *CookieTestSuite) TestSetCookies() {
+	cookies := ts.signIn()
+
+	for _, key := range []string{ts.Config.Cookie.Key, ts.Config.Cookie.CSRFKey, ts.Config.Cookie.RefreshTokenKey} {
+		c, ok := cookies[key]
+		require.True(ts.T(), ok, key)
+		assert.NotEmpty(ts.T(), c.Value)
+		assert.Equal(ts.T(), http.SameSiteStrictMode, c.SameSite)
+		assert.Equal(ts.T(), "example.com", c.Domain)
+		assert.True(ts.T(), c.Secure)
+	}
+	assert.True(ts.T(), cookies[ts.Config.Cookie.Key].HttpOnly)
+	assert.True(ts.T(), cookies[ts.Config.Cookie.RefreshTokenKey].HttpOnly)
+	assert.False(ts.T(), cookies[ts.Config.Cookie.CSRFKey].HttpOnly)
+}
+
+func (ts *CookieTestSuite) TestCSRF() {
+	cookies := ts.signIn()
+
+	updateUser := func(csrf string) *httptest.ResponseRecorder {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"name": "Test"},
+		}))
+		req := httptest.NewRequest(http.MethodPut, "http://localhost/user", &buffer)
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(cookies[ts.Config.Cookie.Key])
+		req.AddCookie(cookies[ts.Config.Cookie.CSRFKey])
+		if csrf != "" {
+			req.Header.Set(csrfHeaderName, csrf)
+		}
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(ts.T(), http.StatusForbidden, updateUser("").Code)
+	assert.Equal(ts.T(), http.StatusForbidden, updateUser("guessed").Code)
+	assert.Equal(ts.T(), http.StatusOK, updateUser(cookies[ts.Config.Cookie.CSRFKey].Value).Code)
+
+	// reading needs no CSRF token
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/user", nil)
+	req.AddCookie(cookies[ts.Config.Cookie.Key])
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	assert.Equal(ts.T(), http.StatusOK, w.Code)
+}
+
+func (ts *CookieTestSuite) TestRefreshTokenCookie() {
+	cookies := ts.signIn()
+
+	refresh := func(csrf string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/token", strings.NewReader("grant_type=refresh_token"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookies[ts.Config.Cookie.RefreshTokenKey])
+		req.AddCookie(cookies[ts.Config.Cookie.CSRFKey])
+		if csrf != "" {
+			req.Header.Set(csrfHeaderName, csrf)
+		}
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(ts.T(), http.StatusForbidden, refresh("").Code)
+
+	w := refresh(cookies[ts.Config.Cookie.CSRFKey].Value)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	// the rotated refresh token replaces the cookie
+	var rotated *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == ts.Config.Cookie.RefreshTokenKey {
+			rotated = c
+		}
+	}
+	require.NotNil(ts.T(), rotated)
+	assert.NotEqual(ts.T(), cookies[ts.Config.Cookie.RefreshTokenKey].Value, rotated.Value)
+}
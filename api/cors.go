@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/rs/cors"
+	"github.com/tigrisdata/gotrue/conf"
+)
+
+// csrfHeaderName is the header clients echo the CSRF cookie in
+const csrfHeaderName = "X-CSRF-Token"
+
+var corsAllowedMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+var corsAllowedHeaders = []string{"Accept", "Authorization", "Content-Type", audHeaderName, useCookieHeader, csrfHeaderName, captchaTokenHeader}
+
+// corsHandler answers cross-origin requests with the allowed origins of the
+// instance. Instances that do not list any share responses with any origin,
+// but without credentials.
+func (a *API) corsHandler(ctx context.Context, next http.Handler) http.Handler {
+	anyOrigin := cors.New(cors.Options{
+		AllowedMethods: corsAllowedMethods,
+		AllowedHeaders: corsAllowedHeaders,
+	}).Handler(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		config := a.corsConfig(ctx, r)
+		if config == nil || len(config.CORS.AllowedOrigins) == 0 {
+			anyOrigin.ServeHTTP(w, r)
+			return
+		}
+		cors.New(cors.Options{
+			AllowedOrigins:   config.CORS.AllowedOrigins,
+			AllowedMethods:   corsAllowedMethods,
+			AllowedHeaders:   corsAllowedHeaders,
+			AllowCredentials: true,
+		}).Handler(next).ServeHTTP(w, r)
+	})
+}
+
+// corsConfig returns the configuration of the instance a request is made to,
+// before the router loads it. It is nil if the instance is not known.
+func (a *API) corsConfig(ctx context.Context, r *http.Request) *conf.Configuration {
+	if !a.config.MultiInstanceMode {
+		return getConfig(ctx)
+	}
+
+	signature := r.Header.Get(jwsSignatureHeaderName)
+	if signature == "" {
+		return nil
+	}
+	_, _, config, err := a.signedInstanceConfig(r.Context(), signature)
+	if err != nil {
+		return nil
+	}
+	return config
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tigrisdata/gotrue/conf"
+)
+
+func TestCORSHandler(t *testing.T) {
+	config := &conf.Configuration{}
+	ctx, err := WithInstanceConfig(context.Background(), config, uuid.Nil)
+	require.NoError(t, err)
+
+	a := &API{config: &conf.GlobalConfiguration{}}
+	h := a.corsHandler(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(method, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://localhost/user", nil)
+		req.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+			req.Header.Set("Access-Control-Request-Headers", csrfHeaderName)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// any origin, without credentials
+	w := request(http.MethodGet, "https://evil.example.net")
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+
+	config.CORS.AllowedOrigins = []string{"https://app.example.com", "https://*.preview.example.com"}
+
+	w = request(http.MethodGet, "https://app.example.com")
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+
+	w = request(http.MethodOptions, "https://pr-1.preview.example.com")
+	assert.Equal(t, "https://pr-1.preview.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, http.MethodPut, w.Header().Get("Access-Control-Allow-Methods"))
+
+	w = request(http.MethodGet, "https://evil.example.net")
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestCheckCSRFToken(t *testing.T) {
+	config := &conf.Configuration{}
+	config.ApplyDefaults()
+
+	request := func(method, cookie, header string) *http.Request {
+		req := httptest.NewRequest(method, "http://localhost/user", nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: config.Cookie.CSRFKey, Value: cookie})
+		}
+		if header != "" {
+			req.Header.Set(csrfHeaderName, header)
+		}
+		return req
+	}
+
+	assert.NoError(t, checkCSRFToken(config, request(http.MethodGet, "", "")))
+	assert.NoError(t, checkCSRFToken(config, request(http.MethodPut, "token", "token")))
+	assert.Error(t, checkCSRFToken(config, request(http.MethodPut, "token", "")))
+	assert.Error(t, checkCSRFToken(config, request(http.MethodPut, "", "token")))
+	assert.Error(t, checkCSRFToken(config, request(http.MethodDelete, "token", "other")))
+}
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/tigrisdata/gotrue/metering"
+	"github.com/tigrisdata/gotrue/models"
+)
+
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// DeviceCodeResponse is the device authorization response (RFC 8628 section 3.2)
+type DeviceCodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceVerifyParams are the parameters the DeviceVerify endpoint accepts
+type DeviceVerifyParams struct {
+	UserCode string `json:"user_code"`
+	Approve  bool   `json:"approve"`
+}
+
+// DeviceAuthorizationResponse describes a pending device authorization to the
+// user asked to approve it
+type DeviceAuthorizationResponse struct {
+	UserCode  string    `json:"user_code"`
+	ClientID  string    `json:"client_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// DeviceCode starts a device authorization, issuing the device code the device
+// polls with and the user code the user enters on the verification page
+func (a *API) DeviceCode(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	config := a.getConfig(ctx)
+
+	if !config.Device.Enabled {
+		return oauthError("unsupported_grant_type", "Device authorization is disabled")
+	}
+
+	code, err := models.NewDeviceCode(ctx, a.db, getInstanceID(ctx), r.FormValue("client_id"), a.requestAud(ctx, r), config.Device.Interval, time.Second*time.Duration(config.Device.Exp))
+	if err != nil {
+		return internalServerError("Database error creating device code").WithInternalError(err)
+	}
+
+	complete, err := url.Parse(config.Device.VerificationURL)
+	if err != nil {
+		return internalServerError("Invalid device verification URL").WithInternalError(err)
+	}
+	q := complete.Query()
+	q.Set("user_code", code.FormattedUserCode())
+	complete.RawQuery = q.Encode()
+
+	return sendJSON(w, http.StatusOK, &DeviceCodeResponse{
+		DeviceCode:              code.DeviceCode,
+		UserCode:                code.FormattedUserCode(),
+		VerificationURI:         config.Device.VerificationURL,
+		VerificationURIComplete: complete.String(),
+		ExpiresIn:               config.Device.Exp,
+		Interval:                code.Interval,
+	})
+}
+
+// DeviceGet shows the signed in user the device authorization for a user code
+// before they approve it
+func (a *API) DeviceGet(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	user, err := getUserFromClaims(ctx, a.db)
+	if err != nil {
+		return unauthorizedError("Invalid user").WithInternalError(err)
+	}
+
+	code, err := a.findPendingDeviceCode(ctx, user, r.FormValue("user_code"))
+	if err != nil {
+		return err
+	}
+
+	return sendJSON(w, http.StatusOK, &DeviceAuthorizationResponse{
+		UserCode:  code.FormattedUserCode(),
+		ClientID:  code.ClientID,
+		CreatedAt: code.CreatedAt,
+		ExpiresAt: code.ExpiresAt,
+	})
+}
+
+// DeviceVerify approves or denies a device authorization on behalf of the
+// signed in user
+func (a *API) DeviceVerify(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	instanceID := getInstanceID(ctx)
+
+	params := &DeviceVerifyParams{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read device verification params: %v", err)
+	}
+
+	user, err := getUserFromClaims(ctx, a.db)
+	if err != nil {
+		return unauthorizedError("Invalid user").WithInternalError(err)
+	}
+
+	code, err := a.findPendingDeviceCode(ctx, user, params.UserCode)
+	if err != nil {
+		return err
+	}
+
+	err = a.db.Tx(ctx, func(ctx context.Context) error {
+		action := models.DeviceDeniedAction
+		if params.Approve {
+			action = models.DeviceApprovedAction
+		}
+		if terr := models.NewAuditLogEntry(ctx, a.db, instanceID, user, action, map[string]interface{}{
+			"client_id": code.ClientID,
+		}); terr != nil {
+			return terr
+		}
+
+		if params.Approve {
+			return code.Approve(ctx, a.db, user)
+		}
+		return code.Deny(ctx, a.db)
+	})
+	if err != nil {
+		return internalServerError("Error verifying device").WithInternalError(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (a *API) findPendingDeviceCode(ctx context.Context, user *models.User, userCode string) (*models.DeviceCode, error) {
+	if userCode == "" {
+		return nil, unprocessableEntityError("Device verification requires a user code")
+	}
+
+	code, err := models.FindDeviceCodeByUserCode(ctx, a.db, getInstanceID(ctx), userCode)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, notFoundError("Invalid or expired user code")
+		}
+		return nil, internalServerError("Database error finding device code").WithInternalError(err)
+	}
+
+	// the device asked for tokens of a specific audience
+	if code.IsExpired() || !code.IsPending() || code.Aud != user.Aud {
+		return nil, notFoundError("Invalid or expired user code")
+	}
+	return code, nil
+}
+
+// DeviceCodeGrant implements the device_code grant type flow (RFC 8628 section 3.4)
+func (a *API) DeviceCodeGrant(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	config := a.getConfig(ctx)
+	instanceID := getInstanceID(ctx)
+
+	if !config.Device.Enabled {
+		return oauthError("unsupported_grant_type", "Device authorization is disabled")
+	}
+
+	deviceCode := r.FormValue("device_code")
+	if deviceCode == "" {
+		return oauthError("invalid_request", "device_code required")
+	}
+
+	code, err := models.FindDeviceCodeByDeviceCode(ctx, a.db, instanceID, deviceCode)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return oauthError("invalid_grant", "Invalid device code")
+		}
+		return internalServerError("Database error finding device code").WithInternalError(err)
+	}
+
+	if code.IsExpired() {
+		if err := code.Delete(ctx, a.db); err != nil {
+			return internalServerError("Database error deleting device code").WithInternalError(err)
+		}
+		return oauthError("expired_token", "Device code has expired")
+	}
+
+	if code.DeniedAt != nil {
+		if err := code.Delete(ctx, a.db); err != nil {
+			return internalServerError("Database error deleting device code").WithInternalError(err)
+		}
+		return oauthError("access_denied", "Device authorization was denied")
+	}
+
+	if code.ApprovedAt == nil {
+		ok, err := code.Poll(ctx, a.db)
+		if err != nil {
+			return internalServerError("Database error polling device code").WithInternalError(err)
+		}
+		if !ok {
+			return oauthError("slow_down", "Polling too frequently")
+		}
+		return oauthError("authorization_pending", "Waiting for the user to approve the device")
+	}
+
+	user, err := models.FindUserByInstanceIDAndID(ctx, a.db, instanceID, code.UserID)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return oauthError("invalid_grant", "Invalid device code")
+		}
+		return internalServerError("Database error finding user").WithInternalError(err)
+	}
+
+	var token *AccessTokenResponse
+	err = a.db.Tx(ctx, func(ctx context.Context) error {
+		var terr error
+		// device codes can only be redeemed once
+		if terr = code.Delete(ctx, a.db); terr != nil {
+			return internalServerError("Database error deleting device code").WithInternalError(terr)
+		}
+		if terr = models.NewAuditLogEntry(ctx, a.db, instanceID, user, models.LoginAction, map[string]interface{}{
+			"provider":  "device",
+			"client_id": code.ClientID,
+		}); terr != nil {
+			return terr
+		}
+		if terr = triggerEventHooks(ctx, a.db, LoginEvent, user, instanceID, config); terr != nil {
+			return terr
+		}
+
+		token, terr = a.issueRefreshToken(ctx, user)
+		return terr
+	})
+	if err != nil {
+		return err
+	}
+	metering.RecordLogin("device_code", user.ID, instanceID)
+	return sendJSON(w, http.StatusOK, token)
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/tigrisdata/gotrue/conf"
+	"github.com/tigrisdata/gotrue/crypto"
+	"github.com/tigrisdata/gotrue/models"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+type DeviceTestSuite struct {
+	suite.Suite
+	API        *API
+	Config     *conf.Configuration
+	Encrypter  *crypto.AESBlockEncrypter
+	instanceID uuid.UUID
+}
+
+func TestDevice(t *testing.T) {
+	api, config, globalConf, instanceID, err := setupAPIForTestForInstance()
+	require.NoError(t, err)
+
+	ts := &DeviceTestSuite{
+		API:        api,
+		Config:     config,
+		Encrypter:  &crypto.AESBlockEncrypter{Key: globalConf.DB.EncryptionKey},
+		instanceID: instanceID,
+	}
+
+	suite.Run(t, ts)
+}
+
+func (ts *DeviceTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	u, err := models.NewUser(ts.instanceID, "test@example.com", "password", ts.Config.JWT.Aud, nil, ts.Encrypter)
+	require.NoError(ts.T(), err, "Error creating test user model")
+	_, err = tigris.GetCollection[models.User](ts.API.db).Insert(context.TODO(), u)
+	require.NoError(ts.T(), err, "Error saving new test user")
+	require.NoError(ts.T(), u.Confirm(context.TODO(), ts.API.db))
+
+	ts.Config.Device.Enabled = true
+	ts.Config.Device.Interval = 5
+}
+
+func (ts *DeviceTestSuite) postForm(path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "http://localhost"+path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *DeviceTestSuite) deviceCode() *DeviceCodeResponse {
+	w := ts.postForm("/device/code", url.Values{"client_id": {"tigris-cli"}})
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	code := &DeviceCodeResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(code))
+	return code
+}
+
+func (ts *DeviceTestSuite) poll(deviceCode string) *httptest.ResponseRecorder {
+	return ts.postForm("/token", url.Values{
+		"grant_type":  {deviceCodeGrantType},
+		"device_code": {deviceCode},
+	})
+}
+
+func (ts *DeviceTestSuite) verify(userCode string, approve bool) *httptest.ResponseRecorder {
+	w := ts.postForm("/token", url.Values{
+		"grant_type": {"password"},
+		"username":   {"test@example.com"},
+		"password":   {"password"},
+	})
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+	token := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(token))
+	return ts.verifyWithToken(userCode, approve, token.Token)
+}
+
+func (ts *DeviceTestSuite) verifyWithToken(userCode string, approve bool, token string) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"user_code": userCode,
+		"approve":   approve,
+	}))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/device/verify", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *DeviceTestSuite) oauthError(w *httptest.ResponseRecorder) string {
+	e := &OAuthError{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(e))
+	return e.Err
+}
+
+func (ts *DeviceTestSuite) TestDeviceFlow() {
+	code := ts.deviceCode()
+	assert.Equal(ts.T(), 5, code.Interval)
+	assert.Contains(ts.T(), code.VerificationURIComplete, "user_code=")
+
+	w := ts.poll(code.DeviceCode)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	assert.Equal(ts.T(), "authorization_pending", ts.oauthError(w))
+
+	w = ts.poll(code.DeviceCode)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	assert.Equal(ts.T(), "slow_down", ts.oauthError(w))
+
+	// user codes are accepted regardless of case and separators
+	userCode := strings.ToLower(strings.ReplaceAll(code.UserCode, "-", ""))
+	require.Equal(ts.T(), http.StatusNoContent, ts.verify(userCode, true).Code)
+
+	w = ts.poll(code.DeviceCode)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+	token := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(token))
+	assert.NotEmpty(ts.T(), token.Token)
+	assert.NotEmpty(ts.T(), token.RefreshToken)
+
+	// the device code can only be redeemed once
+	w = ts.poll(code.DeviceCode)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	assert.Equal(ts.T(), "invalid_grant", ts.oauthError(w))
+}
+
+func (ts *DeviceTestSuite) TestDeviceDenied() {
+	code := ts.deviceCode()
+	require.Equal(ts.T(), http.StatusNoContent, ts.verify(code.UserCode, false).Code)
+
+	w := ts.poll(code.DeviceCode)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	assert.Equal(ts.T(), "access_denied", ts.oauthError(w))
+
+	// a denied code cannot be approved afterwards
+	assert.Equal(ts.T(), http.StatusNotFound, ts.verify(code.UserCode, true).Code)
+}
+
+func (ts *DeviceTestSuite) TestDeviceVerifyImpersonated() {
+	u, err := models.FindUserByInstanceIDAndEmail(context.TODO(), ts.API.db, ts.instanceID, "test@example.com")
+	require.NoError(ts.T(), err)
+
+	// impersonators cannot turn their token into a session of the user
+	code := ts.deviceCode()
+	w := ts.verifyWithToken(code.UserCode, true, impersonationToken(ts.T(), ts.API, ts.Config, u))
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+
+	w = ts.poll(code.DeviceCode)
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	assert.Equal(ts.T(), "authorization_pending", ts.oauthError(w))
+}
@@ -55,6 +55,44 @@ func oauthError(err string, description string) *OAuthError {
 	return &OAuthError{Err: err, Description: description}
 }
 
+// SCIMError is the JSON handler for SCIM error responses (RFC 7644 section 3.12)
+type SCIMError struct {
+	Schemas       []string `json:"schemas"`
+	Status        string   `json:"status"`
+	SCIMType      string   `json:"scimType,omitempty"`
+	Detail        string   `json:"detail,omitempty"`
+	Code          int      `json:"-"`
+	InternalError error    `json:"-"`
+}
+
+func (e *SCIMError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Detail)
+}
+
+// WithInternalError adds internal error information to the error
+func (e *SCIMError) WithInternalError(err error) *SCIMError {
+	e.InternalError = err
+	return e
+}
+
+// Cause returns the root cause error
+func (e *SCIMError) Cause() error {
+	if e.InternalError != nil {
+		return e.InternalError
+	}
+	return e
+}
+
+func scimError(code int, scimType string, fmtString string, args ...interface{}) *SCIMError {
+	return &SCIMError{
+		Schemas:  []string{scimErrorSchema},
+		Status:   fmt.Sprint(code),
+		SCIMType: scimType,
+		Detail:   fmt.Sprintf(fmtString, args...),
+		Code:     code,
+	}
+}
+
 func badRequestError(fmtString string, args ...interface{}) *HTTPError {
 	return httpError(http.StatusBadRequest, fmtString, args...)
 }
@@ -81,11 +119,12 @@ func unprocessableEntityError(fmtString string, args ...interface{}) *HTTPError
 
 // HTTPError is an error with a message and an HTTP status code.
 type HTTPError struct {
-	Code            int    `json:"code"`
-	Message         string `json:"msg"`
-	InternalError   error  `json:"-"`
-	InternalMessage string `json:"-"`
-	ErrorID         string `json:"error_id,omitempty"`
+	Code            int         `json:"code"`
+	Message         string      `json:"msg"`
+	InternalError   error       `json:"-"`
+	InternalMessage string      `json:"-"`
+	ErrorID         string      `json:"error_id,omitempty"`
+	Details         interface{} `json:"details,omitempty"`
 }
 
 func (e *HTTPError) Error() string {
@@ -115,6 +154,12 @@ func (e *HTTPError) WithInternalMessage(fmtString string, args ...interface{}) *
 	return e
 }
 
+// WithDetails adds structured details about the error to the response
+func (e *HTTPError) WithDetails(details interface{}) *HTTPError {
+	e.Details = details
+	return e
+}
+
 func httpError(code int, fmtString string, args ...interface{}) *HTTPError {
 	return &HTTPError{
 		Code:    code,
@@ -173,6 +218,15 @@ func handleError(err error, w http.ResponseWriter, r *http.Request) {
 		if jsonErr := sendJSON(w, http.StatusBadRequest, e); jsonErr != nil {
 			handleError(jsonErr, w, r)
 		}
+	case *SCIMError:
+		if e.Code >= http.StatusInternalServerError {
+			log.Err(e.Cause()).Msg(e.Error())
+		} else {
+			log.Info().Err(e.Cause()).Msg(e.Error())
+		}
+		if jsonErr := sendSCIM(w, e.Code, e); jsonErr != nil {
+			handleError(jsonErr, w, r)
+		}
 	case ErrorCause:
 		handleError(e.Cause(), w, r)
 	default:
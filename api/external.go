@@ -2,6 +2,9 @@ package api
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -12,9 +15,12 @@ import (
 	jwt "github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"github.com/tigrisdata/gotrue/api/provider"
+	"github.com/tigrisdata/gotrue/conf"
+	"github.com/tigrisdata/gotrue/crypto"
 	"github.com/tigrisdata/gotrue/models"
 	"github.com/rs/zerolog"
 	"github.com/tigrisdata/tigris-client-go/tigris"
+	"golang.org/x/oauth2"
 )
 
 type ExternalProviderClaims struct {
@@ -22,8 +28,21 @@ type ExternalProviderClaims struct {
 	Provider    string `json:"provider"`
 	InviteToken string `json:"invite_token,omitempty"`
 	Referrer    string `json:"referrer,omitempty"`
+	// LinkUserID is the signed in user the provider account is linked to
+	LinkUserID string `json:"link_user_id,omitempty"`
+	// LinkNonce is the hash of the link cookie set in the browser that started linking
+	LinkNonce string `json:"link_nonce,omitempty"`
+	// SSOProviderID is the SAML identity provider the user signs in with
+	SSOProviderID string `json:"sso_provider_id,omitempty"`
 }
 
+// externalStateExpiry is how long users have to sign in with the external provider
+const externalStateExpiry = 5 * time.Minute
+
+// linkCookieName is the cookie binding an identity link to the browser that
+// started it, so a link URL cannot be completed in another browser
+const linkCookieName = "nf_link"
+
 // SignupParams are the parameters the Signup endpoint accepts
 type ExternalSignupParams struct {
 	Provider string `json:"provider"`
@@ -31,15 +50,7 @@ type ExternalSignupParams struct {
 }
 
 func (a *API) ExternalProviderRedirect(w http.ResponseWriter, r *http.Request) error {
-	ctx := r.Context()
-	config := a.getConfig(ctx)
-
 	providerType := r.URL.Query().Get("provider")
-	provider, err := a.Provider(ctx, providerType)
-	if err != nil {
-		return badRequestError("Unsupported provider: %+v", err).WithInternalError(err)
-	}
-
 	inviteToken := r.URL.Query().Get("invite_token")
 	if inviteToken != "" {
 		_, userErr := models.FindUserByConfirmationToken(r.Context(), a.db, inviteToken)
@@ -51,30 +62,113 @@ func (a *API) ExternalProviderRedirect(w http.ResponseWriter, r *http.Request) e
 		}
 	}
 
-	referrer := a.getReferrer(r)
+	// users of enterprise SSO are sent to the identity provider of their domain
+	if providerType == "" || providerType == "saml" {
+		if domain := ssoDomain(r); domain != "" {
+			ctx := r.Context()
+			ssoProvider, err := models.FindSSOProviderByDomain(ctx, a.db, getInstanceID(ctx), domain)
+			if err != nil {
+				if models.IsNotFoundError(err) {
+					return notFoundError("No SSO provider is configured for %s", domain)
+				}
+				return internalServerError("Database error finding SSO provider").WithInternalError(err)
+			}
+			providerType = "saml"
+			r = r.WithContext(withSSOProvider(ctx, ssoProvider))
+		}
+	}
+
+	authURL, err := a.externalProviderURL(w, r, providerType, inviteToken, nil)
+	if err != nil {
+		return err
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+	return nil
+}
+
+// ssoDomain returns the domain of the email or domain query parameter
+func ssoDomain(r *http.Request) string {
+	q := r.URL.Query()
+	if email := q.Get("email"); email != "" {
+		return emailDomain(email)
+	}
+	return q.Get("domain")
+}
+
+// emailDomain returns the part of the email after the @
+func emailDomain(email string) string {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return ""
+	}
+	return email[i+1:]
+}
+
+// externalProviderURL returns the URL of the external provider the user signs
+// in at. Signing in links the provider account to linkUser when it is set, in
+// the browser the link cookie is set in.
+func (a *API) externalProviderURL(w http.ResponseWriter, r *http.Request, providerType, inviteToken string, linkUser *models.User) (string, error) {
+	ctx := r.Context()
+	config := a.getConfig(ctx)
+
+	// the PKCE verifier and nonce stay on the server, bound to the state by its ID
+	state, err := models.NewOAuthState(ctx, a.db, getInstanceID(ctx), externalStateExpiry)
+	if err != nil {
+		return "", internalServerError("Error creating state").WithInternalError(err)
+	}
+	ctx = withExternalNonce(ctx, state.Nonce)
+
+	provider, err := a.Provider(ctx, providerType)
+	if err != nil {
+		return "", badRequestError("Unsupported provider: %+v", err).WithInternalError(err)
+	}
+
+	var linkUserID, linkNonce string
+	if linkUser != nil {
+		linkUserID = linkUser.ID.String()
+		nonce := crypto.SecureToken()
+		linkNonce = linkNonceHash(nonce)
+		http.SetCookie(w, newLinkCookie(config, nonce))
+	}
+	var ssoProviderID string
+	if ssoProvider := getSSOProvider(ctx); ssoProvider != nil {
+		ssoProviderID = ssoProvider.ID.String()
+	}
+
+	referrer, err := a.redirectTo(r, "")
+	if err != nil {
+		return "", err
+	}
 	log := getLogEntry(r).With().Str("provider", providerType).Logger()
 	log.Info().Msg("Redirecting to external provider")
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, ExternalProviderClaims{
 		NetlifyMicroserviceClaims: NetlifyMicroserviceClaims{
 			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(5 * time.Minute).Unix(),
+				Id:        state.ID.String(),
+				ExpiresAt: time.Now().Add(externalStateExpiry).Unix(),
 			},
 			SiteURL:    config.SiteURL,
 			InstanceID: getInstanceID(ctx).String(),
 			NetlifyID:  getNetlifyID(ctx),
 		},
-		Provider:    providerType,
-		InviteToken: inviteToken,
-		Referrer:    referrer,
+		Provider:      providerType,
+		InviteToken:   inviteToken,
+		Referrer:      referrer,
+		LinkUserID:    linkUserID,
+		LinkNonce:     linkNonce,
+		SSOProviderID: ssoProviderID,
 	})
 	tokenString, err := token.SignedString([]byte(a.config.OperatorToken))
 	if err != nil {
-		return internalServerError("Error creating state").WithInternalError(err)
+		return "", internalServerError("Error creating state").WithInternalError(err)
 	}
 
-	http.Redirect(w, r, provider.AuthCodeURL(tokenString), http.StatusFound)
-	return nil
+	return provider.AuthCodeURL(tokenString,
+		oauth2.SetAuthURLParam("nonce", state.Nonce),
+		oauth2.SetAuthURLParam("code_challenge", pkceChallenge(state.CodeVerifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	), nil
 }
 
 func (a *API) ExternalProviderCallback(w http.ResponseWriter, r *http.Request) error {
@@ -89,6 +183,7 @@ func (a *API) internalExternalProviderCallback(w http.ResponseWriter, r *http.Re
 
 	providerType := getExternalProviderType(ctx)
 	var userData *provider.UserProvidedData
+	var providerToken *oauth2.Token
 	if providerType == "saml" {
 		samlUserData, err := a.samlCallback(r, ctx)
 		if err != nil {
@@ -96,13 +191,31 @@ func (a *API) internalExternalProviderCallback(w http.ResponseWriter, r *http.Re
 		}
 		userData = samlUserData
 	} else {
-		oAuthUserData, err := a.oAuthCallback(ctx, r, providerType)
+		oAuthUserData, oAuthToken, err := a.oAuthCallback(ctx, r, providerType)
 		if err != nil {
 			return err
 		}
 		userData = oAuthUserData
+		providerToken = oAuthToken
+	}
+
+	identityProvider := providerType
+	if providerType == "saml" {
+		identityProvider = samlIdentityProvider(ctx)
 	}
 
+	if linkUserID := getLinkUserID(ctx); linkUserID != uuid.Nil {
+		if err := a.linkExternalIdentity(ctx, linkUserID, identityProvider, userData, providerToken); err != nil {
+			return err
+		}
+		http.Redirect(w, r, a.getExternalRedirectURL(r), http.StatusFound)
+		return nil
+	}
+
+	// SSO providers vouch for the emails of their domains themselves, so
+	// autoconfirm never stands in for a verified email of theirs
+	autoconfirm := config.Mailer.Autoconfirm && getSSOProvider(ctx) == nil
+
 	var user *models.User
 	var token *AccessTokenResponse
 	err := a.db.Tx(ctx, func(ctx context.Context) error {
@@ -112,23 +225,44 @@ func (a *API) internalExternalProviderCallback(w http.ResponseWriter, r *http.Re
 			if user, terr = a.processInvite(ctx, a.db, userData, instanceID, inviteToken, providerType); terr != nil {
 				return terr
 			}
+			if terr = a.recordIdentity(ctx, user, identityProvider, userData, providerToken); terr != nil {
+				return terr
+			}
+			if providerType == "saml" {
+				if terr = a.applyProviderAttributes(ctx, user, userData); terr != nil {
+					return terr
+				}
+			}
 		} else {
 			aud := a.requestAud(ctx, r)
 
-			// search user using all available emails
+			// the provider account is matched first, as its emails may change
 			var emailData provider.Email
-			for _, e := range userData.Emails {
-				if e.Verified || config.Mailer.Autoconfirm {
-					user, terr = models.FindUserByEmailAndAudience(ctx, a.db, instanceID, e.Email, aud)
-					if terr != nil && !models.IsNotFoundError(terr) {
-						return internalServerError("Error checking for duplicate users").WithInternalError(terr)
-					}
-
-					if user != nil {
+			if user, terr = a.findUserByIdentity(ctx, identityProvider, userData, aud); terr != nil {
+				return terr
+			}
+			if user != nil {
+				for _, e := range userData.Emails {
+					if e.Email == user.Email {
 						emailData = e
 						break
 					}
 				}
+			} else {
+				// search user using all available emails
+				for _, e := range userData.Emails {
+					if e.Verified || autoconfirm {
+						user, terr = models.FindUserByEmailAndAudience(ctx, a.db, instanceID, e.Email, aud)
+						if terr != nil && !models.IsNotFoundError(terr) {
+							return internalServerError("Error checking for duplicate users").WithInternalError(terr)
+						}
+
+						if user != nil {
+							emailData = e
+							break
+						}
+					}
+				}
 			}
 
 			if user == nil {
@@ -137,13 +271,7 @@ func (a *API) internalExternalProviderCallback(w http.ResponseWriter, r *http.Re
 				}
 
 				// prefer primary email for new signups
-				emailData = userData.Emails[0]
-				for _, e := range userData.Emails {
-					if e.Primary {
-						emailData = e
-						break
-					}
-				}
+				emailData = primaryEmail(userData)
 
 				params := &SignupParams{
 					Provider: providerType,
@@ -163,8 +291,17 @@ func (a *API) internalExternalProviderCallback(w http.ResponseWriter, r *http.Re
 				}
 			}
 
+			if terr = a.recordIdentity(ctx, user, identityProvider, userData, providerToken); terr != nil {
+				return terr
+			}
+			if providerType == "saml" {
+				if terr = a.applyProviderAttributes(ctx, user, userData); terr != nil {
+					return terr
+				}
+			}
+
 			if !user.IsConfirmed() {
-				if !emailData.Verified && !config.Mailer.Autoconfirm {
+				if !emailData.Verified && !autoconfirm {
 					mailer := a.Mailer(ctx)
 					referrer := a.getReferrer(r)
 					if terr = sendConfirmation(ctx, a.db, user, mailer, config.SMTP.MaxFrequency, referrer); terr != nil {
@@ -195,6 +332,9 @@ func (a *API) internalExternalProviderCallback(w http.ResponseWriter, r *http.Re
 			}
 		}
 
+		if reason := signInDenied(user); reason != "" {
+			return oauthError("access_denied", reason)
+		}
 		token, terr = a.issueRefreshToken(ctx, user)
 		if terr != nil {
 			return oauthError("server_error", terr.Error())
@@ -207,6 +347,8 @@ func (a *API) internalExternalProviderCallback(w http.ResponseWriter, r *http.Re
 
 	rurl := a.getExternalRedirectURL(r)
 	if token != nil {
+		a.checkNewDevice(ctx, r, user)
+
 		q := url.Values{}
 		q.Set("access_token", token.Token)
 		q.Set("token_type", token.TokenType)
@@ -218,6 +360,150 @@ func (a *API) internalExternalProviderCallback(w http.ResponseWriter, r *http.Re
 	return nil
 }
 
+// findUserByIdentity finds the user the provider account is linked to, if any
+func (a *API) findUserByIdentity(ctx context.Context, providerType string, userData *provider.UserProvidedData, aud string) (*models.User, error) {
+	if userData.Subject == "" {
+		return nil, nil
+	}
+
+	identity, err := models.FindIdentityByProviderID(ctx, a.db, getInstanceID(ctx), providerType, userData.Subject)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, internalServerError("Database error finding identity").WithInternalError(err)
+	}
+
+	user, err := models.FindUserByIdAndAudience(ctx, a.db, identity.InstanceID, identity.UserID, aud)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, internalServerError("Database error finding user").WithInternalError(err)
+	}
+	return user, nil
+}
+
+// recordIdentity links the provider account to the user on the first sign in
+// with it, and keeps its claims and token up to date on later ones. Users have
+// at most one account per provider.
+func (a *API) recordIdentity(ctx context.Context, user *models.User, providerType string, userData *provider.UserProvidedData, tok *oauth2.Token) error {
+	if userData.Subject == "" {
+		return nil
+	}
+	email := primaryEmail(userData).Email
+
+	identity, err := models.FindIdentityByProviderID(ctx, a.db, user.InstanceID, providerType, userData.Subject)
+	if err == nil {
+		if identity.UserID != user.ID {
+			return forbiddenError("This %s account is linked to a different user", providerType)
+		}
+		if err := identity.UpdateSignIn(ctx, a.db, email, userData.Claims); err != nil {
+			return internalServerError("Database error updating identity").WithInternalError(err)
+		}
+		return a.saveProviderToken(ctx, identity, tok)
+	}
+	if !models.IsNotFoundError(err) {
+		return internalServerError("Database error finding identity").WithInternalError(err)
+	}
+
+	if _, err := models.FindIdentityByUserAndProvider(ctx, a.db, user, providerType); err == nil {
+		return forbiddenError("A different %s account is linked to this user", providerType)
+	} else if !models.IsNotFoundError(err) {
+		return internalServerError("Database error finding identity").WithInternalError(err)
+	}
+
+	identity, err = models.NewIdentity(ctx, a.db, user, providerType, userData.Subject, email, userData.Claims)
+	if err != nil {
+		return internalServerError("Database error saving identity").WithInternalError(err)
+	}
+	if err := a.saveProviderToken(ctx, identity, tok); err != nil {
+		return err
+	}
+	return models.NewAuditLogEntry(ctx, a.db, user.InstanceID, user, models.IdentityLinkedAction, map[string]interface{}{
+		"provider":    providerType,
+		"provider_id": userData.Subject,
+	})
+}
+
+// saveProviderToken keeps the token the provider issued on sign in, when
+// provider tokens are enabled
+func (a *API) saveProviderToken(ctx context.Context, identity *models.Identity, tok *oauth2.Token) error {
+	config := a.getConfig(ctx)
+	if tok == nil || !config.External.Tokens.Enabled {
+		return nil
+	}
+
+	if _, err := models.SaveProviderToken(ctx, a.db, a.encrypter, identity, tok); err != nil {
+		return internalServerError("Database error saving provider token").WithInternalError(err)
+	}
+	return nil
+}
+
+// linkExternalIdentity links the provider account to the signed in user that
+// started the external sign in
+func (a *API) linkExternalIdentity(ctx context.Context, userID uuid.UUID, providerType string, userData *provider.UserProvidedData, tok *oauth2.Token) error {
+	if userData.Subject == "" {
+		return badRequestError("Provider %s does not identify its users", providerType)
+	}
+
+	user, err := models.FindUserByInstanceIDAndID(ctx, a.db, getInstanceID(ctx), userID)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return notFoundError(err.Error())
+		}
+		return internalServerError("Database error finding user").WithInternalError(err)
+	}
+
+	_, err = models.FindIdentityByUserAndProvider(ctx, a.db, user, providerType)
+	if err != nil && !models.IsNotFoundError(err) {
+		return internalServerError("Database error finding identity").WithInternalError(err)
+	}
+	linked := err != nil
+
+	if err := a.db.Tx(ctx, func(ctx context.Context) error {
+		return a.recordIdentity(ctx, user, providerType, userData, tok)
+	}); err != nil {
+		return err
+	}
+	if linked {
+		a.notifyIdentityLinked(ctx, user, providerType)
+	}
+	return nil
+}
+
+// applyProviderAttributes keeps the metadata and role of the user in sync with
+// the attributes a SAML identity provider or LDAP directory holds for them
+func (a *API) applyProviderAttributes(ctx context.Context, user *models.User, userData *provider.UserProvidedData) error {
+	if len(userData.Metadata) > 0 {
+		updates := make(map[string]interface{}, len(userData.Metadata))
+		for k, v := range userData.Metadata {
+			updates[k] = v
+		}
+		if err := user.UpdateUserMetaData(ctx, a.db, updates); err != nil {
+			return internalServerError("Database error updating user").WithInternalError(err)
+		}
+	}
+
+	if userData.Role != "" && userData.Role != user.Role {
+		if err := user.SetRole(ctx, a.db, userData.Role); err != nil {
+			return internalServerError("Database error updating user").WithInternalError(err)
+		}
+	}
+	return nil
+}
+
+// primaryEmail returns the primary email of the provider account, or the first
+// one if none is marked primary
+func primaryEmail(userData *provider.UserProvidedData) provider.Email {
+	for _, e := range userData.Emails {
+		if e.Primary {
+			return e
+		}
+	}
+	return userData.Emails[0]
+}
+
 func (a *API) processInvite(ctx context.Context, database *tigris.Database, userData *provider.UserProvidedData, instanceID uuid.UUID, inviteToken, providerType string) (*models.User, error) {
 	config := a.getConfig(ctx)
 	user, err := models.FindUserByConfirmationToken(ctx, database, inviteToken)
@@ -270,7 +556,7 @@ func (a *API) processInvite(ctx context.Context, database *tigris.Database, user
 	return user, nil
 }
 
-func (a *API) loadExternalState(ctx context.Context, state string) (context.Context, error) {
+func (a *API) loadExternalState(ctx context.Context, r *http.Request, state string) (context.Context, error) {
 	claims := ExternalProviderClaims{}
 	p := jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Name}}
 	_, err := p.ParseWithClaims(state, &claims, func(token *jwt.Token) (interface{}, error) {
@@ -285,6 +571,46 @@ func (a *API) loadExternalState(ctx context.Context, state string) (context.Cont
 	if claims.Referrer != "" {
 		ctx = withExternalReferrer(ctx, claims.Referrer)
 	}
+	if claims.LinkUserID != "" {
+		linkUserID, err := uuid.Parse(claims.LinkUserID)
+		if err != nil {
+			return nil, badRequestError("OAuth state is invalid: malformed user ID")
+		}
+		cookie, err := r.Cookie(linkCookieName)
+		if err != nil || subtle.ConstantTimeCompare([]byte(linkNonceHash(cookie.Value)), []byte(claims.LinkNonce)) != 1 {
+			return nil, badRequestError("OAuth state is invalid: identity link was started in another browser")
+		}
+		ctx = withLinkUserID(ctx, linkUserID)
+	}
+	if claims.SSOProviderID != "" {
+		ssoProviderID, err := uuid.Parse(claims.SSOProviderID)
+		if err != nil {
+			return nil, badRequestError("OAuth state is invalid: malformed SSO provider ID")
+		}
+		ssoProvider, err := models.FindSSOProviderByID(ctx, a.db, getInstanceID(ctx), ssoProviderID)
+		if err != nil {
+			if models.IsNotFoundError(err) {
+				return nil, badRequestError("OAuth state is invalid: SSO provider was removed")
+			}
+			return nil, internalServerError("Database error finding SSO provider").WithInternalError(err)
+		}
+		ctx = withSSOProvider(ctx, ssoProvider)
+	}
+
+	stateID, err := uuid.Parse(claims.Id)
+	if err != nil {
+		return nil, badRequestError("OAuth state is invalid: missing state ID")
+	}
+	// states are single-use, so a captured callback URL cannot be replayed
+	stored, err := models.ConsumeOAuthState(ctx, a.db, stateID)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, badRequestError("OAuth state is invalid: state has expired or was already used")
+		}
+		return nil, internalServerError("Database error loading OAuth state").WithInternalError(err)
+	}
+	ctx = withExternalNonce(ctx, stored.Nonce)
+	ctx = withExternalCodeVerifier(ctx, stored.CodeVerifier)
 
 	ctx = withExternalProviderType(ctx, claims.Provider)
 	return withSignature(ctx, state), nil
@@ -306,9 +632,18 @@ func (a *API) Provider(ctx context.Context, name string) (provider.Provider, err
 		return provider.NewGoogleProvider(config.External.Google)
 	case "facebook":
 		return provider.NewFacebookProvider(config.External.Facebook)
+	case "microsoft":
+		return provider.NewMicrosoftProvider(config.External.Microsoft, getExternalNonce(ctx))
+	case "apple":
+		return provider.NewAppleProvider(config.External.Apple, getExternalNonce(ctx))
+	case "slack":
+		return provider.NewSlackProvider(config.External.Slack)
 	case "saml":
-		return provider.NewSamlProvider(config.External.Saml, a.db, getInstanceID(ctx))
+		return a.samlProvider(ctx)
 	default:
+		if oidc, ok := config.External.OIDC.Find(name); ok {
+			return provider.NewOIDCProvider(ctx, oidc, getExternalNonce(ctx))
+		}
 		return nil, fmt.Errorf("Provider %s could not be found", name)
 	}
 }
@@ -365,3 +700,23 @@ func (a *API) getExternalRedirectURL(r *http.Request) string {
 	}
 	return config.SiteURL
 }
+
+// pkceChallenge derives the S256 code challenge of a PKCE verifier (RFC 7636)
+// newLinkCookie returns the cookie holding the link nonce. It is sent with the
+// cross-site POST of SAML callbacks too, so it cannot be SameSite Lax.
+func newLinkCookie(config *conf.Configuration, nonce string) *http.Cookie {
+	cookie := newSessionCookie(config, linkCookieName, nonce, true)
+	cookie.SameSite = http.SameSiteNoneMode
+	cookie.MaxAge = int(externalStateExpiry.Seconds())
+	return cookie
+}
+
+func linkNonceHash(nonce string) string {
+	sum := sha256.Sum256([]byte(nonce))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/tigrisdata/gotrue/conf"
+)
+
+func AppleTestSignupSetup(ts *ExternalTestSuite, tokenCount *int, code string, email string, nonce *string) *httptest.Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	ts.Require().NoError(err)
+
+	teamKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ts.Require().NoError(err)
+	der, err := x509.MarshalPKCS8PrivateKey(teamKey)
+	ts.Require().NoError(err)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/auth/token":
+			*tokenCount++
+			ts.Equal(code, r.FormValue("code"))
+			ts.Equal("authorization_code", r.FormValue("grant_type"))
+			ts.Equal("com.example.app", r.FormValue("client_id"))
+
+			// the client secret is signed with the team's key
+			secret := &jwt.RegisteredClaims{}
+			_, err := jwt.ParseWithClaims(r.FormValue("client_secret"), secret, func(token *jwt.Token) (interface{}, error) {
+				ts.Equal("KEY123", token.Header["kid"])
+				return &teamKey.PublicKey, nil
+			})
+			ts.Require().NoError(err)
+			ts.Equal("TEAM123", secret.Issuer)
+			ts.Equal("com.example.app", secret.Subject)
+			ts.True(secret.VerifyAudience(server.URL, true))
+
+			idToken := signTestIDToken(ts, key, jwt.MapClaims{
+				"iss":              server.URL,
+				"sub":              "001234.abcdef.1234",
+				"aud":              "com.example.app",
+				"exp":              time.Now().Add(time.Minute).Unix(),
+				"nonce":            *nonce,
+				"email":            email,
+				"email_verified":   "true",
+				"is_private_email": "true",
+			})
+			fmt.Fprintf(w, `{"access_token":"apple_token","expires_in":100000,"id_token":%q}`, idToken)
+		case "/auth/keys":
+			writeTestJWKS(w, key)
+		default:
+			w.WriteHeader(500)
+			ts.Fail("unknown apple oauth call %s", r.URL.Path)
+		}
+	}))
+
+	ts.Config.External.Apple = conf.AppleProviderConfiguration{
+		ClientID:    "com.example.app",
+		TeamID:      "TEAM123",
+		KeyID:       "KEY123",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		RedirectURI: "https://identity.services.netlify.com/callback",
+		URL:         server.URL,
+		Enabled:     true,
+	}
+
+	return server
+}
+
+func (ts *ExternalTestSuite) TestSignupExternalApple() {
+	tokenCount := 0
+	server := AppleTestSignupSetup(ts, &tokenCount, "authcode", "xyz@privaterelay.appleid.com", new(string))
+	defer server.Close()
+	defer func() { ts.Config.External.Apple = conf.AppleProviderConfiguration{} }()
+
+	w := performAuthorizationRequest(ts, "apple", "")
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+	q := u.Query()
+	ts.Equal("/auth/authorize", u.Path)
+	ts.Equal("com.example.app", q.Get("client_id"))
+	ts.Equal("name email", q.Get("scope"))
+	ts.Equal("form_post", q.Get("response_mode"))
+	ts.NotEmpty(q.Get("nonce"))
+}
+
+func (ts *ExternalTestSuite) TestSignupExternalApple_FormPost() {
+	tokenCount := 0
+	nonce := ""
+	server := AppleTestSignupSetup(ts, &tokenCount, "authcode", "xyz@privaterelay.appleid.com", &nonce)
+	defer server.Close()
+	defer func() { ts.Config.External.Apple = conf.AppleProviderConfiguration{} }()
+
+	w := performAuthorizationRequest(ts, "apple", "")
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+	nonce = u.Query().Get("nonce")
+
+	// Apple posts the callback, with the name of the user on the first sign in
+	form := url.Values{}
+	form.Set("code", "authcode")
+	form.Set("state", u.Query().Get("state"))
+	form.Set("user", `{"name":{"firstName":"Apple","lastName":"Test"},"email":"xyz@privaterelay.appleid.com"}`)
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/callback", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err = url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+
+	v, err := url.ParseQuery(u.Fragment)
+	ts.Require().NoError(err)
+	ts.Require().Empty(v.Get("error_description"))
+	ts.NotEmpty(v.Get("access_token"))
+	ts.Equal(1, tokenCount)
+
+	// private relay addresses are verified by Apple
+	user := ts.findUser("xyz@privaterelay.appleid.com")
+	ts.True(user.IsConfirmed())
+	ts.Equal("Apple Test", user.UserMetaData["full_name"])
+
+	identities := ts.userIdentities(user)
+	ts.Require().Len(identities, 1)
+	ts.Equal("001234.abcdef.1234", identities[0].ProviderID)
+	ts.Equal("true", identities[0].Claims["is_private_email"])
+}
@@ -21,6 +21,9 @@ func (ts *ExternalTestSuite) TestSignupExternalGoogle() {
 	ts.Equal(ts.Config.External.Google.ClientID, q.Get("client_id"))
 	ts.Equal("code", q.Get("response_type"))
 	ts.Equal("email profile", q.Get("scope"))
+	ts.Equal("S256", q.Get("code_challenge_method"))
+	ts.NotEmpty(q.Get("code_challenge"))
+	ts.NotEmpty(q.Get("nonce"))
 
 	claims := ExternalProviderClaims{}
 	p := jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Name}}
@@ -41,6 +44,7 @@ func GoogleTestSignupSetup(ts *ExternalTestSuite, tokenCount *int, userCount *in
 			ts.Equal(code, r.FormValue("code"))
 			ts.Equal("authorization_code", r.FormValue("grant_type"))
 			ts.Equal(ts.Config.External.Google.RedirectURI, r.FormValue("redirect_uri"))
+			ts.NotEmpty(r.FormValue("code_verifier"))
 
 			w.Header().Add("Content-Type", "application/json")
 			fmt.Fprint(w, `{"access_token":"google_token","expires_in":100000}`)
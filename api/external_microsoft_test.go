@@ -0,0 +1,138 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+const microsoftTestTenant = "9122040d-6c67-4c5b-b112-36a304b66dad"
+
+func (ts *ExternalTestSuite) TestSignupExternalMicrosoft() {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/authorize?provider=microsoft", nil)
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+	q := u.Query()
+	ts.Equal("/common/oauth2/v2.0/authorize", u.Path)
+	ts.Equal(ts.Config.External.Microsoft.RedirectURI, q.Get("redirect_uri"))
+	ts.Equal(ts.Config.External.Microsoft.ClientID, q.Get("client_id"))
+	ts.Equal("code", q.Get("response_type"))
+	ts.Equal("openid email profile", q.Get("scope"))
+	ts.NotEmpty(q.Get("nonce"))
+
+	claims := ExternalProviderClaims{}
+	p := jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Name}}
+	_, err = p.ParseWithClaims(q.Get("state"), &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(ts.API.config.OperatorToken), nil
+	})
+	ts.Require().NoError(err)
+
+	ts.Equal("microsoft", claims.Provider)
+	ts.Equal(ts.Config.SiteURL, claims.SiteURL)
+}
+
+func MicrosoftTestSignupSetup(ts *ExternalTestSuite, tokenCount *int, code string, claims jwt.MapClaims, nonce *string) *httptest.Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	ts.Require().NoError(err)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/common/oauth2/v2.0/token":
+			*tokenCount++
+			ts.Equal(code, r.FormValue("code"))
+			ts.Equal("authorization_code", r.FormValue("grant_type"))
+			ts.Equal(ts.Config.External.Microsoft.RedirectURI, r.FormValue("redirect_uri"))
+
+			idToken := jwt.MapClaims{
+				"iss":   fmt.Sprintf("%s/%s/v2.0", server.URL, microsoftTestTenant),
+				"sub":   "pairwise-subject",
+				"aud":   ts.Config.External.Microsoft.ClientID,
+				"exp":   time.Now().Add(time.Minute).Unix(),
+				"nonce": *nonce,
+				"tid":   microsoftTestTenant,
+				"oid":   "00000000-0000-0000-66f3-3332eca7ea81",
+				"name":  "Microsoft Test",
+			}
+			for k, v := range claims {
+				idToken[k] = v
+			}
+			fmt.Fprintf(w, `{"access_token":"microsoft_token","expires_in":100000,"id_token":%q}`, signTestIDToken(ts, key, idToken))
+		case "/common/discovery/v2.0/keys":
+			writeTestJWKS(w, key)
+		default:
+			w.WriteHeader(500)
+			ts.Fail("unknown microsoft oauth call %s", r.URL.Path)
+		}
+	}))
+
+	ts.Config.External.Microsoft.URL = server.URL
+
+	return server
+}
+
+func (ts *ExternalTestSuite) TestSignupExternalMicrosoft_AuthorizationCode() {
+	tokenCount := 0
+	nonce := ""
+	server := MicrosoftTestSignupSetup(ts, &tokenCount, "authcode", jwt.MapClaims{"email": "microsoft@example.com", "xms_edov": true}, &nonce)
+	defer server.Close()
+
+	u := performOIDCAuthorization(ts, "microsoft", "authcode", &nonce)
+
+	v, err := url.ParseQuery(u.Fragment)
+	ts.Require().NoError(err)
+	ts.Require().Empty(v.Get("error_description"))
+	ts.NotEmpty(v.Get("access_token"))
+	ts.NotEmpty(v.Get("refresh_token"))
+	ts.Equal(1, tokenCount)
+
+	user := ts.findUser("microsoft@example.com")
+	ts.Equal("Microsoft Test", user.UserMetaData["full_name"])
+	ts.True(user.IsConfirmed())
+
+	identities := ts.userIdentities(user)
+	ts.Require().Len(identities, 1)
+	// object IDs are scoped to the tenant
+	ts.Equal(microsoftTestTenant+"|00000000-0000-0000-66f3-3332eca7ea81", identities[0].ProviderID)
+}
+
+func (ts *ExternalTestSuite) TestSignupExternalMicrosoftUnverifiedEmail() {
+	ts.createUser("microsoft@example.com", "Microsoft Test", "", "")
+
+	tokenCount := 0
+	nonce := ""
+	// without xms_edov the email may have been set by anyone in the tenant
+	server := MicrosoftTestSignupSetup(ts, &tokenCount, "authcode", jwt.MapClaims{"email": "microsoft@example.com"}, &nonce)
+	defer server.Close()
+
+	u := performOIDCAuthorization(ts, "microsoft", "authcode", &nonce)
+
+	v, err := url.ParseQuery(u.Fragment)
+	ts.Require().NoError(err)
+	ts.Empty(v.Get("access_token"))
+	ts.Equal("User already exists with this email address", v.Get("error_description"))
+}
+
+func (ts *ExternalTestSuite) TestSignupExternalMicrosoftTenantNotAllowed() {
+	ts.Config.External.Microsoft.AllowedTenants = []string{"72f988bf-86f1-41af-91ab-2d7cd011db47"}
+	defer func() { ts.Config.External.Microsoft.AllowedTenants = nil }()
+
+	tokenCount := 0
+	nonce := ""
+	server := MicrosoftTestSignupSetup(ts, &tokenCount, "authcode", jwt.MapClaims{"email": "microsoft@example.com", "xms_edov": true}, &nonce)
+	defer server.Close()
+
+	u := performOIDCAuthorization(ts, "microsoft", "authcode", &nonce)
+
+	assertAuthorizationFailure(ts, u, "Error getting user email from external provider", "server_error", "microsoft@example.com")
+}
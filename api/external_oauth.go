@@ -5,52 +5,59 @@ import (
 	"net/http"
 
 	"github.com/tigrisdata/gotrue/api/provider"
+	"golang.org/x/oauth2"
 )
 
 // loadOAuthState parses the `state` query parameter as a JWS payload,
 // extracting the provider requested
 func (a *API) loadOAuthState(w http.ResponseWriter, r *http.Request) (context.Context, error) {
-	state := r.URL.Query().Get("state")
+	state := r.FormValue("state")
 	if state == "" {
 		return nil, badRequestError("OAuth state parameter missing")
 	}
 
 	ctx := r.Context()
-	return a.loadExternalState(ctx, state)
+	return a.loadExternalState(ctx, r, state)
 }
 
-func (a *API) oAuthCallback(ctx context.Context, r *http.Request, providerType string) (*provider.UserProvidedData, error) {
-	rq := r.URL.Query()
-
-	extError := rq.Get("error")
+// oAuthCallback exchanges the authorization code and returns the user data
+// along with the token the provider issued
+func (a *API) oAuthCallback(ctx context.Context, r *http.Request, providerType string) (*provider.UserProvidedData, *oauth2.Token, error) {
+	extError := r.FormValue("error")
 	if extError != "" {
-		return nil, oauthError(extError, rq.Get("error_description"))
+		return nil, nil, oauthError(extError, r.FormValue("error_description"))
 	}
 
-	oauthCode := rq.Get("code")
+	oauthCode := r.FormValue("code")
 	if oauthCode == "" {
-		return nil, badRequestError("Authorization code missing")
+		return nil, nil, badRequestError("Authorization code missing")
 	}
 
 	oAuthProvider, err := a.OAuthProvider(ctx, providerType)
 	if err != nil {
-		return nil, badRequestError("Unsupported provider: %+v", err).WithInternalError(err)
+		return nil, nil, badRequestError("Unsupported provider: %+v", err).WithInternalError(err)
 	}
 
 	log := getLogEntry(r).With().Str("provider", providerType).Str("code", oauthCode).Logger()
 	log.Debug().Msg("Exchanging oauth code")
 
-	tok, err := oAuthProvider.GetOAuthToken(oauthCode)
+	tok, err := oAuthProvider.GetOAuthToken(ctx, oauthCode, oauth2.SetAuthURLParam("code_verifier", getExternalCodeVerifier(ctx)))
 	if err != nil {
-		return nil, internalServerError("Unable to exchange external code: %s", oauthCode).WithInternalError(err)
+		return nil, nil, internalServerError("Unable to exchange external code: %s", oauthCode).WithInternalError(err)
 	}
 
 	userData, err := oAuthProvider.GetUserData(ctx, tok)
 	if err != nil {
-		return nil, internalServerError("Error getting user email from external provider").WithInternalError(err)
+		return nil, nil, internalServerError("Error getting user email from external provider").WithInternalError(err)
+	}
+
+	if providerType == "apple" {
+		if name := provider.AppleUserName(r.FormValue("user")); name != "" {
+			userData.Metadata["full_name"] = name
+		}
 	}
 
-	return userData, nil
+	return userData, tok, nil
 }
 
 func (a *API) OAuthProvider(ctx context.Context, name string) (provider.OAuthProvider, error) {
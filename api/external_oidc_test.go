@@ -0,0 +1,131 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/tigrisdata/gotrue/conf"
+)
+
+func OIDCTestSignupSetup(ts *ExternalTestSuite, tokenCount *int, code string, email string, nonce *string) *httptest.Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	ts.Require().NoError(err)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"issuer":%q,"authorization_endpoint":"%[1]s/authorize","token_endpoint":"%[1]s/token","jwks_uri":"%[1]s/jwks"}`, server.URL)
+		case "/jwks":
+			writeTestJWKS(w, key)
+		case "/token":
+			*tokenCount++
+			ts.Equal(code, r.FormValue("code"))
+			ts.Equal("authorization_code", r.FormValue("grant_type"))
+
+			idToken := signTestIDToken(ts, key, jwt.MapClaims{
+				"iss":            server.URL,
+				"sub":            "oidc-subject",
+				"aud":            "testclientid",
+				"exp":            time.Now().Add(time.Minute).Unix(),
+				"nonce":          *nonce,
+				"email":          email,
+				"email_verified": true,
+				"name":           "OIDC Test",
+				"picture":        "http://example.com/avatar",
+			})
+			fmt.Fprintf(w, `{"access_token":"oidc_token","expires_in":100000,"id_token":%q}`, idToken)
+		default:
+			w.WriteHeader(500)
+			ts.Fail("unknown oidc call %s", r.URL.Path)
+		}
+	}))
+
+	ts.Config.External.OIDC = conf.OIDCProviders{{
+		Name:        "corp",
+		Issuer:      server.URL,
+		ClientID:    "testclientid",
+		Secret:      "testsecret",
+		RedirectURI: "https://identity.services.netlify.com/callback",
+		Enabled:     true,
+	}}
+
+	return server
+}
+
+// writeTestJWKS serves the public key the test ID tokens are signed with
+func writeTestJWKS(w http.ResponseWriter, key *rsa.PrivateKey) {
+	n := base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	fmt.Fprintf(w, `{"keys":[{"kty":"RSA","kid":"test","n":%q,"e":%q}]}`, n, e)
+}
+
+func signTestIDToken(ts *ExternalTestSuite, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	idToken, err := token.SignedString(key)
+	ts.Require().NoError(err)
+	return idToken
+}
+
+// performOIDCAuthorization runs the authorization flow, handing the nonce of
+// the authorization request to the fake provider before the callback
+func performOIDCAuthorization(ts *ExternalTestSuite, provider string, code string, nonce *string) *url.URL {
+	w := performAuthorizationRequest(ts, provider, "")
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+	q := u.Query()
+	if *nonce == "" {
+		*nonce = q.Get("nonce")
+	}
+
+	testURL, err := url.Parse("http://localhost/callback")
+	ts.Require().NoError(err)
+	v := testURL.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	testURL.RawQuery = v.Encode()
+	req := httptest.NewRequest(http.MethodGet, testURL.String(), nil)
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err = url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+
+	return u
+}
+
+func (ts *ExternalTestSuite) TestSignupExternalOIDC() {
+	tokenCount := 0
+	nonce := ""
+	server := OIDCTestSignupSetup(ts, &tokenCount, "authcode", "oidc@example.com", &nonce)
+	defer server.Close()
+	defer func() { ts.Config.External.OIDC = nil }()
+
+	u := performOIDCAuthorization(ts, "corp", "authcode", &nonce)
+
+	// the profile comes with the ID token of the single token request
+	assertAuthorizationSuccess(ts, u, tokenCount, tokenCount, "oidc@example.com", "OIDC Test", "http://example.com/avatar")
+}
+
+func (ts *ExternalTestSuite) TestSignupExternalOIDCNonceMismatch() {
+	tokenCount := 0
+	nonce := "replayed-nonce"
+	server := OIDCTestSignupSetup(ts, &tokenCount, "authcode", "oidc@example.com", &nonce)
+	defer server.Close()
+	defer func() { ts.Config.External.OIDC = nil }()
+
+	u := performOIDCAuthorization(ts, "corp", "authcode", &nonce)
+
+	assertAuthorizationFailure(ts, u, "Error getting user email from external provider", "server_error", "oidc@example.com")
+}
@@ -3,25 +3,113 @@ package api
 import (
 	"context"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
+	saml2 "github.com/russellhaering/gosaml2"
 	"github.com/tigrisdata/gotrue/api/provider"
+	"github.com/tigrisdata/gotrue/models"
 )
 
+// samlAssertionReplayWindow is how long assertions without an expiry are kept
+// to reject replays of them
+const samlAssertionReplayWindow = 24 * time.Hour
+
 func (a *API) loadSAMLState(w http.ResponseWriter, r *http.Request) (context.Context, error) {
 	state := r.FormValue("RelayState")
-	if state == "" {
+
+	ctx := r.Context()
+
+	// responses the identity provider sends unsolicited carry no state of ours,
+	// their relay state is at most a hint of the identity provider
+	if state == "" || strings.Count(state, ".") != 2 {
+		return a.loadIdPInitiatedState(ctx, r.FormValue("SAMLResponse"))
+	}
+
+	return a.loadExternalState(ctx, r, state)
+}
+
+// loadIdPInitiatedState accepts a sign in the identity provider started, if the
+// provider that issued the response allows it
+func (a *API) loadIdPInitiatedState(ctx context.Context, samlResponse string) (context.Context, error) {
+	if samlResponse == "" {
 		return nil, badRequestError("SAML RelayState is missing")
 	}
 
-	ctx := r.Context()
+	issuer, err := provider.SamlIssuer(samlResponse)
+	if err != nil {
+		return nil, badRequestError("SAML Response is invalid: %v", err)
+	}
 
-	return a.loadExternalState(ctx, state)
+	ctx, samlProvider, err := a.samlProviderForIssuer(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if !samlProvider.Config.AllowIdPInitiated {
+		return nil, forbiddenError("IdP-initiated sign in is not allowed for %s", issuer)
+	}
+
+	return withExternalProviderType(ctx, "saml"), nil
 }
 
-func (a *API) samlCallback(r *http.Request, ctx context.Context) (*provider.UserProvidedData, error) {
+// samlProvider creates the SAML provider users sign in with: the SSO provider
+// of their domain if one was picked, the one configured for the instance
+// otherwise. SSO providers share the service provider settings of the instance.
+func (a *API) samlProvider(ctx context.Context) (*provider.SamlProvider, error) {
+	config := a.getConfig(ctx)
+
+	ext := config.External.Saml
+	if ssoProvider := getSSOProvider(ctx); ssoProvider != nil {
+		ext.Enabled = true
+		ext.Name = ssoProvider.Name
+		ext.MetadataURL = ssoProvider.MetadataURL
+		ext.MetadataXML = ssoProvider.MetadataXML
+		ext.MetadataSigningCert = ssoProvider.MetadataSigningCert
+		ext.AttributeMapping = ssoProvider.AttributeMapping
+		ext.AllowIdPInitiated = ssoProvider.AllowIdPInitiated
+		return provider.NewSSOSamlProvider(ext, a.db, getInstanceID(ctx), ssoProvider.ID)
+	}
+	return provider.NewSamlProvider(ext, a.db, getInstanceID(ctx))
+}
+
+// samlProviderForIssuer finds the SAML provider of the identity provider that
+// issued a message: the one configured for the instance or one of its SSO
+// providers. SSO providers are added to the returned context.
+func (a *API) samlProviderForIssuer(ctx context.Context, issuer string) (context.Context, *provider.SamlProvider, error) {
 	config := a.getConfig(ctx)
 
-	samlProvider, err := provider.NewSamlProvider(config.External.Saml, a.db, getInstanceID(ctx))
+	if config.External.Saml.Enabled {
+		samlProvider, err := a.samlProvider(ctx)
+		if err != nil {
+			return nil, nil, badRequestError("Could not initialize SAML provider: %+v", err).WithInternalError(err)
+		}
+		if samlProvider.ServiceProvider.IdentityProviderIssuer == issuer {
+			return ctx, samlProvider, nil
+		}
+	}
+
+	ssoProviders, err := models.FindSSOProvidersByInstance(ctx, a.db, getInstanceID(ctx))
+	if err != nil {
+		return nil, nil, internalServerError("Database error finding SSO providers").WithInternalError(err)
+	}
+	for _, ssoProvider := range ssoProviders {
+		ssoCtx := withSSOProvider(ctx, ssoProvider)
+		samlProvider, err := a.samlProvider(ssoCtx)
+		if err != nil {
+			// an unreachable identity provider must not lock out the others
+			continue
+		}
+		if samlProvider.ServiceProvider.IdentityProviderIssuer == issuer {
+			return ssoCtx, samlProvider, nil
+		}
+	}
+
+	return nil, nil, badRequestError("Unknown SAML issuer %s", issuer)
+}
+
+func (a *API) samlCallback(r *http.Request, ctx context.Context) (*provider.UserProvidedData, error) {
+	samlProvider, err := a.samlProvider(ctx)
 	if err != nil {
 		return nil, badRequestError("Could not initialize SAML provider: %+v", err).WithInternalError(err)
 	}
@@ -36,6 +124,10 @@ func (a *API) samlCallback(r *http.Request, ctx context.Context) (*provider.User
 		return nil, internalServerError("Parsing SAML assertion failed: %+v", err).WithInternalError(err)
 	}
 
+	if assertionInfo == nil {
+		return nil, internalServerError("SAML Assertion is missing")
+	}
+
 	if assertionInfo.WarningInfo.InvalidTime {
 		return nil, forbiddenError("SAML response has invalid time")
 	}
@@ -44,23 +136,285 @@ func (a *API) samlCallback(r *http.Request, ctx context.Context) (*provider.User
 		return nil, forbiddenError("SAML response is not in audience")
 	}
 
-	if assertionInfo == nil {
-		return nil, internalServerError("SAML Assertion is missing")
+	if err := a.consumeSAMLAssertions(ctx, assertionInfo); err != nil {
+		return nil, err
 	}
-	userData := &provider.UserProvidedData{
-		Emails: []provider.Email{{
-			Email:    assertionInfo.NameID,
-			Verified: true,
-		}},
+
+	userData := samlProvider.UserData(assertionInfo)
+	if ssoProvider := getSSOProvider(ctx); ssoProvider != nil {
+		// identity providers only vouch for the emails of their own domains,
+		// others could be used to sign in as users of another domain
+		for i, e := range userData.Emails {
+			if !ssoProvider.HasDomain(emailDomain(e.Email)) {
+				return nil, forbiddenError("The identity provider cannot sign in users of %s", emailDomain(e.Email))
+			}
+			userData.Emails[i].Verified = true
+		}
 	}
 	return userData, nil
 }
 
+// consumeSAMLAssertions rejects assertions that were already used to sign in.
+// Assertions are kept until they expire.
+func (a *API) consumeSAMLAssertions(ctx context.Context, info *saml2.AssertionInfo) error {
+	instanceID := getInstanceID(ctx)
+
+	for _, assertion := range info.Assertions {
+		if assertion.ID == "" {
+			return forbiddenError("SAML assertion has no ID")
+		}
+
+		expiresAt := time.Now().Add(samlAssertionReplayWindow)
+		if assertion.Conditions != nil && assertion.Conditions.NotOnOrAfter != "" {
+			if t, err := time.Parse(time.RFC3339, assertion.Conditions.NotOnOrAfter); err == nil {
+				expiresAt = t
+			}
+		}
+
+		if err := models.ConsumeSAMLAssertion(ctx, a.db, instanceID, assertion.ID, expiresAt); err != nil {
+			if models.IsSAMLAssertionReplayedError(err) {
+				return forbiddenError("SAML assertion was already used")
+			}
+			return internalServerError("Database error recording SAML assertion").WithInternalError(err)
+		}
+	}
+	return nil
+}
+
+// samlIdentityProvider is the provider SAML identities are linked under. Name
+// IDs are only unique within their identity provider, so the identities of SSO
+// providers are kept apart by the ID of the provider.
+func samlIdentityProvider(ctx context.Context) string {
+	if ssoProvider := getSSOProvider(ctx); ssoProvider != nil {
+		return "saml:" + ssoProvider.ID.String()
+	}
+	return "saml"
+}
+
+// isSAMLIdentityProvider checks whether identities of the provider were linked
+// through SAML
+func isSAMLIdentityProvider(providerType string) bool {
+	return providerType == "saml" || strings.HasPrefix(providerType, "saml:")
+}
+
+// samlIdentityContext adds the SSO provider a SAML identity was linked through
+// to the context
+func (a *API) samlIdentityContext(ctx context.Context, identity *models.Identity) (context.Context, error) {
+	id, found := strings.CutPrefix(identity.Provider, "saml:")
+	if !found {
+		return ctx, nil
+	}
+	ssoProviderID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ssoProvider, err := models.FindSSOProviderByID(ctx, a.db, getInstanceID(ctx), ssoProviderID)
+	if err != nil {
+		return nil, err
+	}
+	return withSSOProvider(ctx, ssoProvider), nil
+}
+
+// findSAMLIdentity finds the SAML identity the user signed in with last
+func (a *API) findSAMLIdentity(ctx context.Context, user *models.User) (*models.Identity, error) {
+	identities, err := models.FindIdentitiesByUser(ctx, a.db, user)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *models.Identity
+	for _, i := range identities {
+		if !isSAMLIdentityProvider(i.Provider) {
+			continue
+		}
+		if latest == nil || (i.LastSignInAt != nil && (latest.LastSignInAt == nil || i.LastSignInAt.After(*latest.LastSignInAt))) {
+			latest = i
+		}
+	}
+	if latest == nil {
+		return nil, models.IdentityNotFoundError{}
+	}
+	return latest, nil
+}
+
+// SAMLLogout signs the user out and returns the URL that ends their session at
+// the identity provider, if it supports Single Logout
+func (a *API) SAMLLogout(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	instanceID := getInstanceID(ctx)
+
+	a.clearCookieToken(ctx, w)
+
+	user, err := getUserFromClaims(ctx, a.db)
+	if err != nil {
+		return unauthorizedError("Invalid user").WithInternalError(err)
+	}
+
+	identity, err := a.findSAMLIdentity(ctx, user)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return notFoundError("User has no SAML identity")
+		}
+		return internalServerError("Database error finding identity").WithInternalError(err)
+	}
+
+	err = a.db.Tx(ctx, func(ctx context.Context) error {
+		if terr := models.NewAuditLogEntry(ctx, a.db, instanceID, user, models.LogoutAction, map[string]interface{}{
+			"provider": "saml",
+		}); terr != nil {
+			return terr
+		}
+		if terr := a.revokeAccessToken(ctx, getClaims(ctx)); terr != nil {
+			return terr
+		}
+		if getClaims(ctx).Act != nil {
+			return nil
+		}
+		return a.revokeUserTokens(ctx, user)
+	})
+	if err != nil {
+		return internalServerError("Error logging out user").WithInternalError(err)
+	}
+	// tokens acting on behalf of the user must not end the sessions of the
+	// user, here or at the identity provider
+	if getClaims(ctx).Act != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	ctx, err = a.samlIdentityContext(ctx, identity)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			// the SSO provider was removed, so there is no session to end there
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+		return internalServerError("Database error finding SSO provider").WithInternalError(err)
+	}
+
+	samlProvider, err := a.samlProvider(ctx)
+	if err != nil {
+		return internalServerError("Could not initialize SAML provider: %+v", err).WithInternalError(err)
+	}
+	sessionIndex, _ := identity.Claims[provider.SamlSessionIndexClaim].(string)
+	logoutURL, err := samlProvider.LogoutURL(identity.ProviderID, sessionIndex)
+	if err != nil {
+		return internalServerError("Error building SAML logout request").WithInternalError(err)
+	}
+	if logoutURL == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	return sendJSON(w, http.StatusOK, map[string]string{
+		"url": logoutURL,
+	})
+}
+
+// SAMLSingleLogout receives the Single Logout messages of identity providers:
+// requests to sign a user out, and responses to logouts started here
+func (a *API) SAMLSingleLogout(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	instanceID := getInstanceID(ctx)
+
+	if samlResponse := r.FormValue("SAMLResponse"); samlResponse != "" {
+		issuer, err := provider.SamlIssuer(samlResponse)
+		if err != nil {
+			return badRequestError("SAML logout response is invalid: %v", err)
+		}
+		_, samlProvider, err := a.samlProviderForIssuer(ctx, issuer)
+		if err != nil {
+			return err
+		}
+		if _, err := samlProvider.ServiceProvider.ValidateEncodedLogoutResponsePOST(samlResponse); err != nil {
+			return badRequestError("SAML logout response is invalid: %v", err)
+		}
+
+		http.Redirect(w, r, a.getExternalRedirectURL(r), http.StatusFound)
+		return nil
+	}
+
+	samlRequest := r.FormValue("SAMLRequest")
+	if samlRequest == "" {
+		return badRequestError("SAML logout request is missing")
+	}
+	issuer, err := provider.SamlIssuer(samlRequest)
+	if err != nil {
+		return badRequestError("SAML logout request is invalid: %v", err)
+	}
+	ctx, samlProvider, err := a.samlProviderForIssuer(ctx, issuer)
+	if err != nil {
+		return err
+	}
+
+	request, err := samlProvider.ServiceProvider.ValidateEncodedLogoutRequestPOST(samlRequest)
+	if err != nil {
+		return badRequestError("SAML logout request is invalid: %v", err)
+	}
+	// anyone could sign users out with unsigned requests
+	if !request.SignatureValidated {
+		return forbiddenError("SAML logout request is not signed")
+	}
+	if request.NameID == nil || request.NameID.Value == "" {
+		return badRequestError("SAML logout request has no NameID")
+	}
+
+	identity, err := models.FindIdentityByProviderID(ctx, a.db, instanceID, samlIdentityProvider(ctx), request.NameID.Value)
+	if err != nil && !models.IsNotFoundError(err) {
+		return internalServerError("Database error finding identity").WithInternalError(err)
+	}
+	// users unknown here have no session to end
+	if identity != nil {
+		user, err := models.FindUserByInstanceIDAndID(ctx, a.db, instanceID, identity.UserID)
+		if err != nil {
+			return internalServerError("Database error finding user").WithInternalError(err)
+		}
+
+		err = a.db.Tx(ctx, func(ctx context.Context) error {
+			if terr := models.NewAuditLogEntry(ctx, a.db, instanceID, user, models.LogoutAction, map[string]interface{}{
+				"provider":      "saml",
+				"idp_initiated": true,
+			}); terr != nil {
+				return terr
+			}
+			return a.revokeUserTokens(ctx, user)
+		})
+		if err != nil {
+			return internalServerError("Error logging out user").WithInternalError(err)
+		}
+	}
+
+	form, err := samlProvider.LogoutResponseForm(request.ID, r.FormValue("RelayState"))
+	if err != nil {
+		return internalServerError("Error building SAML logout response").WithInternalError(err)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusOK)
+	w.Write(form)
+	return nil
+}
+
 func (a *API) SAMLMetadata(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
-	config := getConfig(ctx)
 
-	samlProvider, err := provider.NewSamlProvider(config.External.Saml, a.db, getInstanceID(ctx))
+	// instances that only use SSO providers have no SAML provider configured
+	if id := r.URL.Query().Get("provider_id"); id != "" {
+		ssoProviderID, err := uuid.Parse(id)
+		if err != nil {
+			return badRequestError("Invalid SSO provider ID")
+		}
+		ssoProvider, err := models.FindSSOProviderByID(ctx, a.db, getInstanceID(ctx), ssoProviderID)
+		if err != nil {
+			if models.IsNotFoundError(err) {
+				return notFoundError(err.Error())
+			}
+			return internalServerError("Database error finding SSO provider").WithInternalError(err)
+		}
+		ctx = withSSOProvider(ctx, ssoProvider)
+	}
+
+	samlProvider, err := a.samlProvider(ctx)
 	if err != nil {
 		return internalServerError("Could not create SAML Provider: %+v", err).WithInternalError(err)
 	}
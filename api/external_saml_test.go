@@ -1,8 +1,10 @@
 package api
 
 import (
+	"context"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	"encoding/xml"
 	"html/template"
@@ -23,6 +25,7 @@ import (
 	dsig "github.com/russellhaering/goxmldsig"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"github.com/tigrisdata/tigris-client-go/tigris"
 )
 
 type ExternalSamlTestSuite struct {
@@ -80,8 +83,12 @@ func (ts *ExternalSamlTestSuite) setupSamlExampleResponse(keyStore dsig.X509KeyS
 		NotAfter:  now.Add(5 * time.Minute).Format(time.RFC3339),
 	})
 
-	// sign
-	resp := doc.SelectElement("Response")
+	return ts.signSamlDocument(doc, "Response", keyStore)
+}
+
+// signSamlDocument signs the root of a SAML message like an identity provider
+func (ts *ExternalSamlTestSuite) signSamlDocument(doc *etree.Document, root string, keyStore dsig.X509KeyStore) string {
+	resp := doc.SelectElement(root)
 	ctx := dsig.NewDefaultSigningContext(keyStore)
 	sig, err := ctx.ConstructSignature(resp, true)
 	ts.Require().NoError(err, "Response signature failed")
@@ -216,3 +223,318 @@ func (ts *ExternalSamlTestSuite) TestMetadata() {
 		}
 	}
 }
+
+func (ts *ExternalSamlTestSuite) TestSSOProviderRoutedByDomain() {
+	server, idpKeyStore := ts.setupSamlMetadata()
+	defer server.Close()
+
+	key, cert := ts.setupSamlSPCert()
+	ts.Config.External.Saml.SigningKey = key
+	ts.Config.External.Saml.SigningCert = cert
+	// SSO providers are used without a SAML provider of the instance
+	ts.Config.External.Saml.Enabled = false
+	defer func() { ts.Config.External.Saml.Enabled = true }()
+
+	ssoProvider, err := models.NewSSOProvider(context.TODO(), ts.API.db, ts.instanceID, models.SSOProvider{
+		Name:        "Example",
+		MetadataURL: server.URL,
+		Domains:     []string{"example.com"},
+	})
+	ts.Require().NoError(err)
+
+	// the same name ID at another identity provider is another account
+	other, err := models.NewUser(ts.instanceID, "other@example.com", "test", ts.Config.JWT.Aud, nil, ts.API.encrypter)
+	ts.Require().NoError(err)
+	_, err = tigris.GetCollection[models.User](ts.API.db).Insert(context.TODO(), other)
+	ts.Require().NoError(err)
+	_, err = models.NewIdentity(context.TODO(), ts.API.db, other, "saml", "saml@example.com", "other@example.com", nil)
+	ts.Require().NoError(err)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/authorize?domain=other.com", nil)
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusNotFound, w.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost/authorize?email=alice@Example.com", nil)
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+	ts.Equal("idp", u.Host)
+	state := u.Query().Get("RelayState")
+	ts.Require().NotEmpty(state)
+
+	form := url.Values{}
+	form.Add("RelayState", state)
+	form.Add("SAMLResponse", ts.setupSamlExampleResponse(idpKeyStore))
+	req = httptest.NewRequest(http.MethodPost, "http://localhost/saml/acs", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err = url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+	v, err := url.ParseQuery(u.Fragment)
+	ts.Require().NoError(err)
+	ts.Empty(v.Get("error_description"))
+	ts.NotEmpty(v.Get("access_token"))
+
+	user, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "saml@example.com", ts.Config.JWT.Aud)
+	ts.Require().NoError(err)
+	identities, err := models.FindIdentitiesByUser(context.TODO(), ts.API.db, user)
+	ts.Require().NoError(err)
+	ts.Require().Len(identities, 1)
+	// name IDs are scoped to the identity provider
+	ts.Equal("saml:"+ssoProvider.ID.String(), identities[0].Provider)
+	ts.Equal("saml@example.com", identities[0].ProviderID)
+}
+
+func (ts *ExternalSamlTestSuite) TestSSOProviderEmailOutsideDomains() {
+	server, idpKeyStore := ts.setupSamlMetadata()
+	defer server.Close()
+
+	key, cert := ts.setupSamlSPCert()
+	ts.Config.External.Saml.SigningKey = key
+	ts.Config.External.Saml.SigningCert = cert
+	// autoconfirm does not make up for the identity provider not vouching
+	ts.Config.Mailer.Autoconfirm = true
+	defer func() { ts.Config.Mailer.Autoconfirm = false }()
+
+	_, err := models.NewSSOProvider(context.TODO(), ts.API.db, ts.instanceID, models.SSOProvider{
+		Name:        "Corp",
+		MetadataURL: server.URL,
+		Domains:     []string{"corp.com"},
+	})
+	ts.Require().NoError(err)
+	existing, err := models.NewUser(ts.instanceID, "saml@example.com", "test", ts.Config.JWT.Aud, nil, ts.API.encrypter)
+	ts.Require().NoError(err)
+	_, err = tigris.GetCollection[models.User](ts.API.db).Insert(context.TODO(), existing)
+	ts.Require().NoError(err)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/authorize?domain=corp.com", nil)
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+
+	// the identity provider of corp.com asserts an example.com user
+	form := url.Values{}
+	form.Add("RelayState", u.Query().Get("RelayState"))
+	form.Add("SAMLResponse", ts.setupSamlExampleResponse(idpKeyStore))
+	req = httptest.NewRequest(http.MethodPost, "http://localhost/saml/acs", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err = url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+	v, err := url.ParseQuery(u.Fragment)
+	ts.Require().NoError(err)
+	ts.Empty(v.Get("access_token"))
+	ts.Equal("The identity provider cannot sign in users of example.com", v.Get("error_description"))
+
+	identities, err := models.FindIdentitiesByUser(context.TODO(), ts.API.db, existing)
+	ts.Require().NoError(err)
+	ts.Empty(identities)
+}
+
+func (ts *ExternalSamlTestSuite) setupSamlExampleLogoutRequest(keyStore dsig.X509KeyStore) string {
+	path := filepath.Join("testdata", "saml-logout-request.xml")
+	doc := ts.docFromTemplate(path, struct{ Now string }{
+		Now: time.Now().Format(time.RFC3339),
+	})
+	return ts.signSamlDocument(doc, "LogoutRequest", keyStore)
+}
+
+func (ts *ExternalSamlTestSuite) postSamlResponse(relayState, samlResponse string) *httptest.ResponseRecorder {
+	form := url.Values{}
+	if relayState != "" {
+		form.Add("RelayState", relayState)
+	}
+	form.Add("SAMLResponse", samlResponse)
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/saml/acs", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *ExternalSamlTestSuite) signInWithSaml(idpKeyStore dsig.X509KeyStore) url.Values {
+	w := ts.postSamlResponse(ts.setupSamlExampleState(), ts.setupSamlExampleResponse(idpKeyStore))
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+	v, err := url.ParseQuery(u.Fragment)
+	ts.Require().NoError(err)
+	ts.Require().Empty(v.Get("error_description"))
+	ts.Require().NotEmpty(v.Get("access_token"))
+	return v
+}
+
+func (ts *ExternalSamlTestSuite) TestSamlAttributeMapping() {
+	server, idpKeyStore := ts.setupSamlMetadata()
+	defer server.Close()
+	ts.Config.External.Saml.MetadataURL = server.URL
+
+	key, cert := ts.setupSamlSPCert()
+	ts.Config.External.Saml.SigningKey = key
+	ts.Config.External.Saml.SigningCert = cert
+	ts.Config.External.Saml.AttributeMapping = conf.SamlAttributeMapping{
+		Metadata: map[string]string{"full_name": "displayName"},
+		Role:     "groups",
+		Roles:    map[string]string{"admins": "saml_admin"},
+	}
+	defer func() { ts.Config.External.Saml.AttributeMapping = conf.SamlAttributeMapping{} }()
+
+	ts.signInWithSaml(idpKeyStore)
+
+	user, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "saml@example.com", ts.Config.JWT.Aud)
+	ts.Require().NoError(err)
+	ts.Equal("Saml Test", user.UserMetaData["full_name"])
+	ts.Equal("saml_admin", user.Role)
+
+	identity, err := models.FindIdentityByUserAndProvider(context.TODO(), ts.API.db, user, "saml")
+	ts.Require().NoError(err)
+	ts.Equal("_session12345test", identity.Claims["session_index"])
+}
+
+func (ts *ExternalSamlTestSuite) TestSamlAssertionReplay() {
+	server, idpKeyStore := ts.setupSamlMetadata()
+	defer server.Close()
+	ts.Config.External.Saml.MetadataURL = server.URL
+
+	key, cert := ts.setupSamlSPCert()
+	ts.Config.External.Saml.SigningKey = key
+	ts.Config.External.Saml.SigningCert = cert
+
+	samlResponse := ts.setupSamlExampleResponse(idpKeyStore)
+	w := ts.postSamlResponse(ts.setupSamlExampleState(), samlResponse)
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+	v, err := url.ParseQuery(u.Fragment)
+	ts.Require().NoError(err)
+	ts.NotEmpty(v.Get("access_token"))
+
+	// the same response with a fresh state
+	w = ts.postSamlResponse(ts.setupSamlExampleState(), samlResponse)
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err = url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+	v, err = url.ParseQuery(u.Fragment)
+	ts.Require().NoError(err)
+	ts.Empty(v.Get("access_token"))
+	ts.Equal("SAML assertion was already used", v.Get("error_description"))
+}
+
+func (ts *ExternalSamlTestSuite) TestSamlIdPInitiated() {
+	server, idpKeyStore := ts.setupSamlMetadata()
+	defer server.Close()
+	ts.Config.External.Saml.MetadataURL = server.URL
+
+	key, cert := ts.setupSamlSPCert()
+	ts.Config.External.Saml.SigningKey = key
+	ts.Config.External.Saml.SigningCert = cert
+
+	// not allowed by default
+	w := ts.postSamlResponse("", ts.setupSamlExampleResponse(idpKeyStore))
+	ts.Require().Equal(http.StatusForbidden, w.Code)
+
+	ts.Config.External.Saml.AllowIdPInitiated = true
+	defer func() { ts.Config.External.Saml.AllowIdPInitiated = false }()
+
+	samlResponse := ts.setupSamlExampleResponse(idpKeyStore)
+	w = ts.postSamlResponse("https://app.example.com/dashboard", samlResponse)
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+	// the relay state of the identity provider is no redirect target
+	ts.True(strings.HasPrefix(u.String(), ts.Config.SiteURL))
+	v, err := url.ParseQuery(u.Fragment)
+	ts.Require().NoError(err)
+	ts.Empty(v.Get("error_description"))
+	ts.NotEmpty(v.Get("access_token"))
+
+	w = ts.postSamlResponse("", samlResponse)
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err = url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+	v, err = url.ParseQuery(u.Fragment)
+	ts.Require().NoError(err)
+	ts.Empty(v.Get("access_token"))
+}
+
+func (ts *ExternalSamlTestSuite) TestSamlLogout() {
+	server, idpKeyStore := ts.setupSamlMetadata()
+	defer server.Close()
+	ts.Config.External.Saml.MetadataURL = server.URL
+
+	key, cert := ts.setupSamlSPCert()
+	ts.Config.External.Saml.SigningKey = key
+	ts.Config.External.Saml.SigningCert = cert
+
+	v := ts.signInWithSaml(idpKeyStore)
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/saml/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+v.Get("access_token"))
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusOK, w.Code)
+
+	data := map[string]string{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(&data))
+	u, err := url.Parse(data["url"])
+	ts.Require().NoError(err)
+	ts.Equal("https://idp/saml2test/slo", u.Scheme+"://"+u.Host+u.Path)
+	ts.NotEmpty(u.Query().Get("SAMLRequest"))
+	ts.NotEmpty(u.Query().Get("Signature"))
+
+	_, _, err = models.FindUserWithRefreshToken(context.TODO(), ts.API.db, v.Get("refresh_token"))
+	ts.Require().Error(err)
+}
+
+func (ts *ExternalSamlTestSuite) TestSamlSingleLogout() {
+	server, idpKeyStore := ts.setupSamlMetadata()
+	defer server.Close()
+	ts.Config.External.Saml.MetadataURL = server.URL
+
+	key, cert := ts.setupSamlSPCert()
+	ts.Config.External.Saml.SigningKey = key
+	ts.Config.External.Saml.SigningCert = cert
+
+	v := ts.signInWithSaml(idpKeyStore)
+
+	// unsigned logout requests are rejected
+	unsigned := ts.docFromTemplate(filepath.Join("testdata", "saml-logout-request.xml"), struct{ Now string }{
+		Now: time.Now().Format(time.RFC3339),
+	})
+	raw, err := unsigned.WriteToBytes()
+	ts.Require().NoError(err)
+	form := url.Values{}
+	form.Add("SAMLRequest", base64.StdEncoding.EncodeToString(raw))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/saml/slo", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusForbidden, w.Code)
+
+	_, _, err = models.FindUserWithRefreshToken(context.TODO(), ts.API.db, v.Get("refresh_token"))
+	ts.Require().NoError(err)
+
+	form = url.Values{}
+	form.Add("SAMLRequest", ts.setupSamlExampleLogoutRequest(idpKeyStore))
+	form.Add("RelayState", "idp-state")
+	req = httptest.NewRequest(http.MethodPost, "http://localhost/saml/slo", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusOK, w.Code)
+	ts.Contains(w.Body.String(), `action="https://idp/saml2test/slo/post"`)
+	ts.Contains(w.Body.String(), `name="SAMLResponse"`)
+	ts.Contains(w.Body.String(), `value="idp-state"`)
+
+	_, _, err = models.FindUserWithRefreshToken(context.TODO(), ts.API.db, v.Get("refresh_token"))
+	ts.Require().Error(err)
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+func (ts *ExternalTestSuite) TestSignupExternalSlack() {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/authorize?provider=slack", nil)
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+	q := u.Query()
+	ts.Equal("/openid/connect/authorize", u.Path)
+	ts.Equal(ts.Config.External.Slack.RedirectURI, q.Get("redirect_uri"))
+	ts.Equal(ts.Config.External.Slack.ClientID, q.Get("client_id"))
+	ts.Equal("code", q.Get("response_type"))
+	ts.Equal("openid email profile", q.Get("scope"))
+
+	claims := ExternalProviderClaims{}
+	p := jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Name}}
+	_, err = p.ParseWithClaims(q.Get("state"), &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(ts.API.config.OperatorToken), nil
+	})
+	ts.Require().NoError(err)
+
+	ts.Equal("slack", claims.Provider)
+	ts.Equal(ts.Config.SiteURL, claims.SiteURL)
+}
+
+func SlackTestSignupSetup(ts *ExternalTestSuite, tokenCount *int, userCount *int, code string, user string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/openid.connect.token":
+			*tokenCount++
+			ts.Equal(code, r.FormValue("code"))
+			ts.Equal("authorization_code", r.FormValue("grant_type"))
+			ts.Equal(ts.Config.External.Slack.RedirectURI, r.FormValue("redirect_uri"))
+
+			w.Header().Add("Content-Type", "application/json")
+			fmt.Fprint(w, `{"ok":true,"access_token":"slack_token","token_type":"Bearer","expires_in":100000}`)
+		case "/api/openid.connect.userInfo":
+			*userCount++
+			w.Header().Add("Content-Type", "application/json")
+			fmt.Fprint(w, user)
+		default:
+			w.WriteHeader(500)
+			ts.Fail("unknown slack oauth call %s", r.URL.Path)
+		}
+	}))
+
+	ts.Config.External.Slack.URL = server.URL
+
+	return server
+}
+
+func (ts *ExternalTestSuite) TestSignupExternalSlack_AuthorizationCode() {
+	tokenCount, userCount := 0, 0
+	code := "authcode"
+	slackUser := `{"ok":true,"sub":"U0R7JM","https://slack.com/team_id":"T0R7GR","email":"slack@example.com","email_verified":true,"name":"Slack Test","picture":"http://example.com/avatar"}`
+	server := SlackTestSignupSetup(ts, &tokenCount, &userCount, code, slackUser)
+	defer server.Close()
+
+	u := performAuthorization(ts, "slack", code, "")
+
+	assertAuthorizationSuccess(ts, u, tokenCount, userCount, "slack@example.com", "Slack Test", "http://example.com/avatar")
+
+	identities := ts.userIdentities(ts.findUser("slack@example.com"))
+	ts.Require().Len(identities, 1)
+	// user IDs are scoped to the workspace
+	ts.Equal("T0R7GR|U0R7JM", identities[0].ProviderID)
+}
+
+func (ts *ExternalTestSuite) TestSignupExternalSlackError() {
+	tokenCount, userCount := 0, 0
+	code := "authcode"
+	server := SlackTestSignupSetup(ts, &tokenCount, &userCount, code, `{"ok":false,"error":"invalid_auth"}`)
+	defer server.Close()
+
+	u := performAuthorization(ts, "slack", code, "")
+
+	assertAuthorizationFailure(ts, u, "Error getting user email from external provider", "server_error", "slack@example.com")
+}
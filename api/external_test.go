@@ -155,3 +155,44 @@ func (ts *ExternalTestSuite) TestSignupExternalUnsupported() {
 	ts.API.handler.ServeHTTP(w, req)
 	ts.Equal(w.Code, http.StatusBadRequest)
 }
+
+func (ts *ExternalTestSuite) TestSignupExternalRedirectToNotAllowed() {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/authorize?provider=github&redirect_to=https%3A%2F%2Fevil.example.net", nil)
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Equal(http.StatusBadRequest, w.Code)
+}
+
+// TestExternalStateReplay tests that a callback URL cannot be used twice
+func (ts *ExternalTestSuite) TestExternalStateReplay() {
+	tokenCount, userCount := 0, 0
+	code := "authcode"
+	googleUser := `{"name":"Google Test","picture":"http://example.com/avatar","email":"google@example.com","verified_email":true}}`
+	server := GoogleTestSignupSetup(ts, &tokenCount, &userCount, code, googleUser)
+	defer server.Close()
+
+	w := performAuthorizationRequest(ts, "google", "")
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+
+	v := url.Values{}
+	v.Set("code", code)
+	v.Set("state", u.Query().Get("state"))
+	callbackURL := "http://localhost/callback?" + v.Encode()
+
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, callbackURL, nil))
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err = url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err, "redirect url parse failed")
+	f, err := url.ParseQuery(u.Fragment)
+	ts.Require().NoError(err)
+	ts.NotEmpty(f.Get("access_token"))
+
+	// the state was consumed by the first callback
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, callbackURL, nil))
+	ts.Equal(http.StatusBadRequest, w.Code)
+	ts.Equal(1, tokenCount)
+}
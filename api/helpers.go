@@ -9,7 +9,6 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptrace"
-	"net/url"
 
 	"github.com/google/uuid"
 	"github.com/tigrisdata/gotrue/conf"
@@ -56,7 +55,11 @@ func getUserFromClaims(ctx context.Context, db *tigris.Database) (*models.User,
 	if claims == nil {
 		return nil, errors.New("Invalid token")
 	}
+	return findUserForClaims(ctx, db, claims)
+}
 
+// findUserForClaims loads the user an access token was issued to
+func findUserForClaims(ctx context.Context, db *tigris.Database, claims *GoTrueClaims) (*models.User, error) {
 	if claims.Subject == "" {
 		return nil, errors.New("Invalid claim: id")
 	}
@@ -103,13 +106,9 @@ func (a *API) getReferrer(r *http.Request) string {
 	ctx := r.Context()
 	config := a.getConfig(ctx)
 	referrer := ""
-	if reqref := r.Referer(); reqref != "" {
-		base, berr := url.Parse(config.SiteURL)
-		refurl, rerr := url.Parse(reqref)
-		// As long as the referrer came from the site, we will redirect back there
-		if berr == nil && rerr == nil && base.Hostname() == refurl.Hostname() {
-			referrer = reqref
-		}
+	// As long as the referrer is an allowed redirect URL, we will redirect back there
+	if reqref := r.Referer(); reqref != "" && isRedirectURLAllowed(config, reqref) {
+		referrer = reqref
 	}
 	return referrer
 }
@@ -237,3 +236,18 @@ func generateRandomInt(max int) int {
 	}
 	return int(binary.BigEndian.Uint32(bytes[:])) % max
 }
+
+// clientIP is the IP address a request is made from. The rate limit header is
+// trusted to carry it if configured, as it is set by a proxy in front of us.
+func (a *API) clientIP(r *http.Request) string {
+	if a.config.RateLimitHeader != "" {
+		if ip := r.Header.Get(a.config.RateLimitHeader); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
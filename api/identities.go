@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/tigrisdata/gotrue/models"
+)
+
+// IdentityLinkParams are the parameters the UserIdentityLink endpoint accepts
+type IdentityLinkParams struct {
+	Provider string `json:"provider"`
+}
+
+// IdentityLinkResponse is the URL of the external provider the user signs in
+// at to link their account there
+type IdentityLinkResponse struct {
+	URL string `json:"url"`
+}
+
+// ProviderTokenResponse is the access token of an external provider account.
+// Refresh tokens never leave the server.
+type ProviderTokenResponse struct {
+	Provider    string     `json:"provider"`
+	AccessToken string     `json:"access_token"`
+	TokenType   string     `json:"token_type"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// UserIdentities lists the external provider accounts linked to the signed in user
+func (a *API) UserIdentities(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	user, err := getUserFromClaims(ctx, a.db)
+	if err != nil {
+		return unauthorizedError("Invalid user").WithInternalError(err)
+	}
+
+	identities, err := models.FindIdentitiesByUser(ctx, a.db, user)
+	if err != nil {
+		return internalServerError("Database error finding identities").WithInternalError(err)
+	}
+	return sendJSON(w, http.StatusOK, identities)
+}
+
+// UserIdentityLink starts linking an external provider account to the signed in
+// user. The returned URL is opened in the browser, and the account is linked on
+// the callback instead of signing in.
+func (a *API) UserIdentityLink(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	params := &IdentityLinkParams{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read identity link params: %v", err)
+	}
+	if params.Provider == "" {
+		return unprocessableEntityError("Linking an identity requires a provider")
+	}
+
+	user, err := getUserFromClaims(ctx, a.db)
+	if err != nil {
+		return unauthorizedError("Invalid user").WithInternalError(err)
+	}
+
+	authURL, err := a.externalProviderURL(w, r, params.Provider, "", user)
+	if err != nil {
+		return err
+	}
+	return sendJSON(w, http.StatusOK, &IdentityLinkResponse{URL: authURL})
+}
+
+// UserIdentityUnlink removes an external provider account from the signed in
+// user. The last way a user signs in cannot be removed.
+func (a *API) UserIdentityUnlink(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	providerType := chi.URLParam(r, "provider")
+
+	user, err := getUserFromClaims(ctx, a.db)
+	if err != nil {
+		return unauthorizedError("Invalid user").WithInternalError(err)
+	}
+
+	identities, err := models.FindIdentitiesByUser(ctx, a.db, user)
+	if err != nil {
+		return internalServerError("Database error finding identities").WithInternalError(err)
+	}
+
+	var identity *models.Identity
+	for _, i := range identities {
+		if i.Provider == providerType {
+			identity = i
+			break
+		}
+	}
+	if identity == nil {
+		return notFoundError("No %s identity is linked to this user", providerType)
+	}
+	if len(identities) == 1 && !a.hasPassword(user) {
+		return unprocessableEntityError("Cannot unlink the only way this user signs in")
+	}
+
+	err = a.db.Tx(ctx, func(ctx context.Context) error {
+		if terr := models.NewAuditLogEntry(ctx, a.db, user.InstanceID, user, models.IdentityUnlinkedAction, map[string]interface{}{
+			"provider":    identity.Provider,
+			"provider_id": identity.ProviderID,
+		}); terr != nil {
+			return terr
+		}
+		return identity.Delete(ctx, a.db)
+	})
+	if err != nil {
+		return internalServerError("Error unlinking identity").WithInternalError(err)
+	}
+	a.notifyIdentityUnlinked(ctx, user, identity.Provider)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// UserIdentityToken returns the access token of the signed in user's account at
+// the provider, refreshing it first when it expired. Only trusted backends,
+// whose access tokens are issued for one of the configured audiences, can read
+// provider tokens.
+func (a *API) UserIdentityToken(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	config := a.getConfig(ctx)
+	providerType := chi.URLParam(r, "provider")
+
+	claims := getClaims(ctx)
+	if claims == nil || !config.External.Tokens.Trusts(claims.Audience) {
+		return forbiddenError("Provider tokens are not available to this audience")
+	}
+
+	user, err := getUserFromClaims(ctx, a.db)
+	if err != nil {
+		return unauthorizedError("Invalid user").WithInternalError(err)
+	}
+
+	identity, err := models.FindIdentityByUserAndProvider(ctx, a.db, user, providerType)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return notFoundError("No %s identity is linked to this user", providerType)
+		}
+		return internalServerError("Database error finding identity").WithInternalError(err)
+	}
+
+	stored, err := models.FindProviderTokenByIdentity(ctx, a.db, identity)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return notFoundError("No %s token is stored for this user", providerType)
+		}
+		return internalServerError("Database error finding provider token").WithInternalError(err)
+	}
+
+	tok := stored.OAuth2Token(a.encrypter)
+	if !tok.Valid() {
+		if tok.RefreshToken == "" {
+			return unprocessableEntityError("The %s token expired and cannot be refreshed, the user must sign in again", providerType)
+		}
+
+		oAuthProvider, err := a.OAuthProvider(ctx, providerType)
+		if err != nil {
+			return badRequestError("Unsupported provider: %+v", err).WithInternalError(err)
+		}
+		tok, err = oAuthProvider.TokenSource(ctx, tok).Token()
+		if err != nil {
+			return unprocessableEntityError("Error refreshing the %s token, the user must sign in again", providerType).WithInternalError(err)
+		}
+		if stored, err = models.SaveProviderToken(ctx, a.db, a.encrypter, identity, tok); err != nil {
+			return internalServerError("Database error saving provider token").WithInternalError(err)
+		}
+	}
+
+	return sendJSON(w, http.StatusOK, &ProviderTokenResponse{
+		Provider:    providerType,
+		AccessToken: tok.AccessToken,
+		TokenType:   tok.Type(),
+		ExpiresAt:   stored.ExpiresAt,
+	})
+}
+
+// adminUserIdentities lists the external provider accounts linked to a user
+func (a *API) adminUserIdentities(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user := getUser(ctx)
+
+	identities, err := models.FindIdentitiesByUser(ctx, a.db, user)
+	if err != nil {
+		return internalServerError("Database error finding identities").WithInternalError(err)
+	}
+	return sendJSON(w, http.StatusOK, identities)
+}
+
+// hasPassword checks whether the user can sign in with a password. Users who
+// signed up with an external provider have none.
+func (a *API) hasPassword(user *models.User) bool {
+	return user.EncryptedPassword != "" && a.encrypter.Decrypt(user.EncryptedPassword, user.EncryptionIV) != ""
+}
@@ -0,0 +1,309 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tigrisdata/gotrue/conf"
+	"github.com/tigrisdata/gotrue/models"
+)
+
+func (ts *ExternalTestSuite) identityRequest(method string, path string, body interface{}, user *models.User) *httptest.ResponseRecorder {
+	token, err := generateAccessToken(user, time.Second*time.Duration(ts.Config.JWT.Exp), ts.Config, NewTokenSigner(ts.Config))
+	ts.Require().NoError(err)
+
+	var buffer bytes.Buffer
+	if body != nil {
+		ts.Require().NoError(json.NewEncoder(&buffer).Encode(body))
+	}
+	req := httptest.NewRequest(method, "http://localhost"+path, &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *ExternalTestSuite) userIdentities(user *models.User) []*models.Identity {
+	w := ts.identityRequest(http.MethodGet, "/user/identities", nil, user)
+	ts.Require().Equal(http.StatusOK, w.Code)
+
+	identities := []*models.Identity{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(&identities))
+	return identities
+}
+
+func (ts *ExternalTestSuite) findUser(email string) *models.User {
+	user, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, email, ts.Config.JWT.Aud)
+	ts.Require().NoError(err)
+	return user
+}
+
+func (ts *ExternalTestSuite) TestIdentityMatchedByProviderSubject() {
+	tokenCount, userCount := 0, 0
+	server := GitHubTestSignupSetup(ts, &tokenCount, &userCount, "authcode", `[{"email":"github@example.com", "primary": true, "verified": true}]`)
+	u := performAuthorization(ts, "github", "authcode", "")
+	server.Close()
+	assertAuthorizationSuccess(ts, u, tokenCount, userCount, "github@example.com", "GitHub Test", "http://example.com/avatar")
+
+	// the email changed at GitHub, the account stays the same
+	tokenCount, userCount = 0, 0
+	server = GitHubTestSignupSetup(ts, &tokenCount, &userCount, "authcode", `[{"email":"renamed@example.com", "primary": true, "verified": true}]`)
+	defer server.Close()
+	u = performAuthorization(ts, "github", "authcode", "")
+
+	v, err := url.ParseQuery(u.Fragment)
+	ts.Require().NoError(err)
+	ts.NotEmpty(v.Get("access_token"))
+
+	_, err = models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "renamed@example.com", ts.Config.JWT.Aud)
+	ts.True(models.IsNotFoundError(err))
+
+	identities := ts.userIdentities(ts.findUser("github@example.com"))
+	ts.Require().Len(identities, 1)
+	ts.Equal("github", identities[0].Provider)
+	ts.Equal("123", identities[0].ProviderID)
+	ts.Equal("renamed@example.com", identities[0].Email)
+	ts.Equal("GitHub Test", identities[0].Claims["name"])
+}
+
+func (ts *ExternalTestSuite) TestIdentityLinkAndUnlink() {
+	user, err := ts.createUser("test@example.com", "Test", "", "")
+	ts.Require().NoError(err)
+	ts.Require().NoError(user.Confirm(context.TODO(), ts.API.db))
+
+	tokenCount, userCount := 0, 0
+	server := GitHubTestSignupSetup(ts, &tokenCount, &userCount, "authcode", `[{"email":"github@example.com", "primary": true, "verified": false}]`)
+	defer server.Close()
+
+	state, cookies := ts.startIdentityLink(user)
+	u := ts.identityLinkCallback(state, cookies)
+	// linking does not sign in
+	ts.Empty(u.Fragment)
+
+	identities := ts.userIdentities(user)
+	ts.Require().Len(identities, 1)
+	ts.Equal("123", identities[0].ProviderID)
+	ts.Equal("github@example.com", identities[0].Email)
+
+	// the linked account signs in as the user, although its email differs
+	u = performAuthorization(ts, "github", "authcode", "")
+	v, err := url.ParseQuery(u.Fragment)
+	ts.Require().NoError(err)
+	ts.NotEmpty(v.Get("access_token"))
+	_, err = models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "github@example.com", ts.Config.JWT.Aud)
+	ts.True(models.IsNotFoundError(err))
+
+	w := ts.identityRequest(http.MethodDelete, "/user/identities/github", nil, user)
+	ts.Require().Equal(http.StatusNoContent, w.Code)
+	ts.Empty(ts.userIdentities(user))
+
+	w = ts.identityRequest(http.MethodDelete, "/user/identities/github", nil, user)
+	ts.Equal(http.StatusNotFound, w.Code)
+}
+
+func (ts *ExternalTestSuite) TestIdentityUnlinkOnlySignInMethod() {
+	tokenCount, userCount := 0, 0
+	server := GitHubTestSignupSetup(ts, &tokenCount, &userCount, "authcode", `[{"email":"github@example.com", "primary": true, "verified": true}]`)
+	defer server.Close()
+	performAuthorization(ts, "github", "authcode", "")
+
+	// users signed up with GitHub have no password to fall back to
+	user := ts.findUser("github@example.com")
+	w := ts.identityRequest(http.MethodDelete, "/user/identities/github", nil, user)
+	ts.Equal(http.StatusUnprocessableEntity, w.Code)
+	ts.Len(ts.userIdentities(user), 1)
+}
+
+func (ts *ExternalTestSuite) TestIdentityLinkedToOtherUser() {
+	tokenCount, userCount := 0, 0
+	server := GitHubTestSignupSetup(ts, &tokenCount, &userCount, "authcode", `[{"email":"github@example.com", "primary": true, "verified": true}]`)
+	defer server.Close()
+	performAuthorization(ts, "github", "authcode", "")
+
+	user, err := ts.createUser("test@example.com", "Test", "", "")
+	ts.Require().NoError(err)
+
+	state, cookies := ts.startIdentityLink(user)
+	u := ts.identityLinkCallback(state, cookies)
+	v, err := url.ParseQuery(u.Fragment)
+	ts.Require().NoError(err)
+	ts.Equal("This github account is linked to a different user", v.Get("error_description"))
+
+	ts.Empty(ts.userIdentities(user))
+}
+
+func (ts *ExternalTestSuite) TestIdentityLinkInOtherBrowser() {
+	user, err := ts.createUser("test@example.com", "Test", "", "")
+	ts.Require().NoError(err)
+	ts.Require().NoError(user.Confirm(context.TODO(), ts.API.db))
+
+	tokenCount, userCount := 0, 0
+	server := GitHubTestSignupSetup(ts, &tokenCount, &userCount, "authcode", `[{"email":"github@example.com", "primary": true, "verified": true}]`)
+	defer server.Close()
+
+	// a link URL opened in a browser without the link cookie, e.g. one an
+	// attacker had a victim open, does not link the victim's account
+	state, _ := ts.startIdentityLink(user)
+	u := ts.identityLinkCallback(state, nil)
+	v, err := url.ParseQuery(u.Fragment)
+	ts.Require().NoError(err)
+	ts.Equal("OAuth state is invalid: identity link was started in another browser", v.Get("error_description"))
+	ts.Equal(0, tokenCount)
+	ts.Empty(ts.userIdentities(user))
+}
+
+// startIdentityLink starts linking a GitHub account to user and returns the
+// state of the link URL along with the cookies set for the browser
+func (ts *ExternalTestSuite) startIdentityLink(user *models.User) (string, []*http.Cookie) {
+	w := ts.identityRequest(http.MethodPost, "/user/identities", &IdentityLinkParams{Provider: "github"}, user)
+	ts.Require().Equal(http.StatusOK, w.Code)
+	link := &IdentityLinkResponse{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(link))
+	u, err := url.Parse(link.URL)
+	ts.Require().NoError(err)
+	return u.Query().Get("state"), w.Result().Cookies()
+}
+
+// identityLinkCallback completes the link in a browser holding cookies and
+// returns where it is redirected to
+func (ts *ExternalTestSuite) identityLinkCallback(state string, cookies []*http.Cookie) *url.URL {
+	callbackURL := fmt.Sprintf("http://localhost/callback?code=authcode&state=%s", url.QueryEscape(state))
+	req := httptest.NewRequest(http.MethodGet, callbackURL, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ts.Require().NoError(err)
+	return u
+}
+
+func (ts *ExternalTestSuite) providerTokenRequest(provider string, user *models.User, aud string) *httptest.ResponseRecorder {
+	claims := newAccessTokenClaims(user, uuid.Nil, time.Second*time.Duration(ts.Config.JWT.Exp), ts.Config)
+	claims.Audience = aud
+	token, err := signAccessToken(claims, ts.Config, NewTokenSigner(ts.Config))
+	ts.Require().NoError(err)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/user/identities/"+provider+"/token", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func GitHubTokenTestSetup(ts *ExternalTestSuite, refreshCount *int, expiresIn int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/login/oauth/access_token":
+			if r.FormValue("grant_type") == "refresh_token" {
+				*refreshCount++
+				ts.Equal("github_refresh_token", r.FormValue("refresh_token"))
+				fmt.Fprint(w, `{"access_token":"github_token_refreshed","token_type":"bearer","expires_in":28800}`)
+				return
+			}
+			fmt.Fprintf(w, `{"access_token":"github_token","token_type":"bearer","expires_in":%d,"refresh_token":"github_refresh_token"}`, expiresIn)
+		case "/api/v3/user":
+			fmt.Fprint(w, `{"id":123,"name":"GitHub Test","avatar_url":"http://example.com/avatar"}`)
+		case "/api/v3/user/emails":
+			fmt.Fprint(w, `[{"email":"github@example.com", "primary": true, "verified": true}]`)
+		default:
+			w.WriteHeader(500)
+			ts.Fail("unknown github oauth call %s", r.URL.Path)
+		}
+	}))
+
+	ts.Config.External.Github.URL = server.URL
+	ts.Config.External.Tokens = conf.ProviderTokensConfiguration{Enabled: true, Audiences: []string{"backend"}}
+
+	return server
+}
+
+func (ts *ExternalTestSuite) TestIdentityProviderToken() {
+	refreshCount := 0
+	server := GitHubTokenTestSetup(ts, &refreshCount, 28800)
+	defer server.Close()
+	defer func() { ts.Config.External.Tokens = conf.ProviderTokensConfiguration{} }()
+	performAuthorization(ts, "github", "authcode", "")
+	user := ts.findUser("github@example.com")
+
+	// tokens issued for users themselves cannot read provider tokens
+	w := ts.providerTokenRequest("github", user, ts.Config.JWT.Aud)
+	ts.Equal(http.StatusForbidden, w.Code)
+
+	w = ts.providerTokenRequest("github", user, "backend")
+	ts.Require().Equal(http.StatusOK, w.Code)
+	resp := &ProviderTokenResponse{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(resp))
+	ts.Equal("github", resp.Provider)
+	ts.Equal("github_token", resp.AccessToken)
+	ts.Equal("Bearer", resp.TokenType)
+	ts.NotNil(resp.ExpiresAt)
+	ts.Equal(0, refreshCount)
+
+	// tokens are stored encrypted
+	identity, err := models.FindIdentityByUserAndProvider(context.TODO(), ts.API.db, user, "github")
+	ts.Require().NoError(err)
+	stored, err := models.FindProviderTokenByIdentity(context.TODO(), ts.API.db, identity)
+	ts.Require().NoError(err)
+	ts.NotEqual("github_token", stored.AccessToken)
+	ts.NotEqual("github_refresh_token", stored.RefreshToken)
+
+	w = ts.providerTokenRequest("gitlab", user, "backend")
+	ts.Equal(http.StatusNotFound, w.Code)
+}
+
+func (ts *ExternalTestSuite) TestIdentityProviderTokenRefresh() {
+	refreshCount := 0
+	// the token expired right away
+	server := GitHubTokenTestSetup(ts, &refreshCount, 1)
+	defer server.Close()
+	defer func() { ts.Config.External.Tokens = conf.ProviderTokensConfiguration{} }()
+	performAuthorization(ts, "github", "authcode", "")
+	user := ts.findUser("github@example.com")
+
+	w := ts.providerTokenRequest("github", user, "backend")
+	ts.Require().Equal(http.StatusOK, w.Code)
+	resp := &ProviderTokenResponse{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(resp))
+	ts.Equal("github_token_refreshed", resp.AccessToken)
+	ts.Equal(1, refreshCount)
+
+	// the refreshed token is kept, along with the refresh token GitHub did not rotate
+	w = ts.providerTokenRequest("github", user, "backend")
+	ts.Require().Equal(http.StatusOK, w.Code)
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(resp))
+	ts.Equal("github_token_refreshed", resp.AccessToken)
+	ts.Equal(1, refreshCount)
+
+	identity, err := models.FindIdentityByUserAndProvider(context.TODO(), ts.API.db, user, "github")
+	ts.Require().NoError(err)
+	stored, err := models.FindProviderTokenByIdentity(context.TODO(), ts.API.db, identity)
+	ts.Require().NoError(err)
+	ts.Equal("github_refresh_token", stored.OAuth2Token(ts.API.encrypter).RefreshToken)
+
+	// unlinking removes the stored token
+	ts.Require().NoError(identity.Delete(context.TODO(), ts.API.db))
+	_, err = models.FindProviderTokenByIdentity(context.TODO(), ts.API.db, identity)
+	ts.True(models.IsNotFoundError(err))
+}
+
+func (ts *ExternalTestSuite) TestIdentityProviderTokenDisabled() {
+	tokenCount, userCount := 0, 0
+	server := GitHubTestSignupSetup(ts, &tokenCount, &userCount, "authcode", `[{"email":"github@example.com", "primary": true, "verified": true}]`)
+	defer server.Close()
+	performAuthorization(ts, "github", "authcode", "")
+
+	w := ts.providerTokenRequest("github", ts.findUser("github@example.com"), "backend")
+	ts.Equal(http.StatusForbidden, w.Code)
+}
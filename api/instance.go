@@ -57,6 +57,12 @@ func (a *API) CreateInstance(w http.ResponseWriter, r *http.Request) error {
 		return badRequestError("Error decoding params: %v", err)
 	}
 
+	if params.BaseConfig != nil {
+		if err := params.BaseConfig.Validate(); err != nil {
+			return unprocessableEntityError("Invalid configuration: %v", err)
+		}
+	}
+
 	_, err := models.GetInstanceByUUID(r.Context(), a.db, params.UUID)
 	if err != nil {
 		if !models.IsNotFoundError(err) {
@@ -109,6 +115,12 @@ func (a *API) UpdateInstance(w http.ResponseWriter, r *http.Request) error {
 		return badRequestError("Error decoding params: %v", err)
 	}
 
+	if params.BaseConfig != nil {
+		if err := params.BaseConfig.Validate(); err != nil {
+			return unprocessableEntityError("Invalid configuration: %v", err)
+		}
+	}
+
 	if err := i.UpdateConfig(r.Context(), a.db, params.BaseConfig); err != nil {
 		return internalServerError("Database error updating instance").WithInternalError(err)
 	}
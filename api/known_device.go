@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tigrisdata/gotrue/models"
+)
+
+// RejectDeviceParams holds the token of a "this wasn't me" link
+type RejectDeviceParams struct {
+	Token string `json:"token"`
+}
+
+// deviceFingerprint tells devices apart by the network their IP address is in
+// and their user agent, so that a changing address within a network is not a
+// new device.
+func (a *API) deviceFingerprint(r *http.Request) (fingerprint, ipPrefix, userAgent string) {
+	ipPrefix = a.clientIP(r)
+	if ip := net.ParseIP(ipPrefix); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			ipPrefix = (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+		} else {
+			ipPrefix = (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+		}
+	}
+	userAgent = r.UserAgent()
+
+	sum := sha256.Sum256([]byte(ipPrefix + "\n" + userAgent))
+	return hex.EncodeToString(sum[:]), ipPrefix, userAgent
+}
+
+// checkNewDevice remembers the device a user signed in from and, when it is
+// not the first device of the user, tells them about it. Errors are only
+// logged, as they must not fail the sign in.
+func (a *API) checkNewDevice(ctx context.Context, r *http.Request, user *models.User) {
+	config := a.getConfig(ctx)
+	if !config.Notifications.NewDevice {
+		return
+	}
+
+	fingerprint, ipPrefix, userAgent := a.deviceFingerprint(r)
+	device, err := models.FindKnownDevice(ctx, a.db, user, fingerprint)
+	if err == nil {
+		if err := device.Seen(ctx, a.db); err != nil {
+			log.Error().Err(err).Str("email", user.Email).Msg("Error updating known device")
+		}
+		return
+	}
+	if !models.IsNotFoundError(err) {
+		log.Error().Err(err).Str("email", user.Email).Msg("Error finding known device")
+		return
+	}
+
+	// the first sign in of a user is not news to them
+	known, err := models.HasKnownDevices(ctx, a.db, user)
+	if err != nil {
+		log.Error().Err(err).Str("email", user.Email).Msg("Error finding known devices")
+		return
+	}
+	device, err = models.NewKnownDevice(ctx, a.db, user, fingerprint, ipPrefix, userAgent)
+	if err != nil {
+		log.Error().Err(err).Str("email", user.Email).Msg("Error saving known device")
+		return
+	}
+	if !known {
+		return
+	}
+
+	token, err := device.NewRejectToken(ctx, a.db)
+	if err != nil {
+		log.Error().Err(err).Str("email", user.Email).Msg("Error saving reject device token")
+		return
+	}
+	if err := a.Mailer(ctx).NewDeviceMail(user, device, token, a.getReferrer(r)); err != nil {
+		log.Error().Err(err).Str("email", user.Email).Msg("Error sending new device email")
+	}
+}
+
+// RejectDevice handles the "this wasn't me" link of new device emails. It
+// signs the user out everywhere and sends them a recovery email, so that
+// whoever signed in loses access and the user can pick a new password.
+func (a *API) RejectDevice(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	config := a.getConfig(ctx)
+	instanceID := getInstanceID(ctx)
+
+	params := &RejectDeviceParams{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return badRequestError("Could not read reject device params: %v", err)
+	}
+	if params.Token == "" {
+		return unprocessableEntityError("Rejecting a device requires a token")
+	}
+
+	device, err := models.FindKnownDeviceByRejectToken(ctx, a.db, params.Token)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return notFoundError("Device not found")
+		}
+		return internalServerError("Database error finding device").WithInternalError(err)
+	}
+	if device.InstanceID != instanceID {
+		return notFoundError("Device not found")
+	}
+	if device.RejectTokenExpired() {
+		return unprocessableEntityError("Reject device token has expired")
+	}
+	user, err := models.FindUserByInstanceIDAndID(ctx, a.db, instanceID, device.UserID)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return notFoundError("User not found")
+		}
+		return internalServerError("Database error finding user").WithInternalError(err)
+	}
+
+	err = a.db.Tx(ctx, func(ctx context.Context) error {
+		if terr := models.NewAuditLogEntry(ctx, a.db, instanceID, user, models.DeviceRejectedAction, map[string]interface{}{
+			"ip_prefix":  device.IPPrefix,
+			"user_agent": device.UserAgent,
+		}); terr != nil {
+			return terr
+		}
+		if terr := a.revokeUserTokens(ctx, user); terr != nil {
+			return terr
+		}
+		// the other devices stay known, so that the next sign in from the
+		// rejected one is still notified
+		if terr := device.Delete(ctx, a.db); terr != nil {
+			return terr
+		}
+		return a.sendPasswordRecovery(ctx, a.db, user, a.Mailer(ctx), config.SMTP.MaxFrequency, a.getReferrer(r))
+	})
+	if err != nil {
+		return internalServerError("Error rejecting device").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, map[string]interface{}{})
+}
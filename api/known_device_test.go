@@ -0,0 +1,225 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/tigrisdata/gotrue/conf"
+	"github.com/tigrisdata/gotrue/crypto"
+	"github.com/tigrisdata/gotrue/models"
+	"github.com/tigrisdata/tigris-client-go/fields"
+	"github.com/tigrisdata/tigris-client-go/filter"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+type KnownDeviceTestSuite struct {
+	suite.Suite
+	API        *API
+	Config     *conf.Configuration
+	Encrypter  *crypto.AESBlockEncrypter
+	instanceID uuid.UUID
+	user       *models.User
+}
+
+func TestKnownDevice(t *testing.T) {
+	api, config, globalConf, instanceID, err := setupAPIForTestForInstance()
+	require.NoError(t, err)
+
+	ts := &KnownDeviceTestSuite{
+		API:        api,
+		Config:     config,
+		Encrypter:  &crypto.AESBlockEncrypter{Key: globalConf.DB.EncryptionKey},
+		instanceID: instanceID,
+	}
+
+	suite.Run(t, ts)
+}
+
+func (ts *KnownDeviceTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	u, err := models.NewUser(ts.instanceID, "test@example.com", "password", ts.Config.JWT.Aud, nil, ts.Encrypter)
+	require.NoError(ts.T(), err, "Error creating test user model")
+	_, err = tigris.GetCollection[models.User](ts.API.db).Insert(context.TODO(), u)
+	require.NoError(ts.T(), err, "Error saving new test user")
+	require.NoError(ts.T(), u.Confirm(context.TODO(), ts.API.db))
+	ts.user = u
+
+	ts.Config.Notifications.NewDevice = true
+}
+
+func (ts *KnownDeviceTestSuite) TearDownTest() {
+	ts.Config.Notifications = conf.NotificationsConfiguration{}
+}
+
+func (ts *KnownDeviceTestSuite) rejectDevice(token string) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"token": token,
+	}))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/devices/reject", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *KnownDeviceTestSuite) passwordGrant(ip, userAgent string) *httptest.ResponseRecorder {
+	form := url.Values{
+		"grant_type": {"password"},
+		"username":   {"test@example.com"},
+		"password":   {"password"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", userAgent)
+	req.RemoteAddr = ip + ":1234"
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *KnownDeviceTestSuite) TestFingerprint() {
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/token", nil)
+	req.Header.Set("User-Agent", "curl/8.0")
+
+	req.RemoteAddr = "10.0.0.1:1234"
+	fingerprint, ipPrefix, userAgent := ts.API.deviceFingerprint(req)
+	ts.Equal("10.0.0.0/24", ipPrefix)
+	ts.Equal("curl/8.0", userAgent)
+
+	// addresses in the same network are the same device
+	req.RemoteAddr = "10.0.0.2:1234"
+	other, _, _ := ts.API.deviceFingerprint(req)
+	ts.Equal(fingerprint, other)
+
+	req.RemoteAddr = "10.0.1.1:1234"
+	other, _, _ = ts.API.deviceFingerprint(req)
+	ts.NotEqual(fingerprint, other)
+
+	req.RemoteAddr = "[2001:db8:1:2::1]:1234"
+	_, ipPrefix, _ = ts.API.deviceFingerprint(req)
+	ts.Equal("2001:db8:1::/48", ipPrefix)
+}
+
+func (ts *KnownDeviceTestSuite) TestNewDevice() {
+	w := ts.passwordGrant("10.0.0.1", "curl/8.0")
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+
+	fingerprint, _, _ := ts.API.deviceFingerprint(&http.Request{RemoteAddr: "10.0.0.1:1234", Header: http.Header{"User-Agent": {"curl/8.0"}}})
+	device, err := models.FindKnownDevice(context.TODO(), ts.API.db, ts.user, fingerprint)
+	ts.Require().NoError(err)
+	ts.Equal("10.0.0.0/24", device.IPPrefix)
+
+	// signing in again from the device keeps it
+	w = ts.passwordGrant("10.0.0.7", "curl/8.0")
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	seen, err := models.FindKnownDevice(context.TODO(), ts.API.db, ts.user, fingerprint)
+	ts.Require().NoError(err)
+	ts.Equal(device.ID, seen.ID)
+
+	w = ts.passwordGrant("192.168.0.1", "Mozilla/5.0")
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	fingerprint, _, _ = ts.API.deviceFingerprint(&http.Request{RemoteAddr: "192.168.0.1:1234", Header: http.Header{"User-Agent": {"Mozilla/5.0"}}})
+	_, err = models.FindKnownDevice(context.TODO(), ts.API.db, ts.user, fingerprint)
+	ts.NoError(err)
+}
+
+func (ts *KnownDeviceTestSuite) TestRejectDevice() {
+	mails := recordMails(ts.T(), ts.API)
+
+	w := ts.passwordGrant("10.0.0.1", "curl/8.0")
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	w = ts.passwordGrant("192.168.0.1", "Mozilla/5.0")
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+
+	fingerprint, _, _ := ts.API.deviceFingerprint(&http.Request{RemoteAddr: "192.168.0.1:1234", Header: http.Header{"User-Agent": {"Mozilla/5.0"}}})
+	device, err := models.FindKnownDevice(context.TODO(), ts.API.db, ts.user, fingerprint)
+	ts.Require().NoError(err)
+	// only the hash of the emailed token is stored
+	token := mails.RejectToken()
+	ts.Require().NotEmpty(token)
+	ts.NotEqual(token, device.RejectTokenHash)
+
+	w = ts.rejectDevice(token)
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+
+	// only the rejected device is forgotten
+	_, err = models.FindKnownDevice(context.TODO(), ts.API.db, ts.user, fingerprint)
+	ts.True(models.IsNotFoundError(err))
+	known, err := models.HasKnownDevices(context.TODO(), ts.API.db, ts.user)
+	ts.Require().NoError(err)
+	ts.True(known)
+
+	u, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "test@example.com", ts.Config.JWT.Aud)
+	ts.Require().NoError(err)
+	ts.NotEmpty(u.RecoveryToken)
+}
+
+func (ts *KnownDeviceTestSuite) TestSignInAfterRejectDevice() {
+	mails := recordMails(ts.T(), ts.API)
+
+	w := ts.passwordGrant("10.0.0.1", "curl/8.0")
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	ts.Empty(mails.Mails())
+
+	w = ts.passwordGrant("192.168.0.1", "Mozilla/5.0")
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	ts.Equal([]recordedMail{{Kind: "new_device", To: "test@example.com"}}, mails.Mails())
+
+	w = ts.rejectDevice(mails.RejectToken())
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+
+	// the rejected device signing in again is still news to the user
+	w = ts.passwordGrant("192.168.0.1", "Mozilla/5.0")
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	ts.Equal([]recordedMail{
+		{Kind: "new_device", To: "test@example.com"},
+		{Kind: "new_device", To: "test@example.com"},
+	}, mails.Mails())
+}
+
+func (ts *KnownDeviceTestSuite) TestRejectDeviceExpiredToken() {
+	mails := recordMails(ts.T(), ts.API)
+
+	w := ts.passwordGrant("10.0.0.1", "curl/8.0")
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	w = ts.passwordGrant("192.168.0.1", "Mozilla/5.0")
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	token := mails.RejectToken()
+
+	fingerprint, _, _ := ts.API.deviceFingerprint(&http.Request{RemoteAddr: "192.168.0.1:1234", Header: http.Header{"User-Agent": {"Mozilla/5.0"}}})
+	device, err := models.FindKnownDevice(context.TODO(), ts.API.db, ts.user, fingerprint)
+	ts.Require().NoError(err)
+	_, err = tigris.GetCollection[models.KnownDevice](ts.API.db).Update(context.TODO(), filter.EqUUID("id", device.ID), fields.Set("reject_token_sent_at", time.Now().UTC().Add(-25*time.Hour)))
+	ts.Require().NoError(err)
+
+	w = ts.rejectDevice(token)
+	ts.Equal(http.StatusUnprocessableEntity, w.Code, w.Body.String())
+
+	// signing in from the device after that confirms it, and clears the token
+	w = ts.passwordGrant("192.168.0.1", "Mozilla/5.0")
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	w = ts.rejectDevice(token)
+	ts.Equal(http.StatusNotFound, w.Code, w.Body.String())
+	_, err = models.FindKnownDevice(context.TODO(), ts.API.db, ts.user, fingerprint)
+	ts.NoError(err)
+}
+
+func (ts *KnownDeviceTestSuite) TestRejectDeviceUnknownToken() {
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/devices/reject", strings.NewReader(`{"token":"unknown"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Equal(http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tigrisdata/gotrue/api/provider"
+	"github.com/tigrisdata/gotrue/models"
+)
+
+// ldapUser authenticates the user against the LDAP directory of the instance
+// and provisions them on their first sign in. No user is returned if the
+// directory does not know the username, so users who are not in the directory
+// keep signing in with their password.
+func (a *API) ldapUser(ctx context.Context, username, password, aud string) (*models.User, error) {
+	config := a.getConfig(ctx)
+	instanceID := getInstanceID(ctx)
+
+	p, err := provider.NewLDAPProvider(config.LDAP)
+	if err != nil {
+		return nil, internalServerError("Could not initialize LDAP provider: %+v", err).WithInternalError(err)
+	}
+
+	userData, err := p.Authenticate(username, password)
+	switch err {
+	case nil:
+	case provider.ErrLDAPUserNotFound:
+		return nil, nil
+	case provider.ErrLDAPInvalidCredentials:
+		log.Warn().Str("username", username).Msg("No user found with that email, or password invalid: LDAP auth failure")
+		return nil, oauthError("invalid_grant", invalidCredentialsMessage)
+	default:
+		return nil, internalServerError("LDAP authentication failed").WithInternalError(err)
+	}
+
+	var user *models.User
+	err = a.db.Tx(ctx, func(ctx context.Context) error {
+		var terr error
+		email := primaryEmail(userData).Email
+
+		if user, terr = a.findUserByIdentity(ctx, "ldap", userData, aud); terr != nil {
+			return terr
+		}
+		if user == nil {
+			user, terr = models.FindUserByEmailAndAudience(ctx, a.db, instanceID, email, aud)
+			if terr != nil && !models.IsNotFoundError(terr) {
+				return internalServerError("Database error finding user").WithInternalError(terr)
+			}
+		}
+
+		if user == nil {
+			if config.DisableSignup {
+				return forbiddenError("Signups not allowed for this instance")
+			}
+
+			params := &SignupParams{
+				Provider: "ldap",
+				Email:    email,
+				Aud:      aud,
+				Data:     make(map[string]interface{}),
+			}
+			for k, v := range userData.Metadata {
+				params.Data[k] = v
+			}
+			if user, terr = a.signupNewUser(ctx, params); terr != nil {
+				return terr
+			}
+			if terr = models.NewAuditLogEntry(ctx, a.db, instanceID, user, models.UserSignedUpAction, map[string]interface{}{
+				"provider": "ldap",
+			}); terr != nil {
+				return terr
+			}
+			if terr = triggerEventHooks(ctx, a.db, SignupEvent, user, instanceID, config); terr != nil {
+				return terr
+			}
+		}
+
+		// the directory vouches for the email of its users
+		if !user.IsConfirmed() {
+			if terr = user.Confirm(ctx, a.db); terr != nil {
+				return internalServerError("Error updating user").WithInternalError(terr)
+			}
+		}
+		if terr = a.recordIdentity(ctx, user, "ldap", userData, nil); terr != nil {
+			return terr
+		}
+		return a.applyProviderAttributes(ctx, user, userData)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// hasLDAPIdentity reports whether the user signs in through the LDAP directory,
+// and so must not sign in with a password of their own
+func (a *API) hasLDAPIdentity(ctx context.Context, user *models.User) (bool, error) {
+	_, err := models.FindIdentityByUserAndProvider(ctx, a.db, user, "ldap")
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return false, nil
+		}
+		return false, internalServerError("Database error finding identity").WithInternalError(err)
+	}
+	return true, nil
+}
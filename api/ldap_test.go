@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/tigrisdata/gotrue/api/provider/ldaptest"
+	"github.com/tigrisdata/gotrue/conf"
+	"github.com/tigrisdata/gotrue/crypto"
+	"github.com/tigrisdata/gotrue/models"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+type LDAPTestSuite struct {
+	suite.Suite
+	API        *API
+	Config     *conf.Configuration
+	Encrypter  *crypto.AESBlockEncrypter
+	instanceID uuid.UUID
+
+	server *ldaptest.Server
+}
+
+func TestLDAP(t *testing.T) {
+	api, config, globalConf, instanceID, err := setupAPIForTestForInstance()
+	require.NoError(t, err)
+
+	server, err := ldaptest.NewServer(
+		&ldaptest.Entry{
+			DN:       "cn=gotrue,ou=services,dc=example,dc=com",
+			Password: "service-secret",
+		},
+		&ldaptest.Entry{
+			DN:       "uid=jdoe,ou=people,dc=example,dc=com",
+			Password: "jdoe-secret",
+			Attributes: map[string][]string{
+				"uid":         {"jdoe"},
+				"mail":        {"jdoe@example.com"},
+				"displayName": {"Jane Doe"},
+				"memberOf":    {"cn=admins,ou=groups,dc=example,dc=com"},
+			},
+		},
+	)
+	require.NoError(t, err)
+	defer server.Close()
+
+	ts := &LDAPTestSuite{
+		API:        api,
+		Config:     config,
+		Encrypter:  &crypto.AESBlockEncrypter{Key: globalConf.DB.EncryptionKey},
+		instanceID: instanceID,
+		server:     server,
+	}
+
+	suite.Run(t, ts)
+}
+
+func (ts *LDAPTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	u, err := models.NewUser(ts.instanceID, "local@example.com", "password", ts.Config.JWT.Aud, nil, ts.Encrypter)
+	require.NoError(ts.T(), err, "Error creating test user model")
+	_, err = tigris.GetCollection[models.User](ts.API.db).Insert(context.TODO(), u)
+	require.NoError(ts.T(), err, "Error saving new test user")
+	require.NoError(ts.T(), u.Confirm(context.TODO(), ts.API.db))
+
+	ts.Config.LDAP = conf.LDAPConfiguration{
+		Enabled:        true,
+		URL:            ts.server.URL,
+		BindDN:         "cn=gotrue,ou=services,dc=example,dc=com",
+		BindPassword:   "service-secret",
+		BaseDN:         "ou=people,dc=example,dc=com",
+		UserFilter:     "(uid={username})",
+		EmailAttribute: "mail",
+		GroupAttribute: "memberOf",
+		Attributes:     map[string]string{"full_name": "displayName"},
+		Roles:          conf.LDAPRoleMapping{"cn=admins,ou=groups,dc=example,dc=com": "admin"},
+		Timeout:        5 * time.Second,
+	}
+	ts.Config.DisableSignup = false
+}
+
+func (ts *LDAPTestSuite) TearDownTest() {
+	ts.Config.LDAP = conf.LDAPConfiguration{}
+}
+
+func (ts *LDAPTestSuite) passwordGrant(username, password string) *httptest.ResponseRecorder {
+	form := url.Values{
+		"grant_type": {"password"},
+		"username":   {username},
+		"password":   {password},
+	}
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *LDAPTestSuite) TestLDAPSignIn() {
+	w := ts.passwordGrant("jdoe", "jdoe-secret")
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	token := &AccessTokenResponse{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(token))
+	ts.NotEmpty(token.Token)
+
+	user, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "jdoe@example.com", ts.Config.JWT.Aud)
+	ts.Require().NoError(err)
+	ts.True(user.IsConfirmed())
+	ts.Equal("Jane Doe", user.UserMetaData["full_name"])
+	ts.Equal("admin", user.Role)
+
+	_, err = models.FindIdentityByUserAndProvider(context.TODO(), ts.API.db, user, "ldap")
+	ts.Require().NoError(err)
+
+	// signing in again finds the provisioned user
+	w = ts.passwordGrant("jdoe", "jdoe-secret")
+	ts.Equal(http.StatusOK, w.Code)
+}
+
+func (ts *LDAPTestSuite) TestLDAPInvalidPassword() {
+	w := ts.passwordGrant("jdoe", "wrong")
+	ts.Require().Equal(http.StatusBadRequest, w.Code)
+	e := &OAuthError{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(e))
+	ts.Equal("invalid_grant", e.Err)
+
+	_, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "jdoe@example.com", ts.Config.JWT.Aud)
+	ts.True(models.IsNotFoundError(err))
+}
+
+func (ts *LDAPTestSuite) TestLDAPSignupDisabled() {
+	ts.Config.DisableSignup = true
+
+	w := ts.passwordGrant("jdoe", "jdoe-secret")
+	ts.Equal(http.StatusForbidden, w.Code)
+}
+
+func (ts *LDAPTestSuite) TestLocalUserFallback() {
+	// users who are not in the directory keep their own password
+	w := ts.passwordGrant("local@example.com", "password")
+	ts.Equal(http.StatusOK, w.Code)
+
+	w = ts.passwordGrant("local@example.com", "wrong")
+	ts.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (ts *LDAPTestSuite) TestLDAPPasswordMaxAge() {
+	ts.Config.Password.MaxAge = time.Nanosecond
+	defer func() { ts.Config.Password.MaxAge = 0 }()
+
+	// the directory manages the passwords of its users
+	w := ts.passwordGrant("jdoe", "jdoe-secret")
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	w = ts.passwordGrant("jdoe", "jdoe-secret")
+	ts.Equal(http.StatusOK, w.Code, w.Body.String())
+
+	w = ts.passwordGrant("local@example.com", "password")
+	ts.Require().Equal(http.StatusBadRequest, w.Code)
+	e := &OAuthError{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(e))
+	ts.Equal(passwordExpiredMessage, e.Description)
+}
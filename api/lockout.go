@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/tigrisdata/gotrue/models"
+)
+
+// checkLockout refuses password sign ins for an email or from an IP address
+// before their back-off delay has passed or while they are locked.
+func (a *API) checkLockout(ctx context.Context, w http.ResponseWriter, r *http.Request, email string) error {
+	config := a.getConfig(ctx)
+	instanceID := getInstanceID(ctx)
+
+	retryAt := time.Time{}
+	for kind, value := range map[string]string{
+		models.LoginFailureEmailKind: email,
+		models.LoginFailureIPKind:    a.clientIP(r),
+	} {
+		failure, err := models.FindLoginFailure(ctx, a.db, instanceID, kind, value)
+		if err != nil {
+			if models.IsNotFoundError(err) {
+				continue
+			}
+			return internalServerError("Database error finding login failures").WithInternalError(err)
+		}
+		if at := failure.RetryAt(config.Lockout.Delay, config.Lockout.Duration); at.After(retryAt) {
+			retryAt = at
+		}
+	}
+
+	if wait := time.Until(retryAt); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return httpError(http.StatusTooManyRequests, "Too many failed sign in attempts, retry later")
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed password sign in for the email and the
+// IP address. Errors are only logged, as the sign in fails anyway.
+func (a *API) recordLoginFailure(ctx context.Context, r *http.Request, email, aud string) {
+	config := a.getConfig(ctx)
+	instanceID := getInstanceID(ctx)
+
+	for kind, value := range map[string]string{
+		models.LoginFailureEmailKind: email,
+		models.LoginFailureIPKind:    a.clientIP(r),
+	} {
+		maxAttempts := config.Lockout.MaxAttempts
+		if kind == models.LoginFailureIPKind {
+			maxAttempts = config.Lockout.MaxIPAttempts
+		}
+		failure, locked, err := models.RecordLoginFailure(ctx, a.db, instanceID, kind, value, maxAttempts, config.Lockout.Duration)
+		if err != nil {
+			log.Error().Err(err).Str(kind, value).Msg("Error recording login failure")
+			continue
+		}
+		if locked {
+			log.Warn().Str(kind, value).Int("failed_attempts", failure.Count).Msg("Sign ins locked after too many failures")
+			a.loginLocked(ctx, failure, aud)
+		}
+	}
+}
+
+// loginLocked records a lock in the audit log and tells the user about it.
+func (a *API) loginLocked(ctx context.Context, failure *models.LoginFailure, aud string) {
+	config := a.getConfig(ctx)
+	instanceID := getInstanceID(ctx)
+
+	actor := models.NewSystemUser(instanceID, aud)
+	var user *models.User
+	if failure.Kind == models.LoginFailureEmailKind {
+		if u, err := models.FindUserByEmailAndAudience(ctx, a.db, instanceID, failure.Value, aud); err == nil {
+			user, actor = u, u
+		}
+	}
+
+	if err := models.NewAuditLogEntry(ctx, a.db, instanceID, actor, models.UserLockedAction, map[string]interface{}{
+		failure.Kind:      failure.Value,
+		"failed_attempts": failure.Count,
+		"locked_until":    failure.LockedUntil,
+	}); err != nil {
+		log.Error().Err(err).Msg("Error recording audit log entry")
+	}
+
+	if user != nil && config.Lockout.Notify {
+		if err := a.Mailer(ctx).LockoutMail(user, *failure.LockedUntil); err != nil {
+			log.Error().Err(err).Str("email", user.Email).Msg("Error sending lockout email")
+		}
+	}
+}
+
+// clearLoginFailure forgets the failures for an email once its user signed in.
+// Failures from the IP address are kept, so that an attacker with one account
+// cannot reset them.
+func (a *API) clearLoginFailure(ctx context.Context, email string) {
+	if err := models.ClearLoginFailure(ctx, a.db, getInstanceID(ctx), models.LoginFailureEmailKind, email); err != nil {
+		log.Error().Err(err).Str("email", email).Msg("Error clearing login failures")
+	}
+}
+
+// adminLockouts lists the emails and IP addresses locked after too many failed
+// sign ins.
+func (a *API) adminLockouts(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	failures, err := models.FindLockedLoginFailures(ctx, a.db, getInstanceID(ctx))
+	if err != nil {
+		return internalServerError("Database error finding lockouts").WithInternalError(err)
+	}
+	return sendJSON(w, http.StatusOK, map[string]interface{}{
+		"lockouts": failures,
+	})
+}
+
+// adminLockoutDelete unlocks an email or IP address.
+func (a *API) adminLockoutDelete(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	instanceID := getInstanceID(ctx)
+
+	id, err := uuid.Parse(chi.URLParam(r, "lockout_id"))
+	if err != nil {
+		return badRequestError("Invalid lockout ID")
+	}
+	failure, err := models.FindLoginFailureByID(ctx, a.db, instanceID, id)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return notFoundError("Lockout not found")
+		}
+		return internalServerError("Database error finding lockout").WithInternalError(err)
+	}
+
+	if err := a.unlock(ctx, failure); err != nil {
+		return err
+	}
+	return sendJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+// adminUserLockout returns the failed sign ins for the email of a user.
+func (a *API) adminUserLockout(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user := getUser(ctx)
+
+	failure, err := models.FindLoginFailure(ctx, a.db, getInstanceID(ctx), models.LoginFailureEmailKind, user.Email)
+	if err != nil {
+		if !models.IsNotFoundError(err) {
+			return internalServerError("Database error finding login failures").WithInternalError(err)
+		}
+		failure = &models.LoginFailure{Kind: models.LoginFailureEmailKind, Value: user.Email}
+	}
+	return sendJSON(w, http.StatusOK, failure)
+}
+
+// adminUserUnlock unlocks the email of a user.
+func (a *API) adminUserUnlock(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user := getUser(ctx)
+
+	failure, err := models.FindLoginFailure(ctx, a.db, getInstanceID(ctx), models.LoginFailureEmailKind, user.Email)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return sendJSON(w, http.StatusOK, map[string]interface{}{})
+		}
+		return internalServerError("Database error finding login failures").WithInternalError(err)
+	}
+
+	if err := a.unlock(ctx, failure); err != nil {
+		return err
+	}
+	return sendJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+func (a *API) unlock(ctx context.Context, failure *models.LoginFailure) error {
+	instanceID := getInstanceID(ctx)
+	adminUser := getAdminUser(ctx)
+
+	return a.db.Tx(ctx, func(ctx context.Context) error {
+		if terr := failure.Delete(ctx, a.db); terr != nil {
+			return internalServerError("Database error unlocking").WithInternalError(terr)
+		}
+		if terr := models.NewAuditLogEntry(ctx, a.db, instanceID, adminUser, models.UserUnlockedAction, map[string]interface{}{
+			failure.Kind: failure.Value,
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/tigrisdata/gotrue/conf"
+	"github.com/tigrisdata/gotrue/crypto"
+	"github.com/tigrisdata/gotrue/models"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+type LockoutTestSuite struct {
+	suite.Suite
+	API        *API
+	Config     *conf.Configuration
+	Encrypter  *crypto.AESBlockEncrypter
+	instanceID uuid.UUID
+}
+
+func TestLockout(t *testing.T) {
+	api, config, globalConf, instanceID, err := setupAPIForTestForInstance()
+	require.NoError(t, err)
+
+	ts := &LockoutTestSuite{
+		API:        api,
+		Config:     config,
+		Encrypter:  &crypto.AESBlockEncrypter{Key: globalConf.DB.EncryptionKey},
+		instanceID: instanceID,
+	}
+
+	suite.Run(t, ts)
+}
+
+func (ts *LockoutTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	u, err := models.NewUser(ts.instanceID, "test@example.com", "password", ts.Config.JWT.Aud, nil, ts.Encrypter)
+	require.NoError(ts.T(), err, "Error creating test user model")
+	_, err = tigris.GetCollection[models.User](ts.API.db).Insert(context.TODO(), u)
+	require.NoError(ts.T(), err, "Error saving new test user")
+	require.NoError(ts.T(), u.Confirm(context.TODO(), ts.API.db))
+
+	ts.Config.Lockout = conf.LockoutConfiguration{
+		Enabled:       true,
+		MaxAttempts:   3,
+		MaxIPAttempts: 10,
+		Delay:         time.Millisecond,
+		Duration:      time.Minute,
+	}
+}
+
+func (ts *LockoutTestSuite) TearDownTest() {
+	ts.Config.Lockout = conf.LockoutConfiguration{}
+}
+
+func (ts *LockoutTestSuite) passwordGrant(username, password, ip string) *httptest.ResponseRecorder {
+	form := url.Values{
+		"grant_type": {"password"},
+		"username":   {username},
+		"password":   {password},
+	}
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = ip + ":1234"
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *LockoutTestSuite) TestBackOff() {
+	ts.Config.Lockout.Delay = time.Minute
+
+	w := ts.passwordGrant("test@example.com", "wrong", "10.0.0.1")
+	ts.Require().Equal(http.StatusBadRequest, w.Code)
+
+	// even the right password has to wait for the delay
+	w = ts.passwordGrant("test@example.com", "password", "10.0.0.2")
+	ts.Require().Equal(http.StatusTooManyRequests, w.Code)
+	ts.Equal("60", w.Header().Get("Retry-After"))
+
+	// as do other emails from the same IP address
+	w = ts.passwordGrant("other@example.com", "password", "10.0.0.1")
+	ts.Equal(http.StatusTooManyRequests, w.Code)
+}
+
+func (ts *LockoutTestSuite) TestLockout() {
+	for i := 0; i < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+		w := ts.passwordGrant("test@example.com", "wrong", "10.0.0.1")
+		ts.Require().Equal(http.StatusBadRequest, w.Code)
+	}
+
+	failure, err := models.FindLoginFailure(context.TODO(), ts.API.db, ts.instanceID, models.LoginFailureEmailKind, "test@example.com")
+	ts.Require().NoError(err)
+	ts.Equal(3, failure.Count)
+	ts.True(failure.IsLocked())
+
+	w := ts.passwordGrant("test@example.com", "password", "10.0.0.2")
+	ts.Require().Equal(http.StatusTooManyRequests, w.Code)
+	ts.NotEmpty(w.Header().Get("Retry-After"))
+
+	require.NoError(ts.T(), models.ClearLoginFailure(context.TODO(), ts.API.db, ts.instanceID, models.LoginFailureEmailKind, "test@example.com"))
+	w = ts.passwordGrant("test@example.com", "password", "10.0.0.2")
+	ts.Equal(http.StatusOK, w.Code, w.Body.String())
+}
+
+func (ts *LockoutTestSuite) TestSuccessResetsFailures() {
+	for i := 0; i < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		w := ts.passwordGrant("test@example.com", "wrong", "10.0.0.1")
+		ts.Require().Equal(http.StatusBadRequest, w.Code)
+	}
+	time.Sleep(10 * time.Millisecond)
+	w := ts.passwordGrant("test@example.com", "password", "10.0.0.1")
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+
+	_, err := models.FindLoginFailure(context.TODO(), ts.API.db, ts.instanceID, models.LoginFailureEmailKind, "test@example.com")
+	ts.True(models.IsNotFoundError(err))
+
+	// failures from the IP address are kept
+	failure, err := models.FindLoginFailure(context.TODO(), ts.API.db, ts.instanceID, models.LoginFailureIPKind, "10.0.0.1")
+	ts.Require().NoError(err)
+	ts.Equal(2, failure.Count)
+}
+
+func (ts *LockoutTestSuite) TestUnknownEmail() {
+	for i := 0; i < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+		w := ts.passwordGrant("nobody@example.com", "wrong", "10.0.0.1")
+		ts.Require().Equal(http.StatusBadRequest, w.Code)
+	}
+
+	// unknown emails lock like known ones, so they cannot be told apart
+	w := ts.passwordGrant("nobody@example.com", "wrong", "10.0.0.2")
+	ts.Equal(http.StatusTooManyRequests, w.Code)
+}
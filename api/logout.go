@@ -23,7 +23,17 @@ func (a *API) Logout(w http.ResponseWriter, r *http.Request) error {
 		if terr := models.NewAuditLogEntry(ctx, a.db, instanceID, u, models.LogoutAction, nil); terr != nil {
 			return terr
 		}
-		return models.Logout(ctx, a.db, instanceID, u.ID)
+		// the presented token is revoked by its ID as well, since tokens of the
+		// system user are not covered by the per user revocation
+		if terr := a.revokeAccessToken(ctx, getClaims(ctx)); terr != nil {
+			return terr
+		}
+		// tokens acting on behalf of the user have no session of their own,
+		// and must not sign the user out
+		if getClaims(ctx).Act != nil {
+			return nil
+		}
+		return a.revokeUserTokens(ctx, u)
 	})
 	if err != nil {
 		return internalServerError("Error logging out user").WithInternalError(err)
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/tigrisdata/gotrue/conf"
+	"github.com/tigrisdata/gotrue/crypto"
+	"github.com/tigrisdata/gotrue/models"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+type LogoutTestSuite struct {
+	suite.Suite
+	API        *API
+	Config     *conf.Configuration
+	Encrypter  *crypto.AESBlockEncrypter
+	instanceID uuid.UUID
+}
+
+func TestLogout(t *testing.T) {
+	api, config, globalConf, instanceID, err := setupAPIForTestForInstance()
+	require.NoError(t, err)
+
+	ts := &LogoutTestSuite{
+		API:        api,
+		Config:     config,
+		Encrypter:  &crypto.AESBlockEncrypter{Key: globalConf.DB.EncryptionKey},
+		instanceID: instanceID,
+	}
+
+	suite.Run(t, ts)
+}
+
+func (ts *LogoutTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	u, err := models.NewUser(ts.instanceID, "test@example.com", "password", ts.Config.JWT.Aud, nil, ts.Encrypter)
+	require.NoError(ts.T(), err, "Error creating test user model")
+	_, err = tigris.GetCollection[models.User](ts.API.db).Insert(context.TODO(), u)
+	require.NoError(ts.T(), err, "Error saving new test user")
+	require.NoError(ts.T(), u.Confirm(context.TODO(), ts.API.db))
+}
+
+func (ts *LogoutTestSuite) login() *AccessTokenResponse {
+	form := url.Values{}
+	form.Set("grant_type", "password")
+	form.Set("username", "test@example.com")
+	form.Set("password", "password")
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	token := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(token))
+	return token
+}
+
+func (ts *LogoutTestSuite) getUser(token string) int {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/user", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w.Code
+}
+
+func (ts *LogoutTestSuite) TestLogoutRevokesAccessToken() {
+	token := ts.login()
+	require.Equal(ts.T(), http.StatusOK, ts.getUser(token.Token))
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/logout", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.Token))
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusNoContent, w.Code)
+
+	assert.Equal(ts.T(), http.StatusUnauthorized, ts.getUser(token.Token))
+}
+
+func (ts *LogoutTestSuite) TestRevokeSession() {
+	token := ts.login()
+
+	claims := &GoTrueClaims{}
+	_, _, err := new(jwt.Parser).ParseUnverified(token.Token, claims)
+	require.NoError(ts.T(), err)
+	require.NotEmpty(ts.T(), claims.Id)
+	require.NotEmpty(ts.T(), claims.SessionID)
+
+	req := httptest.NewRequest(http.MethodDelete, "http://localhost/user/sessions/"+claims.SessionID, nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.Token))
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusNoContent, w.Code)
+
+	assert.Equal(ts.T(), http.StatusUnauthorized, ts.getUser(token.Token))
+
+	_, _, err = models.FindUserWithRefreshToken(context.TODO(), ts.API.db, token.RefreshToken)
+	assert.True(ts.T(), models.IsNotFoundError(err))
+}
+
+func (ts *LogoutTestSuite) TestSignInRightAfterLogout() {
+	token := ts.login()
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/logout", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.Token))
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusNoContent, w.Code)
+
+	// likely within the second of the revocation, which must not cover the
+	// new session
+	newToken := ts.login()
+	assert.Equal(ts.T(), http.StatusOK, ts.getUser(newToken.Token))
+	assert.Equal(ts.T(), http.StatusUnauthorized, ts.getUser(token.Token))
+}
+
+func (ts *LogoutTestSuite) TestLogoutImpersonated() {
+	token := ts.login()
+	u, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	impersonation := impersonationToken(ts.T(), ts.API, ts.Config, u)
+
+	claims := &GoTrueClaims{}
+	_, _, err = new(jwt.Parser).ParseUnverified(token.Token, claims)
+	require.NoError(ts.T(), err)
+	req := httptest.NewRequest(http.MethodDelete, "http://localhost/user/sessions/"+claims.SessionID, nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", impersonation))
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "http://localhost/logout", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", impersonation))
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusNoContent, w.Code)
+
+	// only the impersonation token is revoked, the user stays signed in
+	assert.Equal(ts.T(), http.StatusUnauthorized, ts.getUser(impersonation))
+	assert.Equal(ts.T(), http.StatusOK, ts.getUser(token.Token))
+	_, _, err = models.FindUserWithRefreshToken(context.TODO(), ts.API.db, token.RefreshToken)
+	assert.NoError(ts.T(), err)
+}
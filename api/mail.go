@@ -70,12 +70,26 @@ func sendInvite(ctx context.Context, database *tigris.Database, u *models.User,
 }
 
 func (a *API) sendPasswordRecovery(ctx context.Context, database *tigris.Database, u *models.User, mailer mailer.Mailer, maxFrequency time.Duration, referrerURL string) error {
+	return a.mailPasswordRecovery(ctx, database, u, mailer, maxFrequency, referrerURL, crypto.SecureToken())
+}
+
+// resendPasswordRecovery sends the recovery token the user already has again,
+// so that earlier emails keep working. Users without one get a new token.
+func (a *API) resendPasswordRecovery(ctx context.Context, database *tigris.Database, u *models.User, mailer mailer.Mailer, maxFrequency time.Duration, referrerURL string) error {
+	token := u.RecoveryToken
+	if token == "" {
+		token = crypto.SecureToken()
+	}
+	return a.mailPasswordRecovery(ctx, database, u, mailer, maxFrequency, referrerURL, token)
+}
+
+func (a *API) mailPasswordRecovery(ctx context.Context, database *tigris.Database, u *models.User, mailer mailer.Mailer, maxFrequency time.Duration, referrerURL string, token string) error {
 	if u.RecoverySentAt != nil && !u.RecoverySentAt.Add(maxFrequency).Before(time.Now()) {
 		return nil
 	}
 
 	oldToken := u.RecoveryToken
-	u.RecoveryToken = crypto.SecureToken()
+	u.RecoveryToken = token
 	now := time.Now()
 	if err := mailer.RecoveryMail(u, referrerURL); err != nil {
 		u.RecoveryToken = oldToken
@@ -99,22 +113,41 @@ func (a *API) sendPasswordRecovery(ctx context.Context, database *tigris.Databas
 	return errors.Wrap(err, "Database error updating user for recovery")
 }
 
+// sendEmailChange mails a token confirming the change of email to the new
+// address, and in secure email changes another one to the current address.
 func (a *API) sendEmailChange(ctx context.Context, database *tigris.Database, u *models.User, mailer mailer.Mailer, email string, referrerURL string) error {
+	config := a.getConfig(ctx)
+
 	oldToken := u.EmailChangeToken
+	oldTokenCurrent := u.EmailChangeTokenCurrent
 	oldEmail := u.EmailChange
 	u.EmailChangeToken = crypto.SecureToken()
+	u.EmailChangeTokenCurrent = ""
+	if config.Mailer.SecureEmailChange {
+		u.EmailChangeTokenCurrent = crypto.SecureToken()
+	}
 	u.EmailChange = email
 	now := time.Now()
-	if err := mailer.EmailChangeMail(u, referrerURL); err != nil {
+	err := mailer.EmailChangeMail(u, referrerURL)
+	if err == nil && u.EmailChangeTokenCurrent != "" {
+		err = mailer.EmailChangeCurrentMail(u, referrerURL)
+	}
+	if err != nil {
 		u.EmailChangeToken = oldToken
+		u.EmailChangeTokenCurrent = oldTokenCurrent
 		u.EmailChange = oldEmail
 		return err
 	}
 
 	u.EmailChangeSentAt = &now
+	u.EmailChangeConfirmedNew = false
+	u.EmailChangeConfirmedCurrent = false
 
 	fieldsToSet, err := fields.UpdateBuilder().
 		Set("email_change_token", u.EmailChangeToken).
+		Set("email_change_token_current", u.EmailChangeTokenCurrent).
+		Set("email_change_confirmed_new", u.EmailChangeConfirmedNew).
+		Set("email_change_confirmed_current", u.EmailChangeConfirmedCurrent).
 		Set("email_change", u.EmailChange).
 		Set("email_change_sent_at", u.EmailChangeSentAt).
 		Build()
@@ -130,6 +163,33 @@ func (a *API) sendEmailChange(ctx context.Context, database *tigris.Database, u
 	return errors.Wrap(err, "Database error updating user for email change")
 }
 
+// confirmEmailChange confirms the change of email from the address the token
+// was sent to. The email is only swapped once every address a token was sent
+// to confirmed, in any order.
+func (a *API) confirmEmailChange(ctx context.Context, u *models.User, token string) error {
+	switch {
+	case u.EmailChangeToken != "" && token == u.EmailChangeToken:
+		u.EmailChangeToken = ""
+		u.EmailChangeConfirmedNew = true
+	case u.EmailChangeTokenCurrent != "" && token == u.EmailChangeTokenCurrent:
+		u.EmailChangeTokenCurrent = ""
+		u.EmailChangeConfirmedCurrent = true
+	default:
+		return unauthorizedError("Email Change Token didn't match token on file")
+	}
+
+	if !u.EmailChangeConfirmed() {
+		if err := u.UpdateEmailChangeConfirmation(ctx, a.db); err != nil {
+			return internalServerError("Error updating user").WithInternalError(err)
+		}
+		return nil
+	}
+	if err := u.ConfirmEmailChange(ctx, a.db); err != nil {
+		return internalServerError("Error updating user").WithInternalError(err)
+	}
+	return nil
+}
+
 func (a *API) validateEmail(ctx context.Context, email string) error {
 	if email == "" {
 		return unprocessableEntityError("An email address is required")
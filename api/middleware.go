@@ -8,10 +8,9 @@ import (
 	"io/ioutil"
 	"net/http"
 
-	"github.com/didip/tollbooth/v5"
-	"github.com/didip/tollbooth/v5/limiter"
 	"github.com/google/uuid"
 	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/tigrisdata/gotrue/conf"
 	"github.com/tigrisdata/gotrue/models"
 )
 
@@ -88,66 +87,63 @@ func (a *API) loadInstanceConfig(w http.ResponseWriter, r *http.Request) (contex
 		return nil, badRequestError("Operator signature missing")
 	}
 
+	claims, instanceID, config, err := a.signedInstanceConfig(ctx, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	logEntrySetField(r, "instance_id", instanceID)
+	logEntrySetField(r, "netlify_id", claims.NetlifyID)
+	logEntrySetField(r, "site_url", config.SiteURL)
+
+	ctx = withNetlifyID(ctx, claims.NetlifyID)
+	ctx = withFunctionHooks(ctx, claims.FunctionHooks)
+
+	ctx, err = WithInstanceConfig(ctx, config, instanceID)
+	if err != nil {
+		return nil, internalServerError("Error loading instance config").WithInternalError(err)
+	}
+
+	return ctx, nil
+}
+
+// signedInstanceConfig loads the configuration of the instance an operator
+// signature was issued for.
+func (a *API) signedInstanceConfig(ctx context.Context, signature string) (*NetlifyMicroserviceClaims, uuid.UUID, *conf.Configuration, error) {
 	claims := NetlifyMicroserviceClaims{}
 	p := jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Name}}
 	_, err := p.ParseWithClaims(signature, &claims, func(token *jwt.Token) (interface{}, error) {
 		return []byte(a.config.OperatorToken), nil
 	})
 	if err != nil {
-		return nil, badRequestError("Operator microservice signature is invalid: %v", err)
+		return nil, uuid.Nil, nil, badRequestError("Operator microservice signature is invalid: %v", err)
 	}
 
 	if claims.InstanceID == "" {
-		return nil, badRequestError("Instance ID is missing")
+		return nil, uuid.Nil, nil, badRequestError("Instance ID is missing")
 	}
 	instanceID, err := uuid.Parse(claims.InstanceID)
 	if err != nil {
-		return nil, badRequestError("Instance ID is not a valid UUID")
+		return nil, uuid.Nil, nil, badRequestError("Instance ID is not a valid UUID")
 	}
 
-	logEntrySetField(r, "instance_id", instanceID)
-	logEntrySetField(r, "netlify_id", claims.NetlifyID)
-	instance, err := models.GetInstance(r.Context(), a.db, instanceID)
+	instance, err := models.GetInstance(ctx, a.db, instanceID)
 	if err != nil {
 		if models.IsNotFoundError(err) {
-			return nil, notFoundError("Unable to locate site configuration")
+			return nil, uuid.Nil, nil, notFoundError("Unable to locate site configuration")
 		}
-		return nil, internalServerError("Database error loading instance").WithInternalError(err)
+		return nil, uuid.Nil, nil, internalServerError("Database error loading instance").WithInternalError(err)
 	}
 
 	config, err := instance.Config()
 	if err != nil {
-		return nil, internalServerError("Error loading environment config").WithInternalError(err)
+		return nil, uuid.Nil, nil, internalServerError("Error loading environment config").WithInternalError(err)
 	}
 
 	if claims.SiteURL != "" {
 		config.SiteURL = claims.SiteURL
 	}
-	logEntrySetField(r, "site_url", config.SiteURL)
-
-	ctx = withNetlifyID(ctx, claims.NetlifyID)
-	ctx = withFunctionHooks(ctx, claims.FunctionHooks)
-
-	ctx, err = WithInstanceConfig(ctx, config, instanceID)
-	if err != nil {
-		return nil, internalServerError("Error loading instance config").WithInternalError(err)
-	}
-
-	return ctx, nil
-}
-
-func (a *API) limitHandler(lmt *limiter.Limiter) middlewareHandler {
-	return func(w http.ResponseWriter, req *http.Request) (context.Context, error) {
-		c := req.Context()
-		if limitHeader := a.config.RateLimitHeader; limitHeader != "" {
-			key := req.Header.Get(a.config.RateLimitHeader)
-			err := tollbooth.LimitByKeys(lmt, []string{key})
-			if err != nil {
-				return c, httpError(http.StatusTooManyRequests, "Rate limit exceeded")
-			}
-		}
-		return c, nil
-	}
+	return &claims, instanceID, config, nil
 }
 
 func (a *API) verifyOperatorRequest(w http.ResponseWriter, req *http.Request) (context.Context, error) {
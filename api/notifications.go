@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/tigrisdata/gotrue/mailer"
+	"github.com/tigrisdata/gotrue/models"
+)
+
+// notify sends a security notification to a user when the instance enables
+// it. Errors are only logged, as the change has been made anyway.
+func (a *API) notify(ctx context.Context, enabled bool, user *models.User, kind string, send func(mailer.Mailer) error) {
+	if !enabled {
+		return
+	}
+	if err := send(a.Mailer(ctx)); err != nil {
+		log.Error().Err(err).Str("email", user.Email).Str("notification", kind).Msg("Error sending notification email")
+	}
+}
+
+func (a *API) notifyPasswordChanged(ctx context.Context, user *models.User) {
+	a.notify(ctx, a.getConfig(ctx).Notifications.PasswordChanged, user, "password_changed", func(m mailer.Mailer) error {
+		return m.PasswordChangedMail(user)
+	})
+}
+
+func (a *API) notifyEmailChanged(ctx context.Context, user *models.User, oldEmail string) {
+	a.notify(ctx, a.getConfig(ctx).Notifications.EmailChanged, user, "email_changed", func(m mailer.Mailer) error {
+		return m.EmailChangedMail(user, oldEmail)
+	})
+}
+
+func (a *API) notifyIdentityLinked(ctx context.Context, user *models.User, providerType string) {
+	a.notify(ctx, a.getConfig(ctx).Notifications.IdentityChanged, user, "identity_linked", func(m mailer.Mailer) error {
+		return m.IdentityLinkedMail(user, providerType)
+	})
+}
+
+func (a *API) notifyIdentityUnlinked(ctx context.Context, user *models.User, providerType string) {
+	a.notify(ctx, a.getConfig(ctx).Notifications.IdentityChanged, user, "identity_unlinked", func(m mailer.Mailer) error {
+		return m.IdentityUnlinkedMail(user, providerType)
+	})
+}
+
+// notifyAPIKeyCreated tells the user who created an API key about it. Keys
+// whose creator is not a user of the instance are not notified.
+func (a *API) notifyAPIKeyCreated(ctx context.Context, key *models.User) {
+	config := a.getConfig(ctx)
+	if !config.Notifications.APIKeyCreated || key.AppMetaData == nil || key.AppMetaData.KeyType != models.ApiKeyKeyType {
+		return
+	}
+
+	creatorID, err := uuid.Parse(GetUserIdFromSubject(key.AppMetaData.CreatedBy))
+	if err != nil {
+		return
+	}
+	creator, err := models.FindUserByInstanceIDAndID(ctx, a.db, getInstanceID(ctx), creatorID)
+	if err != nil {
+		if !models.IsNotFoundError(err) {
+			log.Error().Err(err).Str("created_by", key.AppMetaData.CreatedBy).Msg("Error finding creator of API key")
+		}
+		return
+	}
+
+	a.notify(ctx, true, creator, "api_key_created", func(m mailer.Mailer) error {
+		return m.APIKeyCreatedMail(creator, key)
+	})
+}
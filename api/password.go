@@ -0,0 +1,216 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/tigrisdata/gotrue/conf"
+	"github.com/tigrisdata/gotrue/models"
+)
+
+// breachedPrefixLength is the number of hex digits of SHA-1 hashes breached
+// password files are named after
+const breachedPrefixLength = 5
+
+// passwordExpiredMessage is the error of sign ins with a password older than
+// the maximum age
+const passwordExpiredMessage = "Password expired, check your email to reset it"
+
+// PasswordRequirement is a requirement a password does not meet.
+type PasswordRequirement struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+var characterClasses = map[string]func(rune) bool{
+	"lower":  unicode.IsLower,
+	"upper":  unicode.IsUpper,
+	"digit":  unicode.IsDigit,
+	"symbol": func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r) },
+}
+
+// checkPassword refuses passwords the instance does not allow users to set,
+// listing the requirements they do not meet.
+func (a *API) checkPassword(ctx context.Context, email, password string) error {
+	config := a.getConfig(ctx)
+
+	unmet := passwordPolicyViolations(&config.Password, config.SiteURL, email, password)
+	if config.Password.BreachedHashesDir != "" {
+		breached, err := passwordBreached(config.Password.BreachedHashesDir, password)
+		if err != nil {
+			return internalServerError("Error screening password").WithInternalError(err)
+		}
+		if breached {
+			unmet = append(unmet, PasswordRequirement{Code: "breached", Message: "Password is known from a data breach"})
+		}
+	}
+
+	if len(unmet) > 0 {
+		return unprocessableEntityError("Password does not meet the requirements").WithDetails(map[string]interface{}{
+			"password": unmet,
+		})
+	}
+	return nil
+}
+
+// checkPasswordReuse refuses the current password and the ones in the
+// password history of the user.
+func (a *API) checkPasswordReuse(ctx context.Context, user *models.User, password string) error {
+	config := a.getConfig(ctx)
+	if config.Password.HistorySize <= 0 {
+		return nil
+	}
+
+	reused := user.EncryptedPassword != "" && user.Authenticate(password, a.encrypter)
+	if !reused {
+		var err error
+		if reused, err = models.PasswordUsedBefore(ctx, a.db, user, password, config.Password.HistorySize); err != nil {
+			return internalServerError("Error reading password history").WithInternalError(err)
+		}
+	}
+	if reused {
+		return unprocessableEntityError("Password was used recently").WithDetails(map[string]interface{}{
+			"password": []PasswordRequirement{{
+				Code:    "reused",
+				Message: fmt.Sprintf("Password must not be one of the last %d passwords", config.Password.HistorySize),
+			}},
+		})
+	}
+	return nil
+}
+
+// recordPassword adds a password the user set to their password history.
+func (a *API) recordPassword(ctx context.Context, user *models.User, password string) error {
+	config := a.getConfig(ctx)
+	if config.Password.HistorySize <= 0 {
+		return nil
+	}
+	return models.AddPasswordHistory(ctx, a.db, user, password, config.Password.HistorySize)
+}
+
+// updatePassword changes the password of the user and records it in their
+// password history.
+func (a *API) updatePassword(ctx context.Context, user *models.User, password string) error {
+	if err := user.UpdatePassword(ctx, a.db, a.encrypter, password); err != nil {
+		return err
+	}
+	return a.recordPassword(ctx, user, password)
+}
+
+// passwordExpired refuses a sign in with a password older than the maximum
+// age and sends the user a recovery email to set a new one. Further sign ins
+// resend the same token, so they do not void the emails sent before.
+func (a *API) passwordExpired(ctx context.Context, r *http.Request, user *models.User) error {
+	config := a.getConfig(ctx)
+	mailer := a.Mailer(ctx)
+	if err := a.resendPasswordRecovery(ctx, a.db, user, mailer, config.SMTP.MaxFrequency, a.getReferrer(r)); err != nil {
+		return internalServerError("Error sending recovery email").WithInternalError(err)
+	}
+	log.Warn().Str("email", user.Email).Msg("Password expired")
+	return oauthError("invalid_grant", passwordExpiredMessage)
+}
+
+// passwordPolicyViolations returns the requirements of the policy the password
+// does not meet.
+func passwordPolicyViolations(policy *conf.PasswordConfiguration, siteURL, email, password string) []PasswordRequirement {
+	unmet := []PasswordRequirement{}
+
+	length := utf8.RuneCountInString(password)
+	if policy.MinLength > 0 && length < policy.MinLength {
+		unmet = append(unmet, PasswordRequirement{Code: "min_length", Message: fmt.Sprintf("Password must be at least %d characters long", policy.MinLength)})
+	}
+	if policy.MaxLength > 0 && length > policy.MaxLength {
+		unmet = append(unmet, PasswordRequirement{Code: "max_length", Message: fmt.Sprintf("Password must be at most %d characters long", policy.MaxLength)})
+	}
+
+	for _, class := range policy.RequiredCharacters {
+		is, ok := characterClasses[class]
+		if !ok || strings.IndexFunc(password, is) >= 0 {
+			continue
+		}
+		unmet = append(unmet, PasswordRequirement{Code: "characters_" + class, Message: fmt.Sprintf("Password must contain a %s character", class)})
+	}
+
+	banned := policy.BannedWords
+	if policy.BanEmailAndSiteName {
+		banned = append(append([]string{}, banned...), identityWords(siteURL, email)...)
+	}
+	lower := strings.ToLower(password)
+	for _, word := range banned {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" && strings.Contains(lower, word) {
+			unmet = append(unmet, PasswordRequirement{Code: "banned_word", Message: "Password must not contain common words, the email or the site name"})
+			break
+		}
+	}
+
+	return unmet
+}
+
+// identityWords are the email, its local part and the labels of the site name,
+// which guessers try first.
+func identityWords(siteURL, email string) []string {
+	words := []string{}
+	if email != "" {
+		words = append(words, email)
+		if at := strings.LastIndex(email, "@"); at > 0 {
+			words = append(words, email[:at])
+		}
+	}
+	if u, err := url.Parse(siteURL); err == nil && u.Hostname() != "" {
+		words = append(words, u.Hostname())
+		labels := strings.Split(u.Hostname(), ".")
+		for _, label := range labels[:len(labels)-1] {
+			// short labels like www are part of too many passwords
+			if len(label) > 3 {
+				words = append(words, label)
+			}
+		}
+	}
+	return words
+}
+
+// passwordBreached looks the SHA-1 hash of the password up in the file of its
+// prefix in dir. Only the prefix file is read.
+func passwordBreached(dir, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password)) // #nosec G401 -- SHA-1 is what breached password lists use
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:breachedPrefixLength], hash[breachedPrefixLength:]
+
+	f, err := os.Open(filepath.Join(dir, prefix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			f, err = os.Open(filepath.Join(dir, prefix+".txt"))
+		}
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.Wrap(err, "error opening breached password file")
+		}
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			line = line[:i]
+		}
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+	return false, errors.Wrap(scanner.Err(), "error reading breached password file")
+}
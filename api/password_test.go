@@ -0,0 +1,62 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tigrisdata/gotrue/conf"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := &conf.PasswordConfiguration{
+		MinLength:           8,
+		MaxLength:           20,
+		RequiredCharacters:  []string{"lower", "upper", "digit", "symbol"},
+		BannedWords:         []string{"tigris"},
+		BanEmailAndSiteName: true,
+	}
+
+	codes := func(password string) []string {
+		res := []string{}
+		for _, r := range passwordPolicyViolations(policy, "https://www.example.com", "jane.doe@example.org", password) {
+			res = append(res, r.Code)
+		}
+		return res
+	}
+
+	assert.Empty(t, codes("Correct-Horse-1"))
+	assert.Equal(t, []string{"min_length"}, codes("Aa1!"))
+	assert.Equal(t, []string{"max_length"}, codes("Correct-Horse-Battery-1"))
+	assert.Equal(t, []string{"characters_upper", "characters_symbol"}, codes("correcthorse1"))
+	assert.Equal(t, []string{"characters_digit"}, codes("Ünïcödé-Pässwörd"))
+	assert.Equal(t, []string{"banned_word"}, codes("My-TIGRIS-pw1"))
+	assert.Equal(t, []string{"banned_word"}, codes("Jane.Doe-1234"))
+	assert.Equal(t, []string{"banned_word"}, codes("Example-1234"))
+
+	// nothing is required by default
+	assert.Empty(t, passwordPolicyViolations(&conf.PasswordConfiguration{}, "https://example.com", "jane@example.com", "jane"))
+}
+
+func TestPasswordBreached(t *testing.T) {
+	dir := t.TempDir()
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "5BAA6"), []byte("003D68EB55068C33ACE09247EE4C639306B:3\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:9659365\r\n"), 0600))
+
+	breached, err := passwordBreached(dir, "password")
+	require.NoError(t, err)
+	assert.True(t, breached)
+
+	// no file for the prefix
+	breached, err = passwordBreached(dir, "Correct-Horse-1")
+	require.NoError(t, err)
+	assert.False(t, breached)
+
+	// prefix files with a .txt extension are found too
+	require.NoError(t, os.Rename(filepath.Join(dir, "5BAA6"), filepath.Join(dir, "5BAA6.txt")))
+	breached, err = passwordBreached(dir, "password")
+	require.NoError(t, err)
+	assert.True(t, breached)
+}
@@ -0,0 +1,165 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/tigrisdata/gotrue/conf"
+	"golang.org/x/oauth2"
+)
+
+// Sign in with Apple
+
+const (
+	defaultAppleAuthBase = "appleid.apple.com"
+	// client secrets are generated for every token request, so they can be short lived
+	appleClientSecretExpiry = 5 * time.Minute
+)
+
+type appleProvider struct {
+	*oauth2.Config
+	host  string
+	nonce string
+}
+
+type appleClaims struct {
+	jwt.RegisteredClaims
+	Nonce          string      `json:"nonce"`
+	Email          string      `json:"email"`
+	EmailVerified  booleanLike `json:"email_verified"`
+	IsPrivateEmail booleanLike `json:"is_private_email"`
+}
+
+// appleUser is the user the form of the first callback describes. Apple sends
+// the name of the user only this one time, and not in the ID token.
+type appleUser struct {
+	Name struct {
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+	} `json:"name"`
+}
+
+// NewAppleProvider creates a Sign in with Apple provider. ID tokens must carry
+// the given nonce.
+func NewAppleProvider(ext conf.AppleProviderConfiguration, nonce string) (OAuthProvider, error) {
+	if err := ext.Validate(); err != nil {
+		return nil, err
+	}
+
+	host := chooseHost(ext.URL, defaultAppleAuthBase)
+	secret, err := appleClientSecret(ext, host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &appleProvider{
+		Config: &oauth2.Config{
+			ClientID:     ext.ClientID,
+			ClientSecret: secret,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   host + "/auth/authorize",
+				TokenURL:  host + "/auth/token",
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+			RedirectURL: ext.RedirectURI,
+			Scopes:      []string{"name", "email"},
+		},
+		host:  host,
+		nonce: nonce,
+	}, nil
+}
+
+// appleClientSecret signs the JWT Apple expects as client secret with the
+// private key of the team
+func appleClientSecret(ext conf.AppleProviderConfiguration, host string) (string, error) {
+	key, err := jwt.ParseECPrivateKeyFromPEM([]byte(ext.PrivateKey))
+	if err != nil {
+		return "", errors.New("Invalid Apple private key: " + err.Error())
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+		Issuer:    ext.TeamID,
+		Subject:   ext.ClientID,
+		Audience:  jwt.ClaimStrings{host},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(appleClientSecretExpiry)),
+	})
+	token.Header["kid"] = ext.KeyID
+	return token.SignedString(key)
+}
+
+func (g appleProvider) AuthCodeURL(state string, opts ...oauth2.AuthCodeOption) string {
+	// Apple posts the callback when the name or email scope is requested
+	opts = append(opts, oauth2.SetAuthURLParam("response_mode", "form_post"))
+	if g.nonce != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", g.nonce))
+	}
+	return g.Config.AuthCodeURL(state, opts...)
+}
+
+func (g appleProvider) GetOAuthToken(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	return g.Exchange(ctx, code, opts...)
+}
+
+func (g appleProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*UserProvidedData, error) {
+	idToken, ok := tok.Extra("id_token").(string)
+	if !ok || idToken == "" {
+		return nil, errors.New("Apple did not return an ID token")
+	}
+
+	claims := &appleClaims{}
+	if err := getOIDCIssuer(g.host).parseIDToken(ctx, g.host+"/auth/keys", idToken, claims); err != nil {
+		return nil, err
+	}
+	if err := verifyIDTokenClaims(&claims.RegisteredClaims, g.host, g.ClientID); err != nil {
+		return nil, err
+	}
+	if claims.Nonce != g.nonce {
+		return nil, errors.New("Invalid ID token: nonce does not match")
+	}
+
+	raw, err := rawClaims(idToken)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &UserProvidedData{
+		Subject:  claims.Subject,
+		Claims:   raw,
+		Metadata: map[string]string{},
+	}
+
+	// private relay addresses forward to the user's inbox and are verified as well
+	if claims.Email != "" {
+		data.Emails = append(data.Emails, Email{
+			Email:    claims.Email,
+			Verified: bool(claims.EmailVerified) || bool(claims.IsPrivateEmail),
+			Primary:  true,
+		})
+	}
+
+	if len(data.Emails) <= 0 {
+		return nil, errors.New("Unable to find email with Apple provider")
+	}
+
+	return data, nil
+}
+
+// AppleUserName reads the name of the user from the user field Apple posts
+// to the callback on the first sign in.
+func AppleUserName(user string) string {
+	if user == "" {
+		return ""
+	}
+
+	var u appleUser
+	if err := json.Unmarshal([]byte(user), &u); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(u.Name.FirstName + " " + u.Name.LastName)
+}
@@ -19,6 +19,7 @@ type bitbucketProvider struct {
 }
 
 type bitbucketUser struct {
+	UUID   string `json:"uuid"`
 	Name   string `json:"display_name"`
 	Avatar struct {
 		Href string `json:"href"`
@@ -59,17 +60,20 @@ func NewBitbucketProvider(ext conf.OAuthProviderConfiguration) (OAuthProvider, e
 	}, nil
 }
 
-func (g bitbucketProvider) GetOAuthToken(code string) (*oauth2.Token, error) {
-	return g.Exchange(oauth2.NoContext, code)
+func (g bitbucketProvider) GetOAuthToken(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	return g.Exchange(ctx, code, opts...)
 }
 
 func (g bitbucketProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*UserProvidedData, error) {
 	var u bitbucketUser
-	if err := makeRequest(ctx, tok, g.Config, g.APIPath+"/user", &u); err != nil {
+	claims, err := makeClaimsRequest(ctx, tok, g.Config, g.APIPath+"/user", &u)
+	if err != nil {
 		return nil, err
 	}
 
 	data := &UserProvidedData{
+		Subject: u.UUID,
+		Claims:  claims,
 		Metadata: map[string]string{
 			nameKey:      u.Name,
 			avatarURLKey: u.Avatar.Href,
@@ -24,10 +24,11 @@ type facebookProvider struct {
 }
 
 type facebookUser struct {
-	Email     string `json:"email"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Alias     string `json:"name"`
+	ID        providerID `json:"id"`
+	Email     string     `json:"email"`
+	FirstName string     `json:"first_name"`
+	LastName  string     `json:"last_name"`
+	Alias     string     `json:"name"`
 	Avatar    struct {
 		Data struct {
 			URL string `json:"url"`
@@ -39,7 +40,7 @@ type facebookUser struct {
 func NewFacebookProvider(ext conf.OAuthProviderConfiguration) (OAuthProvider, error) {
 	authHost := chooseHost(ext.URL, defaultFacebookAuthBase)
 	tokenHost := chooseHost(ext.URL, defaultFacebookTokenBase)
-	profileURL := chooseHost(ext.URL, defaultFacebookAPIBase) + "/me?fields=email,first_name,last_name,name,picture"
+	profileURL := chooseHost(ext.URL, defaultFacebookAPIBase) + "/me?fields=id,email,first_name,last_name,name,picture"
 
 	return &facebookProvider{
 		Config: &oauth2.Config{
@@ -56,8 +57,8 @@ func NewFacebookProvider(ext conf.OAuthProviderConfiguration) (OAuthProvider, er
 	}, nil
 }
 
-func (p facebookProvider) GetOAuthToken(code string) (*oauth2.Token, error) {
-	return p.Exchange(oauth2.NoContext, code)
+func (p facebookProvider) GetOAuthToken(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	return p.Exchange(ctx, code, opts...)
 }
 
 func (p facebookProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*UserProvidedData, error) {
@@ -67,7 +68,8 @@ func (p facebookProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*
 
 	var u facebookUser
 	url := p.ProfileURL + "&appsecret_proof=" + appsecretProof
-	if err := makeRequest(ctx, tok, p.Config, url, &u); err != nil {
+	claims, err := makeClaimsRequest(ctx, tok, p.Config, url, &u)
+	if err != nil {
 		return nil, err
 	}
 
@@ -76,6 +78,8 @@ func (p facebookProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*
 	}
 
 	return &UserProvidedData{
+		Subject: string(u.ID),
+		Claims:  claims,
 		Metadata: map[string]string{
 			aliasKey:     u.Alias,
 			nameKey:      strings.TrimSpace(u.FirstName + " " + u.LastName),
@@ -22,9 +22,10 @@ type githubProvider struct {
 }
 
 type githubUser struct {
-	Email     string `json:"email"`
-	Name      string `json:"name"`
-	AvatarURL string `json:"avatar_url"`
+	ID        providerID `json:"id"`
+	Email     string     `json:"email"`
+	Name      string     `json:"name"`
+	AvatarURL string     `json:"avatar_url"`
 }
 
 type githubUserEmail struct {
@@ -60,17 +61,20 @@ func NewGithubProvider(ext conf.OAuthProviderConfiguration) (OAuthProvider, erro
 	}, nil
 }
 
-func (g githubProvider) GetOAuthToken(code string) (*oauth2.Token, error) {
-	return g.Exchange(oauth2.NoContext, code)
+func (g githubProvider) GetOAuthToken(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	return g.Exchange(ctx, code, opts...)
 }
 
 func (g githubProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*UserProvidedData, error) {
 	var u githubUser
-	if err := makeRequest(ctx, tok, g.Config, g.APIHost+"/user", &u); err != nil {
+	claims, err := makeClaimsRequest(ctx, tok, g.Config, g.APIHost+"/user", &u)
+	if err != nil {
 		return nil, err
 	}
 
 	data := &UserProvidedData{
+		Subject: string(u.ID),
+		Claims:  claims,
 		Metadata: map[string]string{
 			nameKey:      u.Name,
 			avatarURLKey: u.AvatarURL,
@@ -18,10 +18,11 @@ type gitlabProvider struct {
 }
 
 type gitlabUser struct {
-	Email       string `json:"email"`
-	Name        string `json:"name"`
-	AvatarURL   string `json:"avatar_url"`
-	ConfirmedAt string `json:"confirmed_at"`
+	ID          providerID `json:"id"`
+	Email       string     `json:"email"`
+	Name        string     `json:"name"`
+	AvatarURL   string     `json:"avatar_url"`
+	ConfirmedAt string     `json:"confirmed_at"`
 }
 
 type gitlabUserEmail struct {
@@ -51,18 +52,21 @@ func NewGitlabProvider(ext conf.OAuthProviderConfiguration) (OAuthProvider, erro
 	}, nil
 }
 
-func (g gitlabProvider) GetOAuthToken(code string) (*oauth2.Token, error) {
-	return g.Exchange(oauth2.NoContext, code)
+func (g gitlabProvider) GetOAuthToken(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	return g.Exchange(ctx, code, opts...)
 }
 
 func (g gitlabProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*UserProvidedData, error) {
 	var u gitlabUser
 
-	if err := makeRequest(ctx, tok, g.Config, g.Host+"/api/v4/user", &u); err != nil {
+	claims, err := makeClaimsRequest(ctx, tok, g.Config, g.Host+"/api/v4/user", &u)
+	if err != nil {
 		return nil, err
 	}
 
 	data := &UserProvidedData{
+		Subject: string(u.ID),
+		Claims:  claims,
 		Metadata: map[string]string{
 			nameKey:      u.Name,
 			avatarURLKey: u.AvatarURL,
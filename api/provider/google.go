@@ -19,10 +19,11 @@ type googleProvider struct {
 }
 
 type googleUser struct {
-	Name          string `json:"name"`
-	AvatarURL     string `json:"picture"`
-	Email         string `json:"email"`
-	EmailVerified bool   `json:"verified_email"`
+	ID            providerID `json:"id"`
+	Name          string     `json:"name"`
+	AvatarURL     string     `json:"picture"`
+	Email         string     `json:"email"`
+	EmailVerified bool       `json:"verified_email"`
 }
 
 // NewGoogleProvider creates a Google account provider.
@@ -52,17 +53,20 @@ func NewGoogleProvider(ext conf.OAuthProviderConfiguration) (OAuthProvider, erro
 	}, nil
 }
 
-func (g googleProvider) GetOAuthToken(code string) (*oauth2.Token, error) {
-	return g.Exchange(oauth2.NoContext, code)
+func (g googleProvider) GetOAuthToken(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	return g.Exchange(ctx, code, opts...)
 }
 
 func (g googleProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*UserProvidedData, error) {
 	var u googleUser
-	if err := makeRequest(ctx, tok, g.Config, g.APIPath, &u); err != nil {
+	claims, err := makeClaimsRequest(ctx, tok, g.Config, g.APIPath, &u)
+	if err != nil {
 		return nil, err
 	}
 
 	data := &UserProvidedData{
+		Subject: string(u.ID),
+		Claims:  claims,
 		Metadata: map[string]string{
 			nameKey:      u.Name,
 			avatarURLKey: u.AvatarURL,
@@ -0,0 +1,179 @@
+package provider
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/tigrisdata/gotrue/conf"
+)
+
+var (
+	// ErrLDAPUserNotFound is returned when the directory has no user with the username
+	ErrLDAPUserNotFound = errors.New("LDAP user not found")
+	// ErrLDAPInvalidCredentials is returned when the password of the user is wrong
+	ErrLDAPInvalidCredentials = errors.New("LDAP credentials are invalid")
+)
+
+// LDAPProvider authenticates users against an LDAP directory or Active
+// Directory with search and bind.
+type LDAPProvider struct {
+	Config conf.LDAPConfiguration
+}
+
+// NewLDAPProvider creates an LDAP account provider.
+func NewLDAPProvider(ext conf.LDAPConfiguration) (*LDAPProvider, error) {
+	if !ext.Enabled {
+		return nil, errors.New("LDAP provider is not enabled")
+	}
+	if ext.URL == "" || ext.BaseDN == "" {
+		return nil, errors.New("LDAP provider requires a URL and base DN")
+	}
+	if !strings.Contains(ext.UserFilter, "{username}") {
+		return nil, errors.New("LDAP user filter must contain {username}")
+	}
+	return &LDAPProvider{Config: ext}, nil
+}
+
+func (p LDAPProvider) dial() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: p.Config.InsecureSkipVerify}
+	conn, err := ldap.DialURL(p.Config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: p.Config.Timeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(p.Config.Timeout)
+
+	if p.Config.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Authenticate searches the user with the service account and binds as the
+// user to check the password. The user is mapped as configured.
+func (p LDAPProvider) Authenticate(username, password string) (*UserProvidedData, error) {
+	// binds without a password are unauthenticated and always succeed
+	if username == "" || password == "" {
+		return nil, ErrLDAPInvalidCredentials
+	}
+
+	conn, err := p.dial()
+	if err != nil {
+		return nil, fmt.Errorf("Connecting to LDAP server failed: %+v", err)
+	}
+	defer conn.Close()
+
+	if p.Config.BindDN != "" {
+		if err := conn.Bind(p.Config.BindDN, p.Config.BindPassword); err != nil {
+			return nil, fmt.Errorf("LDAP service account bind failed: %+v", err)
+		}
+	}
+
+	attributes := []string{p.Config.EmailAttribute, p.Config.GroupAttribute}
+	for _, name := range p.Config.Attributes {
+		attributes = append(attributes, name)
+	}
+	search := ldap.NewSearchRequest(
+		p.Config.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2, // more than one entry makes the username ambiguous
+		int(p.Config.Timeout.Seconds()),
+		false,
+		strings.ReplaceAll(p.Config.UserFilter, "{username}", ldap.EscapeFilter(username)),
+		attributes,
+		nil,
+	)
+	result, err := conn.Search(search)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, ErrLDAPUserNotFound
+		}
+		return nil, fmt.Errorf("LDAP user search failed: %+v", err)
+	}
+	switch len(result.Entries) {
+	case 0:
+		return nil, ErrLDAPUserNotFound
+	case 1:
+	default:
+		return nil, fmt.Errorf("LDAP user filter matches more than one user for %s", username)
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrLDAPInvalidCredentials
+		}
+		return nil, fmt.Errorf("LDAP user bind failed: %+v", err)
+	}
+
+	return p.userData(entry)
+}
+
+func (p LDAPProvider) userData(entry *ldap.Entry) (*UserProvidedData, error) {
+	email := entry.GetAttributeValue(p.Config.EmailAttribute)
+	if email == "" {
+		return nil, fmt.Errorf("LDAP user %s has no %s attribute", entry.DN, p.Config.EmailAttribute)
+	}
+
+	groups := entry.GetAttributeValues(p.Config.GroupAttribute)
+	data := &UserProvidedData{
+		// DNs change when users are moved, but are the only ID every
+		// directory has
+		Subject:  entry.DN,
+		Metadata: map[string]string{},
+		Claims: map[string]interface{}{
+			"dn":     entry.DN,
+			"groups": groups,
+		},
+		Emails: []Email{{
+			Email:    email,
+			Verified: true,
+			Primary:  true,
+		}},
+	}
+	for key, name := range p.Config.Attributes {
+		if v := entry.GetAttributeValue(name); v != "" {
+			data.Metadata[key] = v
+		}
+	}
+	data.Role = p.role(groups)
+	return data, nil
+}
+
+// role returns the role of the first group found in the role mapping. DNs are
+// compared case insensitively, as directories do.
+func (p LDAPProvider) role(groups []string) string {
+	for _, group := range groups {
+		for dn, role := range p.Config.Roles {
+			if strings.EqualFold(normalizeDN(dn), normalizeDN(group)) {
+				return role
+			}
+		}
+	}
+	return ""
+}
+
+func normalizeDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.TrimSpace(dn)
+	}
+	parts := make([]string, 0, len(parsed.RDNs))
+	for _, rdn := range parsed.RDNs {
+		attrs := make([]string, 0, len(rdn.Attributes))
+		for _, attr := range rdn.Attributes {
+			attrs = append(attrs, attr.Type+"="+attr.Value)
+		}
+		parts = append(parts, strings.Join(attrs, "+"))
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,138 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tigrisdata/gotrue/conf"
+	"golang.org/x/oauth2"
+)
+
+// Microsoft identity platform (Entra ID)
+
+const (
+	defaultMicrosoftAuthBase = "login.microsoftonline.com"
+	defaultMicrosoftTenant   = "common"
+)
+
+type microsoftProvider struct {
+	*oauth2.Config
+	ext    conf.MicrosoftProviderConfiguration
+	host   string
+	tenant string
+	nonce  string
+}
+
+type microsoftClaims struct {
+	oidcClaims
+	TenantID string `json:"tid"`
+	ObjectID string `json:"oid"`
+	// EmailDomainVerified is only set when the tenant owns the email's domain
+	EmailDomainVerified booleanLike `json:"xms_edov"`
+}
+
+// NewMicrosoftProvider creates a Microsoft account provider. ID tokens must
+// carry the given nonce.
+func NewMicrosoftProvider(ext conf.MicrosoftProviderConfiguration, nonce string) (OAuthProvider, error) {
+	if err := ext.Validate(); err != nil {
+		return nil, err
+	}
+
+	tenant := ext.Tenant
+	if tenant == "" {
+		tenant = defaultMicrosoftTenant
+	}
+	host := chooseHost(ext.URL, defaultMicrosoftAuthBase)
+
+	return &microsoftProvider{
+		Config: &oauth2.Config{
+			ClientID:     ext.ClientID,
+			ClientSecret: ext.Secret,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  host + "/" + tenant + "/oauth2/v2.0/authorize",
+				TokenURL: host + "/" + tenant + "/oauth2/v2.0/token",
+			},
+			RedirectURL: ext.RedirectURI,
+			Scopes:      []string{"openid", "email", "profile"},
+		},
+		ext:    ext,
+		host:   host,
+		tenant: tenant,
+		nonce:  nonce,
+	}, nil
+}
+
+func (g microsoftProvider) AuthCodeURL(state string, opts ...oauth2.AuthCodeOption) string {
+	if g.nonce != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", g.nonce))
+	}
+	return g.Config.AuthCodeURL(state, opts...)
+}
+
+func (g microsoftProvider) GetOAuthToken(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	return g.Exchange(ctx, code, opts...)
+}
+
+func (g microsoftProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*UserProvidedData, error) {
+	idToken, ok := tok.Extra("id_token").(string)
+	if !ok || idToken == "" {
+		return nil, errors.New("Microsoft did not return an ID token")
+	}
+
+	claims := &microsoftClaims{}
+	issuer := getOIDCIssuer(g.host + "/" + g.tenant + "/v2.0")
+	if err := issuer.parseIDToken(ctx, g.host+"/"+g.tenant+"/discovery/v2.0/keys", idToken, claims); err != nil {
+		return nil, err
+	}
+	// multi-tenant endpoints issue tokens of every tenant, each with its own issuer
+	if err := verifyIDTokenClaims(&claims.RegisteredClaims, fmt.Sprintf("%s/%s/v2.0", g.host, claims.TenantID), g.ClientID); err != nil {
+		return nil, err
+	}
+	if claims.Nonce != g.nonce {
+		return nil, errors.New("Invalid ID token: nonce does not match")
+	}
+	if claims.TenantID == "" || !g.ext.AllowsTenant(claims.TenantID) {
+		return nil, fmt.Errorf("Microsoft tenant %q is not allowed", claims.TenantID)
+	}
+
+	raw, err := rawClaims(idToken)
+	if err != nil {
+		return nil, err
+	}
+
+	objectID := claims.ObjectID
+	if objectID == "" {
+		objectID = claims.Subject
+	}
+
+	data := &UserProvidedData{
+		// object IDs are only unique within their tenant
+		Subject: claims.TenantID + "|" + objectID,
+		Claims:  raw,
+		Metadata: map[string]string{
+			nameKey: claims.Name,
+		},
+	}
+
+	// users can set the email of their account to any address, so it is only
+	// trusted when the tenant owns its domain
+	email := claims.Email
+	if email == "" && strings.Contains(claims.PreferredUsername, "@") {
+		email = claims.PreferredUsername
+	}
+	if email != "" {
+		data.Emails = append(data.Emails, Email{
+			Email:    email,
+			Verified: bool(claims.EmailDomainVerified),
+			Primary:  true,
+		})
+	}
+
+	if len(data.Emails) <= 0 {
+		return nil, errors.New("Unable to find email with Microsoft provider")
+	}
+
+	return data, nil
+}
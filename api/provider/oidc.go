@@ -0,0 +1,397 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/tigrisdata/gotrue/conf"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// discovery documents are refetched after this long
+	oidcDiscoveryTTL = time.Hour
+	// unknown key IDs trigger a JWKS refetch at most this often
+	oidcKeysRefreshInterval = time.Minute
+)
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// oidcIssuer caches the discovery document and signing keys of an issuer
+type oidcIssuer struct {
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	discoveredAt  time.Time
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+var (
+	oidcIssuersMu sync.Mutex
+	oidcIssuers   = map[string]*oidcIssuer{}
+)
+
+func getOIDCIssuer(issuer string) *oidcIssuer {
+	oidcIssuersMu.Lock()
+	defer oidcIssuersMu.Unlock()
+
+	i, ok := oidcIssuers[issuer]
+	if !ok {
+		i = &oidcIssuer{}
+		oidcIssuers[issuer] = i
+	}
+	return i
+}
+
+type oidcProvider struct {
+	*oauth2.Config
+	issuer    *oidcIssuer
+	discovery *oidcDiscovery
+	nonce     string
+}
+
+type oidcClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string      `json:"nonce"`
+	Email             string      `json:"email"`
+	EmailVerified     booleanLike `json:"email_verified"`
+	Name              string      `json:"name"`
+	Picture           string      `json:"picture"`
+	PreferredUsername string      `json:"preferred_username"`
+}
+
+// booleanLike accepts both booleans and strings, as some providers send
+// email_verified as "true"
+type booleanLike bool
+
+func (b *booleanLike) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case bool:
+		*b = booleanLike(t)
+	case string:
+		*b = booleanLike(strings.EqualFold(t, "true"))
+	}
+	return nil
+}
+
+// NewOIDCProvider creates a generic OpenID Connect provider, looking up its
+// endpoints through discovery. ID tokens must carry the given nonce.
+func NewOIDCProvider(ctx context.Context, ext conf.OIDCProviderConfiguration, nonce string) (OAuthProvider, error) {
+	if err := ext.Validate(); err != nil {
+		return nil, err
+	}
+
+	issuer := getOIDCIssuer(strings.TrimSuffix(ext.Issuer, "/"))
+	discovery, err := issuer.getDiscovery(ctx, ext.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := ext.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	return &oidcProvider{
+		Config: &oauth2.Config{
+			ClientID:     ext.ClientID,
+			ClientSecret: ext.Secret,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  discovery.AuthorizationEndpoint,
+				TokenURL: discovery.TokenEndpoint,
+			},
+			Scopes:      scopes,
+			RedirectURL: ext.RedirectURI,
+		},
+		issuer:    issuer,
+		discovery: discovery,
+		nonce:     nonce,
+	}, nil
+}
+
+func (g oidcProvider) AuthCodeURL(state string, opts ...oauth2.AuthCodeOption) string {
+	if g.nonce != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", g.nonce))
+	}
+	return g.Config.AuthCodeURL(state, opts...)
+}
+
+func (g oidcProvider) GetOAuthToken(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	return g.Exchange(ctx, code, opts...)
+}
+
+func (g oidcProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*UserProvidedData, error) {
+	idToken, ok := tok.Extra("id_token").(string)
+	if !ok || idToken == "" {
+		return nil, errors.New("OIDC provider did not return an ID token")
+	}
+
+	claims, err := g.verifyIDToken(ctx, idToken)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := rawClaims(idToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// ID tokens do not always carry the profile, the userinfo endpoint does
+	if claims.Email == "" && g.discovery.UserinfoEndpoint != "" {
+		var info oidcClaims
+		infoClaims, err := makeClaimsRequest(ctx, tok, g.Config, g.discovery.UserinfoEndpoint, &info)
+		if err != nil {
+			return nil, err
+		}
+		if info.Subject != claims.Subject {
+			return nil, errors.New("OIDC userinfo subject does not match the ID token")
+		}
+		info.RegisteredClaims = claims.RegisteredClaims
+		claims = &info
+		for k, v := range infoClaims {
+			raw[k] = v
+		}
+	}
+
+	data := &UserProvidedData{
+		Subject: claims.Subject,
+		Claims:  raw,
+		Metadata: map[string]string{
+			nameKey:      claims.Name,
+			avatarURLKey: claims.Picture,
+			aliasKey:     claims.PreferredUsername,
+		},
+	}
+
+	if claims.Email != "" {
+		data.Emails = append(data.Emails, Email{
+			Email:    claims.Email,
+			Verified: bool(claims.EmailVerified),
+			Primary:  true,
+		})
+	}
+
+	if len(data.Emails) <= 0 {
+		return nil, errors.New("Unable to find email with OIDC provider")
+	}
+
+	return data, nil
+}
+
+// verifyIDToken checks the signature of an ID token against the issuer's JWKS,
+// and its issuer, audience, expiry and nonce.
+func (g oidcProvider) verifyIDToken(ctx context.Context, idToken string) (*oidcClaims, error) {
+	claims := &oidcClaims{}
+	if err := g.issuer.parseIDToken(ctx, g.discovery.JWKSURI, idToken, claims); err != nil {
+		return nil, err
+	}
+	if err := verifyIDTokenClaims(&claims.RegisteredClaims, g.discovery.Issuer, g.ClientID); err != nil {
+		return nil, err
+	}
+	if claims.Nonce != g.nonce {
+		return nil, errors.New("Invalid ID token: nonce does not match")
+	}
+	return claims, nil
+}
+
+// parseIDToken parses an ID token into claims, checking its signature against
+// the issuer's JWKS
+func (i *oidcIssuer) parseIDToken(ctx context.Context, jwksURI string, idToken string, claims jwt.Claims) error {
+	p := jwt.Parser{ValidMethods: []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}}
+	_, err := p.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return i.getKey(ctx, jwksURI, kid)
+	})
+	if err != nil {
+		return fmt.Errorf("Invalid ID token: %w", err)
+	}
+	return nil
+}
+
+// rawClaims returns all claims of an ID token that was verified before
+func rawClaims(idToken string) (map[string]interface{}, error) {
+	raw := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(idToken, raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// verifyIDTokenClaims checks the issuer, audience and expiry of an ID token
+func verifyIDTokenClaims(claims *jwt.RegisteredClaims, issuer string, audience string) error {
+	if !claims.VerifyIssuer(issuer, true) {
+		return errors.New("Invalid ID token: unexpected issuer")
+	}
+	if !claims.VerifyAudience(audience, true) {
+		return errors.New("Invalid ID token: unexpected audience")
+	}
+	if !claims.VerifyExpiresAt(time.Now(), true) {
+		return errors.New("Invalid ID token: token is expired")
+	}
+	return nil
+}
+
+func (i *oidcIssuer) getDiscovery(ctx context.Context, issuer string) (*oidcDiscovery, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.discovery != nil && time.Since(i.discoveredAt) < oidcDiscoveryTTL {
+		return i.discovery, nil
+	}
+
+	discovery := &oidcDiscovery{}
+	if err := getJSON(ctx, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", discovery); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	// the issuer must be exactly the one we asked for (OpenID Connect Discovery 4.3)
+	if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", discovery.Issuer, issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document is missing endpoints")
+	}
+
+	i.discovery = discovery
+	i.discoveredAt = time.Now()
+	return discovery, nil
+}
+
+func (i *oidcIssuer) getKey(ctx context.Context, jwksURI string, kid string) (crypto.PublicKey, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if key, ok := i.findKey(kid); ok {
+		return key, nil
+	}
+	// the provider may have rotated its keys
+	if time.Since(i.keysFetchedAt) < oidcKeysRefreshInterval {
+		return nil, fmt.Errorf("Unknown signing key %q", kid)
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(ctx, jwksURI, &jwks); err != nil {
+		return nil, fmt.Errorf("Fetching OIDC signing keys failed: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	i.keys = keys
+	i.keysFetchedAt = time.Now()
+
+	if key, ok := i.findKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("Unknown signing key %q", kid)
+}
+
+func (i *oidcIssuer) findKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(i.keys) == 1 {
+		for _, key := range i.keys {
+			return key, true
+		}
+	}
+	key, ok := i.keys[kid]
+	return key, ok
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("Unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported key type %q", k.Kty)
+	}
+}
+
+func getJSON(ctx context.Context, url string, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return &RequestError{code: res.StatusCode}
+	}
+	return json.NewDecoder(res.Body).Decode(dst)
+}
@@ -13,6 +13,10 @@ const (
 	avatarURLKey = "avatar_url"
 	nameKey      = "full_name"
 	aliasKey     = "slug"
+
+	// SamlSessionIndexClaim keeps the session at the identity provider, which
+	// Single Logout ends
+	SamlSessionIndexClaim = "session_index"
 )
 
 type Email struct {
@@ -22,8 +26,15 @@ type Email struct {
 }
 
 type UserProvidedData struct {
+	// Subject identifies the user at the provider and never changes, unlike
+	// their emails
+	Subject  string
 	Emails   []Email
 	Metadata map[string]string
+	// Claims are the user attributes as returned by the provider
+	Claims map[string]interface{}
+	// Role is the role the provider assigns the user, if any
+	Role string
 }
 
 // Provider is an interface for interacting with external account providers
@@ -35,7 +46,9 @@ type Provider interface {
 type OAuthProvider interface {
 	AuthCodeURL(string, ...oauth2.AuthCodeOption) string
 	GetUserData(context.Context, *oauth2.Token) (*UserProvidedData, error)
-	GetOAuthToken(string) (*oauth2.Token, error)
+	GetOAuthToken(context.Context, string, ...oauth2.AuthCodeOption) (*oauth2.Token, error)
+	// TokenSource refreshes the token once it expires
+	TokenSource(context.Context, *oauth2.Token) oauth2.TokenSource
 }
 
 func chooseHost(base, defaultHost string) string {
@@ -60,6 +73,40 @@ func (r *RequestError) Error() string {
 	return fmt.Sprintf("Request failed with status %d:\n%s", r.code, r.body)
 }
 
+// makeClaimsRequest is makeRequest that also returns the raw response as claims
+func makeClaimsRequest(ctx context.Context, tok *oauth2.Token, g *oauth2.Config, url string, dst interface{}) (map[string]interface{}, error) {
+	var raw json.RawMessage
+	if err := makeRequest(ctx, tok, g, url, &raw); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, dst); err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]interface{})
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// providerID accepts user IDs sent as numbers as well as strings
+type providerID string
+
+func (p *providerID) UnmarshalJSON(data []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err == nil {
+		*p = providerID(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*p = providerID(s)
+	return nil
+}
+
 func makeRequest(ctx context.Context, tok *oauth2.Token, g *oauth2.Config, url string, dst interface{}) error {
 	client := g.Client(ctx, tok)
 	res, err := client.Get(url)
@@ -1,14 +1,34 @@
 package provider_test
 
 import (
+	"bytes"
+	"compress/flate"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/beevik/etree"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	saml2 "github.com/russellhaering/gosaml2"
+	"github.com/russellhaering/gosaml2/types"
+	dsig "github.com/russellhaering/goxmldsig"
 	"github.com/tigrisdata/gotrue/api/provider"
+	"github.com/tigrisdata/gotrue/api/provider/ldaptest"
 	"github.com/tigrisdata/gotrue/conf"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -42,3 +62,615 @@ func TestGithubFail(t *testing.T) {
 	require.Nil(t, user)
 	assert.Equal(t, "Request failed with status 418:\nSomething failed", err.Error())
 }
+
+func TestGithubUserData(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v3/user":
+			fmt.Fprint(rw, `{"id":583231,"login":"octocat","name":"Octocat"}`)
+		case "/api/v3/user/emails":
+			fmt.Fprint(rw, `[{"email":"octocat@example.com","primary":true,"verified":true}]`)
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	gh, err := provider.NewGithubProvider(conf.OAuthProviderConfiguration{
+		ClientID:    "client-id",
+		Secret:      "secret",
+		RedirectURI: "https://redirect.example.org/callback",
+		URL:         srv.URL,
+		Enabled:     true,
+	})
+	require.NoError(t, err)
+
+	user, err := gh.GetUserData(context.Background(), &oauth2.Token{
+		AccessToken: "my-token",
+		Expiry:      time.Now().Add(time.Minute),
+	})
+	require.NoError(t, err)
+	// numeric IDs are kept as they are, without exponent or fraction
+	assert.Equal(t, "583231", user.Subject)
+	assert.Equal(t, "octocat", user.Claims["login"])
+	assert.Equal(t, "Octocat", user.Metadata["full_name"])
+}
+
+type oidcTestServer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+}
+
+func newOIDCTestServer(t *testing.T) *oidcTestServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	s := &oidcTestServer{key: key}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(rw, `{"issuer":%q,"authorization_endpoint":"%[1]s/authorize","token_endpoint":"%[1]s/token","userinfo_endpoint":"%[1]s/userinfo","jwks_uri":"%[1]s/jwks"}`, s.URL)
+		case "/jwks", "/common/discovery/v2.0/keys", "/auth/keys":
+			n := base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+			e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+			fmt.Fprintf(rw, `{"keys":[{"kty":"RSA","kid":"test","use":"sig","n":%q,"e":%q}]}`, n, e)
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *oidcTestServer) idToken(t *testing.T, claims jwt.MapClaims) *oauth2.Token {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString(s.key)
+	require.NoError(t, err)
+
+	return (&oauth2.Token{AccessToken: "my-token", Expiry: time.Now().Add(time.Minute)}).WithExtra(map[string]interface{}{
+		"id_token": signed,
+	})
+}
+
+func (s *oidcTestServer) provider(t *testing.T, nonce string) provider.OAuthProvider {
+	p, err := provider.NewOIDCProvider(context.Background(), conf.OIDCProviderConfiguration{
+		Name:        "test",
+		Issuer:      s.URL,
+		ClientID:    "client-id",
+		Secret:      "secret",
+		RedirectURI: "https://redirect.example.org/callback",
+		Enabled:     true,
+	}, nonce)
+	require.NoError(t, err)
+	return p
+}
+
+func TestOIDC(t *testing.T) {
+	srv := newOIDCTestServer(t)
+	p := srv.provider(t, "nonce")
+
+	authURL, err := url.Parse(p.AuthCodeURL("state"))
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL+"/authorize", authURL.Scheme+"://"+authURL.Host+authURL.Path)
+	assert.Equal(t, "nonce", authURL.Query().Get("nonce"))
+	assert.Equal(t, "openid email profile", authURL.Query().Get("scope"))
+
+	claims := jwt.MapClaims{
+		"iss":            srv.URL,
+		"sub":            "1234",
+		"aud":            "client-id",
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          "nonce",
+		"email":          "oidc@example.com",
+		"email_verified": "true",
+		"name":           "OIDC Test",
+		"picture":        "http://example.com/avatar",
+	}
+	user, err := p.GetUserData(context.Background(), srv.idToken(t, claims))
+	require.NoError(t, err)
+	require.Len(t, user.Emails, 1)
+	assert.Equal(t, provider.Email{Email: "oidc@example.com", Verified: true, Primary: true}, user.Emails[0])
+	assert.Equal(t, "OIDC Test", user.Metadata["full_name"])
+	assert.Equal(t, "http://example.com/avatar", user.Metadata["avatar_url"])
+	assert.Equal(t, "1234", user.Subject)
+	assert.Equal(t, "1234", user.Claims["sub"])
+}
+
+func TestOIDCInvalidIDToken(t *testing.T) {
+	srv := newOIDCTestServer(t)
+	p := srv.provider(t, "nonce")
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   srv.URL,
+			"sub":   "1234",
+			"aud":   "client-id",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": "nonce",
+			"email": "oidc@example.com",
+		}
+	}
+
+	cases := map[string]func(jwt.MapClaims){
+		"nonce":   func(c jwt.MapClaims) { c["nonce"] = "other" },
+		"aud":     func(c jwt.MapClaims) { c["aud"] = "other-client" },
+		"iss":     func(c jwt.MapClaims) { c["iss"] = "https://other.example.org" },
+		"exp":     func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"missing": func(c jwt.MapClaims) { delete(c, "exp") },
+	}
+	for name, modify := range cases {
+		t.Run(name, func(t *testing.T) {
+			claims := valid()
+			modify(claims)
+			_, err := p.GetUserData(context.Background(), srv.idToken(t, claims))
+			require.Error(t, err)
+		})
+	}
+
+	// tokens signed by another key are rejected
+	other := newOIDCTestServer(t)
+	_, err := p.GetUserData(context.Background(), other.idToken(t, valid()))
+	require.Error(t, err)
+}
+
+func TestMicrosoft(t *testing.T) {
+	srv := newOIDCTestServer(t)
+	tenant := "9122040d-6c67-4c5b-b112-36a304b66dad"
+
+	p, err := provider.NewMicrosoftProvider(conf.MicrosoftProviderConfiguration{
+		OAuthProviderConfiguration: conf.OAuthProviderConfiguration{
+			ClientID:    "client-id",
+			Secret:      "secret",
+			RedirectURI: "https://redirect.example.org/callback",
+			URL:         srv.URL,
+			Enabled:     true,
+		},
+		AllowedTenants: []string{tenant},
+	}, "nonce")
+	require.NoError(t, err)
+
+	authURL, err := url.Parse(p.AuthCodeURL("state"))
+	require.NoError(t, err)
+	assert.Equal(t, "/common/oauth2/v2.0/authorize", authURL.Path)
+	assert.Equal(t, "nonce", authURL.Query().Get("nonce"))
+
+	claims := func(tid string) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   srv.URL + "/" + tid + "/v2.0",
+			"sub":   "pairwise",
+			"aud":   "client-id",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": "nonce",
+			"tid":   tid,
+			"oid":   "object-id",
+			"email": "microsoft@example.com",
+			"name":  "Microsoft Test",
+		}
+	}
+
+	user, err := p.GetUserData(context.Background(), srv.idToken(t, claims(tenant)))
+	require.NoError(t, err)
+	assert.Equal(t, tenant+"|object-id", user.Subject)
+	assert.Equal(t, "Microsoft Test", user.Metadata["full_name"])
+	// the email is only verified when the tenant owns its domain
+	assert.Equal(t, provider.Email{Email: "microsoft@example.com", Verified: false, Primary: true}, user.Emails[0])
+
+	verified := claims(tenant)
+	verified["xms_edov"] = true
+	user, err = p.GetUserData(context.Background(), srv.idToken(t, verified))
+	require.NoError(t, err)
+	assert.True(t, user.Emails[0].Verified)
+
+	_, err = p.GetUserData(context.Background(), srv.idToken(t, claims("f8cdef31-a31e-4b4a-93e4-5f571e91255a")))
+	require.Error(t, err, "tenants not allowed must be rejected")
+
+	// the issuer must be the one of the token's tenant
+	spoofed := claims(tenant)
+	spoofed["iss"] = srv.URL + "/f8cdef31-a31e-4b4a-93e4-5f571e91255a/v2.0"
+	_, err = p.GetUserData(context.Background(), srv.idToken(t, spoofed))
+	require.Error(t, err)
+}
+
+func TestApple(t *testing.T) {
+	srv := newOIDCTestServer(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	p, err := provider.NewAppleProvider(conf.AppleProviderConfiguration{
+		ClientID:    "com.example.app",
+		TeamID:      "TEAM123",
+		KeyID:       "KEY123",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		RedirectURI: "https://redirect.example.org/callback",
+		URL:         srv.URL,
+		Enabled:     true,
+	}, "nonce")
+	require.NoError(t, err)
+
+	authURL, err := url.Parse(p.AuthCodeURL("state"))
+	require.NoError(t, err)
+	assert.Equal(t, "form_post", authURL.Query().Get("response_mode"))
+	assert.Equal(t, "name email", authURL.Query().Get("scope"))
+
+	user, err := p.GetUserData(context.Background(), srv.idToken(t, jwt.MapClaims{
+		"iss":              srv.URL,
+		"sub":              "001234.abcdef.1234",
+		"aud":              "com.example.app",
+		"exp":              time.Now().Add(time.Minute).Unix(),
+		"nonce":            "nonce",
+		"email":            "xyz@privaterelay.appleid.com",
+		"is_private_email": "true",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "001234.abcdef.1234", user.Subject)
+	assert.Equal(t, provider.Email{Email: "xyz@privaterelay.appleid.com", Verified: true, Primary: true}, user.Emails[0])
+
+	assert.Equal(t, "Apple Test", provider.AppleUserName(`{"name":{"firstName":"Apple","lastName":"Test"}}`))
+	assert.Equal(t, "", provider.AppleUserName("not json"))
+}
+
+func TestSamlUserData(t *testing.T) {
+	p := provider.SamlProvider{
+		Config: conf.SamlProviderConfiguration{
+			AttributeMapping: conf.SamlAttributeMapping{
+				Email:    "mail",
+				Metadata: map[string]string{"full_name": "displayName", "department": "dept"},
+				Role:     "groups",
+				Roles:    map[string]string{"admins": "admin", "staff": "member"},
+			},
+		},
+	}
+
+	attribute := func(name string, values ...string) types.Attribute {
+		a := types.Attribute{Name: name}
+		for _, v := range values {
+			a.Values = append(a.Values, types.AttributeValue{Value: v})
+		}
+		return a
+	}
+	info := &saml2.AssertionInfo{
+		NameID:       "jdoe",
+		SessionIndex: "session-1",
+		Values: saml2.Values{
+			"mail":        attribute("mail", "jdoe@example.com"),
+			"displayName": attribute("displayName", "Jane Doe"),
+			"groups":      attribute("groups", "everyone", "staff", "admins"),
+		},
+	}
+
+	data := p.UserData(info)
+	assert.Equal(t, "jdoe", data.Subject)
+	require.Len(t, data.Emails, 1)
+	assert.Equal(t, "jdoe@example.com", data.Emails[0].Email)
+	assert.True(t, data.Emails[0].Verified)
+	assert.Equal(t, map[string]string{"full_name": "Jane Doe"}, data.Metadata)
+	assert.Equal(t, "member", data.Role)
+	assert.Equal(t, "session-1", data.Claims[provider.SamlSessionIndexClaim])
+	assert.Equal(t, []string{"everyone", "staff", "admins"}, data.Claims["groups"])
+
+	// without a mapping the name ID is the email
+	data = provider.SamlProvider{}.UserData(info)
+	assert.Equal(t, "jdoe", data.Emails[0].Email)
+	assert.Empty(t, data.Metadata)
+	assert.Empty(t, data.Role)
+}
+
+func TestSamlIssuer(t *testing.T) {
+	request := `<samlp:LogoutRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="_1" Version="2.0">` +
+		`<saml:Issuer xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion">https://idp/saml2test</saml:Issuer>` +
+		`</samlp:LogoutRequest>`
+
+	issuer, err := provider.SamlIssuer(base64.StdEncoding.EncodeToString([]byte(request)))
+	require.NoError(t, err)
+	assert.Equal(t, "https://idp/saml2test", issuer)
+
+	_, err = provider.SamlIssuer(base64.StdEncoding.EncodeToString([]byte(`<samlp:LogoutRequest/>`)))
+	assert.Error(t, err)
+
+	_, err = provider.SamlIssuer("not base64")
+	assert.Error(t, err)
+}
+
+func samlIdPMetadata(t *testing.T, validUntil time.Time) (string, dsig.X509KeyStore) {
+	keyStore := dsig.RandomKeyStoreForTest()
+	_, cert, err := keyStore.GetKeyPair()
+	require.NoError(t, err)
+
+	return fmt.Sprintf(`<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" ID="_metadata" entityID="https://idp/saml2test" validUntil="%s">`+
+		`<md:IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">`+
+		`<md:KeyDescriptor use="signing"><ds:KeyInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:X509Data>`+
+		`<ds:X509Certificate>%s</ds:X509Certificate>`+
+		`</ds:X509Data></ds:KeyInfo></md:KeyDescriptor>`+
+		`<md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="https://idp/saml2test/redirect"/>`+
+		`</md:IDPSSODescriptor>`+
+		`</md:EntityDescriptor>`, validUntil.UTC().Format(time.RFC3339), base64.StdEncoding.EncodeToString(cert)), keyStore
+}
+
+func samlConfig(ext conf.SamlProviderConfiguration) conf.SamlProviderConfiguration {
+	ext.Enabled = true
+	ext.APIBase = "http://localhost"
+	return ext
+}
+
+func TestSamlMetadataCache(t *testing.T) {
+	metadata, _ := samlIdPMetadata(t, time.Now().Add(time.Hour))
+
+	requests := 0
+	down := false
+	newServer := func() *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if down {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, metadata)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+
+	ext := samlConfig(conf.SamlProviderConfiguration{MetadataURL: newServer().URL})
+	for i := 0; i < 3; i++ {
+		p, err := provider.NewSamlProvider(ext, nil, uuid.Nil)
+		require.NoError(t, err)
+		assert.Equal(t, "https://idp/saml2test", p.ServiceProvider.IdentityProviderIssuer)
+	}
+	assert.Equal(t, 1, requests)
+
+	requests = 0
+	ext = samlConfig(conf.SamlProviderConfiguration{
+		MetadataURL:             newServer().URL,
+		MetadataRefreshInterval: time.Nanosecond,
+	})
+	_, err := provider.NewSamlProvider(ext, nil, uuid.Nil)
+	require.NoError(t, err)
+
+	// the last good copy is served while the identity provider is down, which
+	// is not asked again right away
+	down = true
+	for i := 0; i < 2; i++ {
+		p, err := provider.NewSamlProvider(ext, nil, uuid.Nil)
+		require.NoError(t, err)
+		assert.Equal(t, "https://idp/saml2test", p.ServiceProvider.IdentityProviderIssuer)
+	}
+	assert.Equal(t, 2, requests)
+}
+
+func TestSSOSamlMetadataCache(t *testing.T) {
+	metadata, _ := samlIdPMetadata(t, time.Now().Add(time.Hour))
+
+	requests := 0
+	newServer := func() *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			fmt.Fprint(w, metadata)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	first, second := newServer().URL, newServer().URL
+
+	ssoProviderID := uuid.New()
+	load := func(metadataURL string) {
+		_, err := provider.NewSSOSamlProvider(samlConfig(conf.SamlProviderConfiguration{MetadataURL: metadataURL}), nil, uuid.Nil, ssoProviderID)
+		require.NoError(t, err)
+	}
+
+	load(first)
+	load(first)
+	assert.Equal(t, 1, requests)
+
+	// metadata of a changed or removed provider is not kept
+	provider.ForgetSSOSamlMetadata(ssoProviderID)
+	load(first)
+	assert.Equal(t, 2, requests)
+
+	// a provider keeps only the metadata of its current URL
+	load(second)
+	load(first)
+	assert.Equal(t, 4, requests)
+}
+
+func TestSamlMetadataExpired(t *testing.T) {
+	metadata, _ := samlIdPMetadata(t, time.Now().Add(-time.Minute))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, metadata)
+	}))
+	t.Cleanup(srv.Close)
+
+	_, err := provider.NewSamlProvider(samlConfig(conf.SamlProviderConfiguration{MetadataURL: srv.URL}), nil, uuid.Nil)
+	assert.Error(t, err)
+
+	_, err = provider.NewSamlProvider(samlConfig(conf.SamlProviderConfiguration{MetadataXML: metadata}), nil, uuid.Nil)
+	assert.Error(t, err)
+}
+
+func TestSamlPinnedMetadata(t *testing.T) {
+	metadata, _ := samlIdPMetadata(t, time.Now().Add(time.Hour))
+
+	// pinned metadata is never fetched
+	p, err := provider.NewSamlProvider(samlConfig(conf.SamlProviderConfiguration{
+		MetadataURL: "http://localhost:1/metadata",
+		MetadataXML: metadata,
+	}), nil, uuid.Nil)
+	require.NoError(t, err)
+	assert.Equal(t, "https://idp/saml2test/redirect", p.ServiceProvider.IdentityProviderSSOURL)
+}
+
+func TestSamlMetadataSignature(t *testing.T) {
+	metadata, _ := samlIdPMetadata(t, time.Now().Add(time.Hour))
+
+	signer := dsig.RandomKeyStoreForTest()
+	_, signerCert, err := signer.GetKeyPair()
+	require.NoError(t, err)
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signerCert}))
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(metadata))
+	signed, err := dsig.NewDefaultSigningContext(signer).SignEnveloped(doc.Root())
+	require.NoError(t, err)
+	doc.SetRoot(signed)
+	signedMetadata, err := doc.WriteToString()
+	require.NoError(t, err)
+
+	meta, err := provider.ParseSamlMetadata([]byte(signedMetadata), certPEM)
+	require.NoError(t, err)
+	assert.Equal(t, "https://idp/saml2test", meta.EntityID)
+
+	// unsigned metadata, or metadata signed by someone else, is rejected
+	_, err = provider.ParseSamlMetadata([]byte(metadata), certPEM)
+	assert.Error(t, err)
+
+	other := dsig.RandomKeyStoreForTest()
+	_, otherCert, err := other.GetKeyPair()
+	require.NoError(t, err)
+	_, err = provider.ParseSamlMetadata([]byte(signedMetadata), string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherCert})))
+	assert.Error(t, err)
+}
+
+func samlLogoutRequest(t *testing.T, logoutURL string) string {
+	u, err := url.Parse(logoutURL)
+	require.NoError(t, err)
+	raw, err := base64.StdEncoding.DecodeString(u.Query().Get("SAMLRequest"))
+	require.NoError(t, err)
+	request, err := io.ReadAll(flate.NewReader(bytes.NewReader(raw)))
+	require.NoError(t, err)
+	return string(request)
+}
+
+func TestSamlLogoutURL(t *testing.T) {
+	metadata, _ := samlIdPMetadata(t, time.Now().Add(time.Hour))
+	metadata = strings.Replace(metadata, "</md:IDPSSODescriptor>",
+		`<md:SingleLogoutService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="https://idp/saml2test/slo"/></md:IDPSSODescriptor>`, 1)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	p, err := provider.NewSamlProvider(samlConfig(conf.SamlProviderConfiguration{
+		MetadataXML: metadata,
+		SigningKey:  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		SigningCert: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})),
+	}), nil, uuid.Nil)
+	require.NoError(t, err)
+
+	logoutURL, err := p.LogoutURL("jdoe@example.com", "_session")
+	require.NoError(t, err)
+	request := samlLogoutRequest(t, logoutURL)
+	assert.Contains(t, request, "jdoe@example.com")
+	assert.Contains(t, request, "SignatureValue")
+
+	p, err = provider.NewSamlProvider(samlConfig(conf.SamlProviderConfiguration{MetadataXML: metadata}), nil, uuid.Nil)
+	require.NoError(t, err)
+	logoutURL, err = p.LogoutURL("jdoe@example.com", "_session")
+	require.NoError(t, err)
+	assert.NotContains(t, samlLogoutRequest(t, logoutURL), "SignatureValue")
+}
+
+func ldapTestServer(t *testing.T) *ldaptest.Server {
+	srv, err := ldaptest.NewServer(
+		&ldaptest.Entry{
+			DN:       "cn=gotrue,ou=services,dc=example,dc=com",
+			Password: "service-secret",
+		},
+		&ldaptest.Entry{
+			DN:       "uid=jdoe,ou=people,dc=example,dc=com",
+			Password: "jdoe-secret",
+			Attributes: map[string][]string{
+				"uid":         {"jdoe"},
+				"mail":        {"jdoe@example.com"},
+				"displayName": {"Jane Doe"},
+				"memberOf":    {"cn=everyone,ou=groups,dc=example,dc=com", "CN=Admins,OU=Groups,DC=example,DC=com"},
+			},
+		},
+		&ldaptest.Entry{
+			DN:         "uid=nomail,ou=people,dc=example,dc=com",
+			Password:   "nomail-secret",
+			Attributes: map[string][]string{"uid": {"nomail"}},
+		},
+	)
+	require.NoError(t, err)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func ldapConfig(url string) conf.LDAPConfiguration {
+	return conf.LDAPConfiguration{
+		Enabled:        true,
+		URL:            url,
+		BindDN:         "cn=gotrue,ou=services,dc=example,dc=com",
+		BindPassword:   "service-secret",
+		BaseDN:         "ou=people,dc=example,dc=com",
+		UserFilter:     "(&(uid={username})(mail=*))",
+		EmailAttribute: "mail",
+		GroupAttribute: "memberOf",
+		Attributes:     map[string]string{"full_name": "displayName"},
+		Roles:          conf.LDAPRoleMapping{"cn=admins,ou=groups,dc=example,dc=com": "admin"},
+		Timeout:        5 * time.Second,
+	}
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	srv := ldapTestServer(t)
+
+	p, err := provider.NewLDAPProvider(ldapConfig(srv.URL))
+	require.NoError(t, err)
+
+	data, err := p.Authenticate("jdoe", "jdoe-secret")
+	require.NoError(t, err)
+	assert.Equal(t, "uid=jdoe,ou=people,dc=example,dc=com", data.Subject)
+	require.Len(t, data.Emails, 1)
+	assert.Equal(t, "jdoe@example.com", data.Emails[0].Email)
+	assert.True(t, data.Emails[0].Verified)
+	assert.Equal(t, map[string]string{"full_name": "Jane Doe"}, data.Metadata)
+	// group DNs are compared case insensitively
+	assert.Equal(t, "admin", data.Role)
+
+	_, err = p.Authenticate("jdoe", "wrong")
+	assert.Equal(t, provider.ErrLDAPInvalidCredentials, err)
+	_, err = p.Authenticate("jdoe", "")
+	assert.Equal(t, provider.ErrLDAPInvalidCredentials, err)
+	_, err = p.Authenticate("unknown", "jdoe-secret")
+	assert.Equal(t, provider.ErrLDAPUserNotFound, err)
+	// the username is escaped in the filter
+	_, err = p.Authenticate("*", "jdoe-secret")
+	assert.Equal(t, provider.ErrLDAPUserNotFound, err)
+}
+
+func TestLDAPAuthenticateFailures(t *testing.T) {
+	srv := ldapTestServer(t)
+
+	config := ldapConfig(srv.URL)
+	config.UserFilter = "(uid={username})"
+	p, err := provider.NewLDAPProvider(config)
+	require.NoError(t, err)
+	_, err = p.Authenticate("nomail", "nomail-secret")
+	assert.Error(t, err)
+
+	config.BindPassword = "wrong"
+	p, err = provider.NewLDAPProvider(config)
+	require.NoError(t, err)
+	_, err = p.Authenticate("jdoe", "jdoe-secret")
+	assert.Error(t, err)
+	assert.NotEqual(t, provider.ErrLDAPInvalidCredentials, err)
+
+	config.UserFilter = "(uid=jdoe)"
+	_, err = provider.NewLDAPProvider(config)
+	assert.Error(t, err)
+}
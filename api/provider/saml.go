@@ -10,9 +10,7 @@ import (
 	"encoding/xml"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"math/big"
-	"net/http"
 	"net/url"
 	"strings"
 	"time"
@@ -30,6 +28,10 @@ import (
 
 type SamlProvider struct {
 	ServiceProvider *saml2.SAMLServiceProvider
+	Config          conf.SamlProviderConfiguration
+
+	// sloPostURL is where logout responses are posted to the identity provider
+	sloPostURL string
 }
 
 type ConfigX509KeyStore struct {
@@ -38,45 +40,25 @@ type ConfigX509KeyStore struct {
 	Conf       conf.SamlProviderConfiguration
 }
 
-func getMetadata(url string) (*types.EntityDescriptor, error) {
-	res, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-
-	if res.StatusCode >= 300 {
-		return nil, fmt.Errorf("Request failed with status %s", res.Status)
-	}
-
-	rawMetadata, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	metadata := &types.EntityDescriptor{}
-	err = xml.Unmarshal(rawMetadata, metadata)
-	if err != nil {
-		return nil, err
-	}
-
-	// TODO: cache in memory
+// NewSamlProvider creates a Saml account provider.
+func NewSamlProvider(ext conf.SamlProviderConfiguration, database *tigris.Database, instanceId uuid.UUID) (*SamlProvider, error) {
+	return newSamlProvider(ext, database, instanceId, instanceMetadataOwner(instanceId))
+}
 
-	return metadata, nil
+// NewSSOSamlProvider creates the Saml account provider of an SSO provider of
+// the instance.
+func NewSSOSamlProvider(ext conf.SamlProviderConfiguration, database *tigris.Database, instanceId, ssoProviderID uuid.UUID) (*SamlProvider, error) {
+	return newSamlProvider(ext, database, instanceId, ssoMetadataOwner(ssoProviderID))
 }
 
-// NewSamlProvider creates a Saml account provider.
-func NewSamlProvider(ext conf.SamlProviderConfiguration, database *tigris.Database, instanceId uuid.UUID) (*SamlProvider, error) {
+func newSamlProvider(ext conf.SamlProviderConfiguration, database *tigris.Database, instanceId uuid.UUID, metadataOwner string) (*SamlProvider, error) {
 	if !ext.Enabled {
 		return nil, errors.New("SAML Provider is not enabled")
 	}
 
-	if _, err := url.Parse(ext.MetadataURL); err != nil {
-		return nil, fmt.Errorf("Metadata URL is invalid: %+v", err)
-	}
-
-	meta, err := getMetadata(ext.MetadataURL)
+	meta, err := loadMetadata(metadataOwner, ext)
 	if err != nil {
-		return nil, fmt.Errorf("Fetching metadata failed: %+v", err)
+		return nil, fmt.Errorf("Loading metadata failed: %+v", err)
 	}
 
 	baseURI, err := url.Parse(strings.Trim(ext.APIBase, "/"))
@@ -97,6 +79,17 @@ func NewSamlProvider(ext conf.SamlProviderConfiguration, database *tigris.Databa
 		return nil, errors.New("No valid SSO service found in IDP metadata")
 	}
 
+	// logout requests are redirected, logout responses posted
+	var sloRedirectURL, sloPostURL string
+	for _, service := range meta.IDPSSODescriptor.SingleLogoutServices {
+		switch service.Binding {
+		case saml2.BindingHttpRedirect:
+			sloRedirectURL = service.Location
+		case saml2.BindingHttpPost:
+			sloPostURL = service.Location
+		}
+	}
+
 	certStore := dsig.MemoryX509CertificateStore{
 		Roots: []*x509.Certificate{},
 	}
@@ -129,7 +122,9 @@ func NewSamlProvider(ext conf.SamlProviderConfiguration, database *tigris.Databa
 	sp := &saml2.SAMLServiceProvider{
 		IdentityProviderSSOURL:      ssoService.Location,
 		IdentityProviderIssuer:      meta.EntityID,
+		IdentityProviderSLOURL:      sloRedirectURL,
 		AssertionConsumerServiceURL: baseURI.String() + "/saml/acs",
+		ServiceProviderSLOURL:       baseURI.String() + "/saml/slo",
 		ServiceProviderIssuer:       baseURI.String() + "/saml",
 		SignAuthnRequests:           true,
 		AudienceURI:                 baseURI.String() + "/saml",
@@ -140,6 +135,8 @@ func NewSamlProvider(ext conf.SamlProviderConfiguration, database *tigris.Databa
 
 	p := &SamlProvider{
 		ServiceProvider: sp,
+		Config:          ext,
+		sloPostURL:      sloPostURL,
 	}
 	return p, nil
 }
@@ -162,6 +159,12 @@ func (p SamlProvider) SPMetadata() ([]byte, error) {
 	// therefore they are removed since they are optional anyways and mostly unused
 	metadata.SPSSODescriptor.KeyDescriptors[1].EncryptionMethods = []types.EncryptionMethod{}
 
+	// identity providers post logout requests and responses
+	metadata.SPSSODescriptor.SingleLogoutServices = []types.Endpoint{{
+		Binding:  saml2.BindingHttpPost,
+		Location: p.ServiceProvider.ServiceProviderSLOURL,
+	}}
+
 	rawMetadata, err := xml.Marshal(metadata)
 	if err != nil {
 		return nil, err
@@ -170,6 +173,109 @@ func (p SamlProvider) SPMetadata() ([]byte, error) {
 	return rawMetadata, nil
 }
 
+// UserData maps the attributes of an assertion to the user, as configured in
+// the attribute mapping.
+func (p SamlProvider) UserData(info *saml2.AssertionInfo) *UserProvidedData {
+	mapping := p.Config.AttributeMapping
+
+	email := info.NameID
+	if mapping.Email != "" {
+		if v := info.Values.Get(mapping.Email); v != "" {
+			email = v
+		}
+	}
+
+	claims := make(map[string]interface{})
+	for name := range info.Values {
+		claims[name] = info.Values.GetAll(name)
+	}
+	if info.SessionIndex != "" {
+		claims[SamlSessionIndexClaim] = info.SessionIndex
+	}
+
+	data := &UserProvidedData{
+		Subject:  info.NameID,
+		Claims:   claims,
+		Metadata: map[string]string{},
+		Emails: []Email{{
+			Email:    email,
+			Verified: true,
+			Primary:  true,
+		}},
+	}
+	for key, name := range mapping.Metadata {
+		if v := info.Values.Get(name); v != "" {
+			data.Metadata[key] = v
+		}
+	}
+	if mapping.Role != "" {
+		for _, v := range info.Values.GetAll(mapping.Role) {
+			if role, ok := mapping.Roles[v]; ok {
+				data.Role = role
+				break
+			}
+		}
+	}
+	return data
+}
+
+// LogoutURL redirects the user to the identity provider to end their session
+// there. It is empty when the identity provider does not support Single Logout.
+// The request is signed when the service provider has a signing key, as
+// identity providers may not act on unsigned requests.
+func (p SamlProvider) LogoutURL(nameID, sessionIndex string) (string, error) {
+	if p.ServiceProvider.IdentityProviderSLOURL == "" {
+		return "", nil
+	}
+
+	build := p.ServiceProvider.BuildLogoutRequestDocumentNoSig
+	if p.Config.SigningKey != "" {
+		build = p.ServiceProvider.BuildLogoutRequestDocument
+	}
+	doc, err := build(nameID, sessionIndex)
+	if err != nil {
+		return "", err
+	}
+	return p.ServiceProvider.BuildLogoutURLRedirect("", doc)
+}
+
+// LogoutResponseForm answers a logout request of the identity provider with an
+// HTML form posting the signed response.
+func (p SamlProvider) LogoutResponseForm(requestID, relayState string) ([]byte, error) {
+	if p.sloPostURL != "" {
+		p.ServiceProvider.IdentityProviderSLOURL = p.sloPostURL
+	}
+	if p.ServiceProvider.IdentityProviderSLOURL == "" {
+		return nil, errors.New("No SLO service found in IDP metadata")
+	}
+
+	doc, err := p.ServiceProvider.BuildLogoutResponseDocument(saml2.StatusCodeSuccess, requestID)
+	if err != nil {
+		return nil, err
+	}
+	return p.ServiceProvider.BuildLogoutResponseBodyPostFromDocument(relayState, doc)
+}
+
+// SamlIssuer reads the issuer of a posted SAML message without verifying it,
+// to find the identity provider to verify the message with.
+func SamlIssuer(encoded string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	message := struct {
+		Issuer string `xml:"Issuer"`
+	}{}
+	if err := xml.Unmarshal(raw, &message); err != nil {
+		return "", err
+	}
+	if message.Issuer == "" {
+		return "", errors.New("SAML message has no issuer")
+	}
+	return strings.TrimSpace(message.Issuer), nil
+}
+
 func (ks ConfigX509KeyStore) GetKeyPair() (*rsa.PrivateKey, []byte, error) {
 	if ks.Conf.SigningCert == "" && ks.Conf.SigningKey == "" {
 		return ks.CreateSigningCert()
@@ -0,0 +1,235 @@
+package provider
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/google/uuid"
+	"github.com/russellhaering/gosaml2/types"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/tigrisdata/gotrue/conf"
+)
+
+const (
+	// defaultMetadataRefreshInterval is how long fetched metadata is used if
+	// no refresh interval is configured
+	defaultMetadataRefreshInterval = time.Hour
+	// metadataRetryInterval is how long the last good copy of metadata is
+	// served before fetching it is tried again
+	metadataRetryInterval = time.Minute
+	// maxMetadataSize limits the metadata read from identity providers
+	maxMetadataSize = 1 << 20
+)
+
+var metadataClient = &http.Client{Timeout: 10 * time.Second}
+
+type cachedMetadata struct {
+	// source identifies the metadata the entry holds, see metadataSource
+	source   string
+	metadata *types.EntityDescriptor
+	// refreshAt is when the metadata is fetched again. Pinned metadata is
+	// never fetched.
+	refreshAt time.Time
+}
+
+// metadataCache keeps the parsed metadata of identity providers, so they are
+// not asked for it on every request. It holds one entry per provider, which is
+// replaced when the metadata of the provider changes.
+var metadataCache = struct {
+	sync.Mutex
+	entries map[string]*cachedMetadata
+}{entries: map[string]*cachedMetadata{}}
+
+func cachedMetadataEntry(owner, source string) *cachedMetadata {
+	metadataCache.Lock()
+	defer metadataCache.Unlock()
+	if entry := metadataCache.entries[owner]; entry != nil && entry.source == source {
+		return entry
+	}
+	return nil
+}
+
+func cacheMetadataEntry(owner string, entry *cachedMetadata) {
+	metadataCache.Lock()
+	defer metadataCache.Unlock()
+	metadataCache.entries[owner] = entry
+}
+
+// ForgetSSOSamlMetadata drops the cached metadata of an SSO provider, so it is
+// loaded again after the provider changed and not kept once it is removed.
+func ForgetSSOSamlMetadata(ssoProviderID uuid.UUID) {
+	metadataCache.Lock()
+	defer metadataCache.Unlock()
+	delete(metadataCache.entries, ssoMetadataOwner(ssoProviderID))
+}
+
+func instanceMetadataOwner(instanceID uuid.UUID) string {
+	return "instance:" + instanceID.String()
+}
+
+func ssoMetadataOwner(ssoProviderID uuid.UUID) string {
+	return "sso:" + ssoProviderID.String()
+}
+
+// metadataSource identifies metadata by its source and the certificate it is
+// verified with, so metadata verified with one certificate is not used
+// unverified once the certificate changed
+func metadataSource(source, signingCert string) string {
+	sum := sha256.Sum256([]byte(source + "\x00" + signingCert))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadMetadata returns the metadata of the identity provider: the pinned XML if
+// configured, otherwise the metadata fetched from its URL. Fetched metadata is
+// used until the refresh interval passes, and its last good copy is served
+// while the identity provider is unreachable, until its validUntil passes.
+func loadMetadata(owner string, ext conf.SamlProviderConfiguration) (*types.EntityDescriptor, error) {
+	now := time.Now()
+
+	if ext.MetadataXML != "" {
+		source := metadataSource(ext.MetadataXML, ext.MetadataSigningCert)
+		if entry := cachedMetadataEntry(owner, source); entry != nil {
+			return entry.metadata, checkMetadataValidity(entry.metadata, now)
+		}
+		meta, err := ParseSamlMetadata([]byte(ext.MetadataXML), ext.MetadataSigningCert)
+		if err != nil {
+			return nil, err
+		}
+		cacheMetadataEntry(owner, &cachedMetadata{source: source, metadata: meta})
+		return meta, nil
+	}
+
+	if ext.MetadataURL == "" {
+		return nil, errors.New("Metadata URL or XML is required")
+	}
+	if _, err := url.Parse(ext.MetadataURL); err != nil {
+		return nil, fmt.Errorf("Metadata URL is invalid: %+v", err)
+	}
+
+	source := metadataSource(ext.MetadataURL, ext.MetadataSigningCert)
+	entry := cachedMetadataEntry(owner, source)
+	if entry != nil && now.Before(entry.refreshAt) && checkMetadataValidity(entry.metadata, now) == nil {
+		return entry.metadata, nil
+	}
+
+	meta, err := fetchMetadata(ext.MetadataURL, ext.MetadataSigningCert)
+	if err != nil {
+		if entry != nil && checkMetadataValidity(entry.metadata, now) == nil {
+			cacheMetadataEntry(owner, &cachedMetadata{
+				source:    source,
+				metadata:  entry.metadata,
+				refreshAt: now.Add(metadataRetryInterval),
+			})
+			return entry.metadata, nil
+		}
+		return nil, err
+	}
+
+	interval := ext.MetadataRefreshInterval
+	if interval <= 0 {
+		interval = defaultMetadataRefreshInterval
+	}
+	cacheMetadataEntry(owner, &cachedMetadata{
+		source:    source,
+		metadata:  meta,
+		refreshAt: now.Add(interval),
+	})
+	return meta, nil
+}
+
+func fetchMetadata(url, signingCert string) (*types.EntityDescriptor, error) {
+	res, err := metadataClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return nil, fmt.Errorf("Request failed with status %s", res.Status)
+	}
+
+	rawMetadata, err := ioutil.ReadAll(io.LimitReader(res.Body, maxMetadataSize))
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSamlMetadata(rawMetadata, signingCert)
+}
+
+// ParseSamlMetadata parses the metadata of an identity provider. If a signing
+// certificate is given, the metadata must be signed with it. Metadata past its
+// validUntil is rejected.
+func ParseSamlMetadata(rawMetadata []byte, signingCert string) (*types.EntityDescriptor, error) {
+	if signingCert != "" {
+		verified, err := verifyMetadataSignature(rawMetadata, signingCert)
+		if err != nil {
+			return nil, err
+		}
+		rawMetadata = verified
+	}
+
+	metadata := &types.EntityDescriptor{}
+	if err := xml.Unmarshal(rawMetadata, metadata); err != nil {
+		return nil, err
+	}
+	if metadata.IDPSSODescriptor == nil {
+		return nil, errors.New("Metadata has no IDPSSODescriptor")
+	}
+
+	if err := checkMetadataValidity(metadata, time.Now()); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// verifyMetadataSignature returns the signed part of the metadata, which is
+// all that may be read from it
+func verifyMetadataSignature(rawMetadata []byte, signingCert string) ([]byte, error) {
+	block, _ := pem.Decode([]byte(signingCert))
+	if block == nil {
+		return nil, errors.New("Metadata signing certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Parsing metadata signing certificate failed: %+v", err)
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(rawMetadata); err != nil {
+		return nil, err
+	}
+	if doc.Root() == nil {
+		return nil, errors.New("Metadata is empty")
+	}
+
+	ctx := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+	verified, err := ctx.Validate(doc.Root())
+	if err != nil {
+		return nil, fmt.Errorf("Metadata signature is invalid: %+v", err)
+	}
+
+	verifiedDoc := etree.NewDocument()
+	verifiedDoc.SetRoot(verified)
+	return verifiedDoc.WriteToBytes()
+}
+
+func checkMetadataValidity(metadata *types.EntityDescriptor, now time.Time) error {
+	if !metadata.ValidUntil.IsZero() && now.After(metadata.ValidUntil) {
+		return fmt.Errorf("Metadata expired at %s", metadata.ValidUntil.Format(time.RFC3339))
+	}
+	return nil
+}
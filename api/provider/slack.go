@@ -0,0 +1,92 @@
+package provider
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tigrisdata/gotrue/conf"
+	"golang.org/x/oauth2"
+)
+
+// Slack (Sign in with Slack, OpenID Connect)
+
+const defaultSlackAPIBase = "slack.com"
+
+type slackProvider struct {
+	*oauth2.Config
+	APIPath string
+}
+
+type slackUser struct {
+	OK            bool   `json:"ok"`
+	Error         string `json:"error"`
+	Subject       string `json:"sub"`
+	TeamID        string `json:"https://slack.com/team_id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	AvatarURL     string `json:"picture"`
+}
+
+// NewSlackProvider creates a Slack account provider.
+func NewSlackProvider(ext conf.OAuthProviderConfiguration) (OAuthProvider, error) {
+	if err := ext.Validate(); err != nil {
+		return nil, err
+	}
+
+	apiPath := chooseHost(ext.URL, defaultSlackAPIBase)
+
+	return &slackProvider{
+		Config: &oauth2.Config{
+			ClientID:     ext.ClientID,
+			ClientSecret: ext.Secret,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  apiPath + "/openid/connect/authorize",
+				TokenURL: apiPath + "/api/openid.connect.token",
+			},
+			Scopes:      []string{"openid", "email", "profile"},
+			RedirectURL: ext.RedirectURI,
+		},
+		APIPath: apiPath + "/api",
+	}, nil
+}
+
+func (g slackProvider) GetOAuthToken(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	return g.Exchange(ctx, code, opts...)
+}
+
+func (g slackProvider) GetUserData(ctx context.Context, tok *oauth2.Token) (*UserProvidedData, error) {
+	var u slackUser
+	claims, err := makeClaimsRequest(ctx, tok, g.Config, g.APIPath+"/openid.connect.userInfo", &u)
+	if err != nil {
+		return nil, err
+	}
+	// Slack reports errors in the body of successful responses
+	if !u.OK {
+		return nil, errors.New("Slack user info request failed: " + u.Error)
+	}
+
+	data := &UserProvidedData{
+		// user IDs are only unique within their workspace
+		Subject: u.TeamID + "|" + u.Subject,
+		Claims:  claims,
+		Metadata: map[string]string{
+			nameKey:      u.Name,
+			avatarURLKey: u.AvatarURL,
+		},
+	}
+
+	if u.Email != "" {
+		data.Emails = append(data.Emails, Email{
+			Email:    u.Email,
+			Verified: u.EmailVerified,
+			Primary:  true,
+		})
+	}
+
+	if len(data.Emails) <= 0 {
+		return nil, errors.New("Unable to find email with Slack provider")
+	}
+
+	return data, nil
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxRateLimitBodySize is how much of request bodies is read for the email
+// or client requests are counted by
+const maxRateLimitBodySize = 1 << 20
+
+// limitHandler limits the requests to the named endpoint as the instance
+// configures, counting them apart by IP address, email or client.
+func (a *API) limitHandler(endpoint string) middlewareHandler {
+	return func(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+		ctx := r.Context()
+		config := a.getConfig(ctx)
+		limit := config.RateLimit.Endpoint(endpoint)
+		if limit.Requests <= 0 {
+			return ctx, nil
+		}
+
+		remaining := limit.Requests
+		var reset time.Time
+		for _, by := range limit.By {
+			value := a.rateLimitKey(r, by)
+			if value == "" {
+				continue
+			}
+			key := strings.Join([]string{getInstanceID(ctx).String(), endpoint, by, value}, ":")
+			count, windowReset, err := a.limiter.Increment(ctx, key, limit.Period)
+			if err != nil {
+				// an unavailable store does not take the endpoint down
+				log.Error().Err(err).Str("endpoint", endpoint).Msg("Error counting request for rate limit")
+				return ctx, nil
+			}
+			if left := limit.Requests - count; left < remaining || reset.IsZero() {
+				remaining, reset = left, windowReset
+			}
+		}
+		if reset.IsZero() {
+			return ctx, nil
+		}
+
+		resetIn := strconv.Itoa(int(math.Ceil(time.Until(reset).Seconds())))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		w.Header().Set("RateLimit-Reset", resetIn)
+		if remaining < 0 {
+			w.Header().Set("RateLimit-Remaining", "0")
+			w.Header().Set("Retry-After", resetIn)
+			return ctx, httpError(http.StatusTooManyRequests, "Rate limit exceeded")
+		}
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		return ctx, nil
+	}
+}
+
+// rateLimitKey returns what the request is counted by: its IP address, the
+// email, or the client ID in its form or JSON body.
+func (a *API) rateLimitKey(r *http.Request, by string) string {
+	switch by {
+	case "ip":
+		return a.clientIP(r)
+	case "email":
+		if email := requestBodyValue(r, "email"); email != "" {
+			return strings.ToLower(email)
+		}
+		return strings.ToLower(requestBodyValue(r, "username"))
+	case "client":
+		if clientID := requestBodyValue(r, "client_id"); clientID != "" {
+			return clientID
+		}
+		clientID, _, _ := r.BasicAuth()
+		return clientID
+	}
+	return ""
+}
+
+// requestBodyValue reads a value of a form or JSON request body, leaving the
+// body to be read again.
+func requestBodyValue(r *http.Request, name string) string {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return r.FormValue(name)
+	}
+	if r.Body == nil {
+		return ""
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRateLimitBodySize))
+	if err != nil {
+		return ""
+	}
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(body, &values); err != nil {
+		return ""
+	}
+	value, _ := values[name].(string)
+	return value
+}
@@ -10,7 +10,8 @@ import (
 
 // RecoverParams holds the parameters for a password recovery request
 type RecoverParams struct {
-	Email string `json:"email"`
+	Email      string `json:"email"`
+	RedirectTo string `json:"redirect_to"`
 }
 
 // Recover sends a recovery email
@@ -28,6 +29,10 @@ func (a *API) Recover(w http.ResponseWriter, r *http.Request) error {
 	if params.Email == "" {
 		return unprocessableEntityError("Password recovery requires an email")
 	}
+	referrer, err := a.redirectTo(r, params.RedirectTo)
+	if err != nil {
+		return err
+	}
 
 	aud := a.requestAud(ctx, r)
 	user, err := models.FindUserByEmailAndAudience(r.Context(), a.db, instanceID, params.Email, aud)
@@ -44,7 +49,6 @@ func (a *API) Recover(w http.ResponseWriter, r *http.Request) error {
 		}
 
 		mailer := a.Mailer(ctx)
-		referrer := a.getReferrer(r)
 		return a.sendPasswordRecovery(ctx, a.db, user, mailer, config.SMTP.MaxFrequency, referrer)
 	})
 	if err != nil {
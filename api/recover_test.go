@@ -143,3 +143,31 @@ func (ts *RecoverTestSuite) TestRecover_NewEmailSent() {
 	// ensure it sent a new email
 	assert.WithinDuration(ts.T(), time.Now(), *u.RecoverySentAt, 1*time.Second)
 }
+
+func (ts *RecoverTestSuite) TestRecover_RedirectTo() {
+	ts.Config.RedirectURLs = []string{"https://app.example.org/reset"}
+	defer func() { ts.Config.RedirectURLs = nil }()
+
+	recoverRequest := func(redirectTo string) *httptest.ResponseRecorder {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+			"email":       "test@example.com",
+			"redirect_to": redirectTo,
+		}))
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/recover", &buffer)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := recoverRequest("https://app.example.org/other")
+	assert.Equal(ts.T(), http.StatusBadRequest, w.Code)
+
+	u, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	assert.Empty(ts.T(), u.RecoveryToken)
+
+	w = recoverRequest("https://app.example.org/reset")
+	assert.Equal(ts.T(), http.StatusOK, w.Code)
+}
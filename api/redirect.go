@@ -0,0 +1,136 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/tigrisdata/gotrue/conf"
+)
+
+// redirectToParam is the parameter naming where users are sent back to after
+// following an email link or signing in with an external provider
+const redirectToParam = "redirect_to"
+
+// redirectTo returns the URL users are sent back to, redirectTo if set, else
+// the redirect_to query parameter, else the Referer if it is allowed. An
+// explicit URL that is not allowed is refused.
+func (a *API) redirectTo(r *http.Request, redirectTo string) (string, error) {
+	if redirectTo == "" {
+		redirectTo = r.URL.Query().Get(redirectToParam)
+	}
+	if redirectTo == "" {
+		return a.getReferrer(r), nil
+	}
+
+	config := a.getConfig(r.Context())
+	if !isRedirectURLAllowed(config, redirectTo) {
+		return "", badRequestError("Redirect URL is not allowed: %s", redirectTo)
+	}
+	return redirectTo, nil
+}
+
+// isRedirectURLAllowed reports whether users can be sent to rawURL, which is
+// the case for URLs of the site and those matching the redirect URLs of the
+// instance.
+func isRedirectURLAllowed(config *conf.Configuration, rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.User != nil {
+		return false
+	}
+
+	if site, err := url.Parse(config.SiteURL); err == nil && site.Host != "" {
+		if strings.EqualFold(site.Scheme, u.Scheme) && strings.EqualFold(site.Host, u.Host) {
+			return true
+		}
+	}
+
+	target := redirectMatchTarget(u)
+	for _, pattern := range config.RedirectURLs {
+		if matchRedirectPattern(strings.TrimSpace(pattern), target) {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectMatchTarget is the URL without query, fragment and trailing slash,
+// which patterns are matched against.
+func redirectMatchTarget(u *url.URL) string {
+	target := strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host) + u.EscapedPath()
+	return strings.TrimSuffix(target, "/")
+}
+
+// splitRedirectURL splits a redirect URL or pattern into its scheme, host and
+// path. Patterns are not parsed as URLs, as their hosts contain wildcards.
+func splitRedirectURL(s string) (scheme, host, path string, ok bool) {
+	scheme, rest, ok := strings.Cut(s, "://")
+	if !ok {
+		return "", "", "", false
+	}
+	if i := strings.Index(rest, "/"); i >= 0 {
+		return scheme, rest[:i], rest[i:], true
+	}
+	return scheme, rest, "", true
+}
+
+// matchRedirectPattern matches target against a redirect URL pattern. The host
+// and the path are matched apart: * stands for any characters but . in the
+// host and any characters but / in the path, so it does not cross host labels
+// or path segments, and ** stands for any characters in the path. The host
+// cannot contain **, which would let any host match.
+func matchRedirectPattern(pattern, target string) bool {
+	pattern = strings.TrimSuffix(pattern, "/")
+	if pattern == "" {
+		return false
+	}
+	if !strings.Contains(pattern, "*") {
+		return strings.EqualFold(pattern, target)
+	}
+
+	scheme, host, path, ok := splitRedirectURL(pattern)
+	if !ok || strings.Contains(scheme, "*") || strings.Contains(host, "**") {
+		return false
+	}
+	targetScheme, targetHost, targetPath, ok := splitRedirectURL(target)
+	if !ok || !strings.EqualFold(scheme, targetScheme) {
+		return false
+	}
+
+	if !matchGlob(redirectGlob(host, "[^.]*"), targetHost) {
+		return false
+	}
+
+	// a trailing /** also matches the URL without a path
+	anyPath := strings.HasSuffix(path, "/**")
+	path = strings.TrimSuffix(path, "/**")
+	expr := redirectGlob(path, "[^/]*")
+	if anyPath {
+		expr += "(/.*)?"
+	}
+	return matchGlob(expr, targetPath)
+}
+
+// redirectGlob turns a part of a redirect URL pattern into a regular
+// expression, in which * becomes one, and ** any characters.
+func redirectGlob(pattern, one string) string {
+	var expr strings.Builder
+	for i, part := range strings.Split(pattern, "**") {
+		if i > 0 {
+			expr.WriteString(".*")
+		}
+		for j, piece := range strings.Split(part, "*") {
+			if j > 0 {
+				expr.WriteString(one)
+			}
+			expr.WriteString(regexp.QuoteMeta(piece))
+		}
+	}
+	return expr.String()
+}
+
+func matchGlob(expr, s string) bool {
+	re, err := regexp.Compile("(?i)^" + expr + "$")
+	return err == nil && re.MatchString(s)
+}
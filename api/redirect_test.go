@@ -0,0 +1,68 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tigrisdata/gotrue/conf"
+)
+
+func TestIsRedirectURLAllowed(t *testing.T) {
+	config := &conf.Configuration{
+		SiteURL: "https://example.com",
+		RedirectURLs: []string{
+			"https://app.example.org/callback",
+			"https://*.preview.example.org/**",
+			"http://localhost:3000/*",
+			"https://**.example.net",
+			"https://docs.example.net/**/guide",
+			"com.example.app://login",
+		},
+	}
+
+	cases := []struct {
+		url     string
+		allowed bool
+	}{
+		// the site
+		{"https://example.com", true},
+		{"https://example.com/admin?tab=users#top", true},
+		{"http://example.com/admin", false},
+		{"https://example.com:8443/admin", false},
+
+		// exact entries, ignoring query, fragment and trailing slash
+		{"https://app.example.org/callback", true},
+		{"https://app.example.org/callback/?next=1", true},
+		{"https://APP.example.org/callback", true},
+		{"https://app.example.org/callback/other", false},
+		{"https://app.example.org/", false},
+		{"com.example.app://login", true},
+
+		// * does not cross host labels or path segments
+		{"http://localhost:3000/welcome", true},
+		{"http://localhost:3000/welcome/back", false},
+		{"https://pr-42.preview.example.org/", true},
+		{"https://pr-42.preview.example.org/a/b/c", true},
+		{"https://evil.com.preview.example.org/", false},
+		{"https://preview.example.org/", false},
+
+		// ** only matches within the path
+		{"https://docs.example.net/v1/en/guide", true},
+		{"https://docs.example.net/evil.com/guide", true},
+		{"https://evil.com/docs.example.net/guide", false},
+		{"https://evil.com/x.example.net", false},
+		{"https://a.example.net", false},
+
+		// look-alikes
+		{"https://example.com.evil.com", false},
+		{"https://example.com@evil.com", false},
+		{"https://app.example.org.evil.com/callback", false},
+		{"//evil.com", false},
+		{"/relative", false},
+		{"javascript:alert(1)", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.allowed, isRedirectURLAllowed(config, c.url), c.url)
+	}
+}
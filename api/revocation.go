@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/tigrisdata/gotrue/conf"
+	"github.com/tigrisdata/gotrue/models"
+)
+
+// accessTokenTTL is the longest time an access token issued now stays valid,
+// which is also how long a revocation list entry has to be kept.
+func accessTokenTTL(config *conf.Configuration) time.Duration {
+	return time.Second * time.Duration(config.JWT.Exp)
+}
+
+func (a *API) isAccessTokenRevoked(ctx context.Context, claims *GoTrueClaims) (bool, error) {
+	userID := GetUserIdFromSubject(claims.Subject)
+	if userID == models.SystemUserID || userID == models.SystemUserUUID.String() {
+		userID = ""
+	}
+	return models.IsAccessTokenRevoked(ctx, a.db, claims.Id, claims.SessionID, userID, time.Unix(claims.IssuedAt, 0))
+}
+
+// revokeAccessToken adds a single access token to the revocation list.
+func (a *API) revokeAccessToken(ctx context.Context, claims *GoTrueClaims) error {
+	config := a.getConfig(ctx)
+	return models.RevokeAccessToken(ctx, a.db, getInstanceID(ctx), claims.Id, accessTokenTTL(config))
+}
+
+// revokeSession deletes the refresh tokens of a session and revokes the
+// access tokens issued for it.
+func (a *API) revokeSession(ctx context.Context, user *models.User, sessionID uuid.UUID) error {
+	config := a.getConfig(ctx)
+	instanceID := getInstanceID(ctx)
+
+	a.tokenCache.Remove(user.Email)
+	if err := models.RevokeSession(ctx, a.db, instanceID, user.ID, sessionID); err != nil {
+		return err
+	}
+	return models.RevokeSessionAccessTokens(ctx, a.db, instanceID, sessionID, accessTokenTTL(config))
+}
+
+// revokeUserTokens signs a user out of every session and revokes all access
+// tokens issued to the user so far.
+func (a *API) revokeUserTokens(ctx context.Context, user *models.User) error {
+	config := a.getConfig(ctx)
+	instanceID := getInstanceID(ctx)
+
+	a.tokenCache.Remove(user.Email)
+	if err := models.RevokeUserAccessTokens(ctx, a.db, instanceID, user.ID, accessTokenTTL(config)); err != nil {
+		return err
+	}
+	return models.Logout(ctx, a.db, instanceID, user.ID)
+}
+
+// UserRevokeSession signs the current user out of one of their sessions
+func (a *API) UserRevokeSession(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	instanceID := getInstanceID(ctx)
+
+	sessionID, err := uuid.Parse(chi.URLParam(r, "session_id"))
+	if err != nil {
+		return badRequestError("Invalid session ID")
+	}
+
+	user, err := getUserFromClaims(ctx, a.db)
+	if err != nil {
+		return unauthorizedError("Invalid user").WithInternalError(err)
+	}
+
+	err = a.db.Tx(ctx, func(ctx context.Context) error {
+		if terr := models.NewAuditLogEntry(ctx, a.db, instanceID, user, models.SessionRevokedAction, map[string]interface{}{
+			"session_id": sessionID,
+		}); terr != nil {
+			return terr
+		}
+		return a.revokeSession(ctx, user, sessionID)
+	})
+	if err != nil {
+		return internalServerError("Error revoking session").WithInternalError(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,1269 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/tigrisdata/gotrue/models"
+	"github.com/tigrisdata/tigris-client-go/filter"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+const (
+	scimUserSchema          = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema         = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimListSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimPatchSchema         = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimErrorSchema         = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimProviderSchema      = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimResourceTypeSchema  = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	scimSchemaSchema        = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	scimMaxResults          = 200
+	scimContentType         = "application/scim+json"
+	scimProvider            = "scim"
+	scimFullNameMetadataKey = "full_name"
+)
+
+// scimBool is a boolean some identity providers send as a string, e.g. "False"
+type scimBool bool
+
+func (b *scimBool) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case bool:
+		*b = scimBool(v)
+	case string:
+		parsed, err := strconv.ParseBool(strings.ToLower(v))
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		*b = scimBool(parsed)
+	case nil:
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %v", v)
+	}
+	return nil
+}
+
+type scimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimMultiValue struct {
+	Value   string   `json:"value"`
+	Display string   `json:"display,omitempty"`
+	Type    string   `json:"type,omitempty"`
+	Primary scimBool `json:"primary,omitempty"`
+	Ref     string   `json:"$ref,omitempty"`
+}
+
+type scimMeta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+}
+
+// scimUser is the SCIM representation of a user. The user name is the email.
+type scimUser struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id,omitempty"`
+	ExternalID  string           `json:"externalId,omitempty"`
+	UserName    string           `json:"userName"`
+	Name        *scimName        `json:"name,omitempty"`
+	DisplayName string           `json:"displayName,omitempty"`
+	Emails      []scimMultiValue `json:"emails,omitempty"`
+	Active      *scimBool        `json:"active,omitempty"`
+	Roles       []scimMultiValue `json:"roles,omitempty"`
+	Groups      []scimMultiValue `json:"groups,omitempty"`
+	Meta        *scimMeta        `json:"meta,omitempty"`
+}
+
+// scimGroup is the SCIM representation of a group.
+type scimGroup struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id,omitempty"`
+	ExternalID  string           `json:"externalId,omitempty"`
+	DisplayName string           `json:"displayName"`
+	Members     []scimMultiValue `json:"members"`
+	Meta        *scimMeta        `json:"meta,omitempty"`
+}
+
+type scimListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+type scimPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []scimPatchOperation `json:"Operations"`
+}
+
+func sendSCIM(w http.ResponseWriter, status int, obj interface{}) error {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Error encoding json response: %v", obj))
+	}
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	_, err = w.Write(b)
+	return err
+}
+
+// scim serves a SCIM endpoint. Errors are sent in the SCIM error format.
+func (a *API) scim(fn apiHandler) apiHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		err := fn(w, r)
+		if e, ok := err.(*HTTPError); ok {
+			scimType := ""
+			if e.Code == http.StatusConflict {
+				scimType = "uniqueness"
+			}
+			return scimError(e.Code, scimType, e.Message).WithInternalError(e.Cause())
+		}
+		return err
+	}
+}
+
+// requireSCIMToken authenticates identity providers with a SCIM token of the
+// instance
+func (a *API) requireSCIMToken(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	ctx := r.Context()
+	matches := bearerRegexp.FindStringSubmatch(r.Header.Get("Authorization"))
+	if len(matches) != 2 {
+		return nil, scimError(http.StatusUnauthorized, "", "This endpoint requires a SCIM bearer token")
+	}
+
+	token, err := models.FindSCIMToken(ctx, a.db, getInstanceID(ctx), matches[1])
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, scimError(http.StatusUnauthorized, "", "Invalid SCIM token")
+		}
+		return nil, scimError(http.StatusInternalServerError, "", "Database error finding SCIM token").WithInternalError(err)
+	}
+	if err := token.Touch(ctx, a.db); err != nil {
+		return nil, scimError(http.StatusInternalServerError, "", "Database error updating SCIM token").WithInternalError(err)
+	}
+	logEntrySetField(r, "scim_token_id", token.ID)
+	return withSCIMToken(ctx, token), nil
+}
+
+// scimAuditLog records a change made through SCIM, naming the token the
+// identity provider used
+func (a *API) scimAuditLog(ctx context.Context, action models.AuditAction, traits map[string]interface{}) error {
+	config := a.getConfig(ctx)
+	instanceID := getInstanceID(ctx)
+	token := getSCIMToken(ctx)
+
+	traits["scim_token_id"] = token.ID
+	traits["scim_token_name"] = token.Name
+	return models.NewAuditLogEntry(ctx, a.db, instanceID, models.NewSystemUser(instanceID, config.JWT.Aud), action, traits)
+}
+
+func scimLocation(r *http.Request, resourceType string, id string) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/scim/v2/%s/%s", scheme, r.Host, resourceType, id)
+}
+
+// scimResourceMap returns the JSON representation of a resource that filters
+// and patch operations work on.
+func scimResourceMap(resource interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	return m, json.Unmarshal(b, &m)
+}
+
+func decodeSCIMResource(doc map[string]interface{}, resource interface{}) error {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, resource); err != nil {
+		return scimError(http.StatusBadRequest, "invalidValue", "Invalid resource: %v", err)
+	}
+	return nil
+}
+
+// scimList filters and pages resources for a list response.
+func scimList(r *http.Request, resources []interface{}) (*scimListResponse, error) {
+	query := r.URL.Query()
+
+	if expr := query.Get("filter"); expr != "" {
+		f, err := parseSCIMFilter(expr)
+		if err != nil {
+			return nil, err
+		}
+		matched := []interface{}{}
+		for _, resource := range resources {
+			m, err := scimResourceMap(resource)
+			if err != nil {
+				return nil, err
+			}
+			if f.match(m) {
+				matched = append(matched, resource)
+			}
+		}
+		resources = matched
+	}
+
+	startIndex := 1
+	if s := query.Get("startIndex"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 1 {
+			startIndex = n
+		}
+	}
+	count := scimMaxResults
+	if s := query.Get("count"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n >= 0 && n < scimMaxResults {
+			count = n
+		}
+	}
+
+	page := []interface{}{}
+	if startIndex <= len(resources) {
+		end := startIndex - 1 + count
+		if end > len(resources) {
+			end = len(resources)
+		}
+		page = resources[startIndex-1 : end]
+	}
+	return &scimListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: len(resources),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	}, nil
+}
+
+// SCIMServiceProviderConfig describes the SCIM features GoTrue supports
+func (a *API) SCIMServiceProviderConfig(w http.ResponseWriter, r *http.Request) error {
+	return sendSCIM(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{scimProviderSchema},
+		"patch":          map[string]interface{}{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": scimMaxResults},
+		"changePassword": map[string]interface{}{"supported": false},
+		"sort":           map[string]interface{}{"supported": false},
+		"etag":           map[string]interface{}{"supported": false},
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer Token",
+			"description": "Authentication with a SCIM token of the instance",
+			"primary":     true,
+		}},
+		"meta": map[string]interface{}{"resourceType": "ServiceProviderConfig"},
+	})
+}
+
+// SCIMResourceTypes lists the resource types GoTrue provisions
+func (a *API) SCIMResourceTypes(w http.ResponseWriter, r *http.Request) error {
+	types := []interface{}{
+		map[string]interface{}{
+			"schemas":  []string{scimResourceTypeSchema},
+			"id":       "User",
+			"name":     "User",
+			"endpoint": "/Users",
+			"schema":   scimUserSchema,
+			"meta":     map[string]interface{}{"resourceType": "ResourceType"},
+		},
+		map[string]interface{}{
+			"schemas":  []string{scimResourceTypeSchema},
+			"id":       "Group",
+			"name":     "Group",
+			"endpoint": "/Groups",
+			"schema":   scimGroupSchema,
+			"meta":     map[string]interface{}{"resourceType": "ResourceType"},
+		},
+	}
+	return sendSCIM(w, http.StatusOK, &scimListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: len(types),
+		StartIndex:   1,
+		ItemsPerPage: len(types),
+		Resources:    types,
+	})
+}
+
+func scimAttribute(name, typ string, multiValued, required bool, subAttributes ...map[string]interface{}) map[string]interface{} {
+	attr := map[string]interface{}{
+		"name":        name,
+		"type":        typ,
+		"multiValued": multiValued,
+		"required":    required,
+		"caseExact":   false,
+		"mutability":  "readWrite",
+		"returned":    "default",
+		"uniqueness":  "none",
+	}
+	if len(subAttributes) > 0 {
+		attr["subAttributes"] = subAttributes
+	}
+	return attr
+}
+
+var scimSchemas = []interface{}{
+	map[string]interface{}{
+		"schemas": []string{scimSchemaSchema},
+		"id":      scimUserSchema,
+		"name":    "User",
+		"attributes": []map[string]interface{}{
+			scimAttribute("userName", "string", false, true),
+			scimAttribute("externalId", "string", false, false),
+			scimAttribute("name", "complex", false, false,
+				scimAttribute("formatted", "string", false, false),
+				scimAttribute("givenName", "string", false, false),
+				scimAttribute("familyName", "string", false, false),
+			),
+			scimAttribute("displayName", "string", false, false),
+			scimAttribute("emails", "complex", true, false,
+				scimAttribute("value", "string", false, false),
+				scimAttribute("type", "string", false, false),
+				scimAttribute("primary", "boolean", false, false),
+			),
+			scimAttribute("active", "boolean", false, false),
+			scimAttribute("roles", "complex", true, false,
+				scimAttribute("value", "string", false, false),
+			),
+			scimAttribute("groups", "complex", true, false,
+				scimAttribute("value", "string", false, false),
+				scimAttribute("display", "string", false, false),
+			),
+		},
+		"meta": map[string]interface{}{"resourceType": "Schema"},
+	},
+	map[string]interface{}{
+		"schemas": []string{scimSchemaSchema},
+		"id":      scimGroupSchema,
+		"name":    "Group",
+		"attributes": []map[string]interface{}{
+			scimAttribute("displayName", "string", false, true),
+			scimAttribute("externalId", "string", false, false),
+			scimAttribute("members", "complex", true, false,
+				scimAttribute("value", "string", false, false),
+				scimAttribute("display", "string", false, false),
+			),
+		},
+		"meta": map[string]interface{}{"resourceType": "Schema"},
+	},
+}
+
+// SCIMSchemas lists the schemas of the User and Group resources
+func (a *API) SCIMSchemas(w http.ResponseWriter, r *http.Request) error {
+	return sendSCIM(w, http.StatusOK, &scimListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: len(scimSchemas),
+		StartIndex:   1,
+		ItemsPerPage: len(scimSchemas),
+		Resources:    scimSchemas,
+	})
+}
+
+// SCIMSchema returns the schema of the User or Group resource
+func (a *API) SCIMSchema(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "schema_id")
+	for _, s := range scimSchemas {
+		if s.(map[string]interface{})["id"] == id {
+			return sendSCIM(w, http.StatusOK, s)
+		}
+	}
+	return scimError(http.StatusNotFound, "", "Schema %s not found", id)
+}
+
+func (a *API) scimUserResource(r *http.Request, user *models.User, groups []*models.SCIMGroup) *scimUser {
+	active := scimBool(!user.IsDeactivated())
+	resource := &scimUser{
+		Schemas:  []string{scimUserSchema},
+		ID:       user.ID.String(),
+		UserName: user.Email,
+		Emails:   []scimMultiValue{{Value: user.Email, Type: "work", Primary: true}},
+		Active:   &active,
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     scimLocation(r, "Users", user.ID.String()),
+		},
+	}
+
+	name := &scimName{}
+	if v, ok := user.UserMetaData[scimFullNameMetadataKey].(string); ok {
+		name.Formatted = v
+		resource.DisplayName = v
+	}
+	if v, ok := user.UserMetaData["given_name"].(string); ok {
+		name.GivenName = v
+	}
+	if v, ok := user.UserMetaData["family_name"].(string); ok {
+		name.FamilyName = v
+	}
+	if *name != (scimName{}) {
+		resource.Name = name
+	}
+
+	if user.AppMetaData != nil {
+		resource.ExternalID = user.AppMetaData.ExternalID
+		for _, role := range user.AppMetaData.Roles {
+			resource.Roles = append(resource.Roles, scimMultiValue{Value: role})
+			for _, g := range groups {
+				if g.DisplayName == role {
+					resource.Groups = append(resource.Groups, scimMultiValue{
+						Value:   g.ID.String(),
+						Display: g.DisplayName,
+						Ref:     scimLocation(r, "Groups", g.ID.String()),
+					})
+				}
+			}
+		}
+	}
+	return resource
+}
+
+// email is the user name, or the primary email for identity providers that
+// use other user names
+func (u *scimUser) email() string {
+	email := strings.TrimSpace(u.UserName)
+	if strings.Contains(email, "@") {
+		return email
+	}
+	for _, e := range u.Emails {
+		if e.Primary {
+			return strings.TrimSpace(e.Value)
+		}
+	}
+	if len(u.Emails) > 0 {
+		return strings.TrimSpace(u.Emails[0].Value)
+	}
+	return email
+}
+
+func (u *scimUser) userMetaData() map[string]interface{} {
+	data := map[string]interface{}{
+		scimFullNameMetadataKey: nil,
+		"given_name":            nil,
+		"family_name":           nil,
+	}
+	fullName := u.DisplayName
+	if u.Name != nil {
+		if u.Name.Formatted != "" {
+			fullName = u.Name.Formatted
+		}
+		if u.Name.GivenName != "" {
+			data["given_name"] = u.Name.GivenName
+		}
+		if u.Name.FamilyName != "" {
+			data["family_name"] = u.Name.FamilyName
+		}
+		if fullName == "" {
+			fullName = strings.TrimSpace(u.Name.GivenName + " " + u.Name.FamilyName)
+		}
+	}
+	if fullName != "" {
+		data[scimFullNameMetadataKey] = fullName
+	}
+	return data
+}
+
+func (u *scimUser) roles() []string {
+	roles := []string{}
+	for _, r := range u.Roles {
+		if v := strings.TrimSpace(r.Value); v != "" {
+			roles = append(roles, v)
+		}
+	}
+	return roles
+}
+
+func (a *API) getSCIMUser(r *http.Request) (*scimUser, error) {
+	params := &scimUser{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return nil, scimError(http.StatusBadRequest, "invalidSyntax", "Could not decode user: %v", err)
+	}
+	return params, nil
+}
+
+func (a *API) loadSCIMUser(ctx context.Context, r *http.Request) (*models.User, error) {
+	config := a.getConfig(ctx)
+	id, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		return nil, scimError(http.StatusNotFound, "", "User not found")
+	}
+	user, err := models.FindUserByIdAndAudience(ctx, a.db, getInstanceID(ctx), id, config.JWT.Aud)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, scimError(http.StatusNotFound, "", "User not found")
+		}
+		return nil, internalServerError("Database error finding user").WithInternalError(err)
+	}
+	if !a.scimManaged(ctx, user) {
+		return nil, scimError(http.StatusNotFound, "", "User not found")
+	}
+	return user, nil
+}
+
+// scimManaged reports whether the identity provider may see and change the
+// user. Admins, super admins and API keys are left to the admin API.
+func (a *API) scimManaged(ctx context.Context, user *models.User) bool {
+	if a.isAdmin(ctx, user, user.Aud) {
+		return false
+	}
+	return user.AppMetaData == nil || user.AppMetaData.KeyType != models.ApiKeyKeyType
+}
+
+// findSCIMUsers returns the users of the instance the identity provider
+// manages
+func (a *API) findSCIMUsers(ctx context.Context) ([]*models.User, error) {
+	config := a.getConfig(ctx)
+	users, err := models.FindUsersByInstanceAndAudience(ctx, a.db, getInstanceID(ctx), config.JWT.Aud)
+	if err != nil {
+		return nil, err
+	}
+	managed := make([]*models.User, 0, len(users))
+	for _, u := range users {
+		if a.scimManaged(ctx, u) {
+			managed = append(managed, u)
+		}
+	}
+	return managed, nil
+}
+
+// SCIMUsers lists the users of the instance
+func (a *API) SCIMUsers(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	instanceID := getInstanceID(ctx)
+
+	users, err := a.findSCIMUsers(ctx)
+	if err != nil {
+		return internalServerError("Database error finding users").WithInternalError(err)
+	}
+	groups, err := models.FindSCIMGroupsByInstance(ctx, a.db, instanceID)
+	if err != nil {
+		return internalServerError("Database error finding groups").WithInternalError(err)
+	}
+
+	resources := make([]interface{}, 0, len(users))
+	for _, u := range users {
+		resources = append(resources, a.scimUserResource(r, u, groups))
+	}
+	list, err := scimList(r, resources)
+	if err != nil {
+		return err
+	}
+	return sendSCIM(w, http.StatusOK, list)
+}
+
+// SCIMUserGet returns a user
+func (a *API) SCIMUserGet(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user, err := a.loadSCIMUser(ctx, r)
+	if err != nil {
+		return err
+	}
+	groups, err := models.FindSCIMGroupsByInstance(ctx, a.db, getInstanceID(ctx))
+	if err != nil {
+		return internalServerError("Database error finding groups").WithInternalError(err)
+	}
+	return sendSCIM(w, http.StatusOK, a.scimUserResource(r, user, groups))
+}
+
+// SCIMUserCreate provisions a user. The identity provider vouches for the
+// email, and the user signs in through it.
+func (a *API) SCIMUserCreate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	config := a.getConfig(ctx)
+	instanceID := getInstanceID(ctx)
+
+	params, err := a.getSCIMUser(r)
+	if err != nil {
+		return err
+	}
+	email := params.email()
+	if err := a.validateEmail(ctx, email); err != nil {
+		return err
+	}
+	exists, err := models.IsDuplicatedEmail(ctx, a.db, instanceID, email, config.JWT.Aud)
+	if err != nil {
+		return internalServerError("Database error checking email").WithInternalError(err)
+	}
+	if exists {
+		return scimError(http.StatusConflict, "uniqueness", "A user with this email address has already been registered")
+	}
+
+	data := map[string]interface{}{}
+	for k, v := range params.userMetaData() {
+		if v != nil {
+			data[k] = v
+		}
+	}
+
+	var user *models.User
+	err = a.db.Tx(ctx, func(ctx context.Context) error {
+		var terr error
+		user, terr = a.signupNewUser(ctx, &SignupParams{
+			Provider: scimProvider,
+			Email:    email,
+			Aud:      config.JWT.Aud,
+			Data:     data,
+			AppData: models.UserAppMetadata{
+				ExternalID: params.ExternalID,
+				Roles:      params.roles(),
+			},
+		})
+		if terr != nil {
+			return terr
+		}
+		if terr = user.Confirm(ctx, a.db); terr != nil {
+			return internalServerError("Database error updating user").WithInternalError(terr)
+		}
+		if params.Active != nil && !bool(*params.Active) {
+			if terr = user.SetDeactivated(ctx, a.db, true); terr != nil {
+				return internalServerError("Database error updating user").WithInternalError(terr)
+			}
+		}
+		return a.scimAuditLog(ctx, models.SCIMUserCreatedAction, map[string]interface{}{
+			"user_id":    user.ID,
+			"user_email": user.Email,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	groups, err := models.FindSCIMGroupsByInstance(ctx, a.db, instanceID)
+	if err != nil {
+		return internalServerError("Database error finding groups").WithInternalError(err)
+	}
+	return sendSCIM(w, http.StatusCreated, a.scimUserResource(r, user, groups))
+}
+
+// updateSCIMUser replaces the attributes of a user. Deactivating a user signs
+// them out everywhere.
+func (a *API) updateSCIMUser(ctx context.Context, user *models.User, params *scimUser) error {
+	config := a.getConfig(ctx)
+	instanceID := getInstanceID(ctx)
+
+	email := params.email()
+	if err := a.validateEmail(ctx, email); err != nil {
+		return err
+	}
+	if !strings.EqualFold(email, user.Email) {
+		exists, err := models.IsDuplicatedEmail(ctx, a.db, instanceID, email, config.JWT.Aud)
+		if err != nil {
+			return internalServerError("Database error checking email").WithInternalError(err)
+		}
+		if exists {
+			return scimError(http.StatusConflict, "uniqueness", "A user with this email address has already been registered")
+		}
+	}
+
+	return a.db.Tx(ctx, func(ctx context.Context) error {
+		oldEmail := user.Email
+		if email != user.Email {
+			a.tokenCache.Remove(user.Email)
+			if terr := user.SetEmail(ctx, a.db, email); terr != nil {
+				return internalServerError("Database error updating user").WithInternalError(terr)
+			}
+		}
+		if terr := user.UpdateUserMetaData(ctx, a.db, params.userMetaData()); terr != nil {
+			return internalServerError("Database error updating user").WithInternalError(terr)
+		}
+
+		appData := &models.UserAppMetadata{ExternalID: params.ExternalID}
+		if params.Roles != nil {
+			appData.Roles = params.roles()
+		}
+		if terr := user.PatchAppMetaData(ctx, a.db, appData); terr != nil {
+			return internalServerError("Database error updating user").WithInternalError(terr)
+		}
+
+		deactivate := params.Active != nil && !bool(*params.Active)
+		wasDeactivated := user.IsDeactivated()
+		if terr := user.SetDeactivated(ctx, a.db, deactivate); terr != nil {
+			return internalServerError("Database error updating user").WithInternalError(terr)
+		}
+		if deactivate && !wasDeactivated {
+			if terr := a.revokeUserTokens(ctx, user); terr != nil {
+				return internalServerError("Error revoking user sessions").WithInternalError(terr)
+			}
+		}
+
+		traits := map[string]interface{}{
+			"user_id":    user.ID,
+			"user_email": user.Email,
+			"active":     !deactivate,
+		}
+		if oldEmail != user.Email {
+			traits["old_email"] = oldEmail
+		}
+		return a.scimAuditLog(ctx, models.SCIMUserModifiedAction, traits)
+	})
+}
+
+// SCIMUserReplace replaces a user. Roles are kept if omitted.
+func (a *API) SCIMUserReplace(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user, err := a.loadSCIMUser(ctx, r)
+	if err != nil {
+		return err
+	}
+	params, err := a.getSCIMUser(r)
+	if err != nil {
+		return err
+	}
+	if err := a.updateSCIMUser(ctx, user, params); err != nil {
+		return err
+	}
+
+	groups, err := models.FindSCIMGroupsByInstance(ctx, a.db, getInstanceID(ctx))
+	if err != nil {
+		return internalServerError("Database error finding groups").WithInternalError(err)
+	}
+	return sendSCIM(w, http.StatusOK, a.scimUserResource(r, user, groups))
+}
+
+func (a *API) getSCIMPatch(r *http.Request) (*scimPatchRequest, error) {
+	params := &scimPatchRequest{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return nil, scimError(http.StatusBadRequest, "invalidSyntax", "Could not decode patch: %v", err)
+	}
+	return params, nil
+}
+
+// SCIMUserPatch changes attributes of a user
+func (a *API) SCIMUserPatch(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user, err := a.loadSCIMUser(ctx, r)
+	if err != nil {
+		return err
+	}
+	patch, err := a.getSCIMPatch(r)
+	if err != nil {
+		return err
+	}
+	groups, err := models.FindSCIMGroupsByInstance(ctx, a.db, getInstanceID(ctx))
+	if err != nil {
+		return internalServerError("Database error finding groups").WithInternalError(err)
+	}
+
+	doc, err := scimResourceMap(a.scimUserResource(r, user, groups))
+	if err != nil {
+		return internalServerError("Error encoding user").WithInternalError(err)
+	}
+	if err := applySCIMPatch(doc, patch.Operations); err != nil {
+		return err
+	}
+	params := &scimUser{}
+	if err := decodeSCIMResource(doc, params); err != nil {
+		return err
+	}
+	if params.Roles == nil {
+		params.Roles = []scimMultiValue{}
+	}
+	if err := a.updateSCIMUser(ctx, user, params); err != nil {
+		return err
+	}
+	return sendSCIM(w, http.StatusOK, a.scimUserResource(r, user, groups))
+}
+
+// SCIMUserDelete deletes a user
+func (a *API) SCIMUserDelete(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user, err := a.loadSCIMUser(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	err = a.db.Tx(ctx, func(ctx context.Context) error {
+		if terr := a.scimAuditLog(ctx, models.SCIMUserDeletedAction, map[string]interface{}{
+			"user_id":    user.ID,
+			"user_email": user.Email,
+		}); terr != nil {
+			return internalServerError("Error recording audit log entry").WithInternalError(terr)
+		}
+		if _, terr := tigris.GetCollection[models.User](a.db).Delete(ctx, filter.EqUUID("id", user.ID)); terr != nil {
+			return internalServerError("Database error deleting user").WithInternalError(terr)
+		}
+		if terr := models.DeleteIdentitiesByUser(ctx, a.db, user); terr != nil {
+			return internalServerError("Database error deleting user identities").WithInternalError(terr)
+		}
+		if terr := models.DeletePasswordHistory(ctx, a.db, user); terr != nil {
+			return internalServerError("Database error deleting password history").WithInternalError(terr)
+		}
+		if terr := models.DeleteKnownDevices(ctx, a.db, user); terr != nil {
+			return internalServerError("Database error deleting known devices").WithInternalError(terr)
+		}
+		if terr := a.revokeUserTokens(ctx, user); terr != nil {
+			return internalServerError("Error revoking user sessions").WithInternalError(terr)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (a *API) scimGroupResource(r *http.Request, group *models.SCIMGroup, users []*models.User) *scimGroup {
+	resource := &scimGroup{
+		Schemas:     []string{scimGroupSchema},
+		ID:          group.ID.String(),
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Members:     []scimMultiValue{},
+		Meta: &scimMeta{
+			ResourceType: "Group",
+			Created:      &group.CreatedAt,
+			LastModified: &group.UpdatedAt,
+			Location:     scimLocation(r, "Groups", group.ID.String()),
+		},
+	}
+	for _, u := range users {
+		if hasRole(u, group.DisplayName) {
+			resource.Members = append(resource.Members, scimMultiValue{
+				Value:   u.ID.String(),
+				Display: u.Email,
+				Ref:     scimLocation(r, "Users", u.ID.String()),
+			})
+		}
+	}
+	return resource
+}
+
+func hasRole(user *models.User, role string) bool {
+	if user.AppMetaData == nil {
+		return false
+	}
+	for _, r := range user.AppMetaData.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *API) getSCIMGroup(r *http.Request) (*scimGroup, error) {
+	params := &scimGroup{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return nil, scimError(http.StatusBadRequest, "invalidSyntax", "Could not decode group: %v", err)
+	}
+	return params, nil
+}
+
+func (a *API) loadSCIMGroup(ctx context.Context, r *http.Request) (*models.SCIMGroup, error) {
+	id, err := uuid.Parse(chi.URLParam(r, "group_id"))
+	if err != nil {
+		return nil, scimError(http.StatusNotFound, "", "Group not found")
+	}
+	group, err := models.FindSCIMGroupByID(ctx, a.db, getInstanceID(ctx), id)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, scimError(http.StatusNotFound, "", "Group not found")
+		}
+		return nil, internalServerError("Database error finding group").WithInternalError(err)
+	}
+	return group, nil
+}
+
+// validateSCIMGroup checks that the display name is set and not used by
+// another group
+func (a *API) validateSCIMGroup(ctx context.Context, group *models.SCIMGroup, displayName string) error {
+	displayName = strings.TrimSpace(displayName)
+	if displayName == "" {
+		return scimError(http.StatusBadRequest, "invalidValue", "Groups require a display name")
+	}
+	groups, err := models.FindSCIMGroupsByInstance(ctx, a.db, getInstanceID(ctx))
+	if err != nil {
+		return internalServerError("Database error finding groups").WithInternalError(err)
+	}
+	for _, g := range groups {
+		if (group == nil || g.ID != group.ID) && g.DisplayName == displayName {
+			return scimError(http.StatusConflict, "uniqueness", "A group named %s already exists", displayName)
+		}
+	}
+	return nil
+}
+
+// setSCIMGroupMembers gives the members of the group its role, and takes the
+// former name of the group away from everyone else.
+func (a *API) setSCIMGroupMembers(ctx context.Context, users []*models.User, group *models.SCIMGroup, oldName string, members []scimMultiValue) error {
+	want := map[string]bool{}
+	for _, m := range members {
+		want[strings.TrimSpace(m.Value)] = true
+	}
+	found := 0
+	for _, u := range users {
+		if want[u.ID.String()] {
+			found++
+		}
+	}
+	if found != len(want) {
+		return scimError(http.StatusBadRequest, "invalidValue", "Unknown group member")
+	}
+
+	for _, u := range users {
+		var current []string
+		if u.AppMetaData != nil {
+			current = u.AppMetaData.Roles
+		}
+		roles := []string{}
+		for _, r := range current {
+			if r != oldName && r != group.DisplayName {
+				roles = append(roles, r)
+			}
+		}
+		if want[u.ID.String()] {
+			roles = append(roles, group.DisplayName)
+		}
+		if reflect.DeepEqual(roles, current) || (len(roles) == 0 && len(current) == 0) {
+			continue
+		}
+		if err := u.PatchAppMetaData(ctx, a.db, &models.UserAppMetadata{Roles: roles}); err != nil {
+			return internalServerError("Database error updating user").WithInternalError(err)
+		}
+		a.tokenCache.Remove(u.Email)
+	}
+	return nil
+}
+
+// SCIMGroups lists the groups of the instance
+func (a *API) SCIMGroups(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	instanceID := getInstanceID(ctx)
+
+	groups, err := models.FindSCIMGroupsByInstance(ctx, a.db, instanceID)
+	if err != nil {
+		return internalServerError("Database error finding groups").WithInternalError(err)
+	}
+	users, err := a.findSCIMUsers(ctx)
+	if err != nil {
+		return internalServerError("Database error finding users").WithInternalError(err)
+	}
+
+	resources := make([]interface{}, 0, len(groups))
+	for _, g := range groups {
+		resources = append(resources, a.scimGroupResource(r, g, users))
+	}
+	list, err := scimList(r, resources)
+	if err != nil {
+		return err
+	}
+	return sendSCIM(w, http.StatusOK, list)
+}
+
+// SCIMGroupGet returns a group with its members
+func (a *API) SCIMGroupGet(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	group, err := a.loadSCIMGroup(ctx, r)
+	if err != nil {
+		return err
+	}
+	users, err := a.findSCIMUsers(ctx)
+	if err != nil {
+		return internalServerError("Database error finding users").WithInternalError(err)
+	}
+	return sendSCIM(w, http.StatusOK, a.scimGroupResource(r, group, users))
+}
+
+// SCIMGroupCreate creates a group. Its members get the display name of the
+// group as a role.
+func (a *API) SCIMGroupCreate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	instanceID := getInstanceID(ctx)
+
+	params, err := a.getSCIMGroup(r)
+	if err != nil {
+		return err
+	}
+	if err := a.validateSCIMGroup(ctx, nil, params.DisplayName); err != nil {
+		return err
+	}
+	users, err := a.findSCIMUsers(ctx)
+	if err != nil {
+		return internalServerError("Database error finding users").WithInternalError(err)
+	}
+
+	var group *models.SCIMGroup
+	err = a.db.Tx(ctx, func(ctx context.Context) error {
+		var terr error
+		group, terr = models.NewSCIMGroup(ctx, a.db, instanceID, params.DisplayName, params.ExternalID)
+		if terr != nil {
+			return internalServerError("Database error creating group").WithInternalError(terr)
+		}
+		if terr = a.setSCIMGroupMembers(ctx, users, group, group.DisplayName, params.Members); terr != nil {
+			return terr
+		}
+		return a.scimAuditLog(ctx, models.SCIMGroupCreatedAction, map[string]interface{}{
+			"group_id":   group.ID,
+			"group_name": group.DisplayName,
+			"members":    len(params.Members),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return sendSCIM(w, http.StatusCreated, a.scimGroupResource(r, group, users))
+}
+
+// updateSCIMGroup renames a group and replaces its members
+func (a *API) updateSCIMGroup(ctx context.Context, group *models.SCIMGroup, users []*models.User, params *scimGroup) error {
+	if err := a.validateSCIMGroup(ctx, group, params.DisplayName); err != nil {
+		return err
+	}
+
+	return a.db.Tx(ctx, func(ctx context.Context) error {
+		oldName := group.DisplayName
+		group.DisplayName = strings.TrimSpace(params.DisplayName)
+		group.ExternalID = params.ExternalID
+		if terr := group.Update(ctx, a.db); terr != nil {
+			return internalServerError("Database error updating group").WithInternalError(terr)
+		}
+		if terr := a.setSCIMGroupMembers(ctx, users, group, oldName, params.Members); terr != nil {
+			return terr
+		}
+		traits := map[string]interface{}{
+			"group_id":   group.ID,
+			"group_name": group.DisplayName,
+			"members":    len(params.Members),
+		}
+		if oldName != group.DisplayName {
+			traits["old_group_name"] = oldName
+		}
+		return a.scimAuditLog(ctx, models.SCIMGroupModifiedAction, traits)
+	})
+}
+
+// SCIMGroupReplace replaces a group
+func (a *API) SCIMGroupReplace(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	group, err := a.loadSCIMGroup(ctx, r)
+	if err != nil {
+		return err
+	}
+	params, err := a.getSCIMGroup(r)
+	if err != nil {
+		return err
+	}
+	users, err := a.findSCIMUsers(ctx)
+	if err != nil {
+		return internalServerError("Database error finding users").WithInternalError(err)
+	}
+	if err := a.updateSCIMGroup(ctx, group, users, params); err != nil {
+		return err
+	}
+	return sendSCIM(w, http.StatusOK, a.scimGroupResource(r, group, users))
+}
+
+// SCIMGroupPatch renames a group or adds and removes members
+func (a *API) SCIMGroupPatch(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	group, err := a.loadSCIMGroup(ctx, r)
+	if err != nil {
+		return err
+	}
+	patch, err := a.getSCIMPatch(r)
+	if err != nil {
+		return err
+	}
+	users, err := a.findSCIMUsers(ctx)
+	if err != nil {
+		return internalServerError("Database error finding users").WithInternalError(err)
+	}
+
+	doc, err := scimResourceMap(a.scimGroupResource(r, group, users))
+	if err != nil {
+		return internalServerError("Error encoding group").WithInternalError(err)
+	}
+	if err := applySCIMPatch(doc, patch.Operations); err != nil {
+		return err
+	}
+	params := &scimGroup{}
+	if err := decodeSCIMResource(doc, params); err != nil {
+		return err
+	}
+	if err := a.updateSCIMGroup(ctx, group, users, params); err != nil {
+		return err
+	}
+	return sendSCIM(w, http.StatusOK, a.scimGroupResource(r, group, users))
+}
+
+// SCIMGroupDelete deletes a group and takes its role away from the members
+func (a *API) SCIMGroupDelete(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	group, err := a.loadSCIMGroup(ctx, r)
+	if err != nil {
+		return err
+	}
+	users, err := a.findSCIMUsers(ctx)
+	if err != nil {
+		return internalServerError("Database error finding users").WithInternalError(err)
+	}
+
+	err = a.db.Tx(ctx, func(ctx context.Context) error {
+		for _, u := range users {
+			if !hasRole(u, group.DisplayName) {
+				continue
+			}
+			roles := []string{}
+			for _, role := range u.AppMetaData.Roles {
+				if role != group.DisplayName {
+					roles = append(roles, role)
+				}
+			}
+			if terr := u.PatchAppMetaData(ctx, a.db, &models.UserAppMetadata{Roles: roles}); terr != nil {
+				return internalServerError("Database error updating user").WithInternalError(terr)
+			}
+			a.tokenCache.Remove(u.Email)
+		}
+		if terr := group.Delete(ctx, a.db); terr != nil {
+			return internalServerError("Database error deleting group").WithInternalError(terr)
+		}
+		return a.scimAuditLog(ctx, models.SCIMGroupDeletedAction, map[string]interface{}{
+			"group_id":   group.ID,
+			"group_name": group.DisplayName,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// SCIMTokenResponse is a SCIM token as admins see it, without its hash. The
+// token itself is only set when it is created.
+type SCIMTokenResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Token      string     `json:"token,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func newSCIMTokenResponse(token *models.SCIMToken, secret string) *SCIMTokenResponse {
+	return &SCIMTokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		Token:      secret,
+		CreatedAt:  token.CreatedAt,
+		LastUsedAt: token.LastUsedAt,
+	}
+}
+
+// adminSCIMTokens lists the SCIM tokens of the instance
+func (a *API) adminSCIMTokens(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	tokens, err := models.FindSCIMTokensByInstance(ctx, a.db, getInstanceID(ctx))
+	if err != nil {
+		return internalServerError("Database error finding SCIM tokens").WithInternalError(err)
+	}
+	resp := make([]*SCIMTokenResponse, 0, len(tokens))
+	for _, token := range tokens {
+		resp = append(resp, newSCIMTokenResponse(token, ""))
+	}
+	return sendJSON(w, http.StatusOK, map[string]interface{}{
+		"tokens": resp,
+	})
+}
+
+// adminSCIMTokenCreate creates a SCIM token. The token is only returned once.
+func (a *API) adminSCIMTokenCreate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	instanceID := getInstanceID(ctx)
+	adminUser := getAdminUser(ctx)
+
+	params := struct {
+		Name string `json:"name"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		return badRequestError("Could not decode SCIM token params: %v", err)
+	}
+	if strings.TrimSpace(params.Name) == "" {
+		return unprocessableEntityError("SCIM tokens require a name")
+	}
+
+	var token *models.SCIMToken
+	var secret string
+	err := a.db.Tx(ctx, func(ctx context.Context) error {
+		var terr error
+		token, secret, terr = models.NewSCIMToken(ctx, a.db, instanceID, strings.TrimSpace(params.Name))
+		if terr != nil {
+			return terr
+		}
+		return models.NewAuditLogEntry(ctx, a.db, instanceID, adminUser, models.SCIMTokenCreatedAction, map[string]interface{}{
+			"scim_token_id":   token.ID,
+			"scim_token_name": token.Name,
+		})
+	})
+	if err != nil {
+		return internalServerError("Database error creating SCIM token").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusCreated, newSCIMTokenResponse(token, secret))
+}
+
+// adminSCIMTokenDelete revokes a SCIM token
+func (a *API) adminSCIMTokenDelete(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	instanceID := getInstanceID(ctx)
+	adminUser := getAdminUser(ctx)
+
+	id, err := uuid.Parse(chi.URLParam(r, "token_id"))
+	if err != nil {
+		return badRequestError("Invalid SCIM token ID")
+	}
+	token, err := models.FindSCIMTokenByID(ctx, a.db, instanceID, id)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return notFoundError("SCIM token not found")
+		}
+		return internalServerError("Database error finding SCIM token").WithInternalError(err)
+	}
+
+	err = a.db.Tx(ctx, func(ctx context.Context) error {
+		if terr := models.NewAuditLogEntry(ctx, a.db, instanceID, adminUser, models.SCIMTokenDeletedAction, map[string]interface{}{
+			"scim_token_id":   token.ID,
+			"scim_token_name": token.Name,
+		}); terr != nil {
+			return terr
+		}
+		return token.Delete(ctx, a.db)
+	})
+	if err != nil {
+		return internalServerError("Database error deleting SCIM token").WithInternalError(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,628 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// scimFilter is a parsed SCIM filter expression (RFC 7644 section 3.4.2.2)
+// matched against the JSON representation of a resource.
+type scimFilter interface {
+	match(resource map[string]interface{}) bool
+}
+
+type scimCompareFilter struct {
+	path  []string
+	op    string
+	value interface{}
+}
+
+type scimLogicalFilter struct {
+	op          string
+	left, right scimFilter
+}
+
+type scimNotFilter struct {
+	filter scimFilter
+}
+
+// scimValuePathFilter matches resources with an element of a multi-valued
+// attribute matching the inner filter, e.g. emails[type eq "work"]
+type scimValuePathFilter struct {
+	attr   string
+	filter scimFilter
+}
+
+func (f *scimLogicalFilter) match(resource map[string]interface{}) bool {
+	if f.op == "and" {
+		return f.left.match(resource) && f.right.match(resource)
+	}
+	return f.left.match(resource) || f.right.match(resource)
+}
+
+func (f *scimNotFilter) match(resource map[string]interface{}) bool {
+	return !f.filter.match(resource)
+}
+
+func (f *scimValuePathFilter) match(resource map[string]interface{}) bool {
+	for _, v := range scimValues(resource, []string{f.attr}) {
+		if elem, ok := v.(map[string]interface{}); ok && f.filter.match(elem) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *scimCompareFilter) match(resource map[string]interface{}) bool {
+	values := scimValues(resource, f.path)
+	if f.op == "pr" {
+		for _, v := range values {
+			if v != nil && v != "" {
+				return true
+			}
+		}
+		return false
+	}
+	if f.op == "ne" {
+		for _, v := range values {
+			if scimCompare(v, "eq", f.value) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range values {
+		if scimCompare(v, f.op, f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// scimCompare compares an attribute value with the value of a filter. Strings
+// are compared case insensitively.
+func scimCompare(v interface{}, op string, want interface{}) bool {
+	switch want := want.(type) {
+	case string:
+		got, ok := v.(string)
+		if !ok {
+			return false
+		}
+		got, want = strings.ToLower(got), strings.ToLower(want)
+		switch op {
+		case "eq":
+			return got == want
+		case "co":
+			return strings.Contains(got, want)
+		case "sw":
+			return strings.HasPrefix(got, want)
+		case "ew":
+			return strings.HasSuffix(got, want)
+		case "gt":
+			return got > want
+		case "ge":
+			return got >= want
+		case "lt":
+			return got < want
+		case "le":
+			return got <= want
+		}
+	case float64:
+		got, ok := v.(float64)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "eq":
+			return got == want
+		case "gt":
+			return got > want
+		case "ge":
+			return got >= want
+		case "lt":
+			return got < want
+		case "le":
+			return got <= want
+		}
+	default:
+		return op == "eq" && reflect.DeepEqual(v, want)
+	}
+	return false
+}
+
+// scimValues returns the values at the attribute path of the resource.
+// Multi-valued attributes on the way are flattened.
+func scimValues(v interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		if values, ok := v.([]interface{}); ok {
+			return values
+		}
+		return []interface{}{v}
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		key, ok := scimKey(v, path[0])
+		if !ok {
+			return nil
+		}
+		return scimValues(v[key], path[1:])
+	case []interface{}:
+		var values []interface{}
+		for _, elem := range v {
+			values = append(values, scimValues(elem, path)...)
+		}
+		return values
+	}
+	return nil
+}
+
+// scimKey finds the key of an attribute, since attribute names are case
+// insensitive.
+func scimKey(m map[string]interface{}, name string) (string, bool) {
+	if _, ok := m[name]; ok {
+		return name, true
+	}
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			return k, true
+		}
+	}
+	return name, false
+}
+
+// scimAttrPath strips the schema URN from an attribute path, e.g.
+// urn:ietf:params:scim:schemas:core:2.0:User:name.givenName
+func scimAttrPath(path string) string {
+	if i := strings.LastIndex(path, ":"); i >= 0 {
+		return path[i+1:]
+	}
+	return path
+}
+
+func invalidFilterError(fmtString string, args ...interface{}) *SCIMError {
+	return scimError(http.StatusBadRequest, "invalidFilter", fmtString, args...)
+}
+
+type scimFilterParser struct {
+	tokens []string
+	pos    int
+}
+
+// parseSCIMFilter parses a filter expression.
+func parseSCIMFilter(expr string) (scimFilter, error) {
+	tokens, err := scimTokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &scimFilterParser{tokens: tokens}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, invalidFilterError("Unexpected %q in filter", p.tokens[p.pos])
+	}
+	return f, nil
+}
+
+func scimTokenize(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(expr); j++ {
+				if expr[j] == '\\' {
+					j++
+				} else if expr[j] == '"' {
+					break
+				}
+			}
+			if j >= len(expr) {
+				return nil, invalidFilterError("Unterminated string in filter")
+			}
+			tokens = append(tokens, expr[i:j+1])
+			i = j + 1
+		default:
+			j := i
+			for j < len(expr) && !strings.ContainsRune(" \t\n()[]\"", rune(expr[j])) {
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func (p *scimFilterParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *scimFilterParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *scimFilterParser) parseOr() (scimFilter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &scimLogicalFilter{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *scimFilterParser) parseAnd() (scimFilter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &scimLogicalFilter{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *scimFilterParser) parseUnary() (scimFilter, error) {
+	switch t := p.next(); {
+	case t == "":
+		return nil, invalidFilterError("Unexpected end of filter")
+	case t == "(":
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, invalidFilterError("Missing ) in filter")
+		}
+		return f, nil
+	case strings.EqualFold(t, "not"):
+		if p.next() != "(" {
+			return nil, invalidFilterError("Missing ( after not in filter")
+		}
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, invalidFilterError("Missing ) in filter")
+		}
+		return &scimNotFilter{filter: f}, nil
+	case strings.ContainsAny(t, "()[]\""):
+		return nil, invalidFilterError("Unexpected %q in filter", t)
+	default:
+		attr := scimAttrPath(t)
+		if p.peek() == "[" {
+			p.next()
+			f, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if p.next() != "]" {
+				return nil, invalidFilterError("Missing ] in filter")
+			}
+			return &scimValuePathFilter{attr: attr, filter: f}, nil
+		}
+		return p.parseComparison(attr)
+	}
+}
+
+func (p *scimFilterParser) parseComparison(attr string) (scimFilter, error) {
+	f := &scimCompareFilter{path: strings.Split(attr, "."), op: strings.ToLower(p.next())}
+	switch f.op {
+	case "pr":
+		return f, nil
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, invalidFilterError("Unknown operator %q in filter", f.op)
+	}
+
+	t := p.next()
+	switch {
+	case t == "":
+		return nil, invalidFilterError("Missing value in filter")
+	case strings.HasPrefix(t, "\""):
+		var s string
+		if err := json.Unmarshal([]byte(t), &s); err != nil {
+			return nil, invalidFilterError("Invalid string %s in filter", t)
+		}
+		f.value = s
+	case t == "true" || t == "false":
+		f.value = t == "true"
+	case t == "null":
+		f.value = nil
+	default:
+		n, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return nil, invalidFilterError("Invalid value %q in filter", t)
+		}
+		f.value = n
+	}
+	switch f.value.(type) {
+	case string, float64:
+	default:
+		if f.op != "eq" && f.op != "ne" {
+			return nil, invalidFilterError("Operator %q needs a string or number", f.op)
+		}
+	}
+	return f, nil
+}
+
+// scimPatchOperation is an operation of a PATCH request (RFC 7644 section 3.5.2)
+type scimPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// scimPatchPath is the target of a patch operation, e.g.
+// emails[type eq "work"].value
+type scimPatchPath struct {
+	attr   string
+	filter scimFilter
+	sub    string
+
+	// filterAttr and filterValue are set for filters like value eq "x", which
+	// add operations create a matching element for
+	filterAttr  string
+	filterValue interface{}
+}
+
+func invalidPathError(fmtString string, args ...interface{}) *SCIMError {
+	return scimError(http.StatusBadRequest, "invalidPath", fmtString, args...)
+}
+
+func parseSCIMPatchPath(path string) (*scimPatchPath, error) {
+	p := &scimPatchPath{}
+	rest := path
+	if i := strings.Index(path, "["); i >= 0 {
+		j := strings.LastIndex(path, "]")
+		if j < i {
+			return nil, invalidPathError("Invalid path %q", path)
+		}
+		p.attr = scimAttrPath(path[:i])
+		f, err := parseSCIMFilter(path[i+1 : j])
+		if err != nil {
+			return nil, invalidPathError("Invalid filter in path %q", path)
+		}
+		p.filter = f
+		if c, ok := f.(*scimCompareFilter); ok && c.op == "eq" && len(c.path) == 1 {
+			p.filterAttr, p.filterValue = c.path[0], c.value
+		}
+		rest = strings.TrimPrefix(path[j+1:], ".")
+		if strings.ContainsAny(rest, "[]") {
+			return nil, invalidPathError("Invalid path %q", path)
+		}
+		p.sub = rest
+	} else {
+		rest = scimAttrPath(rest)
+		parts := strings.SplitN(rest, ".", 2)
+		p.attr = parts[0]
+		if len(parts) == 2 {
+			p.sub = parts[1]
+		}
+	}
+	if p.attr == "" {
+		return nil, invalidPathError("Invalid path %q", path)
+	}
+	return p, nil
+}
+
+// applySCIMPatch applies the operations to the JSON representation of a
+// resource.
+func applySCIMPatch(doc map[string]interface{}, operations []scimPatchOperation) error {
+	for _, op := range operations {
+		name := strings.ToLower(op.Op)
+		switch name {
+		case "add", "replace", "remove":
+		default:
+			return scimError(http.StatusBadRequest, "invalidSyntax", "Unknown patch operation %q", op.Op)
+		}
+
+		if op.Path == "" {
+			if name == "remove" {
+				return scimError(http.StatusBadRequest, "noTarget", "Remove operations require a path")
+			}
+			values, ok := op.Value.(map[string]interface{})
+			if !ok {
+				return scimError(http.StatusBadRequest, "invalidValue", "Operations without a path require an object value")
+			}
+			for path, value := range values {
+				if err := applySCIMPatchPath(doc, name, path, value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := applySCIMPatchPath(doc, name, op.Path, op.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applySCIMPatchPath(doc map[string]interface{}, op string, path string, value interface{}) error {
+	p, err := parseSCIMPatchPath(path)
+	if err != nil {
+		return err
+	}
+	key, _ := scimKey(doc, p.attr)
+
+	if p.filter == nil {
+		if p.sub != "" {
+			m, ok := doc[key].(map[string]interface{})
+			if !ok {
+				if op == "remove" {
+					return nil
+				}
+				m = map[string]interface{}{}
+				doc[key] = m
+			}
+			subKey, _ := scimKey(m, p.sub)
+			if op == "remove" {
+				delete(m, subKey)
+			} else {
+				m[subKey] = value
+			}
+			return nil
+		}
+
+		existing := doc[key]
+		switch op {
+		case "remove":
+			// some identity providers name the elements to remove in the value
+			if elems, ok := existing.([]interface{}); ok && value != nil {
+				doc[key] = scimRemoveElements(elems, value)
+			} else {
+				delete(doc, key)
+			}
+		case "add":
+			if elems, ok := existing.([]interface{}); ok {
+				doc[key] = scimAddElements(elems, value)
+				return nil
+			}
+			doc[key] = scimMerge(existing, value)
+		case "replace":
+			doc[key] = scimMerge(existing, value)
+		}
+		return nil
+	}
+
+	elems, _ := doc[key].([]interface{})
+	matched := false
+	kept := []interface{}{}
+	for _, elem := range elems {
+		m, ok := elem.(map[string]interface{})
+		if !ok || !p.filter.match(m) {
+			kept = append(kept, elem)
+			continue
+		}
+		matched = true
+		switch {
+		case op == "remove" && p.sub == "":
+			continue
+		case op == "remove":
+			subKey, _ := scimKey(m, p.sub)
+			delete(m, subKey)
+		case p.sub != "":
+			subKey, _ := scimKey(m, p.sub)
+			m[subKey] = value
+		default:
+			if v, ok := value.(map[string]interface{}); ok {
+				for k, sv := range v {
+					subKey, _ := scimKey(m, k)
+					m[subKey] = sv
+				}
+			}
+		}
+		kept = append(kept, m)
+	}
+
+	if !matched {
+		if op == "remove" {
+			return nil
+		}
+		if p.filterAttr == "" {
+			return scimError(http.StatusBadRequest, "noTarget", "No value matches path %q", path)
+		}
+		elem := map[string]interface{}{p.filterAttr: p.filterValue}
+		if p.sub != "" {
+			elem[p.sub] = value
+		} else if v, ok := value.(map[string]interface{}); ok {
+			for k, sv := range v {
+				elem[k] = sv
+			}
+		}
+		kept = append(kept, elem)
+	}
+	doc[key] = kept
+	return nil
+}
+
+// scimMerge replaces a value. The sub-attributes of complex attributes that
+// are not in the new value are kept.
+func scimMerge(existing, value interface{}) interface{} {
+	old, ok := existing.(map[string]interface{})
+	if !ok {
+		return value
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+	for k, v := range m {
+		key, _ := scimKey(old, k)
+		old[key] = v
+	}
+	return old
+}
+
+func scimElements(value interface{}) []interface{} {
+	if elems, ok := value.([]interface{}); ok {
+		return elems
+	}
+	return []interface{}{value}
+}
+
+func scimElementValue(elem interface{}) string {
+	if m, ok := elem.(map[string]interface{}); ok {
+		key, _ := scimKey(m, "value")
+		return fmt.Sprint(m[key])
+	}
+	return fmt.Sprint(elem)
+}
+
+func scimAddElements(elems []interface{}, value interface{}) []interface{} {
+	seen := map[string]bool{}
+	for _, elem := range elems {
+		seen[scimElementValue(elem)] = true
+	}
+	for _, elem := range scimElements(value) {
+		if v := scimElementValue(elem); !seen[v] {
+			seen[v] = true
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
+
+func scimRemoveElements(elems []interface{}, value interface{}) []interface{} {
+	remove := map[string]bool{}
+	for _, elem := range scimElements(value) {
+		remove[scimElementValue(elem)] = true
+	}
+	kept := []interface{}{}
+	for _, elem := range elems {
+		if !remove[scimElementValue(elem)] {
+			kept = append(kept, elem)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scimTestUser(t *testing.T) map[string]interface{} {
+	doc := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"userName": "Jane@Example.com",
+		"externalId": "00u1",
+		"name": {"givenName": "Jane", "familyName": "Doe"},
+		"active": true,
+		"emails": [
+			{"value": "jane@example.com", "type": "work", "primary": true},
+			{"value": "jane@home.org", "type": "home"}
+		],
+		"roles": [{"value": "admin"}]
+	}`), &doc))
+	return doc
+}
+
+func TestSCIMFilter(t *testing.T) {
+	doc := scimTestUser(t)
+
+	cases := map[string]bool{
+		`userName eq "jane@example.com"`:                                true,
+		`USERNAME Eq "JANE@EXAMPLE.COM"`:                                true,
+		`userName eq "john@example.com"`:                                false,
+		`userName ne "john@example.com"`:                                true,
+		`urn:ietf:params:scim:schemas:core:2.0:User:userName sw "jane"`: true,
+		`name.familyName co "oe"`:                                       true,
+		`emails.value ew "@home.org"`:                                   true,
+		`emails[type eq "work" and value co "example"]`:                 true,
+		`emails[type eq "work" and value co "home"]`:                    false,
+		`active eq true`:                                                true,
+		`title pr`:                                                      false,
+		`externalId pr and not (roles.value eq "user")`:                 true,
+		`(userName eq "x" or externalId eq "00u1") and active eq true`:  true,
+		`userName eq "x" or externalId eq "y"`:                          false,
+	}
+	for expr, want := range cases {
+		f, err := parseSCIMFilter(expr)
+		require.NoError(t, err, expr)
+		assert.Equal(t, want, f.match(doc), expr)
+	}
+
+	for _, expr := range []string{`userName`, `userName eq`, `userName xx "a"`, `(userName eq "a"`, `emails[type eq "work"`, `userName co true`, `userName eq "a`} {
+		_, err := parseSCIMFilter(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestSCIMPatch(t *testing.T) {
+	doc := scimTestUser(t)
+
+	ops := []scimPatchOperation{}
+	require.NoError(t, json.Unmarshal([]byte(`[
+		{"op": "Replace", "path": "name.givenName", "value": "Janet"},
+		{"op": "replace", "value": {"active": "False", "displayName": "Janet Doe"}},
+		{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "janet@example.com"},
+		{"op": "remove", "path": "emails[type eq \"home\"]"},
+		{"op": "add", "path": "roles", "value": [{"value": "admin"}, {"value": "billing"}]},
+		{"op": "add", "path": "phoneNumbers[type eq \"mobile\"].value", "value": "+15550100"}
+	]`), &ops))
+	require.NoError(t, applySCIMPatch(doc, ops))
+
+	params := &scimUser{}
+	require.NoError(t, decodeSCIMResource(doc, params))
+	assert.Equal(t, "Janet", params.Name.GivenName)
+	assert.Equal(t, "Doe", params.Name.FamilyName)
+	assert.Equal(t, "Janet Doe", params.DisplayName)
+	require.NotNil(t, params.Active)
+	assert.False(t, bool(*params.Active))
+	require.Len(t, params.Emails, 1)
+	assert.Equal(t, "janet@example.com", params.Emails[0].Value)
+	assert.Equal(t, []string{"admin", "billing"}, params.roles())
+	assert.Equal(t, []interface{}{map[string]interface{}{"type": "mobile", "value": "+15550100"}}, doc["phoneNumbers"])
+
+	// members are removed by filter or by value
+	group := map[string]interface{}{
+		"displayName": "admins",
+		"members":     []interface{}{map[string]interface{}{"value": "1"}, map[string]interface{}{"value": "2"}, map[string]interface{}{"value": "3"}},
+	}
+	ops = []scimPatchOperation{}
+	require.NoError(t, json.Unmarshal([]byte(`[
+		{"op": "remove", "path": "members[value eq \"1\"]"},
+		{"op": "remove", "path": "members", "value": [{"value": "2"}]},
+		{"op": "add", "path": "members", "value": [{"value": "3"}, {"value": "4"}]}
+	]`), &ops))
+	require.NoError(t, applySCIMPatch(group, ops))
+	g := &scimGroup{}
+	require.NoError(t, decodeSCIMResource(group, g))
+	require.Len(t, g.Members, 2)
+	assert.Equal(t, "3", g.Members[0].Value)
+	assert.Equal(t, "4", g.Members[1].Value)
+
+	for _, op := range []scimPatchOperation{
+		{Op: "move", Path: "userName"},
+		{Op: "remove"},
+		{Op: "replace", Value: "x"},
+		{Op: "replace", Path: "emails[type eq \"other\" or primary eq false].value", Value: "x"},
+	} {
+		assert.Error(t, applySCIMPatch(scimTestUser(t), []scimPatchOperation{op}), op.Op)
+	}
+}
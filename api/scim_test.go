@@ -0,0 +1,382 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/tigrisdata/gotrue/conf"
+	"github.com/tigrisdata/gotrue/crypto"
+	"github.com/tigrisdata/gotrue/models"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+func (ts *AdminTestSuite) TestAdminSCIMTokens() {
+	var buffer bytes.Buffer
+	ts.Require().NoError(json.NewEncoder(&buffer).Encode(map[string]interface{}{"name": "Okta"}))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/admin/scim/tokens", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusCreated, w.Code)
+	ts.NotContains(w.Body.String(), "token_hash")
+
+	created := struct {
+		ID    uuid.UUID `json:"id"`
+		Name  string    `json:"name"`
+		Token string    `json:"token"`
+	}{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(&created))
+	ts.Equal("Okta", created.Name)
+	ts.NotEmpty(created.Token)
+
+	scimConfig := func() int {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/scim/v2/ServiceProviderConfig", nil)
+		req.Header.Set("Authorization", "Bearer "+created.Token)
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	ts.Equal(http.StatusOK, scimConfig())
+
+	// tokens are listed without the token itself
+	req = httptest.NewRequest(http.MethodGet, "http://localhost/admin/scim/tokens", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusOK, w.Code)
+	ts.NotContains(w.Body.String(), created.Token)
+	ts.NotContains(w.Body.String(), "token_hash")
+	ts.Contains(w.Body.String(), created.ID.String())
+
+	req = httptest.NewRequest(http.MethodDelete, "http://localhost/admin/scim/tokens/"+created.ID.String(), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusNoContent, w.Code)
+	ts.Equal(http.StatusUnauthorized, scimConfig())
+}
+
+type SCIMTestSuite struct {
+	suite.Suite
+	API        *API
+	Config     *conf.Configuration
+	Encrypter  *crypto.AESBlockEncrypter
+	instanceID uuid.UUID
+
+	token string
+}
+
+func TestSCIM(t *testing.T) {
+	api, config, globalConf, instanceID, err := setupAPIForTestForInstance()
+	require.NoError(t, err)
+
+	ts := &SCIMTestSuite{
+		API:        api,
+		Config:     config,
+		Encrypter:  &crypto.AESBlockEncrypter{Key: globalConf.DB.EncryptionKey},
+		instanceID: instanceID,
+	}
+
+	suite.Run(t, ts)
+}
+
+func (ts *SCIMTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	_, token, err := models.NewSCIMToken(context.TODO(), ts.API.db, ts.instanceID, "Okta")
+	ts.Require().NoError(err)
+	ts.token = token
+}
+
+func (ts *SCIMTestSuite) scimRequest(method string, path string, body interface{}) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	if body != nil {
+		ts.Require().NoError(json.NewEncoder(&buffer).Encode(body))
+	}
+	req := httptest.NewRequest(method, "http://localhost/scim/v2"+path, &buffer)
+	req.Header.Set("Content-Type", scimContentType)
+	req.Header.Set("Authorization", "Bearer "+ts.token)
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *SCIMTestSuite) createUser(userName string) *scimUser {
+	w := ts.scimRequest(http.MethodPost, "/Users", map[string]interface{}{
+		"schemas":    []string{scimUserSchema},
+		"userName":   userName,
+		"externalId": "ext-" + userName,
+		"name":       map[string]interface{}{"givenName": "Jane", "familyName": "Doe"},
+		"active":     true,
+	})
+	ts.Require().Equal(http.StatusCreated, w.Code, w.Body.String())
+	ts.Equal(scimContentType, w.Header().Get("Content-Type"))
+	user := &scimUser{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(user))
+	return user
+}
+
+func (ts *SCIMTestSuite) list(path string) *scimListResponse {
+	w := ts.scimRequest(http.MethodGet, path, nil)
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	list := &scimListResponse{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(list))
+	return list
+}
+
+func (ts *SCIMTestSuite) auditActions() []string {
+	entries, err := models.FindAuditLogEntries(context.TODO(), ts.API.db, ts.instanceID, nil, "", nil)
+	ts.Require().NoError(err)
+	actions := []string{}
+	for _, e := range entries {
+		actions = append(actions, fmt.Sprint(e.Payload["action"]))
+	}
+	return actions
+}
+
+func (ts *SCIMTestSuite) TestSCIMUnauthorized() {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/scim/v2/Users", nil)
+	req.Header.Set("Authorization", "Bearer invalid")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Require().Equal(http.StatusUnauthorized, w.Code)
+
+	e := &SCIMError{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(e))
+	ts.Equal([]string{scimErrorSchema}, e.Schemas)
+	ts.Equal("401", e.Status)
+}
+
+func (ts *SCIMTestSuite) TestSCIMDiscovery() {
+	w := ts.scimRequest(http.MethodGet, "/ServiceProviderConfig", nil)
+	ts.Require().Equal(http.StatusOK, w.Code)
+	ts.Contains(w.Body.String(), scimProviderSchema)
+
+	ts.Equal(2, ts.list("/Schemas").TotalResults)
+	ts.Equal(2, ts.list("/ResourceTypes").TotalResults)
+
+	w = ts.scimRequest(http.MethodGet, "/Schemas/"+scimGroupSchema, nil)
+	ts.Equal(http.StatusOK, w.Code)
+}
+
+func (ts *SCIMTestSuite) TestSCIMUsers() {
+	created := ts.createUser("jane@example.com")
+	ts.Equal("jane@example.com", created.UserName)
+	ts.Equal("ext-jane@example.com", created.ExternalID)
+	ts.Equal("Jane Doe", created.DisplayName)
+
+	user, err := models.FindUserByInstanceIDAndEmail(context.TODO(), ts.API.db, ts.instanceID, "jane@example.com")
+	ts.Require().NoError(err)
+	ts.True(user.IsConfirmed())
+	ts.Equal(scimProvider, user.AppMetaData.Provider)
+
+	w := ts.scimRequest(http.MethodPost, "/Users", map[string]interface{}{"userName": "jane@example.com"})
+	ts.Require().Equal(http.StatusConflict, w.Code)
+	ts.Contains(w.Body.String(), "uniqueness")
+
+	ts.createUser("john@example.com")
+	list := ts.list("/Users?filter=" + url.QueryEscape(`userName eq "JANE@example.com"`))
+	ts.Equal(1, list.TotalResults)
+	ts.Equal(2, ts.list("/Users").TotalResults)
+	list = ts.list("/Users?startIndex=2&count=1")
+	ts.Equal(2, list.TotalResults)
+	ts.Equal(1, list.ItemsPerPage)
+
+	w = ts.scimRequest(http.MethodGet, "/Users?filter="+url.QueryEscape(`userName eq`), nil)
+	ts.Equal(http.StatusBadRequest, w.Code)
+	ts.Contains(w.Body.String(), "invalidFilter")
+
+	w = ts.scimRequest(http.MethodPut, "/Users/"+created.ID, map[string]interface{}{
+		"userName":    "jane.doe@example.com",
+		"displayName": "Jane D.",
+		"active":      true,
+	})
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	replaced := &scimUser{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(replaced))
+	ts.Equal("jane.doe@example.com", replaced.UserName)
+	ts.Equal("Jane D.", replaced.DisplayName)
+	ts.Nil(replaced.Name)
+
+	w = ts.scimRequest(http.MethodDelete, "/Users/"+created.ID, nil)
+	ts.Require().Equal(http.StatusNoContent, w.Code)
+	w = ts.scimRequest(http.MethodGet, "/Users/"+created.ID, nil)
+	ts.Equal(http.StatusNotFound, w.Code)
+
+	ts.Subset(ts.auditActions(), []string{
+		string(models.SCIMUserCreatedAction),
+		string(models.SCIMUserModifiedAction),
+		string(models.SCIMUserDeletedAction),
+	})
+}
+
+func (ts *SCIMTestSuite) TestSCIMDeactivation() {
+	u, err := models.NewUser(ts.instanceID, "local@example.com", "password", ts.Config.JWT.Aud, nil, ts.Encrypter)
+	ts.Require().NoError(err)
+	_, err = tigris.GetCollection[models.User](ts.API.db).Insert(context.TODO(), u)
+	ts.Require().NoError(err)
+	ts.Require().NoError(u.Confirm(context.TODO(), ts.API.db))
+
+	signIn := func() *httptest.ResponseRecorder {
+		form := url.Values{"grant_type": {"password"}, "username": {"local@example.com"}, "password": {"password"}}
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+	w := signIn()
+	ts.Require().Equal(http.StatusOK, w.Code)
+	token := &AccessTokenResponse{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(token))
+
+	// Entra ID sends booleans as strings
+	w = ts.scimRequest(http.MethodPatch, "/Users/"+u.ID.String(), map[string]interface{}{
+		"schemas":    []string{scimPatchSchema},
+		"Operations": []map[string]interface{}{{"op": "Replace", "path": "active", "value": "False"}},
+	})
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	patched := &scimUser{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(patched))
+	ts.False(bool(*patched.Active))
+
+	ts.Equal(http.StatusBadRequest, signIn().Code)
+
+	// sessions of the user are revoked
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {token.RefreshToken}}
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	ts.Equal(http.StatusBadRequest, w.Code)
+
+	w = ts.scimRequest(http.MethodPatch, "/Users/"+u.ID.String(), map[string]interface{}{
+		"schemas":    []string{scimPatchSchema},
+		"Operations": []map[string]interface{}{{"op": "replace", "value": map[string]interface{}{"active": true}}},
+	})
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	ts.Equal(http.StatusOK, signIn().Code)
+}
+
+func (ts *SCIMTestSuite) TestSCIMAdminsNotManaged() {
+	superAdmin, err := models.NewUser(ts.instanceID, "super@example.com", "password", ts.Config.JWT.Aud, nil, ts.Encrypter)
+	ts.Require().NoError(err)
+	superAdmin.IsSuperAdmin = true
+	admin, err := models.NewUser(ts.instanceID, "admin@example.com", "password", ts.Config.JWT.Aud, nil, ts.Encrypter)
+	ts.Require().NoError(err)
+	admin.AppMetaData = &models.UserAppMetadata{Roles: []string{ts.Config.JWT.AdminGroupName}}
+	apiKey, err := models.NewUser(ts.instanceID, "key@example.com", "password", ts.Config.JWT.Aud, nil, ts.Encrypter)
+	ts.Require().NoError(err)
+	apiKey.AppMetaData = &models.UserAppMetadata{KeyType: models.ApiKeyKeyType}
+	for _, u := range []*models.User{superAdmin, admin, apiKey} {
+		_, err = tigris.GetCollection[models.User](ts.API.db).Insert(context.TODO(), u)
+		ts.Require().NoError(err)
+	}
+	managed := ts.createUser("jane@example.com")
+
+	list := ts.list("/Users")
+	ts.Require().Len(list.Resources, 1)
+
+	for _, u := range []*models.User{superAdmin, admin, apiKey} {
+		path := "/Users/" + u.ID.String()
+		ts.Equal(http.StatusNotFound, ts.scimRequest(http.MethodGet, path, nil).Code, u.Email)
+		ts.Equal(http.StatusNotFound, ts.scimRequest(http.MethodPatch, path, map[string]interface{}{
+			"schemas":    []string{scimPatchSchema},
+			"Operations": []map[string]interface{}{{"op": "replace", "path": "active", "value": false}},
+		}).Code, u.Email)
+		ts.Equal(http.StatusNotFound, ts.scimRequest(http.MethodPut, path, map[string]interface{}{
+			"schemas":  []string{scimUserSchema},
+			"userName": u.Email,
+			"active":   false,
+		}).Code, u.Email)
+		ts.Equal(http.StatusNotFound, ts.scimRequest(http.MethodDelete, path, nil).Code, u.Email)
+
+		found, err := models.FindUserByInstanceIDAndID(context.TODO(), ts.API.db, ts.instanceID, u.ID)
+		ts.Require().NoError(err)
+		ts.False(found.IsDeactivated())
+	}
+
+	// groups cannot take admins in either
+	w := ts.scimRequest(http.MethodPost, "/Groups", map[string]interface{}{
+		"schemas":     []string{scimGroupSchema},
+		"displayName": "Engineering",
+		"members":     []map[string]interface{}{{"value": managed.ID}, {"value": admin.ID.String()}},
+	})
+	ts.Equal(http.StatusBadRequest, w.Code, w.Body.String())
+}
+
+func (ts *SCIMTestSuite) roles(id string) []string {
+	user, err := models.FindUserByInstanceIDAndID(context.TODO(), ts.API.db, ts.instanceID, uuid.MustParse(id))
+	ts.Require().NoError(err)
+	if user.AppMetaData == nil {
+		return nil
+	}
+	return user.AppMetaData.Roles
+}
+
+func (ts *SCIMTestSuite) TestSCIMGroups() {
+	jane := ts.createUser("jane@example.com")
+	john := ts.createUser("john@example.com")
+
+	w := ts.scimRequest(http.MethodPost, "/Groups", map[string]interface{}{
+		"schemas":     []string{scimGroupSchema},
+		"displayName": "admins",
+		"members":     []map[string]interface{}{{"value": jane.ID}},
+	})
+	ts.Require().Equal(http.StatusCreated, w.Code, w.Body.String())
+	group := &scimGroup{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(group))
+	ts.Require().Len(group.Members, 1)
+	ts.Contains(ts.roles(jane.ID), "admins")
+
+	w = ts.scimRequest(http.MethodPost, "/Groups", map[string]interface{}{"displayName": "admins"})
+	ts.Equal(http.StatusConflict, w.Code)
+	w = ts.scimRequest(http.MethodPost, "/Groups", map[string]interface{}{
+		"displayName": "billing",
+		"members":     []map[string]interface{}{{"value": uuid.NewString()}},
+	})
+	ts.Equal(http.StatusBadRequest, w.Code)
+
+	w = ts.scimRequest(http.MethodPatch, "/Groups/"+group.ID, map[string]interface{}{
+		"schemas": []string{scimPatchSchema},
+		"Operations": []map[string]interface{}{
+			{"op": "add", "path": "members", "value": []map[string]interface{}{{"value": john.ID}}},
+			{"op": "remove", "path": fmt.Sprintf("members[value eq %q]", jane.ID)},
+			{"op": "replace", "path": "displayName", "value": "owners"},
+		},
+	})
+	ts.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	ts.NotContains(ts.roles(jane.ID), "admins")
+	ts.NotContains(ts.roles(jane.ID), "owners")
+	ts.Equal([]string{"owners"}, ts.roles(john.ID))
+
+	user := &scimUser{}
+	w = ts.scimRequest(http.MethodGet, "/Users/"+john.ID, nil)
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(user))
+	ts.Require().Len(user.Groups, 1)
+	ts.Equal(group.ID, user.Groups[0].Value)
+
+	list := ts.list("/Groups?filter=" + url.QueryEscape(fmt.Sprintf(`members[value eq %q]`, john.ID)))
+	ts.Equal(1, list.TotalResults)
+
+	w = ts.scimRequest(http.MethodDelete, "/Groups/"+group.ID, nil)
+	ts.Require().Equal(http.StatusNoContent, w.Code)
+	ts.Empty(ts.roles(john.ID))
+
+	ts.Subset(ts.auditActions(), []string{
+		string(models.SCIMGroupCreatedAction),
+		string(models.SCIMGroupModifiedAction),
+		string(models.SCIMGroupDeletedAction),
+	})
+}
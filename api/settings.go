@@ -8,24 +8,49 @@ type ProviderSettings struct {
 	GitLab    bool `json:"gitlab"`
 	Google    bool `json:"google"`
 	Facebook  bool `json:"facebook"`
+	Microsoft bool `json:"microsoft"`
+	Apple     bool `json:"apple"`
+	Slack     bool `json:"slack"`
 	Email     bool `json:"email"`
 	SAML      bool `json:"saml"`
+	// OIDC lists the names of the enabled OpenID Connect providers
+	OIDC []string `json:"oidc,omitempty"`
 }
 
 type ProviderLabels struct {
 	SAML string `json:"saml,omitempty"`
 }
 
+type CaptchaSettings struct {
+	Enabled  bool   `json:"enabled"`
+	Provider string `json:"provider,omitempty"`
+	SiteKey  string `json:"site_key,omitempty"`
+}
+
 type Settings struct {
 	ExternalProviders ProviderSettings `json:"external"`
 	ExternalLabels    ProviderLabels   `json:"external_labels"`
 	DisableSignup     bool             `json:"disable_signup"`
 	Autoconfirm       bool             `json:"autoconfirm"`
+	Captcha           CaptchaSettings  `json:"captcha"`
 }
 
 func (a *API) Settings(w http.ResponseWriter, r *http.Request) error {
 	config := a.getConfig(r.Context())
 
+	captcha := CaptchaSettings{Enabled: config.Captcha.Enabled}
+	if captcha.Enabled {
+		captcha.Provider = config.Captcha.Provider
+		captcha.SiteKey = config.Captcha.SiteKey
+	}
+
+	var oidc []string
+	for _, p := range config.External.OIDC {
+		if p.Enabled {
+			oidc = append(oidc, p.Name)
+		}
+	}
+
 	return sendJSON(w, http.StatusOK, &Settings{
 		ExternalProviders: ProviderSettings{
 			Bitbucket: config.External.Bitbucket.Enabled,
@@ -33,13 +58,18 @@ func (a *API) Settings(w http.ResponseWriter, r *http.Request) error {
 			GitLab:    config.External.Gitlab.Enabled,
 			Google:    config.External.Google.Enabled,
 			Facebook:  config.External.Facebook.Enabled,
+			Microsoft: config.External.Microsoft.Enabled,
+			Apple:     config.External.Apple.Enabled,
+			Slack:     config.External.Slack.Enabled,
 			Email:     !config.External.Email.Disabled,
 			SAML:      config.External.Saml.Enabled,
+			OIDC:      oidc,
 		},
 		ExternalLabels: ProviderLabels{
 			SAML: config.External.Saml.Name,
 		},
 		DisableSignup: config.DisableSignup,
 		Autoconfirm:   config.Mailer.Autoconfirm,
+		Captcha:       captcha,
 	})
 }
@@ -12,13 +12,14 @@ import (
 
 // SignupParams are the parameters the Signup endpoint accepts
 type SignupParams struct {
-	Email    string                 `json:"email"`
-	Password string                 `json:"password"`
-	Role     string                 `json:"role"`
-	Data     map[string]interface{} `json:"data"`
-	AppData  models.UserAppMetadata `json:"app_data"`
-	Provider string                 `json:"-"`
-	Aud      string                 `json:"-"`
+	Email      string                 `json:"email"`
+	Password   string                 `json:"password"`
+	Role       string                 `json:"role"`
+	Data       map[string]interface{} `json:"data"`
+	AppData    models.UserAppMetadata `json:"app_data"`
+	RedirectTo string                 `json:"redirect_to"`
+	Provider   string                 `json:"-"`
+	Aud        string                 `json:"-"`
 }
 
 // Signup is the endpoint for registering a new user
@@ -43,6 +44,13 @@ func (a *API) Signup(w http.ResponseWriter, r *http.Request) error {
 	if err := a.validateEmail(ctx, params.Email); err != nil {
 		return err
 	}
+	if err := a.checkPassword(ctx, params.Email, params.Password); err != nil {
+		return err
+	}
+	referrer, err := a.redirectTo(r, params.RedirectTo)
+	if err != nil {
+		return err
+	}
 
 	instanceID := getInstanceID(ctx)
 	params.Aud = a.requestAud(ctx, r)
@@ -51,6 +59,7 @@ func (a *API) Signup(w http.ResponseWriter, r *http.Request) error {
 		return internalServerError("Database error finding user").WithInternalError(err)
 	}
 
+	created := user == nil
 	err = a.db.Tx(ctx, func(ctx context.Context) error {
 		var terr error
 		if user != nil {
@@ -81,7 +90,6 @@ func (a *API) Signup(w http.ResponseWriter, r *http.Request) error {
 			}
 		} else {
 			mailer := a.Mailer(ctx)
-			referrer := a.getReferrer(r)
 			if terr = sendConfirmation(ctx, a.db, user, mailer, config.SMTP.MaxFrequency, referrer); terr != nil {
 				return internalServerError("Error sending confirmation mail").WithInternalError(terr)
 			}
@@ -92,6 +100,9 @@ func (a *API) Signup(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	if created {
+		a.notifyAPIKeyCreated(ctx, user)
+	}
 
 	user.EncryptedPassword = a.encrypter.Decrypt(user.EncryptedPassword, user.EncryptionIV)
 	return sendJSON(w, http.StatusOK, user)
@@ -125,6 +136,11 @@ func (a *API) signupNewUser(ctx context.Context, params *SignupParams) (*models.
 	if terr != nil {
 		return nil, internalServerError("Database error saving new user").WithInternalError(terr)
 	}
+	if params.Password != "" {
+		if terr := a.recordPassword(ctx, user, params.Password); terr != nil {
+			return nil, internalServerError("Error recording password").WithInternalError(terr)
+		}
+	}
 
 	if terr := user.SetRole(ctx, a.db, config.JWT.DefaultGroupName); terr != nil {
 		return nil, internalServerError("Database error updating user").WithInternalError(terr)
@@ -259,3 +259,64 @@ func (ts *SignupTestSuite) TestVerifySignup() {
 
 	assert.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
 }
+
+// TestSignupPasswordPolicy tests that passwords not meeting the policy are refused
+func (ts *SignupTestSuite) TestSignupPasswordPolicy() {
+	ts.Config.Password.MinLength = 8
+	ts.Config.Password.RequiredCharacters = []string{"digit"}
+	defer func() {
+		ts.Config.Password.MinLength = 0
+		ts.Config.Password.RequiredCharacters = nil
+	}()
+
+	var buffer bytes.Buffer
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+		"email":    "test@example.com",
+		"password": "test",
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/signup", &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code)
+
+	data := struct {
+		Details struct {
+			Password []PasswordRequirement `json:"password"`
+		} `json:"details"`
+	}{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	require.Len(ts.T(), data.Details.Password, 2)
+	assert.Equal(ts.T(), "min_length", data.Details.Password[0].Code)
+	assert.Equal(ts.T(), "characters_digit", data.Details.Password[1].Code)
+
+	_, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "test@example.com", ts.Config.JWT.Aud)
+	assert.True(ts.T(), models.IsNotFoundError(err))
+}
+
+func (ts *SignupTestSuite) TestSignupRedirectTo() {
+	ts.Config.RedirectURLs = []string{"https://app.example.org/**"}
+	defer func() { ts.Config.RedirectURLs = nil }()
+
+	signup := func(email, redirectTo string) *httptest.ResponseRecorder {
+		var buffer bytes.Buffer
+		require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
+			"email":       email,
+			"password":    "test",
+			"redirect_to": redirectTo,
+		}))
+		req := httptest.NewRequest(http.MethodPost, "/signup", &buffer)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := signup("test@example.com", "https://evil.example.net/welcome")
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	_, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "test@example.com", ts.Config.JWT.Aud)
+	assert.True(ts.T(), models.IsNotFoundError(err))
+
+	w = signup("test@example.com", "https://app.example.org/welcome")
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+}
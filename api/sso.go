@@ -0,0 +1,244 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/tigrisdata/gotrue/api/provider"
+	"github.com/tigrisdata/gotrue/conf"
+	"github.com/tigrisdata/gotrue/models"
+)
+
+// SSOProviderParams are the parameters the SSO provider admin endpoints accept
+type SSOProviderParams struct {
+	Name        string   `json:"name"`
+	MetadataURL string   `json:"metadata_url"`
+	Domains     []string `json:"domains"`
+
+	// MetadataXML and MetadataSigningCert are cleared with empty strings
+	MetadataXML         *string `json:"metadata_xml"`
+	MetadataSigningCert *string `json:"metadata_signing_cert"`
+
+	AttributeMapping  *conf.SamlAttributeMapping `json:"attribute_mapping"`
+	AllowIdPInitiated *bool                      `json:"allow_idp_initiated"`
+}
+
+func (a *API) loadSSOProvider(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	ctx := r.Context()
+	ssoProviderID, err := uuid.Parse(chi.URLParam(r, "provider_id"))
+	if err != nil {
+		return nil, badRequestError("Invalid SSO provider ID")
+	}
+	logEntrySetField(r, "sso_provider_id", ssoProviderID)
+
+	ssoProvider, err := models.FindSSOProviderByID(ctx, a.db, getInstanceID(ctx), ssoProviderID)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, notFoundError("SSO provider not found")
+		}
+		return nil, internalServerError("Database error loading SSO provider").WithInternalError(err)
+	}
+
+	return withSSOProvider(ctx, ssoProvider), nil
+}
+
+func (a *API) getSSOProviderParams(r *http.Request) (*SSOProviderParams, error) {
+	params := &SSOProviderParams{}
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		return nil, badRequestError("Could not decode SSO provider params: %v", err)
+	}
+	return params, nil
+}
+
+// validateSSOProvider checks the pinned metadata or the metadata URL, and that
+// each domain is bound to the provider only
+func (a *API) validateSSOProvider(ctx context.Context, ssoProvider *models.SSOProvider) error {
+	if ssoProvider.MetadataXML != "" {
+		if _, err := provider.ParseSamlMetadata([]byte(ssoProvider.MetadataXML), ssoProvider.MetadataSigningCert); err != nil {
+			return unprocessableEntityError("Invalid SAML metadata: %v", err)
+		}
+	} else {
+		u, err := url.Parse(ssoProvider.MetadataURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return unprocessableEntityError("SSO providers require metadata XML or a valid metadata URL")
+		}
+	}
+
+	domains := models.NormalizeDomains(ssoProvider.Domains)
+	if len(domains) == 0 {
+		return unprocessableEntityError("SSO providers require at least one domain")
+	}
+	for _, d := range domains {
+		if strings.ContainsAny(d, "@/ ") {
+			return unprocessableEntityError("Invalid domain %s", d)
+		}
+	}
+
+	providers, err := models.FindSSOProvidersByInstance(ctx, a.db, getInstanceID(ctx))
+	if err != nil {
+		return internalServerError("Database error finding SSO providers").WithInternalError(err)
+	}
+	for _, p := range providers {
+		if p.ID == ssoProvider.ID {
+			continue
+		}
+		for _, d := range domains {
+			if p.HasDomain(d) {
+				return unprocessableEntityError("Domain %s is already bound to SSO provider %s", d, p.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// adminSSOProviders lists the SAML identity providers of the instance
+func (a *API) adminSSOProviders(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	providers, err := models.FindSSOProvidersByInstance(ctx, a.db, getInstanceID(ctx))
+	if err != nil {
+		return internalServerError("Database error finding SSO providers").WithInternalError(err)
+	}
+	return sendJSON(w, http.StatusOK, map[string]interface{}{
+		"providers": providers,
+	})
+}
+
+// adminSSOProviderCreate adds a SAML identity provider bound to email domains
+func (a *API) adminSSOProviderCreate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	instanceID := getInstanceID(ctx)
+	adminUser := getAdminUser(ctx)
+
+	params, err := a.getSSOProviderParams(r)
+	if err != nil {
+		return err
+	}
+	settings := models.SSOProvider{
+		Name:        params.Name,
+		MetadataURL: params.MetadataURL,
+		Domains:     params.Domains,
+	}
+	if params.MetadataXML != nil {
+		settings.MetadataXML = *params.MetadataXML
+	}
+	if params.MetadataSigningCert != nil {
+		settings.MetadataSigningCert = *params.MetadataSigningCert
+	}
+	if params.AttributeMapping != nil {
+		settings.AttributeMapping = *params.AttributeMapping
+	}
+	if params.AllowIdPInitiated != nil {
+		settings.AllowIdPInitiated = *params.AllowIdPInitiated
+	}
+	if err := a.validateSSOProvider(ctx, &settings); err != nil {
+		return err
+	}
+
+	var ssoProvider *models.SSOProvider
+	err = a.db.Tx(ctx, func(ctx context.Context) error {
+		var terr error
+		ssoProvider, terr = models.NewSSOProvider(ctx, a.db, instanceID, settings)
+		if terr != nil {
+			return terr
+		}
+		return models.NewAuditLogEntry(ctx, a.db, instanceID, adminUser, models.SSOProviderCreatedAction, map[string]interface{}{
+			"sso_provider_id": ssoProvider.ID,
+			"domains":         ssoProvider.Domains,
+		})
+	})
+	if err != nil {
+		return internalServerError("Database error creating SSO provider").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusCreated, ssoProvider)
+}
+
+// adminSSOProviderGet returns a SAML identity provider
+func (a *API) adminSSOProviderGet(w http.ResponseWriter, r *http.Request) error {
+	return sendJSON(w, http.StatusOK, getSSOProvider(r.Context()))
+}
+
+// adminSSOProviderUpdate changes a SAML identity provider. Omitted parameters
+// keep their values.
+func (a *API) adminSSOProviderUpdate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	instanceID := getInstanceID(ctx)
+	adminUser := getAdminUser(ctx)
+	ssoProvider := getSSOProvider(ctx)
+
+	params, err := a.getSSOProviderParams(r)
+	if err != nil {
+		return err
+	}
+	if params.Name != "" {
+		ssoProvider.Name = params.Name
+	}
+	if params.MetadataURL != "" {
+		ssoProvider.MetadataURL = params.MetadataURL
+	}
+	if params.Domains != nil {
+		ssoProvider.Domains = params.Domains
+	}
+	if params.MetadataXML != nil {
+		ssoProvider.MetadataXML = *params.MetadataXML
+	}
+	if params.MetadataSigningCert != nil {
+		ssoProvider.MetadataSigningCert = *params.MetadataSigningCert
+	}
+	if params.AttributeMapping != nil {
+		ssoProvider.AttributeMapping = *params.AttributeMapping
+	}
+	if params.AllowIdPInitiated != nil {
+		ssoProvider.AllowIdPInitiated = *params.AllowIdPInitiated
+	}
+	if err := a.validateSSOProvider(ctx, ssoProvider); err != nil {
+		return err
+	}
+
+	err = a.db.Tx(ctx, func(ctx context.Context) error {
+		if terr := ssoProvider.Update(ctx, a.db); terr != nil {
+			return terr
+		}
+		return models.NewAuditLogEntry(ctx, a.db, instanceID, adminUser, models.SSOProviderModifiedAction, map[string]interface{}{
+			"sso_provider_id": ssoProvider.ID,
+			"domains":         ssoProvider.Domains,
+		})
+	})
+	if err != nil {
+		return internalServerError("Database error updating SSO provider").WithInternalError(err)
+	}
+	provider.ForgetSSOSamlMetadata(ssoProvider.ID)
+
+	return sendJSON(w, http.StatusOK, ssoProvider)
+}
+
+// adminSSOProviderDelete removes a SAML identity provider
+func (a *API) adminSSOProviderDelete(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	instanceID := getInstanceID(ctx)
+	adminUser := getAdminUser(ctx)
+	ssoProvider := getSSOProvider(ctx)
+
+	err := a.db.Tx(ctx, func(ctx context.Context) error {
+		if terr := models.NewAuditLogEntry(ctx, a.db, instanceID, adminUser, models.SSOProviderDeletedAction, map[string]interface{}{
+			"sso_provider_id": ssoProvider.ID,
+			"domains":         ssoProvider.Domains,
+		}); terr != nil {
+			return terr
+		}
+		return ssoProvider.Delete(ctx, a.db)
+	})
+	if err != nil {
+		return internalServerError("Database error deleting SSO provider").WithInternalError(err)
+	}
+	provider.ForgetSSOSamlMetadata(ssoProvider.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
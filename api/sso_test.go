@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/tigrisdata/gotrue/models"
+)
+
+func (ts *AdminTestSuite) ssoProviderRequest(method string, path string, body interface{}) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	if body != nil {
+		ts.Require().NoError(json.NewEncoder(&buffer).Encode(body))
+	}
+	req := httptest.NewRequest(method, "http://localhost/admin/sso/providers"+path, &buffer)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ts.token))
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *AdminTestSuite) TestAdminSSOProviders() {
+	w := ts.ssoProviderRequest(http.MethodPost, "", &SSOProviderParams{
+		Name:        "Corp",
+		MetadataURL: "https://idp.corp.com/metadata",
+		Domains:     []string{"Corp.com", " corp.io", "corp.com"},
+	})
+	ts.Require().Equal(http.StatusCreated, w.Code)
+	created := &models.SSOProvider{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(created))
+	ts.Equal("Corp", created.Name)
+	ts.Equal([]string{"corp.com", "corp.io"}, created.Domains)
+
+	w = ts.ssoProviderRequest(http.MethodGet, "/"+created.ID.String(), nil)
+	ts.Require().Equal(http.StatusOK, w.Code)
+
+	w = ts.ssoProviderRequest(http.MethodPut, "/"+created.ID.String(), &SSOProviderParams{Domains: []string{"corp.com"}})
+	ts.Require().Equal(http.StatusOK, w.Code)
+	updated := &models.SSOProvider{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(updated))
+	ts.Equal("https://idp.corp.com/metadata", updated.MetadataURL)
+	ts.Equal([]string{"corp.com"}, updated.Domains)
+
+	w = ts.ssoProviderRequest(http.MethodGet, "", nil)
+	ts.Require().Equal(http.StatusOK, w.Code)
+	list := struct {
+		Providers []*models.SSOProvider `json:"providers"`
+	}{}
+	ts.Require().NoError(json.NewDecoder(w.Body).Decode(&list))
+	ts.Require().Len(list.Providers, 1)
+
+	w = ts.ssoProviderRequest(http.MethodDelete, "/"+created.ID.String(), nil)
+	ts.Require().Equal(http.StatusNoContent, w.Code)
+	w = ts.ssoProviderRequest(http.MethodGet, "/"+created.ID.String(), nil)
+	ts.Equal(http.StatusNotFound, w.Code)
+}
+
+func (ts *AdminTestSuite) TestAdminSSOProviderValidation() {
+	invalidXML := "<md:EntityDescriptor"
+	expiredXML := `<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://idp/other" validUntil="2020-01-01T00:00:00Z">` +
+		`<md:IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol"/>` +
+		`</md:EntityDescriptor>`
+
+	w := ts.ssoProviderRequest(http.MethodPost, "", &SSOProviderParams{
+		MetadataURL: "https://idp.corp.com/metadata",
+		Domains:     []string{"corp.com"},
+	})
+	ts.Require().Equal(http.StatusCreated, w.Code)
+
+	cases := map[string]*SSOProviderParams{
+		"missing metadata URL": {Domains: []string{"other.com"}},
+		"invalid metadata URL": {MetadataURL: "idp/metadata", Domains: []string{"other.com"}},
+		"missing domains":      {MetadataURL: "https://idp.other.com/metadata"},
+		"invalid domain":       {MetadataURL: "https://idp.other.com/metadata", Domains: []string{"alice@other.com"}},
+		"invalid metadata XML": {MetadataXML: &invalidXML, Domains: []string{"other.com"}},
+		"expired metadata XML": {MetadataXML: &expiredXML, Domains: []string{"other.com"}},
+		// a domain signs in with a single identity provider
+		"domain already bound": {MetadataURL: "https://idp.other.com/metadata", Domains: []string{"other.com", "CORP.com"}},
+	}
+	for name, params := range cases {
+		w := ts.ssoProviderRequest(http.MethodPost, "", params)
+		ts.Equal(http.StatusUnprocessableEntity, w.Code, name)
+	}
+}
@@ -10,9 +10,11 @@ import (
 	"time"
 
 	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/rs/zerolog/log"
 	"github.com/tigrisdata/gotrue/conf"
+	"github.com/tigrisdata/gotrue/crypto"
 	"github.com/tigrisdata/gotrue/metering"
 	"github.com/tigrisdata/gotrue/models"
 )
@@ -20,15 +22,26 @@ import (
 // GoTrueClaims is a struct that used for JWT claims
 type GoTrueClaims struct {
 	jwt.StandardClaims
+	SessionID      string                 `json:"session_id,omitempty"`
+	Act            *ActorClaim            `json:"act,omitempty"`
 	TigrisMetadata map[string]interface{} `json:"https://tigris"`
 }
 
+// ActorClaim identifies the party acting on behalf of the subject of a token.
+// Nested actors record earlier delegations.
+type ActorClaim struct {
+	Subject string      `json:"sub"`
+	Act     *ActorClaim `json:"act,omitempty"`
+}
+
 // AccessTokenResponse represents an OAuth2 success response
 type AccessTokenResponse struct {
 	Token        string `json:"access_token"`
 	TokenType    string `json:"token_type"` // Bearer
 	ExpiresIn    int    `json:"expires_in"`
-	RefreshToken string `json:"refresh_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// IssuedTokenType is only set for token exchange responses
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
 }
 
 const useCookieHeader = "x-use-cookie"
@@ -44,6 +57,10 @@ func (a *API) Token(w http.ResponseWriter, r *http.Request) error {
 		return a.ResourceOwnerPasswordGrant(ctx, w, r)
 	case "refresh_token":
 		return a.RefreshTokenGrant(ctx, w, r)
+	case tokenExchangeGrantType:
+		return a.TokenExchangeGrant(ctx, w, r)
+	case deviceCodeGrantType:
+		return a.DeviceCodeGrant(ctx, w, r)
 	default:
 		return oauthError("unsupported_grant_type", "")
 	}
@@ -59,24 +76,35 @@ func (a *API) ResourceOwnerPasswordGrant(ctx context.Context, w http.ResponseWri
 	instanceID := getInstanceID(ctx)
 	config := a.getConfig(ctx)
 
-	user, err := models.FindUserByEmailAndAudience(r.Context(), a.db, instanceID, username, aud)
+	if config.Lockout.Enabled {
+		if err := a.checkLockout(ctx, w, r, username); err != nil {
+			return err
+		}
+	}
+
+	user, fromDirectory, err := a.passwordUser(ctx, username, password, aud)
 	if err != nil {
-		if models.IsNotFoundError(err) {
-			log.Warn().Str("email", username).Msg("No user found with that email, or password invalid.")
-			return oauthError("invalid_grant", "No user found with that email, or password invalid.")
+		if config.Lockout.Enabled && isInvalidCredentials(err) {
+			a.recordLoginFailure(ctx, r, username, aud)
 		}
-		return internalServerError("Database error finding user").WithInternalError(err)
+		return err
+	}
+	if config.Lockout.Enabled {
+		a.clearLoginFailure(ctx, username)
 	}
 
-	if !user.IsConfirmed() {
-		return oauthError("invalid_grant", "Email not confirmed")
+	if reason := signInDenied(user); reason != "" {
+		log.Warn().Str("email", username).Msg(reason)
+		return oauthError("invalid_grant", reason)
 	}
 
-	if !user.Authenticate(password, a.encrypter) {
-		log.Warn().Str("email", username).Msg("No user found with that email, or password invalid: Auth failure")
-		return oauthError("invalid_grant", "No user found with that email, or password invalid.")
+	// the directory manages the passwords of its users
+	if !fromDirectory && user.PasswordExpired(config.Password.MaxAge) {
+		return a.passwordExpired(ctx, r, user)
 	}
 
+	a.checkNewDevice(ctx, r, user)
+
 	if a.config.API.EnableTokenCache && a.tokenCache.Contains(user.Email) {
 		cachedValue, contains := a.tokenCache.Get(user.Email)
 		if contains {
@@ -114,7 +142,7 @@ func (a *API) ResourceOwnerPasswordGrant(ctx context.Context, w http.ResponseWri
 		}
 
 		if cookie != "" && config.Cookie.Duration > 0 {
-			if terr = a.setCookieToken(config, token.Token, cookie == useSessionCookie, w); terr != nil {
+			if terr = a.setCookieToken(config, token.Token, token.RefreshToken, cookie == useSessionCookie, w); terr != nil {
 				return internalServerError("Failed to set JWT cookie. %s", terr)
 			}
 		}
@@ -129,6 +157,66 @@ func (a *API) ResourceOwnerPasswordGrant(ctx context.Context, w http.ResponseWri
 	return sendJSON(w, http.StatusOK, token)
 }
 
+// invalidCredentialsMessage is the error of password sign ins with an unknown
+// email or a wrong password, which are not told apart
+const invalidCredentialsMessage = "No user found with that email, or password invalid."
+
+func isInvalidCredentials(err error) bool {
+	e, ok := err.(*OAuthError)
+	return ok && e.Description == invalidCredentialsMessage
+}
+
+// passwordUser finds the user signing in with a password, in the directory
+// first if LDAP is enabled. It reports whether the directory checked the
+// password.
+func (a *API) passwordUser(ctx context.Context, username, password, aud string) (*models.User, bool, error) {
+	config := a.getConfig(ctx)
+	instanceID := getInstanceID(ctx)
+
+	var user *models.User
+	var err error
+	if config.LDAP.Enabled {
+		if user, err = a.ldapUser(ctx, username, password, aud); err != nil {
+			return nil, false, err
+		}
+		if user != nil {
+			return user, true, nil
+		}
+	}
+
+	user, err = models.FindUserByEmailAndAudience(ctx, a.db, instanceID, username, aud)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			log.Warn().Str("email", username).Msg(invalidCredentialsMessage)
+			return nil, false, oauthError("invalid_grant", invalidCredentialsMessage)
+		}
+		return nil, false, internalServerError("Database error finding user").WithInternalError(err)
+	}
+
+	if !user.IsConfirmed() {
+		return nil, false, oauthError("invalid_grant", "Email not confirmed")
+	}
+
+	if !user.Authenticate(password, a.encrypter) {
+		log.Warn().Str("email", username).Msg("No user found with that email, or password invalid: Auth failure")
+		return nil, false, oauthError("invalid_grant", invalidCredentialsMessage)
+	}
+
+	// users removed from the directory lose access
+	if config.LDAP.Enabled {
+		ldapUser, err := a.hasLDAPIdentity(ctx, user)
+		if err != nil {
+			return nil, false, err
+		}
+		if ldapUser {
+			log.Warn().Str("email", username).Msg("LDAP user is not in the directory anymore")
+			return nil, false, oauthError("invalid_grant", invalidCredentialsMessage)
+		}
+	}
+
+	return user, false, nil
+}
+
 // RefreshTokenGrant implements the refresh_token grant type flow
 func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	config := a.getConfig(ctx)
@@ -136,6 +224,18 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 	tokenStr := r.FormValue("refresh_token")
 	cookie := r.Header.Get(useCookieHeader)
 
+	// browsers keeping the session in cookies send the refresh token in one
+	fromCookie := false
+	if tokenStr == "" && config.Cookie.RefreshTokenKey != "" {
+		if c, err := r.Cookie(config.Cookie.RefreshTokenKey); err == nil && c.Value != "" {
+			if err := checkCSRFToken(config, r); err != nil {
+				return err
+			}
+			tokenStr = c.Value
+			fromCookie = true
+		}
+	}
+
 	if tokenStr == "" {
 		return oauthError("invalid_request", "refresh_token required")
 	}
@@ -150,9 +250,18 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 
 	if token.Revoked {
 		a.clearCookieToken(ctx, w)
+		// a revoked refresh token being reused means the session may be compromised
+		if terr := a.revokeSession(ctx, user, token.SessionID); terr != nil {
+			return internalServerError("Error revoking session").WithInternalError(terr)
+		}
 		return oauthError("invalid_grant", "Invalid Refresh Token").WithInternalMessage("Possible abuse attempt: %v", r)
 	}
 
+	if reason := signInDenied(user); reason != "" {
+		a.clearCookieToken(ctx, w)
+		return oauthError("invalid_grant", reason)
+	}
+
 	var tokenString string
 	var newToken *models.RefreshToken
 
@@ -167,13 +276,13 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 			return internalServerError(terr.Error())
 		}
 
-		tokenString, terr = generateAccessToken(user, time.Second*time.Duration(config.JWT.Exp), a.getConfig(ctx), a.tokenSigner)
+		tokenString, terr = generateSessionAccessToken(user, newToken.SessionID, time.Second*time.Duration(config.JWT.Exp), a.getConfig(ctx), a.tokenSigner)
 		if terr != nil {
 			return internalServerError("error generating jwt token").WithInternalError(terr)
 		}
 
-		if cookie != "" && config.Cookie.Duration > 0 {
-			if terr = a.setCookieToken(config, tokenString, cookie == useSessionCookie, w); terr != nil {
+		if (cookie != "" || fromCookie) && config.Cookie.Duration > 0 {
+			if terr = a.setCookieToken(config, tokenString, newToken.Token, cookie == useSessionCookie, w); terr != nil {
 				return internalServerError("Failed to set JWT cookie. %s", terr)
 			}
 		}
@@ -191,7 +300,17 @@ func (a *API) RefreshTokenGrant(ctx context.Context, w http.ResponseWriter, r *h
 	})
 }
 
+// generateAccessToken issues an access token that is not bound to a session.
 func generateAccessToken(user *models.User, expiresIn time.Duration, config *conf.Configuration, tokenSigner *TokenSigner) (string, error) {
+	return signAccessToken(newAccessTokenClaims(user, uuid.Nil, expiresIn, config), config, tokenSigner)
+}
+
+// generateSessionAccessToken issues an access token for the session of a refresh token.
+func generateSessionAccessToken(user *models.User, sessionID uuid.UUID, expiresIn time.Duration, config *conf.Configuration, tokenSigner *TokenSigner) (string, error) {
+	return signAccessToken(newAccessTokenClaims(user, sessionID, expiresIn, config), config, tokenSigner)
+}
+
+func newAccessTokenClaims(user *models.User, sessionID uuid.UUID, expiresIn time.Duration, config *conf.Configuration) *GoTrueClaims {
 	var tigrisClaims = make(map[string]interface{})
 	// superadmin doesn't have app metadata
 	if user.AppMetaData != nil {
@@ -209,6 +328,7 @@ func generateAccessToken(user *models.User, expiresIn time.Duration, config *con
 	}
 	claims := &GoTrueClaims{
 		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewString(),
 			Subject:   "gt|" + user.ID.String(), // customize sub b
 			Audience:  user.Aud,
 			Issuer:    fmt.Sprintf("http://%s", config.SiteURL),
@@ -217,7 +337,13 @@ func generateAccessToken(user *models.User, expiresIn time.Duration, config *con
 		},
 		TigrisMetadata: tigrisClaims,
 	}
+	if sessionID != uuid.Nil {
+		claims.SessionID = sessionID.String()
+	}
+	return claims
+}
 
+func signAccessToken(claims *GoTrueClaims, config *conf.Configuration, tokenSigner *TokenSigner) (string, error) {
 	switch config.JWT.Algorithm {
 	case jwa.RS256.String():
 		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
@@ -228,9 +354,25 @@ func generateAccessToken(user *models.User, expiresIn time.Duration, config *con
 	}
 }
 
+// signInDenied explains why the user may not sign in or use their tokens, and
+// is empty if they may
+func signInDenied(user *models.User) string {
+	switch {
+	case user.IsBanned():
+		return "User is banned"
+	case user.IsDeactivated():
+		return "User is deactivated"
+	}
+	return ""
+}
+
 func (a *API) issueRefreshToken(ctx context.Context, user *models.User) (*AccessTokenResponse, error) {
 	config := a.getConfig(ctx)
 
+	if reason := signInDenied(user); reason != "" {
+		return nil, oauthError("invalid_grant", reason)
+	}
+
 	now := time.Now()
 	user.LastSignInAt = &now
 
@@ -247,7 +389,7 @@ func (a *API) issueRefreshToken(ctx context.Context, user *models.User) (*Access
 		config := a.getConfig(ctx)
 		tokenSigner := NewTokenSigner(config)
 
-		tokenString, terr = generateAccessToken(user, time.Second*time.Duration(config.JWT.Exp), config, tokenSigner)
+		tokenString, terr = generateSessionAccessToken(user, refreshToken.SessionID, time.Second*time.Duration(config.JWT.Exp), config, tokenSigner)
 		if terr != nil {
 			return internalServerError("error generating jwt token").WithInternalError(terr)
 		}
@@ -265,35 +407,66 @@ func (a *API) issueRefreshToken(ctx context.Context, user *models.User) (*Access
 	}, nil
 }
 
-func (a *API) setCookieToken(config *conf.Configuration, tokenString string, session bool, w http.ResponseWriter) error {
-	exp := time.Second * time.Duration(config.Cookie.Duration)
-	cookie := &http.Cookie{
-		Name:     config.Cookie.Key,
-		Value:    tokenString,
-		Secure:   true,
-		HttpOnly: true,
-		Path:     "/",
+// setCookieToken keeps the session in cookies: the access token, the refresh
+// token if the instance names a cookie for it, and the CSRF token clients echo
+// in requests authenticated by cookie.
+func (a *API) setCookieToken(config *conf.Configuration, tokenString, refreshToken string, session bool, w http.ResponseWriter) error {
+	cookies := []*http.Cookie{
+		newSessionCookie(config, config.Cookie.Key, tokenString, true),
+		newSessionCookie(config, config.Cookie.CSRFKey, crypto.SecureToken(), false),
 	}
-	if !session {
-		cookie.Expires = time.Now().Add(exp)
-		cookie.MaxAge = config.Cookie.Duration
+	if config.Cookie.RefreshTokenKey != "" && refreshToken != "" {
+		cookies = append(cookies, newSessionCookie(config, config.Cookie.RefreshTokenKey, refreshToken, true))
 	}
 
-	http.SetCookie(w, cookie)
+	exp := time.Second * time.Duration(config.Cookie.Duration)
+	for _, cookie := range cookies {
+		if !session {
+			cookie.Expires = time.Now().Add(exp)
+			cookie.MaxAge = config.Cookie.Duration
+		}
+		http.SetCookie(w, cookie)
+	}
 	return nil
 }
 
 func (a *API) clearCookieToken(ctx context.Context, w http.ResponseWriter) {
 	config := getConfig(ctx)
-	http.SetCookie(w, &http.Cookie{
-		Name:     config.Cookie.Key,
-		Value:    "",
-		Expires:  time.Now().Add(-1 * time.Hour * 10),
-		MaxAge:   -1,
+	keys := []string{config.Cookie.Key, config.Cookie.CSRFKey}
+	if config.Cookie.RefreshTokenKey != "" {
+		keys = append(keys, config.Cookie.RefreshTokenKey)
+	}
+	for _, key := range keys {
+		cookie := newSessionCookie(config, key, "", true)
+		cookie.Expires = time.Now().Add(-1 * time.Hour * 10)
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
+
+// newSessionCookie returns a cookie with the attributes of the instance.
+// Only the CSRF token is readable by scripts.
+func newSessionCookie(config *conf.Configuration, name, value string, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Domain:   config.Cookie.Domain,
 		Secure:   true,
-		HttpOnly: true,
+		HttpOnly: httpOnly,
 		Path:     "/",
-	})
+		SameSite: cookieSameSite(config.Cookie.SameSite),
+	}
+}
+
+func cookieSameSite(sameSite string) http.SameSite {
+	switch strings.ToLower(sameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
 }
 
 func getExpiry(tokenPayload string) int64 {
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tigrisdata/gotrue/models"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+	jwtTokenType           = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// TokenExchangeGrant implements the token exchange grant type flow (RFC 8693).
+// The subject token is exchanged for a token targeting the requested audience,
+// and when an actor token is presented the new token carries an act claim
+// naming the actor.
+func (a *API) TokenExchangeGrant(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	config := a.getConfig(ctx)
+	instanceID := getInstanceID(ctx)
+
+	if !config.TokenExchange.Enabled {
+		return oauthError("unsupported_grant_type", "Token exchange is disabled")
+	}
+
+	if t := r.FormValue("requested_token_type"); t != "" && t != accessTokenType {
+		return oauthError("invalid_request", "Unsupported requested_token_type")
+	}
+
+	subjectClaims, subject, err := a.exchangedTokenUser(ctx, r.FormValue("subject_token"), r.FormValue("subject_token_type"), "subject_token")
+	if err != nil {
+		return err
+	}
+
+	var actorClaims *GoTrueClaims
+	var actor *models.User
+	if r.FormValue("actor_token") != "" {
+		actorClaims, actor, err = a.exchangedTokenUser(ctx, r.FormValue("actor_token"), r.FormValue("actor_token_type"), "actor_token")
+		if err != nil {
+			return err
+		}
+	} else if r.FormValue("actor_token_type") != "" {
+		return oauthError("invalid_request", "actor_token_type given without actor_token")
+	}
+
+	aud := r.FormValue("audience")
+	if aud == "" {
+		aud = subjectClaims.Audience
+	}
+
+	actorEmail := ""
+	if actor != nil {
+		actorEmail = actor.Email
+	}
+	if !config.TokenExchange.Allows(subjectClaims.Audience, aud, actorEmail) {
+		return oauthError("invalid_target", "Token exchange is not allowed for this audience")
+	}
+
+	// the exchanged token never outlives the subject token
+	expiresIn := time.Second * time.Duration(config.TokenExchange.Exp)
+	if remaining := time.Until(time.Unix(subjectClaims.ExpiresAt, 0)); remaining < expiresIn {
+		expiresIn = remaining
+	}
+
+	// keep the subject's session so revoking it revokes exchanged tokens as well
+	sessionID, _ := uuid.Parse(subjectClaims.SessionID)
+	claims := newAccessTokenClaims(subject, sessionID, expiresIn, config)
+	claims.Audience = aud
+	claims.Act = subjectClaims.Act
+	if actor != nil {
+		claims.Act = &ActorClaim{Subject: actorClaims.Subject, Act: subjectClaims.Act}
+	}
+
+	tokenString, err := signAccessToken(claims, config, a.tokenSigner)
+	if err != nil {
+		return internalServerError("error generating jwt token").WithInternalError(err)
+	}
+
+	auditActor := subject
+	traits := map[string]interface{}{
+		"subject_id": subject.ID,
+		"audience":   aud,
+		"jti":        claims.Id,
+	}
+	if actor != nil {
+		auditActor = actor
+		traits["actor_id"] = actor.ID
+	}
+	if err := models.NewAuditLogEntry(ctx, a.db, instanceID, auditActor, models.TokenExchangedAction, traits); err != nil {
+		return internalServerError("Error recording audit log entry").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, &AccessTokenResponse{
+		Token:           tokenString,
+		TokenType:       "bearer",
+		ExpiresIn:       int(expiresIn.Seconds()),
+		IssuedTokenType: accessTokenType,
+	})
+}
+
+// exchangedTokenUser verifies a token presented to the token exchange grant and
+// loads the user it was issued to, who must still be allowed to sign in.
+func (a *API) exchangedTokenUser(ctx context.Context, token string, tokenType string, param string) (*GoTrueClaims, *models.User, error) {
+	if token == "" {
+		return nil, nil, oauthError("invalid_request", param+" required")
+	}
+	if tokenType != accessTokenType && tokenType != jwtTokenType {
+		return nil, nil, oauthError("invalid_request", "Unsupported "+param+"_type")
+	}
+
+	parsed, herr := a.verifyAccessToken(ctx, token)
+	if herr != nil {
+		if herr.Code == http.StatusUnauthorized {
+			return nil, nil, oauthError("invalid_grant", "Invalid "+param).WithInternalError(herr)
+		}
+		return nil, nil, herr
+	}
+	claims := parsed.Claims.(*GoTrueClaims)
+
+	userID := GetUserIdFromSubject(claims.Subject)
+	if userID == models.SystemUserID || userID == models.SystemUserUUID.String() {
+		return nil, nil, oauthError("invalid_grant", "System tokens cannot be exchanged")
+	}
+
+	user, err := findUserForClaims(ctx, a.db, claims)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, nil, oauthError("invalid_grant", "Invalid "+param).WithInternalError(err)
+		}
+		return nil, nil, internalServerError("Database error finding user").WithInternalError(err)
+	}
+	if reason := signInDenied(user); reason != "" {
+		return nil, nil, oauthError("invalid_grant", reason)
+	}
+	return claims, user, nil
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/tigrisdata/gotrue/conf"
+	"github.com/tigrisdata/gotrue/crypto"
+	"github.com/tigrisdata/gotrue/models"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+type TokenExchangeTestSuite struct {
+	suite.Suite
+	API        *API
+	Config     *conf.Configuration
+	Encrypter  *crypto.AESBlockEncrypter
+	instanceID uuid.UUID
+}
+
+func TestTokenExchange(t *testing.T) {
+	api, config, globalConf, instanceID, err := setupAPIForTestForInstance()
+	require.NoError(t, err)
+
+	ts := &TokenExchangeTestSuite{
+		API:        api,
+		Config:     config,
+		Encrypter:  &crypto.AESBlockEncrypter{Key: globalConf.DB.EncryptionKey},
+		instanceID: instanceID,
+	}
+
+	suite.Run(t, ts)
+}
+
+func (ts *TokenExchangeTestSuite) SetupTest() {
+	models.TruncateAll(ts.API.db)
+
+	for _, email := range []string{"test@example.com", "service@example.com"} {
+		u, err := models.NewUser(ts.instanceID, email, "password", ts.Config.JWT.Aud, nil, ts.Encrypter)
+		require.NoError(ts.T(), err, "Error creating test user model")
+		_, err = tigris.GetCollection[models.User](ts.API.db).Insert(context.TODO(), u)
+		require.NoError(ts.T(), err, "Error saving new test user")
+		require.NoError(ts.T(), u.Confirm(context.TODO(), ts.API.db))
+	}
+
+	ts.Config.TokenExchange = conf.TokenExchangeConfiguration{
+		Enabled: true,
+		Exp:     600,
+		Policies: conf.TokenExchangePolicies{
+			{Audiences: []string{"downstream"}, SubjectAudiences: []string{ts.Config.JWT.Aud}, Actors: []string{"service@example.com"}},
+		},
+	}
+}
+
+func (ts *TokenExchangeTestSuite) login(email string) string {
+	form := url.Values{}
+	form.Set("grant_type", "password")
+	form.Set("username", email)
+	form.Set("password", "password")
+
+	w := ts.token(form)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	token := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(token))
+	return token.Token
+}
+
+func (ts *TokenExchangeTestSuite) token(form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *TokenExchangeTestSuite) exchangeForm(subjectToken string, aud string) url.Values {
+	form := url.Values{}
+	form.Set("grant_type", tokenExchangeGrantType)
+	form.Set("subject_token", subjectToken)
+	form.Set("subject_token_type", accessTokenType)
+	form.Set("audience", aud)
+	return form
+}
+
+func (ts *TokenExchangeTestSuite) TestDownscope() {
+	w := ts.token(ts.exchangeForm(ts.login("test@example.com"), "downstream"))
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	token := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(token))
+	assert.Equal(ts.T(), accessTokenType, token.IssuedTokenType)
+	assert.Empty(ts.T(), token.RefreshToken)
+	assert.LessOrEqual(ts.T(), token.ExpiresIn, 600)
+
+	claims := &GoTrueClaims{}
+	_, _, err := new(jwt.Parser).ParseUnverified(token.Token, claims)
+	require.NoError(ts.T(), err)
+	assert.Equal(ts.T(), "downstream", claims.Audience)
+	assert.Nil(ts.T(), claims.Act)
+}
+
+func (ts *TokenExchangeTestSuite) TestDelegation() {
+	form := ts.exchangeForm(ts.login("test@example.com"), "downstream")
+	form.Set("actor_token", ts.login("service@example.com"))
+	form.Set("actor_token_type", accessTokenType)
+
+	w := ts.token(form)
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	token := &AccessTokenResponse{}
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(token))
+
+	claims := &GoTrueClaims{}
+	_, _, err := new(jwt.Parser).ParseUnverified(token.Token, claims)
+	require.NoError(ts.T(), err)
+
+	subject, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	actor, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "service@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+
+	assert.Equal(ts.T(), "gt|"+subject.ID.String(), claims.Subject)
+	require.NotNil(ts.T(), claims.Act)
+	assert.Equal(ts.T(), "gt|"+actor.ID.String(), claims.Act.Subject)
+}
+
+func (ts *TokenExchangeTestSuite) TestPolicyDenied() {
+	w := ts.token(ts.exchangeForm(ts.login("test@example.com"), "elsewhere"))
+	assert.Equal(ts.T(), http.StatusBadRequest, w.Code)
+
+	// the subject is not allowed to act on behalf of the service
+	form := ts.exchangeForm(ts.login("service@example.com"), "downstream")
+	form.Set("actor_token", ts.login("test@example.com"))
+	form.Set("actor_token_type", accessTokenType)
+	w = ts.token(form)
+	assert.Equal(ts.T(), http.StatusBadRequest, w.Code)
+}
+
+func (ts *TokenExchangeTestSuite) TestRevokedSubjectToken() {
+	subjectToken := ts.login("test@example.com")
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+subjectToken)
+	w := httptest.NewRecorder()
+	ts.API.handler.ServeHTTP(w, req)
+	require.Equal(ts.T(), http.StatusNoContent, w.Code)
+
+	w = ts.token(ts.exchangeForm(subjectToken, "downstream"))
+	assert.Equal(ts.T(), http.StatusBadRequest, w.Code)
+}
+
+func (ts *TokenExchangeTestSuite) TestDeniedUsers() {
+	subjectToken := ts.login("test@example.com")
+	actorToken := ts.login("service@example.com")
+
+	// tokens issued before a ban or deactivation are not exchanged after
+	subject, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), subject.Ban(context.TODO(), ts.API.db, models.BannedForever, "spam"))
+	w := ts.token(ts.exchangeForm(subjectToken, "downstream"))
+	assert.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	assert.Contains(ts.T(), w.Body.String(), "User is banned")
+
+	require.NoError(ts.T(), subject.Unban(context.TODO(), ts.API.db))
+	actor, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "service@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), actor.SetDeactivated(context.TODO(), ts.API.db, true))
+	form := ts.exchangeForm(subjectToken, "downstream")
+	form.Set("actor_token", actorToken)
+	form.Set("actor_token_type", accessTokenType)
+	w = ts.token(form)
+	assert.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	assert.Contains(ts.T(), w.Body.String(), "User is deactivated")
+}
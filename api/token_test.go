@@ -2,10 +2,14 @@ package api
 
 import (
 	"bytes"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/tigrisdata/gotrue/conf"
@@ -57,6 +61,9 @@ func (ts *TokenTestSuite) TestRateLimitToken() {
 	w := httptest.NewRecorder()
 	ts.API.handler.ServeHTTP(w, req)
 	assert.Equal(ts.T(), http.StatusTooManyRequests, w.Code)
+	assert.Equal(ts.T(), "30", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(ts.T(), "0", w.Header().Get("RateLimit-Remaining"))
+	assert.NotEmpty(ts.T(), w.Header().Get("Retry-After"))
 
 	// It ignores X-Forwarded-For by default
 	req.Header.Set("X-Forwarded-For", "1.1.1.1")
@@ -72,3 +79,31 @@ func (ts *TokenTestSuite) TestRateLimitToken() {
 	ts.API.handler.ServeHTTP(w, req)
 	assert.Equal(ts.T(), http.StatusBadRequest, w.Code)
 }
+
+func (ts *TokenTestSuite) TestRateLimitByEmail() {
+	ts.Config.RateLimit.Recover = conf.RateLimit{Requests: 2, Period: time.Hour, By: []string{"email"}}
+	defer func() { ts.Config.RateLimit.Recover = conf.RateLimit{} }()
+
+	recoverRequest := func(email, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/recover", strings.NewReader(`{"email":"`+email+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("My-Custom-Header", ip)
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		w := recoverRequest("limited@example.com", fmt.Sprintf("10.0.0.%d", i))
+		assert.NotEqual(ts.T(), http.StatusTooManyRequests, w.Code)
+		assert.Equal(ts.T(), strconv.Itoa(1-i), w.Header().Get("RateLimit-Remaining"))
+	}
+
+	// changing the IP address does not help
+	w := recoverRequest("Limited@example.com", "10.0.0.9")
+	assert.Equal(ts.T(), http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(ts.T(), w.Header().Get("Retry-After"))
+
+	w = recoverRequest("other@example.com", "10.0.0.9")
+	assert.NotEqual(ts.T(), http.StatusTooManyRequests, w.Code)
+}
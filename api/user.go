@@ -46,9 +46,20 @@ func (a *API) UserGet(w http.ResponseWriter, r *http.Request) error {
 		return internalServerError("Database error finding user").WithInternalError(err)
 	}
 
+	a.hideEmailChangeTokens(ctx, user)
 	return sendJSON(w, http.StatusOK, user)
 }
 
+// hideEmailChangeTokens keeps the tokens of secure email changes out of
+// responses, so that holding a session of the user is not enough to confirm
+// them.
+func (a *API) hideEmailChangeTokens(ctx context.Context, user *models.User) {
+	if a.getConfig(ctx).Mailer.SecureEmailChange {
+		user.EmailChangeToken = ""
+		user.EmailChangeTokenCurrent = ""
+	}
+}
+
 func GetUserIdFromSubject(subject string) string {
 	return strings.Replace(subject, "gt|", "", 1)
 }
@@ -83,12 +94,25 @@ func (a *API) UserUpdate(w http.ResponseWriter, r *http.Request) error {
 	log := getLogEntry(r)
 	log.Debug().Msgf("Checking params for token %v", params)
 
+	if params.Password != "" {
+		if err := a.checkPassword(ctx, user.Email, params.Password); err != nil {
+			return err
+		}
+		if err := a.checkPasswordReuse(ctx, user, params.Password); err != nil {
+			return err
+		}
+	}
+
+	oldEmail := user.Email
 	err = a.db.Tx(ctx, func(ctx context.Context) error {
 		var terr error
 		if params.Password != "" {
-			if terr = user.UpdatePassword(ctx, a.db, a.encrypter, params.Password); terr != nil {
+			if terr = a.updatePassword(ctx, user, params.Password); terr != nil {
 				return internalServerError("Error during password storage").WithInternalError(terr)
 			}
+			if terr = a.revokeUserTokens(ctx, user); terr != nil {
+				return internalServerError("Error revoking user sessions").WithInternalError(terr)
+			}
 		}
 
 		if params.Data != nil {
@@ -110,12 +134,8 @@ func (a *API) UserUpdate(w http.ResponseWriter, r *http.Request) error {
 		if params.EmailChangeToken != "" {
 			log.Debug().Msgf("Got change token %v", params.EmailChangeToken)
 
-			if params.EmailChangeToken != user.EmailChangeToken {
-				return unauthorizedError("Email Change Token didn't match token on file")
-			}
-
-			if terr = user.ConfirmEmailChange(ctx, a.db); terr != nil {
-				return internalServerError("Error updating user").WithInternalError(terr)
+			if terr = a.confirmEmailChange(ctx, user, params.EmailChangeToken); terr != nil {
+				return terr
 			}
 		} else if params.Email != "" && params.Email != user.Email {
 			if terr = a.validateEmail(ctx, params.Email); terr != nil {
@@ -146,6 +166,14 @@ func (a *API) UserUpdate(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	if params.Password != "" {
+		a.notifyPasswordChanged(ctx, user)
+	}
+	if user.Email != oldEmail {
+		a.notifyEmailChanged(ctx, user, oldEmail)
+	}
+
+	a.hideEmailChangeTokens(ctx, user)
 	user.EncryptedPassword = a.encrypter.Decrypt(user.EncryptedPassword, user.EncryptionIV)
 	return sendJSON(w, http.StatusOK, user)
 }
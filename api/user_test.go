@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -18,6 +20,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"github.com/tigrisdata/tigris-client-go/fields"
+	"github.com/tigrisdata/tigris-client-go/filter"
 	"github.com/tigrisdata/tigris-client-go/tigris"
 )
 
@@ -58,28 +62,187 @@ func (ts *UserTestSuite) TestUser_UpdatePassword() {
 	u, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "test@example.com", ts.Config.JWT.Aud)
 	require.NoError(ts.T(), err)
 
-	// Request body
+	w := ts.updatePassword(u, "newpass")
+	require.Equal(ts.T(), http.StatusOK, w.Code)
+
+	u, err = models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+
+	assert.True(ts.T(), u.Authenticate("newpass", ts.Encrypter))
+}
+
+// userToken signs an access token for the user
+func (ts *UserTestSuite) userToken(u *models.User) string {
+	token, err := generateAccessToken(u, time.Second*time.Duration(ts.Config.JWT.Exp), ts.Config, NewTokenSigner(ts.Config))
+	require.NoError(ts.T(), err)
+	return token
+}
+
+// updateUser sends PUT /user with the params, authenticated with the token
+func (ts *UserTestSuite) updateUser(token string, params map[string]interface{}) *httptest.ResponseRecorder {
 	var buffer bytes.Buffer
-	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(map[string]interface{}{
-		"password": "newpass",
-	}))
+	require.NoError(ts.T(), json.NewEncoder(&buffer).Encode(params))
 
-	// Setup request
 	req := httptest.NewRequest(http.MethodPut, "http://localhost/user", &buffer)
 	req.Header.Set("Content-Type", "application/json")
-	tokenSigner := NewTokenSigner(ts.Config)
-
-	token, err := generateAccessToken(u, time.Second*time.Duration(ts.Config.JWT.Exp), ts.Config, tokenSigner)
-	require.NoError(ts.T(), err)
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 
-	// Setup response recorder
 	w := httptest.NewRecorder()
 	ts.API.handler.ServeHTTP(w, req)
-	require.Equal(ts.T(), w.Code, http.StatusOK)
+	return w
+}
+
+func (ts *UserTestSuite) updatePassword(u *models.User, password string) *httptest.ResponseRecorder {
+	return ts.updateUser(ts.userToken(u), map[string]interface{}{"password": password})
+}
+
+func (ts *UserTestSuite) confirmEmailChange(u *models.User, emailChangeToken string) *httptest.ResponseRecorder {
+	return ts.updateUser(ts.userToken(u), map[string]interface{}{"email_change_token": emailChangeToken})
+}
 
-	u, err = models.FindUserByEmailAndAudience(req.Context(), ts.API.db, ts.instanceID, "test@example.com", ts.Config.JWT.Aud)
+func (ts *UserTestSuite) TestUser_UpdateImpersonated() {
+	u, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "test@example.com", ts.Config.JWT.Aud)
 	require.NoError(ts.T(), err)
 
-	assert.True(ts.T(), u.Authenticate("newpass", ts.Encrypter))
+	w := ts.updateUser(impersonationToken(ts.T(), ts.API, ts.Config, u), map[string]interface{}{
+		"password": "impersonator-pass",
+	})
+	require.Equal(ts.T(), http.StatusForbidden, w.Code)
+
+	u, err = models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	assert.True(ts.T(), u.Authenticate("password", ts.Encrypter))
+}
+
+func (ts *UserTestSuite) TestUser_UpdatePasswordReuse() {
+	ts.Config.Password.HistorySize = 2
+	defer func() { ts.Config.Password.HistorySize = 0 }()
+
+	u, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+
+	// the current password
+	w := ts.updatePassword(u, "password")
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(ts.T(), w.Body.String(), `"reused"`)
+
+	require.Equal(ts.T(), http.StatusOK, ts.updatePassword(u, "first-new").Code)
+	require.Equal(ts.T(), http.StatusOK, ts.updatePassword(u, "second-new").Code)
+
+	// in the history
+	w = ts.updatePassword(u, "first-new")
+	require.Equal(ts.T(), http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(ts.T(), w.Body.String(), `"reused"`)
+
+	// pushed out of the history
+	require.Equal(ts.T(), http.StatusOK, ts.updatePassword(u, "third-new").Code)
+	require.Equal(ts.T(), http.StatusOK, ts.updatePassword(u, "first-new").Code)
+}
+
+func (ts *UserTestSuite) TestUser_PasswordExpired() {
+	ts.Config.Password.MaxAge = 24 * time.Hour
+	defer func() { ts.Config.Password.MaxAge = 0 }()
+
+	u, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), u.Confirm(context.TODO(), ts.API.db))
+
+	passwordGrant := func() *httptest.ResponseRecorder {
+		form := url.Values{
+			"grant_type": {"password"},
+			"username":   {"test@example.com"},
+			"password":   {"password"},
+		}
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		ts.API.handler.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(ts.T(), http.StatusOK, passwordGrant().Code)
+
+	changedAt := time.Now().Add(-48 * time.Hour).UTC()
+	_, err = tigris.GetCollection[models.User](ts.API.db).Update(context.TODO(), filter.EqUUID("id", u.ID), fields.Set("password_changed_at", changedAt))
+	require.NoError(ts.T(), err)
+
+	w := passwordGrant()
+	require.Equal(ts.T(), http.StatusBadRequest, w.Code)
+	assert.Contains(ts.T(), w.Body.String(), passwordExpiredMessage)
+
+	u, err = models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	assert.NotEmpty(ts.T(), u.RecoveryToken)
+	require.NotNil(ts.T(), u.RecoverySentAt)
+
+	// signing in again once another email may be sent keeps the emailed token
+	sentAt := time.Now().Add(-time.Hour).UTC()
+	_, err = tigris.GetCollection[models.User](ts.API.db).Update(context.TODO(), filter.EqUUID("id", u.ID), fields.Set("recovery_sent_at", sentAt))
+	require.NoError(ts.T(), err)
+	require.Equal(ts.T(), http.StatusBadRequest, passwordGrant().Code)
+
+	resent, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	assert.Equal(ts.T(), u.RecoveryToken, resent.RecoveryToken)
+	require.NotNil(ts.T(), resent.RecoverySentAt)
+	assert.True(ts.T(), resent.RecoverySentAt.After(sentAt))
+}
+
+func (ts *UserTestSuite) TestUser_ConfirmEmailChange() {
+	ts.Config.Notifications.EmailChanged = true
+	defer func() { ts.Config.Notifications.EmailChanged = false }()
+	mails := recordMails(ts.T(), ts.API)
+
+	u, err := models.FindUserByEmailAndAudience(context.TODO(), ts.API.db, ts.instanceID, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.sendEmailChange(context.TODO(), ts.API.db, u, ts.API.Mailer(context.WithValue(context.TODO(), configKey, ts.Config)), "new@example.com", ""))
+
+	w := ts.confirmEmailChange(u, u.EmailChangeToken)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	u, err = models.FindUserByInstanceIDAndID(context.TODO(), ts.API.db, ts.instanceID, u.ID)
+	require.NoError(ts.T(), err)
+	assert.Equal(ts.T(), "new@example.com", u.Email)
+	assert.Empty(ts.T(), u.EmailChange)
+	assert.Empty(ts.T(), u.EmailChangeToken)
+
+	// the old address is told, in case it was not its owner who changed it
+	assert.Equal(ts.T(), []recordedMail{{Kind: "email_changed", To: "test@example.com"}}, mails.Mails())
+}
+
+func (ts *UserTestSuite) TestUser_SecureEmailChange() {
+	ts.Config.Mailer.SecureEmailChange = true
+	defer func() { ts.Config.Mailer.SecureEmailChange = false }()
+
+	ctx := context.WithValue(context.TODO(), configKey, ts.Config)
+	u, err := models.FindUserByEmailAndAudience(ctx, ts.API.db, ts.instanceID, "test@example.com", ts.Config.JWT.Aud)
+	require.NoError(ts.T(), err)
+	require.NoError(ts.T(), ts.API.sendEmailChange(ctx, ts.API.db, u, ts.API.Mailer(ctx), "new@example.com", ""))
+	require.NotEmpty(ts.T(), u.EmailChangeTokenCurrent)
+	newToken, currentToken := u.EmailChangeToken, u.EmailChangeTokenCurrent
+
+	// the current address confirms first, the email is not swapped yet
+	w := ts.confirmEmailChange(u, currentToken)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+	data := make(map[string]interface{})
+	require.NoError(ts.T(), json.NewDecoder(w.Body).Decode(&data))
+	assert.Equal(ts.T(), "test@example.com", data["email"])
+	assert.Equal(ts.T(), "new@example.com", data["new_email"])
+	assert.Equal(ts.T(), true, data["email_change_confirmed_current"])
+	assert.Equal(ts.T(), false, data["email_change_confirmed_new"])
+	assert.Empty(ts.T(), data["email_change_token"])
+
+	// tokens are single use
+	w = ts.confirmEmailChange(u, currentToken)
+	require.Equal(ts.T(), http.StatusUnauthorized, w.Code)
+
+	w = ts.confirmEmailChange(u, newToken)
+	require.Equal(ts.T(), http.StatusOK, w.Code, w.Body.String())
+
+	u, err = models.FindUserByInstanceIDAndID(ctx, ts.API.db, ts.instanceID, u.ID)
+	require.NoError(ts.T(), err)
+	assert.Equal(ts.T(), "new@example.com", u.Email)
+	assert.Empty(ts.T(), u.EmailChange)
+	assert.False(ts.T(), u.EmailChangeConfirmedCurrent)
+	assert.False(ts.T(), u.EmailChangeConfirmedNew)
 }
@@ -63,7 +63,7 @@ func (a *API) Verify(w http.ResponseWriter, r *http.Request) error {
 		}
 
 		if cookie != "" && config.Cookie.Duration > 0 {
-			if terr = a.setCookieToken(config, token.Token, cookie == useSessionCookie, w); terr != nil {
+			if terr = a.setCookieToken(config, token.Token, token.RefreshToken, cookie == useSessionCookie, w); terr != nil {
 				return internalServerError("Failed to set JWT cookie. %s", terr)
 			}
 		}
@@ -95,7 +95,10 @@ func (a *API) signupVerify(ctx context.Context, params *VerifyParams) (*models.U
 				if params.Password == "" {
 					return unprocessableEntityError("Invited users must specify a password")
 				}
-				if terr = user.UpdatePassword(ctx, a.db, a.encrypter, params.Password); terr != nil {
+				if terr = a.checkPassword(ctx, user.Email, params.Password); terr != nil {
+					return terr
+				}
+				if terr = a.updatePassword(ctx, user, params.Password); terr != nil {
 					return internalServerError("Error storing password").WithInternalError(terr)
 				}
 			}
@@ -131,11 +134,28 @@ func (a *API) recoverVerify(ctx context.Context, params *VerifyParams) (*models.
 		return nil, internalServerError("Database error finding user").WithInternalError(err)
 	}
 
+	if params.Password != "" {
+		if err := a.checkPassword(ctx, user.Email, params.Password); err != nil {
+			return nil, err
+		}
+		if err := a.checkPasswordReuse(ctx, user, params.Password); err != nil {
+			return nil, err
+		}
+	}
+
 	err = a.db.Tx(ctx, func(ctx context.Context) error {
 		var terr error
 		if terr = user.Recover(ctx, a.db); terr != nil {
 			return terr
 		}
+		if params.Password != "" {
+			if terr = a.updatePassword(ctx, user, params.Password); terr != nil {
+				return terr
+			}
+			if terr = a.revokeUserTokens(ctx, user); terr != nil {
+				return terr
+			}
+		}
 		if !user.IsConfirmed() {
 			if terr = models.NewAuditLogEntry(ctx, a.db, instanceID, user, models.UserSignedUpAction, nil); terr != nil {
 				return terr
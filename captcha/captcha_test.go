@@ -0,0 +1,84 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// siteverifyServer accepts the token "valid" for the secret "secret".
+func siteverifyServer(t *testing.T, forms chan<- url.Values) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		if forms != nil {
+			forms <- r.PostForm
+		}
+		res := verifyResponse{Success: r.PostForm.Get("secret") == "secret" && r.PostForm.Get("response") == "valid"}
+		if !res.Success {
+			res.ErrorCodes = []string{"invalid-input-response"}
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(res))
+	}))
+}
+
+func TestVerify(t *testing.T) {
+	for _, provider := range []string{HCaptchaProvider, TurnstileProvider} {
+		t.Run(provider, func(t *testing.T) {
+			forms := make(chan url.Values, 1)
+			server := siteverifyServer(t, forms)
+			defer server.Close()
+
+			v, err := NewVerifier(provider, "secret", "site-key", server.URL)
+			require.NoError(t, err)
+
+			require.NoError(t, v.Verify(context.Background(), "valid", "10.0.0.1"))
+			form := <-forms
+			assert.Equal(t, "10.0.0.1", form.Get("remoteip"))
+			if provider == HCaptchaProvider {
+				assert.Equal(t, "site-key", form.Get("sitekey"))
+			} else {
+				assert.Empty(t, form.Get("sitekey"))
+			}
+
+			err = v.Verify(context.Background(), "invalid", "")
+			assert.True(t, errors.Is(err, ErrVerificationFailed), err)
+			<-forms
+
+			// empty tokens are refused without asking the provider
+			assert.True(t, errors.Is(v.Verify(context.Background(), "", ""), ErrVerificationFailed))
+		})
+	}
+}
+
+func TestVerifyUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	v, err := NewVerifier(TurnstileProvider, "secret", "", server.URL)
+	require.NoError(t, err)
+	err = v.Verify(context.Background(), "valid", "")
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrVerificationFailed))
+}
+
+func TestNewVerifier(t *testing.T) {
+	v, err := NewVerifier(HCaptchaProvider, "secret", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, HCaptchaVerifyURL, v.(*siteVerifier).url)
+
+	v, err = NewVerifier(TurnstileProvider, "secret", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, TurnstileVerifyURL, v.(*siteVerifier).url)
+
+	_, err = NewVerifier("recaptcha", "secret", "", "")
+	assert.Error(t, err)
+}
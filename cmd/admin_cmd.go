@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/tigrisdata/gotrue/conf"
@@ -16,6 +17,8 @@ import (
 
 var autoconfirm, isSuperAdmin, isAdmin bool
 var audience, instanceID string
+var banDuration time.Duration
+var banReason string
 
 func getAudience(c *conf.Configuration) string {
 	if audience == "" {
@@ -30,7 +33,7 @@ func adminCmd() *cobra.Command {
 		Use: "admin",
 	}
 
-	adminCmd.AddCommand(&adminCreateUserCmd, &adminDeleteUserCmd)
+	adminCmd.AddCommand(&adminCreateUserCmd, &adminDeleteUserCmd, &adminBanUserCmd, &adminUnbanUserCmd)
 	adminCmd.PersistentFlags().StringVarP(&audience, "aud", "a", "", "Set the new user's audience")
 	adminCmd.PersistentFlags().StringVarP(&instanceID, "instance_id", "i", "", "Set the instance ID to interact with")
 
@@ -38,6 +41,9 @@ func adminCmd() *cobra.Command {
 	adminCreateUserCmd.Flags().BoolVar(&isSuperAdmin, "superadmin", false, "Create user with superadmin privileges")
 	adminCreateUserCmd.Flags().BoolVar(&isAdmin, "admin", false, "Create user with admin privileges")
 
+	adminBanUserCmd.Flags().DurationVar(&banDuration, "duration", 0, "Ban the user for this long instead of indefinitely")
+	adminBanUserCmd.Flags().StringVar(&banReason, "reason", "", "Record why the user was banned")
+
 	return adminCmd
 }
 
@@ -65,6 +71,30 @@ var adminDeleteUserCmd = cobra.Command{
 	},
 }
 
+var adminBanUserCmd = cobra.Command{
+	Use: "banuser",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			log.Fatal().Msg("Not enough arguments to banuser command. Expected at least ID or email")
+			return
+		}
+
+		execWithConfigAndArgs(cmd, adminBanUser, args)
+	},
+}
+
+var adminUnbanUserCmd = cobra.Command{
+	Use: "unbanuser",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			log.Fatal().Msg("Not enough arguments to unbanuser command. Expected at least ID or email")
+			return
+		}
+
+		execWithConfigAndArgs(cmd, adminUnbanUser, args)
+	},
+}
+
 var adminEditRoleCmd = cobra.Command{
 	Use: "editrole",
 	Run: func(cmd *cobra.Command, args []string) {
@@ -143,6 +173,25 @@ func adminDeleteUser(globalConfig *conf.GlobalConfiguration, config *conf.Config
 		log.Fatal().Msgf("Error removing user (%s): %+v", args[0], err)
 	}
 
+	if err = models.DeleteIdentitiesByUser(context.TODO(), database, user); err != nil {
+		log.Fatal().Msgf("Error removing identities of user (%s): %+v", args[0], err)
+	}
+
+	if err = models.DeletePasswordHistory(context.TODO(), database, user); err != nil {
+		log.Fatal().Msgf("Error removing password history of user (%s): %+v", args[0], err)
+	}
+
+	if err = models.DeleteKnownDevices(context.TODO(), database, user); err != nil {
+		log.Fatal().Msgf("Error removing known devices of user (%s): %+v", args[0], err)
+	}
+
+	if err = models.RevokeUserAccessTokens(context.TODO(), database, iid, user.ID, time.Second*time.Duration(config.JWT.Exp)); err != nil {
+		log.Fatal().Msgf("Error revoking access tokens of user (%s): %+v", args[0], err)
+	}
+	if err = models.Logout(context.TODO(), database, iid, user.ID); err != nil {
+		log.Fatal().Msgf("Error removing refresh tokens of user (%s): %+v", args[0], err)
+	}
+
 	log.Info().Msgf("Removed user: %s", args[0])
 }
 
@@ -176,3 +225,69 @@ func adminEditRole(globalConfig *conf.GlobalConfiguration, config *conf.Configur
 
 	log.Info().Msgf("Updated user: %s", args[0])
 }
+
+func adminBanUser(globalConfig *conf.GlobalConfiguration, config *conf.Configuration, database *tigris.Database, args []string) {
+	iid := uuid.Must(uuid.Parse(instanceID))
+
+	user, err := models.FindUserByEmailAndAudience(context.TODO(), database, iid, args[0], getAudience(config))
+	if err != nil {
+		userID := uuid.Must(uuid.Parse(args[0]))
+		user, err = models.FindUserByInstanceIDAndID(context.TODO(), database, iid, userID)
+		if err != nil {
+			log.Fatal().Msgf("Error finding user (%s): %+v", userID, err)
+		}
+	}
+
+	until := models.BannedForever
+	if banDuration > 0 {
+		until = time.Now().Add(banDuration)
+	}
+
+	if err = user.Ban(context.TODO(), database, until, banReason); err != nil {
+		log.Fatal().Msgf("Error banning user (%s): %+v", args[0], err)
+	}
+
+	if err = models.RevokeUserAccessTokens(context.TODO(), database, iid, user.ID, time.Second*time.Duration(config.JWT.Exp)); err != nil {
+		log.Fatal().Msgf("Error revoking access tokens of user (%s): %+v", args[0], err)
+	}
+	if err = models.Logout(context.TODO(), database, iid, user.ID); err != nil {
+		log.Fatal().Msgf("Error removing refresh tokens of user (%s): %+v", args[0], err)
+	}
+
+	if err = models.NewAuditLogEntry(context.TODO(), database, iid, models.NewSystemUser(iid, getAudience(config)), models.UserBannedAction, map[string]interface{}{
+		"user_id":      user.ID,
+		"user_email":   user.Email,
+		"banned_until": user.BannedUntil,
+		"reason":       banReason,
+	}); err != nil {
+		log.Fatal().Msgf("Error recording audit log entry (%s): %+v", args[0], err)
+	}
+
+	log.Info().Msgf("Banned user: %s", args[0])
+}
+
+func adminUnbanUser(globalConfig *conf.GlobalConfiguration, config *conf.Configuration, database *tigris.Database, args []string) {
+	iid := uuid.Must(uuid.Parse(instanceID))
+
+	user, err := models.FindUserByEmailAndAudience(context.TODO(), database, iid, args[0], getAudience(config))
+	if err != nil {
+		userID := uuid.Must(uuid.Parse(args[0]))
+		user, err = models.FindUserByInstanceIDAndID(context.TODO(), database, iid, userID)
+		if err != nil {
+			log.Fatal().Msgf("Error finding user (%s): %+v", userID, err)
+		}
+	}
+
+	if err = user.Unban(context.TODO(), database); err != nil {
+		log.Fatal().Msgf("Error unbanning user (%s): %+v", args[0], err)
+	}
+
+	if err = models.NewAuditLogEntry(context.TODO(), database, iid, models.NewSystemUser(iid, getAudience(config)), models.UserUnbannedAction, map[string]interface{}{
+		"user_id":    user.ID,
+		"user_email": user.Email,
+	}); err != nil {
+		log.Fatal().Msgf("Error recording audit log entry (%s): %+v", args[0], err)
+	}
+
+	log.Info().Msgf("Unbanned user: %s", args[0])
+}
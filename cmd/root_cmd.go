@@ -87,7 +87,7 @@ func bootstrapSchemas(ctx context.Context, globalConfig *conf.GlobalConfiguratio
 	if err != nil {
 		log.Error().Err(err).Msgf("Failed to create tigris project: %+v", err)
 	}
-	db, err := tigrisClient.OpenDatabase(ctx, &models.AuditLogEntry{}, &models.User{}, &models.RefreshToken{}, &models.Instance{}, &models.Invitation{})
+	db, err := tigrisClient.OpenDatabase(ctx, &models.AuditLogEntry{}, &models.User{}, &models.RefreshToken{}, &models.Instance{}, &models.Invitation{}, &models.RevokedToken{}, &models.DeviceCode{}, &models.OAuthState{}, &models.Identity{}, &models.ProviderToken{}, &models.SSOProvider{}, &models.SAMLAssertion{}, &models.SCIMToken{}, &models.SCIMGroup{}, &models.LoginFailure{}, &models.PasswordHistoryEntry{}, &models.KnownDevice{})
 	if err != nil {
 		log.Fatal().Err(err).Msgf("Error opening database: %+v", err)
 	}
@@ -4,7 +4,9 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -31,6 +33,59 @@ type SamlProviderConfiguration struct {
 	Name        string `json:"name"`
 	SigningCert string `json:"signing_cert" envconfig:"SIGNING_CERT"`
 	SigningKey  string `json:"signing_key" envconfig:"SIGNING_KEY"`
+	// MetadataXML pins the metadata of the identity provider, which is then not
+	// fetched from MetadataURL
+	MetadataXML string `json:"metadata_xml" envconfig:"METADATA_XML"`
+	// MetadataRefreshInterval is how long fetched metadata is used before it is
+	// fetched again
+	MetadataRefreshInterval time.Duration `json:"metadata_refresh_interval" envconfig:"METADATA_REFRESH_INTERVAL"`
+	// MetadataSigningCert is the PEM certificate metadata must be signed with.
+	// Unsigned metadata is accepted if empty.
+	MetadataSigningCert string `json:"metadata_signing_cert" envconfig:"METADATA_SIGNING_CERT"`
+	// AllowIdPInitiated accepts sign ins started at the identity provider,
+	// which carry no state of a request of ours
+	AllowIdPInitiated bool                 `json:"allow_idp_initiated" envconfig:"ALLOW_IDP_INITIATED"`
+	AttributeMapping  SamlAttributeMapping `json:"attribute_mapping" envconfig:"ATTRIBUTE_MAPPING"`
+}
+
+// SamlAttributeMapping maps the attributes of SAML assertions to users.
+type SamlAttributeMapping struct {
+	// Email is the attribute holding the email of the user, the name ID if empty
+	Email string `json:"email"`
+	// Metadata maps user metadata keys to attribute names
+	Metadata map[string]string `json:"metadata"`
+	// Role is the attribute holding the role of the user, e.g. a group
+	Role string `json:"role"`
+	// Roles maps values of the role attribute to roles. Values without a role
+	// are ignored, so identity providers cannot assign arbitrary roles.
+	Roles map[string]string `json:"roles"`
+}
+
+// Decode reads the mapping from JSON when set from the environment
+func (m *SamlAttributeMapping) Decode(value string) error {
+	return json.Unmarshal([]byte(value), m)
+}
+
+// MicrosoftProviderConfiguration holds the config of the Microsoft identity
+// platform (Entra ID).
+type MicrosoftProviderConfiguration struct {
+	OAuthProviderConfiguration
+	// Tenant is common, organizations, consumers or the ID of a single tenant
+	Tenant string `json:"tenant"`
+	// AllowedTenants restricts sign ins to users of these tenants (tid claim)
+	AllowedTenants []string `json:"allowed_tenants" split_words:"true"`
+}
+
+// AppleProviderConfiguration holds the config of Sign in with Apple. Instead of
+// a static secret, client secrets are JWTs signed with the private key.
+type AppleProviderConfiguration struct {
+	ClientID    string `json:"client_id" split_words:"true"`
+	TeamID      string `json:"team_id" split_words:"true"`
+	KeyID       string `json:"key_id" split_words:"true"`
+	PrivateKey  string `json:"private_key" split_words:"true"`
+	RedirectURI string `json:"redirect_uri" split_words:"true"`
+	URL         string `json:"url"`
+	Enabled     bool   `json:"enabled"`
 }
 
 // DBConfiguration holds all the database related configuration.
@@ -80,8 +135,11 @@ type GlobalConfiguration struct {
 	Tracing           TracingConfig
 	SMTP              SMTPConfiguration
 
-	RateLimitHeader  string                  `split_words:"true"`
-	InvitationConfig InvitationConfiguration `envconfig:"invitation"`
+	RateLimitHeader string `split_words:"true"`
+	// RateLimitStore is where requests are counted, memory or redis
+	RateLimitStore    string                  `split_words:"true"`
+	RateLimitRedisURL string                  `envconfig:"RATE_LIMIT_REDIS_URL"`
+	InvitationConfig  InvitationConfiguration `envconfig:"invitation"`
 }
 
 type InvitationConfiguration struct {
@@ -98,17 +156,78 @@ type EmailContentConfiguration struct {
 	Confirmation string `json:"confirmation"`
 	Recovery     string `json:"recovery"`
 	EmailChange  string `json:"email_change" split_words:"true"`
+	Lockout      string `json:"lockout"`
+	NewDevice    string `json:"new_device" split_words:"true"`
+
+	// EmailChangeCurrent is the mail to the current address in secure email
+	// changes
+	EmailChangeCurrent string `json:"email_change_current" split_words:"true"`
+
+	PasswordChanged  string `json:"password_changed" split_words:"true"`
+	EmailChanged     string `json:"email_changed" split_words:"true"`
+	IdentityLinked   string `json:"identity_linked" split_words:"true"`
+	IdentityUnlinked string `json:"identity_unlinked" split_words:"true"`
+	APIKeyCreated    string `json:"api_key_created" envconfig:"API_KEY_CREATED"`
 }
 
 type ProviderConfiguration struct {
-	Bitbucket   OAuthProviderConfiguration `json:"bitbucket"`
-	Github      OAuthProviderConfiguration `json:"github"`
-	Gitlab      OAuthProviderConfiguration `json:"gitlab"`
-	Google      OAuthProviderConfiguration `json:"google"`
-	Facebook    OAuthProviderConfiguration `json:"facebook"`
-	Email       EmailProviderConfiguration `json:"email"`
-	Saml        SamlProviderConfiguration  `json:"saml"`
-	RedirectURL string                     `json:"redirect_url"`
+	Bitbucket   OAuthProviderConfiguration     `json:"bitbucket"`
+	Github      OAuthProviderConfiguration     `json:"github"`
+	Gitlab      OAuthProviderConfiguration     `json:"gitlab"`
+	Google      OAuthProviderConfiguration     `json:"google"`
+	Facebook    OAuthProviderConfiguration     `json:"facebook"`
+	Microsoft   MicrosoftProviderConfiguration `json:"microsoft"`
+	Apple       AppleProviderConfiguration     `json:"apple"`
+	Slack       OAuthProviderConfiguration     `json:"slack"`
+	Email       EmailProviderConfiguration     `json:"email"`
+	Saml        SamlProviderConfiguration      `json:"saml"`
+	OIDC        OIDCProviders                  `json:"oidc"`
+	Tokens      ProviderTokensConfiguration    `json:"tokens"`
+	RedirectURL string                         `json:"redirect_url"`
+}
+
+// ProviderTokensConfiguration holds the configuration for keeping the tokens
+// external providers issue, so trusted backends can call the providers on
+// behalf of users.
+type ProviderTokensConfiguration struct {
+	Enabled bool `json:"enabled"`
+	// Audiences are the audiences of the access tokens trusted backends present
+	// to read provider tokens
+	Audiences []string `json:"audiences"`
+}
+
+// Trusts reports whether access tokens issued for aud may read provider tokens.
+func (t *ProviderTokensConfiguration) Trusts(aud string) bool {
+	return t.Enabled && contains(t.Audiences, aud)
+}
+
+// OIDCProviderConfiguration holds the config of a generic OpenID Connect
+// provider, whose endpoints are found through discovery on the issuer.
+type OIDCProviderConfiguration struct {
+	Name        string   `json:"name"`
+	Issuer      string   `json:"issuer"`
+	ClientID    string   `json:"client_id"`
+	Secret      string   `json:"secret"`
+	RedirectURI string   `json:"redirect_uri"`
+	Scopes      []string `json:"scopes"`
+	Enabled     bool     `json:"enabled"`
+}
+
+// OIDCProviders is decoded from a JSON array when set from the environment
+type OIDCProviders []OIDCProviderConfiguration
+
+func (p *OIDCProviders) Decode(value string) error {
+	return json.Unmarshal([]byte(value), p)
+}
+
+// Find returns the provider configured with the given name.
+func (p OIDCProviders) Find(name string) (OIDCProviderConfiguration, bool) {
+	for _, provider := range p {
+		if strings.EqualFold(provider.Name, name) {
+			return provider, true
+		}
+	}
+	return OIDCProviderConfiguration{}, false
 }
 
 type SMTPConfiguration struct {
@@ -127,6 +246,9 @@ type MailerConfiguration struct {
 	URLPaths    EmailContentConfiguration `json:"url_paths"`
 	Type        string                    `json:"type"`
 	CustomerIO  CustomerIOConfiguration   `json:"customerio"`
+	// SecureEmailChange requires changes of email to be confirmed from both
+	// the current and the new address
+	SecureEmailChange bool `json:"secure_email_change" split_words:"true"`
 }
 
 type CustomerIOConfiguration struct {
@@ -136,19 +258,52 @@ type CustomerIOConfiguration struct {
 
 // Configuration holds all the per-instance configuration.
 type Configuration struct {
-	SiteURL          string                `json:"site_url" split_words:"true" required:"true"`
-	TigrisWebsiteURL string                `json:"tigris_website_url" split_words:"true" required:"false"`
-	TigrisConsoleURL string                `json:"tigris_console_url" split_words:"true" required:"false"`
-	JWT              JWTConfiguration      `json:"jwt"`
-	SMTP             SMTPConfiguration     `json:"smtp"`
-	Mailer           MailerConfiguration   `json:"mailer"`
-	External         ProviderConfiguration `json:"external"`
-	DisableSignup    bool                  `json:"disable_signup" split_words:"true"`
-	Webhook          WebhookConfig         `json:"webhook" split_words:"true"`
-	Cookie           struct {
-		Key      string `json:"key"`
-		Duration int    `json:"duration"`
-	} `json:"cookies"`
+	SiteURL          string                     `json:"site_url" split_words:"true" required:"true"`
+	RedirectURLs     []string                   `json:"redirect_urls" envconfig:"REDIRECT_URLS"`
+	TigrisWebsiteURL string                     `json:"tigris_website_url" split_words:"true" required:"false"`
+	TigrisConsoleURL string                     `json:"tigris_console_url" split_words:"true" required:"false"`
+	JWT              JWTConfiguration           `json:"jwt"`
+	SMTP             SMTPConfiguration          `json:"smtp"`
+	Mailer           MailerConfiguration        `json:"mailer"`
+	External         ProviderConfiguration      `json:"external"`
+	DisableSignup    bool                       `json:"disable_signup" split_words:"true"`
+	Webhook          WebhookConfig              `json:"webhook" split_words:"true"`
+	TokenExchange    TokenExchangeConfiguration `json:"token_exchange" split_words:"true"`
+	Device           DeviceConfiguration        `json:"device"`
+	Impersonation    ImpersonationConfiguration `json:"impersonation"`
+	LDAP             LDAPConfiguration          `json:"ldap"`
+	Lockout          LockoutConfiguration       `json:"lockout"`
+	Notifications    NotificationsConfiguration `json:"notifications"`
+	RateLimit        RateLimitConfiguration     `json:"rate_limit" split_words:"true"`
+	Password         PasswordConfiguration      `json:"password"`
+	Captcha          CaptchaConfiguration       `json:"captcha"`
+	CORS             CORSConfiguration          `json:"cors"`
+	Cookie           CookieConfiguration        `json:"cookies"`
+}
+
+// CookieConfiguration holds the cookies sessions are kept in when clients ask
+// for them with the x-use-cookie header.
+type CookieConfiguration struct {
+	// Key names the cookie holding the access token
+	Key      string `json:"key"`
+	Duration int    `json:"duration"`
+	// SameSite is strict, lax or none
+	SameSite string `json:"same_site" split_words:"true"`
+	// Domain the cookies are sent to, the host of the request if empty
+	Domain string `json:"domain"`
+	// RefreshTokenKey names the cookie holding the refresh token, which is
+	// not kept in a cookie if empty
+	RefreshTokenKey string `json:"refresh_token_key" split_words:"true"`
+	// CSRFKey names the cookie holding the token clients echo in the
+	// X-CSRF-Token header of requests authenticated by cookie
+	CSRFKey string `json:"csrf_key" envconfig:"CSRF_KEY"`
+}
+
+// CORSConfiguration holds the origins allowed to make cross-origin requests.
+type CORSConfiguration struct {
+	// AllowedOrigins may contain one * wildcard each. Any origin is allowed
+	// without credentials if empty.
+	AllowedOrigins []string `json:"allowed_origins" split_words:"true"`
 }
 
 func loadEnvironment(filename string) error {
@@ -182,6 +337,223 @@ func (w *WebhookConfig) HasEvent(event string) bool {
 	return false
 }
 
+// TokenExchangeConfiguration holds the configuration of the token exchange grant (RFC 8693).
+type TokenExchangeConfiguration struct {
+	Enabled bool `json:"enabled"`
+	// Exp caps the lifetime of exchanged tokens in seconds, defaults to the JWT expiry
+	Exp      int                   `json:"exp"`
+	Policies TokenExchangePolicies `json:"policies"`
+}
+
+// TokenExchangePolicy allows tokens issued for the subject audiences to be
+// exchanged for tokens targeting one of the audiences.
+type TokenExchangePolicy struct {
+	Audiences []string `json:"audiences"`
+	// SubjectAudiences limits which subject tokens can be exchanged, any if empty
+	SubjectAudiences []string `json:"subject_audiences"`
+	// Actors are the emails of the users allowed to act on behalf of a subject.
+	// Without actors only the subject can exchange its own token.
+	Actors []string `json:"actors"`
+}
+
+// TokenExchangePolicies is decoded from a JSON array when set from the environment
+type TokenExchangePolicies []TokenExchangePolicy
+
+func (p *TokenExchangePolicies) Decode(value string) error {
+	return json.Unmarshal([]byte(value), p)
+}
+
+// Allows reports whether a subject token issued for subjectAud can be exchanged
+// for a token targeting aud, by the actor if one is given.
+func (t *TokenExchangeConfiguration) Allows(subjectAud string, aud string, actor string) bool {
+	for _, policy := range t.Policies {
+		if !contains(policy.Audiences, aud) {
+			continue
+		}
+		if len(policy.SubjectAudiences) > 0 && !contains(policy.SubjectAudiences, subjectAud) {
+			continue
+		}
+		if actor != "" && !contains(policy.Actors, actor) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// DeviceConfiguration holds the configuration of the device authorization grant (RFC 8628).
+type DeviceConfiguration struct {
+	Enabled bool `json:"enabled"`
+	// Exp is the lifetime of device and user codes in seconds
+	Exp int `json:"exp"`
+	// Interval is the minimum number of seconds devices wait between polls
+	Interval int `json:"interval"`
+	// VerificationURL is the page where users enter the user code, defaults to /device on the site URL
+	VerificationURL string `json:"verification_url" split_words:"true"`
+}
+
+// ImpersonationConfiguration holds the configuration of admin impersonation tokens.
+type ImpersonationConfiguration struct {
+	// Exp is the lifetime of impersonation tokens in seconds
+	Exp int `json:"exp"`
+	// Role lets admins holding it in their app metadata roles impersonate users,
+	// otherwise only super admins can
+	Role string `json:"role"`
+}
+
+// LockoutConfiguration holds how failed password sign ins slow down further
+// attempts for the same email and from the same IP address, until they are
+// locked out for a while.
+type LockoutConfiguration struct {
+	Enabled bool `json:"enabled"`
+	// MaxAttempts is the number of consecutive failures for an email after
+	// which it is locked
+	MaxAttempts int `json:"max_attempts" split_words:"true"`
+	// MaxIPAttempts is the number of consecutive failures from an IP address
+	// after which it is locked
+	MaxIPAttempts int `json:"max_ip_attempts" envconfig:"MAX_IP_ATTEMPTS"`
+	// Delay is the wait after the first failure, doubling with every further one
+	Delay time.Duration `json:"delay"`
+	// Duration is how long locks last. Failures older than that are forgotten.
+	Duration time.Duration `json:"duration"`
+	// Notify emails users when their account gets locked
+	Notify bool `json:"notify"`
+}
+
+// CaptchaConfiguration holds the captcha clients solve to sign up, recover a
+// password or sign in with a password.
+type CaptchaConfiguration struct {
+	Enabled bool `json:"enabled"`
+	// Provider is hcaptcha or turnstile
+	Provider string `json:"provider"`
+	// SiteKey is the public key of the site, advertised to clients
+	SiteKey string `json:"site_key" split_words:"true"`
+	Secret  string `json:"secret"`
+	// VerifyURL is the siteverify endpoint of the provider, the public one
+	// if empty
+	VerifyURL string `json:"verify_url" split_words:"true"`
+}
+
+// NotificationsConfiguration holds which security notifications users are
+// emailed.
+type NotificationsConfiguration struct {
+	// NewDevice notifies sign ins from devices the user did not sign in
+	// from before
+	NewDevice bool `json:"new_device" split_words:"true"`
+	// PasswordChanged notifies users that their password was changed
+	PasswordChanged bool `json:"password_changed" split_words:"true"`
+	// EmailChanged notifies the previous address of a user that their email
+	// was changed
+	EmailChanged bool `json:"email_changed" split_words:"true"`
+	// IdentityChanged notifies users that an external provider account was
+	// linked to or unlinked from them
+	IdentityChanged bool `json:"identity_changed" split_words:"true"`
+	// APIKeyCreated notifies users that an API key was created by them
+	APIKeyCreated bool `json:"api_key_created" envconfig:"API_KEY_CREATED"`
+}
+
+// PasswordConfiguration holds the requirements of passwords users set.
+type PasswordConfiguration struct {
+	MinLength int `json:"min_length" split_words:"true"`
+	MaxLength int `json:"max_length" split_words:"true"`
+	// RequiredCharacters are the classes of characters passwords contain
+	// at least one of: lower, upper, digit and symbol
+	RequiredCharacters []string `json:"required_characters" split_words:"true"`
+	// BannedWords may not be part of passwords, regardless of case
+	BannedWords []string `json:"banned_words" split_words:"true"`
+	// BanEmailAndSiteName bans the email of the user and the site name as words
+	BanEmailAndSiteName bool `json:"ban_email_and_site_name" split_words:"true"`
+	// BreachedHashesDir holds the SHA-1 hashes of breached passwords in files
+	// named after the first 5 hex digits of the hashes, with lines of the
+	// remaining digits and a count like the k-anonymity range API of Have I
+	// Been Pwned. Passwords are not screened if empty.
+	BreachedHashesDir string `json:"breached_hashes_dir" split_words:"true"`
+	// HistorySize is the number of last passwords users cannot set again
+	HistorySize int `json:"history_size" split_words:"true"`
+	// MaxAge is how long passwords can be used to sign in before they have to
+	// be reset, forever if 0
+	MaxAge time.Duration `json:"max_age" split_words:"true"`
+}
+
+// RateLimitConfiguration holds the request limits of endpoints.
+type RateLimitConfiguration struct {
+	Token            RateLimit `json:"token"`
+	Signup           RateLimit `json:"signup"`
+	Recover          RateLimit `json:"recover"`
+	Verify           RateLimit `json:"verify"`
+	InvitationVerify RateLimit `json:"invitation_verify" split_words:"true"`
+}
+
+// Endpoint returns the limit of the named endpoint.
+func (c *RateLimitConfiguration) Endpoint(name string) RateLimit {
+	switch name {
+	case "token":
+		return c.Token
+	case "signup":
+		return c.Signup
+	case "recover":
+		return c.Recover
+	case "verify":
+		return c.Verify
+	case "invitation_verify":
+		return c.InvitationVerify
+	}
+	return RateLimit{}
+}
+
+// RateLimit limits the requests to an endpoint.
+type RateLimit struct {
+	// Requests is the number of requests allowed per period, unlimited if 0
+	Requests int           `json:"requests"`
+	Period   time.Duration `json:"period"`
+	// By are what requests are counted by, each apart: ip, email or client
+	By []string `json:"by"`
+}
+
+// LDAPConfiguration holds the directory the password grant authenticates users
+// against. Users are searched with the service account and then bound as.
+type LDAPConfiguration struct {
+	Enabled bool `json:"enabled"`
+	// URL of the directory, ldap:// or ldaps://
+	URL                string `json:"url"`
+	StartTLS           bool   `json:"start_tls" split_words:"true"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify" split_words:"true"`
+	// BindDN and BindPassword are the service account searching users,
+	// searches are anonymous if empty
+	BindDN       string `json:"bind_dn" split_words:"true"`
+	BindPassword string `json:"bind_password" split_words:"true"`
+	BaseDN       string `json:"base_dn" split_words:"true"`
+	// UserFilter finds the user signing in, {username} is replaced with the
+	// escaped username
+	UserFilter     string `json:"user_filter" split_words:"true"`
+	EmailAttribute string `json:"email_attribute" split_words:"true"`
+	// Attributes maps user metadata keys to LDAP attributes
+	Attributes map[string]string `json:"attributes"`
+	// GroupAttribute holds the groups of users, e.g. memberOf
+	GroupAttribute string `json:"group_attribute" split_words:"true"`
+	// Roles maps group DNs to roles, the first group of a user found sets the role
+	Roles   LDAPRoleMapping `json:"roles"`
+	Timeout time.Duration   `json:"timeout"`
+}
+
+// LDAPRoleMapping maps group DNs to roles. It is read from JSON when set from
+// the environment, as DNs contain commas.
+type LDAPRoleMapping map[string]string
+
+// Decode reads the mapping from JSON when set from the environment
+func (m *LDAPRoleMapping) Decode(value string) error {
+	return json.Unmarshal([]byte(value), m)
+}
+
 // LoadGlobal loads configuration from file and environment variables.
 func LoadGlobal(filename string) (*GlobalConfiguration, error) {
 	if err := loadEnvironment(filename); err != nil {
@@ -214,9 +586,38 @@ func LoadConfig(filename string) (*Configuration, error) {
 		return nil, err
 	}
 	config.ApplyDefaults()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 	return config, nil
 }
 
+// Validate refuses settings the configured mailer cannot send the emails of.
+// The customer.io mailer only sends invitations.
+func (config *Configuration) Validate() error {
+	if config.Mailer.Type != "customerio" {
+		return nil
+	}
+	emails := []struct {
+		name    string
+		enabled bool
+	}{
+		{"secure email change", config.Mailer.SecureEmailChange},
+		{"lockout", config.Lockout.Notify},
+		{"new device", config.Notifications.NewDevice},
+		{"password changed", config.Notifications.PasswordChanged},
+		{"email changed", config.Notifications.EmailChanged},
+		{"identity changed", config.Notifications.IdentityChanged},
+		{"API key created", config.Notifications.APIKeyCreated},
+	}
+	for _, email := range emails {
+		if email.enabled {
+			return fmt.Errorf("The customerio mailer cannot send %s emails", email.name)
+		}
+	}
+	return nil
+}
+
 // ApplyDefaults sets defaults for a Configuration
 func (config *Configuration) ApplyDefaults() {
 	if config.JWT.AdminGroupName == "" {
@@ -239,6 +640,12 @@ func (config *Configuration) ApplyDefaults() {
 	if config.Mailer.URLPaths.EmailChange == "" {
 		config.Mailer.URLPaths.EmailChange = "/"
 	}
+	if config.Mailer.URLPaths.EmailChangeCurrent == "" {
+		config.Mailer.URLPaths.EmailChangeCurrent = config.Mailer.URLPaths.EmailChange
+	}
+	if config.Mailer.URLPaths.NewDevice == "" {
+		config.Mailer.URLPaths.NewDevice = "/"
+	}
 
 	if config.SMTP.MaxFrequency == 0 {
 		config.SMTP.MaxFrequency = 15 * time.Minute
@@ -252,6 +659,79 @@ func (config *Configuration) ApplyDefaults() {
 		config.Cookie.Duration = 86400
 	}
 
+	if config.Cookie.SameSite == "" {
+		config.Cookie.SameSite = "lax"
+	}
+
+	if config.Cookie.CSRFKey == "" {
+		config.Cookie.CSRFKey = "nf_csrf"
+	}
+
+	if config.TokenExchange.Exp == 0 {
+		config.TokenExchange.Exp = config.JWT.Exp
+	}
+
+	if config.Device.Exp == 0 {
+		config.Device.Exp = 900
+	}
+	if config.Device.Interval == 0 {
+		config.Device.Interval = 5
+	}
+	if config.Device.VerificationURL == "" {
+		config.Device.VerificationURL = strings.TrimRight(config.SiteURL, "/") + "/device"
+	}
+
+	if config.Impersonation.Exp == 0 {
+		config.Impersonation.Exp = 900
+	}
+
+	if config.Lockout.MaxAttempts == 0 {
+		config.Lockout.MaxAttempts = 5
+	}
+	if config.Lockout.MaxIPAttempts == 0 {
+		config.Lockout.MaxIPAttempts = 20
+	}
+	if config.Lockout.Delay == 0 {
+		config.Lockout.Delay = time.Second
+	}
+	if config.Lockout.Duration == 0 {
+		config.Lockout.Duration = 15 * time.Minute
+	}
+
+	if config.Password.MaxLength == 0 {
+		config.Password.MaxLength = 1024
+	}
+
+	if config.Captcha.Provider == "" {
+		config.Captcha.Provider = "hcaptcha"
+	}
+
+	if config.RateLimit.Token.Requests == 0 {
+		// the limit of /token from before limits were configurable
+		config.RateLimit.Token = RateLimit{Requests: 30, Period: 5 * time.Minute}
+	}
+	for _, limit := range []*RateLimit{&config.RateLimit.Token, &config.RateLimit.Signup, &config.RateLimit.Recover, &config.RateLimit.Verify, &config.RateLimit.InvitationVerify} {
+		if limit.Period == 0 {
+			limit.Period = time.Hour
+		}
+		if len(limit.By) == 0 {
+			limit.By = []string{"ip"}
+		}
+	}
+
+	if config.LDAP.UserFilter == "" {
+		config.LDAP.UserFilter = "(uid={username})"
+	}
+	if config.LDAP.EmailAttribute == "" {
+		config.LDAP.EmailAttribute = "mail"
+	}
+	if config.LDAP.GroupAttribute == "" {
+		config.LDAP.GroupAttribute = "memberOf"
+	}
+	if config.LDAP.Timeout == 0 {
+		config.LDAP.Timeout = 10 * time.Second
+	}
+
 	if config.TigrisWebsiteURL == "" {
 		config.TigrisWebsiteURL = "https://tigrisdata.com"
 	}
@@ -300,3 +780,54 @@ func (o *OAuthProviderConfiguration) Validate() error {
 	}
 	return nil
 }
+
+// AllowsTenant checks whether users of the tenant may sign in.
+func (o *MicrosoftProviderConfiguration) AllowsTenant(tenantID string) bool {
+	if len(o.AllowedTenants) == 0 {
+		return true
+	}
+	for _, t := range o.AllowedTenants {
+		if strings.EqualFold(t, tenantID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *AppleProviderConfiguration) Validate() error {
+	if !o.Enabled {
+		return errors.New("Provider is not enabled")
+	}
+	if o.ClientID == "" {
+		return errors.New("Missing Oauth client ID")
+	}
+	if o.TeamID == "" || o.KeyID == "" {
+		return errors.New("Missing Apple team or key ID")
+	}
+	if o.PrivateKey == "" {
+		return errors.New("Missing Apple private key")
+	}
+	if o.RedirectURI == "" {
+		return errors.New("Missing redirect URI")
+	}
+	return nil
+}
+
+func (o *OIDCProviderConfiguration) Validate() error {
+	if !o.Enabled {
+		return errors.New("Provider is not enabled")
+	}
+	if o.Issuer == "" {
+		return errors.New("Missing OIDC issuer")
+	}
+	if o.ClientID == "" {
+		return errors.New("Missing Oauth client ID")
+	}
+	if o.Secret == "" {
+		return errors.New("Missing Oauth secret")
+	}
+	if o.RedirectURI == "" {
+		return errors.New("Missing redirect URI")
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package conf
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/stretchr/testify/assert"
@@ -48,3 +49,208 @@ func TestTracing(t *testing.T) {
 	assert.Equal(t, "127.0.0.1", gc.Tracing.Host)
 	assert.Equal(t, map[string]string{"tag1": "value1", "tag2": "value2"}, gc.Tracing.Tags)
 }
+
+func TestTokenExchangePolicies(t *testing.T) {
+	os.Setenv("GOTRUE_SITE_URL", "http://localhost")
+	os.Setenv("GOTRUE_JWT_SECRET", "secret")
+	os.Setenv("GOTRUE_JWT_EXP", "3600")
+	os.Setenv("GOTRUE_TOKEN_EXCHANGE_ENABLED", "true")
+	os.Setenv("GOTRUE_TOKEN_EXCHANGE_POLICIES", `[{"audiences":["billing"],"subject_audiences":["api"],"actors":["svc@example.com"]}]`)
+
+	c, err := LoadConfig("")
+	require.NoError(t, err)
+	require.Len(t, c.TokenExchange.Policies, 1)
+	assert.Equal(t, 3600, c.TokenExchange.Exp)
+
+	assert.True(t, c.TokenExchange.Allows("api", "billing", ""))
+	assert.True(t, c.TokenExchange.Allows("api", "billing", "svc@example.com"))
+	assert.False(t, c.TokenExchange.Allows("api", "billing", "other@example.com"))
+	assert.False(t, c.TokenExchange.Allows("other", "billing", ""))
+	assert.False(t, c.TokenExchange.Allows("api", "api", ""))
+}
+
+func TestMicrosoftProvider(t *testing.T) {
+	os.Setenv("GOTRUE_SITE_URL", "http://localhost")
+	os.Setenv("GOTRUE_JWT_SECRET", "secret")
+	os.Setenv("GOTRUE_EXTERNAL_MICROSOFT_ENABLED", "true")
+	os.Setenv("GOTRUE_EXTERNAL_MICROSOFT_CLIENT_ID", "client-id")
+	os.Setenv("GOTRUE_EXTERNAL_MICROSOFT_TENANT", "organizations")
+	os.Setenv("GOTRUE_EXTERNAL_MICROSOFT_ALLOWED_TENANTS", "72f988bf-86f1-41af-91ab-2d7cd011db47,9122040d-6c67-4c5b-b112-36a304b66dad")
+
+	c, err := LoadConfig("")
+	require.NoError(t, err)
+	ms := c.External.Microsoft
+	assert.True(t, ms.Enabled)
+	assert.Equal(t, "client-id", ms.ClientID)
+	assert.Equal(t, "organizations", ms.Tenant)
+
+	assert.True(t, ms.AllowsTenant("9122040D-6C67-4C5B-B112-36A304B66DAD"))
+	assert.False(t, ms.AllowsTenant("f8cdef31-a31e-4b4a-93e4-5f571e91255a"))
+}
+
+func TestProviderTokens(t *testing.T) {
+	os.Setenv("GOTRUE_SITE_URL", "http://localhost")
+	os.Setenv("GOTRUE_JWT_SECRET", "secret")
+	os.Setenv("GOTRUE_EXTERNAL_TOKENS_AUDIENCES", "github-sync,backend")
+
+	c, err := LoadConfig("")
+	require.NoError(t, err)
+	// audiences are only trusted once provider tokens are kept
+	assert.False(t, c.External.Tokens.Trusts("backend"))
+
+	c.External.Tokens.Enabled = true
+	assert.True(t, c.External.Tokens.Trusts("backend"))
+	assert.False(t, c.External.Tokens.Trusts(c.JWT.Aud))
+}
+
+func TestSamlAttributeMapping(t *testing.T) {
+	os.Setenv("GOTRUE_SITE_URL", "http://localhost")
+	os.Setenv("GOTRUE_JWT_SECRET", "secret")
+	os.Setenv("GOTRUE_EXTERNAL_SAML_ATTRIBUTE_MAPPING", `{"email":"mail","metadata":{"full_name":"displayName"},"role":"groups","roles":{"admins":"admin"}}`)
+	defer os.Unsetenv("GOTRUE_EXTERNAL_SAML_ATTRIBUTE_MAPPING")
+
+	c, err := LoadConfig("")
+	require.NoError(t, err)
+	mapping := c.External.Saml.AttributeMapping
+	assert.Equal(t, "mail", mapping.Email)
+	assert.Equal(t, map[string]string{"full_name": "displayName"}, mapping.Metadata)
+	assert.Equal(t, "groups", mapping.Role)
+	assert.Equal(t, "admin", mapping.Roles["admins"])
+}
+
+func TestLDAPConfiguration(t *testing.T) {
+	os.Setenv("GOTRUE_SITE_URL", "http://localhost")
+	os.Setenv("GOTRUE_JWT_SECRET", "secret")
+	os.Setenv("GOTRUE_LDAP_ATTRIBUTES", "full_name:displayName")
+	os.Setenv("GOTRUE_LDAP_ROLES", `{"cn=admins,ou=groups,dc=example,dc=com":"admin"}`)
+	defer os.Unsetenv("GOTRUE_LDAP_ATTRIBUTES")
+	defer os.Unsetenv("GOTRUE_LDAP_ROLES")
+
+	c, err := LoadConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, "(uid={username})", c.LDAP.UserFilter)
+	assert.Equal(t, "mail", c.LDAP.EmailAttribute)
+	assert.Equal(t, "memberOf", c.LDAP.GroupAttribute)
+	assert.Equal(t, map[string]string{"full_name": "displayName"}, c.LDAP.Attributes)
+	assert.Equal(t, "admin", c.LDAP.Roles["cn=admins,ou=groups,dc=example,dc=com"])
+}
+
+func TestLockoutConfiguration(t *testing.T) {
+	os.Setenv("GOTRUE_SITE_URL", "http://localhost")
+	os.Setenv("GOTRUE_JWT_SECRET", "secret")
+	os.Setenv("GOTRUE_LOCKOUT_ENABLED", "true")
+	os.Setenv("GOTRUE_LOCKOUT_MAX_IP_ATTEMPTS", "50")
+	defer os.Unsetenv("GOTRUE_LOCKOUT_ENABLED")
+	defer os.Unsetenv("GOTRUE_LOCKOUT_MAX_IP_ATTEMPTS")
+
+	c, err := LoadConfig("")
+	require.NoError(t, err)
+	assert.True(t, c.Lockout.Enabled)
+	assert.Equal(t, 5, c.Lockout.MaxAttempts)
+	assert.Equal(t, 50, c.Lockout.MaxIPAttempts)
+	assert.Equal(t, time.Second, c.Lockout.Delay)
+	assert.Equal(t, 15*time.Minute, c.Lockout.Duration)
+}
+
+func TestRateLimitConfiguration(t *testing.T) {
+	os.Setenv("GOTRUE_SITE_URL", "http://localhost")
+	os.Setenv("GOTRUE_JWT_SECRET", "secret")
+	os.Setenv("GOTRUE_RATE_LIMIT_SIGNUP_REQUESTS", "10")
+	os.Setenv("GOTRUE_RATE_LIMIT_INVITATION_VERIFY_REQUESTS", "5")
+	os.Setenv("GOTRUE_RATE_LIMIT_INVITATION_VERIFY_BY", "ip,email")
+	defer os.Unsetenv("GOTRUE_RATE_LIMIT_SIGNUP_REQUESTS")
+	defer os.Unsetenv("GOTRUE_RATE_LIMIT_INVITATION_VERIFY_REQUESTS")
+	defer os.Unsetenv("GOTRUE_RATE_LIMIT_INVITATION_VERIFY_BY")
+
+	c, err := LoadConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, RateLimit{Requests: 30, Period: 5 * time.Minute, By: []string{"ip"}}, c.RateLimit.Endpoint("token"))
+	assert.Equal(t, RateLimit{Requests: 10, Period: time.Hour, By: []string{"ip"}}, c.RateLimit.Endpoint("signup"))
+	assert.Equal(t, RateLimit{Requests: 5, Period: time.Hour, By: []string{"ip", "email"}}, c.RateLimit.Endpoint("invitation_verify"))
+	assert.Equal(t, 0, c.RateLimit.Endpoint("recover").Requests)
+}
+
+func TestPasswordConfiguration(t *testing.T) {
+	os.Setenv("GOTRUE_SITE_URL", "http://localhost")
+	os.Setenv("GOTRUE_JWT_SECRET", "secret")
+	os.Setenv("GOTRUE_PASSWORD_MIN_LENGTH", "10")
+	os.Setenv("GOTRUE_PASSWORD_REQUIRED_CHARACTERS", "lower,digit")
+	defer os.Unsetenv("GOTRUE_PASSWORD_MIN_LENGTH")
+	os.Setenv("GOTRUE_PASSWORD_HISTORY_SIZE", "5")
+	os.Setenv("GOTRUE_PASSWORD_MAX_AGE", "2160h")
+	defer os.Unsetenv("GOTRUE_PASSWORD_REQUIRED_CHARACTERS")
+	defer os.Unsetenv("GOTRUE_PASSWORD_HISTORY_SIZE")
+	defer os.Unsetenv("GOTRUE_PASSWORD_MAX_AGE")
+
+	c, err := LoadConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, 10, c.Password.MinLength)
+	assert.Equal(t, 1024, c.Password.MaxLength)
+	assert.Equal(t, []string{"lower", "digit"}, c.Password.RequiredCharacters)
+	assert.Equal(t, 5, c.Password.HistorySize)
+	assert.Equal(t, 90*24*time.Hour, c.Password.MaxAge)
+}
+
+func TestCaptchaConfiguration(t *testing.T) {
+	os.Setenv("GOTRUE_SITE_URL", "http://localhost")
+	os.Setenv("GOTRUE_JWT_SECRET", "secret")
+	os.Setenv("GOTRUE_CAPTCHA_ENABLED", "true")
+	os.Setenv("GOTRUE_CAPTCHA_SITE_KEY", "site-key")
+	os.Setenv("GOTRUE_CAPTCHA_VERIFY_URL", "http://localhost:8081/siteverify")
+	defer os.Unsetenv("GOTRUE_CAPTCHA_ENABLED")
+	defer os.Unsetenv("GOTRUE_CAPTCHA_SITE_KEY")
+	defer os.Unsetenv("GOTRUE_CAPTCHA_VERIFY_URL")
+
+	c, err := LoadConfig("")
+	require.NoError(t, err)
+	assert.True(t, c.Captcha.Enabled)
+	assert.Equal(t, "hcaptcha", c.Captcha.Provider)
+	assert.Equal(t, "site-key", c.Captcha.SiteKey)
+	assert.Equal(t, "http://localhost:8081/siteverify", c.Captcha.VerifyURL)
+}
+
+func TestRedirectURLsConfiguration(t *testing.T) {
+	os.Setenv("GOTRUE_SITE_URL", "http://localhost")
+	os.Setenv("GOTRUE_JWT_SECRET", "secret")
+	os.Setenv("GOTRUE_REDIRECT_URLS", "https://app.example.com/**,http://localhost:3000/*")
+	defer os.Unsetenv("GOTRUE_REDIRECT_URLS")
+
+	c, err := LoadConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://app.example.com/**", "http://localhost:3000/*"}, c.RedirectURLs)
+}
+
+func TestCookieConfiguration(t *testing.T) {
+	os.Setenv("GOTRUE_SITE_URL", "http://localhost")
+	os.Setenv("GOTRUE_JWT_SECRET", "secret")
+	os.Setenv("GOTRUE_COOKIE_DOMAIN", "example.com")
+	os.Setenv("GOTRUE_COOKIE_REFRESH_TOKEN_KEY", "nf_refresh")
+	os.Setenv("GOTRUE_CORS_ALLOWED_ORIGINS", "https://app.example.com,https://*.example.org")
+	defer os.Unsetenv("GOTRUE_COOKIE_DOMAIN")
+	defer os.Unsetenv("GOTRUE_COOKIE_REFRESH_TOKEN_KEY")
+	defer os.Unsetenv("GOTRUE_CORS_ALLOWED_ORIGINS")
+
+	c, err := LoadConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, "nf_jwt", c.Cookie.Key)
+	assert.Equal(t, "lax", c.Cookie.SameSite)
+	assert.Equal(t, "example.com", c.Cookie.Domain)
+	assert.Equal(t, "nf_refresh", c.Cookie.RefreshTokenKey)
+	assert.Equal(t, "nf_csrf", c.Cookie.CSRFKey)
+	assert.Equal(t, []string{"https://app.example.com", "https://*.example.org"}, c.CORS.AllowedOrigins)
+}
+
+func TestCustomerIOMailerConfiguration(t *testing.T) {
+	os.Setenv("GOTRUE_SITE_URL", "http://localhost")
+	os.Setenv("GOTRUE_JWT_SECRET", "secret")
+	os.Setenv("GOTRUE_MAILER_TYPE", "customerio")
+	defer os.Unsetenv("GOTRUE_MAILER_TYPE")
+
+	_, err := LoadConfig("")
+	require.NoError(t, err)
+
+	os.Setenv("GOTRUE_MAILER_SECURE_EMAIL_CHANGE", "true")
+	defer os.Unsetenv("GOTRUE_MAILER_SECURE_EMAIL_CHANGE")
+	_, err = LoadConfig("")
+	assert.EqualError(t, err, "The customerio mailer cannot send secure email change emails")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"time"
 
 	"github.com/customerio/go-customerio"
 	"github.com/tigrisdata/gotrue/conf"
@@ -69,6 +70,44 @@ func (m *CustomerIOMailer) EmailChangeMail(user *models.User, referrerURL string
 	return nil
 }
 
+func (m *CustomerIOMailer) EmailChangeCurrentMail(user *models.User, referrerURL string) error {
+	return errUnsupportedMail("secure email change")
+}
+
+func (m *CustomerIOMailer) LockoutMail(user *models.User, lockedUntil time.Time) error {
+	return errUnsupportedMail("lockout")
+}
+
+func (m *CustomerIOMailer) NewDeviceMail(user *models.User, device *models.KnownDevice, rejectToken, referrerURL string) error {
+	return errUnsupportedMail("new device")
+}
+
+func (m *CustomerIOMailer) PasswordChangedMail(user *models.User) error {
+	return errUnsupportedMail("password changed")
+}
+
+func (m *CustomerIOMailer) EmailChangedMail(user *models.User, oldEmail string) error {
+	return errUnsupportedMail("email changed")
+}
+
+func (m *CustomerIOMailer) IdentityLinkedMail(user *models.User, provider string) error {
+	return errUnsupportedMail("identity linked")
+}
+
+func (m *CustomerIOMailer) IdentityUnlinkedMail(user *models.User, provider string) error {
+	return errUnsupportedMail("identity unlinked")
+}
+
+func (m *CustomerIOMailer) APIKeyCreatedMail(user *models.User, key *models.User) error {
+	return errUnsupportedMail("API key created")
+}
+
+// errUnsupportedMail is returned for emails there is no customer.io template
+// for. Configurations enabling them are refused when loaded.
+func errUnsupportedMail(kind string) error {
+	return fmt.Errorf("customerio mailer cannot send %s emails", kind)
+}
+
 func (m CustomerIOMailer) Send(user *models.User, subject, body string, data map[string]interface{}) error {
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/url"
 	"regexp"
+	"time"
 
 	"github.com/customerio/go-customerio"
 	"github.com/tigrisdata/gotrue/conf"
@@ -27,6 +28,14 @@ type Mailer interface {
 	ConfirmationMail(user *models.User, referrerURL string) error
 	RecoveryMail(user *models.User, referrerURL string) error
 	EmailChangeMail(user *models.User, referrerURL string) error
+	EmailChangeCurrentMail(user *models.User, referrerURL string) error
+	LockoutMail(user *models.User, lockedUntil time.Time) error
+	NewDeviceMail(user *models.User, device *models.KnownDevice, rejectToken, referrerURL string) error
+	PasswordChangedMail(user *models.User) error
+	EmailChangedMail(user *models.User, oldEmail string) error
+	IdentityLinkedMail(user *models.User, provider string) error
+	IdentityUnlinkedMail(user *models.User, provider string) error
+	APIKeyCreatedMail(user *models.User, key *models.User) error
 	ValidateEmail(email string) error
 }
 
@@ -1,6 +1,10 @@
 package mailer
 
-import "github.com/tigrisdata/gotrue/models"
+import (
+	"time"
+
+	"github.com/tigrisdata/gotrue/models"
+)
 
 type noopMailer struct {
 }
@@ -29,6 +33,38 @@ func (m *noopMailer) EmailChangeMail(user *models.User, referrerURL string) erro
 	return nil
 }
 
+func (m *noopMailer) EmailChangeCurrentMail(user *models.User, referrerURL string) error {
+	return nil
+}
+
+func (m *noopMailer) LockoutMail(user *models.User, lockedUntil time.Time) error {
+	return nil
+}
+
+func (m *noopMailer) NewDeviceMail(user *models.User, device *models.KnownDevice, rejectToken, referrerURL string) error {
+	return nil
+}
+
+func (m *noopMailer) PasswordChangedMail(user *models.User) error {
+	return nil
+}
+
+func (m *noopMailer) EmailChangedMail(user *models.User, oldEmail string) error {
+	return nil
+}
+
+func (m *noopMailer) IdentityLinkedMail(user *models.User, provider string) error {
+	return nil
+}
+
+func (m *noopMailer) IdentityUnlinkedMail(user *models.User, provider string) error {
+	return nil
+}
+
+func (m *noopMailer) APIKeyCreatedMail(user *models.User, key *models.User) error {
+	return nil
+}
+
 func (m noopMailer) Send(user *models.User, subject, body string, data map[string]interface{}) error {
 	return nil
 }
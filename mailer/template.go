@@ -2,6 +2,7 @@ package mailer
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/badoux/checkmail"
 	"github.com/tigrisdata/gotrue/conf"
@@ -41,6 +42,51 @@ const defaultEmailChangeMail = `<h2>Confirm email address change</h2>
 <p>Follow this link to confirm the update of your email address from {{ .Email }} to {{ .NewEmail }}:</p>
 <p><a href="{{ .ConfirmationURL }}">Change email address</a></p>`
 
+const defaultEmailChangeCurrentMail = `<h2>Confirm email address change</h2>
+
+<p>A change of the email address of your account on {{ .SiteURL }} from {{ .Email }} to {{ .NewEmail }} was requested.
+Follow this link to confirm it:</p>
+<p><a href="{{ .ConfirmationURL }}">Change email address</a></p>
+<p>If this wasn't you, do not follow the link and reset your password right away.</p>`
+
+const defaultLockoutMail = `<h2>Your account has been locked</h2>
+
+<p>There were too many failed attempts to sign in to your account {{ .Email }} on {{ .SiteURL }}.
+Signing in is locked until {{ .LockedUntil }}.</p>
+<p>If this wasn't you, someone may be trying to guess your password. Consider resetting it.</p>`
+
+const defaultNewDeviceMail = `<h2>New sign in to your account</h2>
+
+<p>Your account {{ .Email }} on {{ .SiteURL }} was signed in to from a new device on {{ .SignedInAt }}:</p>
+<p>{{ .UserAgent }}<br>IP address {{ .IPPrefix }}</p>
+<p>If this was you, you can ignore this email. If it wasn't, follow this link to sign out everywhere and reset your password:</p>
+<p><a href="{{ .RejectURL }}">This wasn't me</a></p>`
+
+const defaultPasswordChangedMail = `<h2>Your password has been changed</h2>
+
+<p>The password of your account {{ .Email }} on {{ .SiteURL }} was changed on {{ .ChangedAt }}.</p>
+<p>If this wasn't you, reset your password right away and contact us.</p>`
+
+const defaultEmailChangedMail = `<h2>Your email address has been changed</h2>
+
+<p>The email address of your account on {{ .SiteURL }} was changed from {{ .OldEmail }} to {{ .NewEmail }} on {{ .ChangedAt }}.</p>
+<p>If this wasn't you, contact us right away.</p>`
+
+const defaultIdentityLinkedMail = `<h2>A sign in method has been added</h2>
+
+<p>Your {{ .Provider }} account can now be used to sign in to your account {{ .Email }} on {{ .SiteURL }}.</p>
+<p>If this wasn't you, reset your password right away and contact us.</p>`
+
+const defaultIdentityUnlinkedMail = `<h2>A sign in method has been removed</h2>
+
+<p>Your {{ .Provider }} account can no longer be used to sign in to your account {{ .Email }} on {{ .SiteURL }}.</p>
+<p>If this wasn't you, reset your password right away and contact us.</p>`
+
+const defaultAPIKeyCreatedMail = `<h2>A new API key has been created</h2>
+
+<p>The API key {{ .KeyName }} was created for your account {{ .Email }} on {{ .SiteURL }} on {{ .CreatedAt }}.</p>
+<p>If this wasn't you, delete the key and reset your password right away.</p>`
+
 // ValidateEmail returns nil if the email is valid,
 // otherwise an error indicating the reason it is invalid
 func (m TemplateMailer) ValidateEmail(email string) error {
@@ -135,6 +181,31 @@ func (m *TemplateMailer) EmailChangeMail(user *models.User, referrerURL string)
 	)
 }
 
+// EmailChangeCurrentMail sends the confirmation of a secure email change to
+// the current address of a user
+func (m *TemplateMailer) EmailChangeCurrentMail(user *models.User, referrerURL string) error {
+	url, err := getSiteURL(referrerURL, m.Config.SiteURL, m.Config.Mailer.URLPaths.EmailChangeCurrent, "email_change_token="+user.EmailChangeTokenCurrent)
+	if err != nil {
+		return err
+	}
+	data := map[string]interface{}{
+		"SiteURL":         m.Config.SiteURL,
+		"ConfirmationURL": url,
+		"Email":           user.Email,
+		"NewEmail":        user.EmailChange,
+		"Token":           user.EmailChangeTokenCurrent,
+		"Data":            user.UserMetaData,
+	}
+
+	return m.Mailer.Mail(
+		user.Email,
+		withDefault(m.Config.Mailer.Subjects.EmailChangeCurrent, "Confirm Email Change"),
+		enforceRelativeURL(m.Config.Mailer.Templates.EmailChangeCurrent),
+		defaultEmailChangeCurrentMail,
+		data,
+	)
+}
+
 // RecoveryMail sends a password recovery mail
 func (m *TemplateMailer) RecoveryMail(user *models.User, referrerURL string) error {
 	url, err := getSiteURL(referrerURL, m.Config.SiteURL, m.Config.Mailer.URLPaths.Recovery, "recovery_token="+user.RecoveryToken)
@@ -158,6 +229,157 @@ func (m *TemplateMailer) RecoveryMail(user *models.User, referrerURL string) err
 	)
 }
 
+// LockoutMail tells a user that signing in to their account has been locked
+// after too many failed attempts
+func (m *TemplateMailer) LockoutMail(user *models.User, lockedUntil time.Time) error {
+	data := map[string]interface{}{
+		"SiteURL":     m.Config.SiteURL,
+		"Email":       user.Email,
+		"LockedUntil": lockedUntil.UTC().Format(time.RFC1123),
+		"Data":        user.UserMetaData,
+	}
+
+	return m.Mailer.Mail(
+		user.Email,
+		withDefault(m.Config.Mailer.Subjects.Lockout, "Your Account Has Been Locked"),
+		enforceRelativeURL(m.Config.Mailer.Templates.Lockout),
+		defaultLockoutMail,
+		data,
+	)
+}
+
+// NewDeviceMail tells a user that their account was signed in to from a
+// device they did not use before. rejectToken rejects the device.
+func (m *TemplateMailer) NewDeviceMail(user *models.User, device *models.KnownDevice, rejectToken, referrerURL string) error {
+	url, err := getSiteURL(referrerURL, m.Config.SiteURL, m.Config.Mailer.URLPaths.NewDevice, "reject_device_token="+rejectToken)
+	if err != nil {
+		return err
+	}
+	data := map[string]interface{}{
+		"SiteURL":    m.Config.SiteURL,
+		"RejectURL":  url,
+		"Email":      user.Email,
+		"Token":      rejectToken,
+		"IPPrefix":   device.IPPrefix,
+		"UserAgent":  device.UserAgent,
+		"SignedInAt": device.CreatedAt.UTC().Format(time.RFC1123),
+		"Data":       user.UserMetaData,
+	}
+
+	return m.Mailer.Mail(
+		user.Email,
+		withDefault(m.Config.Mailer.Subjects.NewDevice, "New Sign In to Your Account"),
+		enforceRelativeURL(m.Config.Mailer.Templates.NewDevice),
+		defaultNewDeviceMail,
+		data,
+	)
+}
+
+// PasswordChangedMail tells a user that their password was changed
+func (m *TemplateMailer) PasswordChangedMail(user *models.User) error {
+	data := map[string]interface{}{
+		"SiteURL":   m.Config.SiteURL,
+		"Email":     user.Email,
+		"ChangedAt": time.Now().UTC().Format(time.RFC1123),
+		"Data":      user.UserMetaData,
+	}
+
+	return m.Mailer.Mail(
+		user.Email,
+		withDefault(m.Config.Mailer.Subjects.PasswordChanged, "Your Password Has Been Changed"),
+		enforceRelativeURL(m.Config.Mailer.Templates.PasswordChanged),
+		defaultPasswordChangedMail,
+		data,
+	)
+}
+
+// EmailChangedMail tells the previous address of a user that their email was
+// changed
+func (m *TemplateMailer) EmailChangedMail(user *models.User, oldEmail string) error {
+	data := map[string]interface{}{
+		"SiteURL":   m.Config.SiteURL,
+		"Email":     oldEmail,
+		"OldEmail":  oldEmail,
+		"NewEmail":  user.Email,
+		"ChangedAt": time.Now().UTC().Format(time.RFC1123),
+		"Data":      user.UserMetaData,
+	}
+
+	return m.Mailer.Mail(
+		oldEmail,
+		withDefault(m.Config.Mailer.Subjects.EmailChanged, "Your Email Address Has Been Changed"),
+		enforceRelativeURL(m.Config.Mailer.Templates.EmailChanged),
+		defaultEmailChangedMail,
+		data,
+	)
+}
+
+// IdentityLinkedMail tells a user that an external provider account was
+// linked to them
+func (m *TemplateMailer) IdentityLinkedMail(user *models.User, provider string) error {
+	data := map[string]interface{}{
+		"SiteURL":  m.Config.SiteURL,
+		"Email":    user.Email,
+		"Provider": provider,
+		"Data":     user.UserMetaData,
+	}
+
+	return m.Mailer.Mail(
+		user.Email,
+		withDefault(m.Config.Mailer.Subjects.IdentityLinked, "A Sign In Method Has Been Added"),
+		enforceRelativeURL(m.Config.Mailer.Templates.IdentityLinked),
+		defaultIdentityLinkedMail,
+		data,
+	)
+}
+
+// IdentityUnlinkedMail tells a user that an external provider account was
+// unlinked from them
+func (m *TemplateMailer) IdentityUnlinkedMail(user *models.User, provider string) error {
+	data := map[string]interface{}{
+		"SiteURL":  m.Config.SiteURL,
+		"Email":    user.Email,
+		"Provider": provider,
+		"Data":     user.UserMetaData,
+	}
+
+	return m.Mailer.Mail(
+		user.Email,
+		withDefault(m.Config.Mailer.Subjects.IdentityUnlinked, "A Sign In Method Has Been Removed"),
+		enforceRelativeURL(m.Config.Mailer.Templates.IdentityUnlinked),
+		defaultIdentityUnlinkedMail,
+		data,
+	)
+}
+
+// APIKeyCreatedMail tells a user that an API key was created by them
+func (m *TemplateMailer) APIKeyCreatedMail(user *models.User, key *models.User) error {
+	keyName := key.Email
+	if key.AppMetaData != nil && key.AppMetaData.Name != "" {
+		keyName = key.AppMetaData.Name
+	}
+	createdAt := time.Now()
+	if key.CreatedAt != nil {
+		createdAt = *key.CreatedAt
+	}
+	data := map[string]interface{}{
+		"SiteURL":   m.Config.SiteURL,
+		"Email":     user.Email,
+		"KeyName":   keyName,
+		"KeyID":     key.Email,
+		"CreatedAt": createdAt.UTC().Format(time.RFC1123),
+		"Data":      user.UserMetaData,
+	}
+
+	return m.Mailer.Mail(
+		user.Email,
+		withDefault(m.Config.Mailer.Subjects.APIKeyCreated, "A New API Key Has Been Created"),
+		enforceRelativeURL(m.Config.Mailer.Templates.APIKeyCreated),
+		defaultAPIKeyCreatedMail,
+		data,
+	)
+}
+
 // Send can be used to send one-off emails to users
 func (m TemplateMailer) Send(user *models.User, subject, body string, data map[string]interface{}) error {
 	return m.Mailer.Mail(
@@ -7,8 +7,8 @@ import (
 	"strings"
 
 	"github.com/google/uuid"
-	"github.com/tigrisdata/gotrue/storage/namespace"
 	"github.com/pkg/errors"
+	"github.com/tigrisdata/gotrue/storage/namespace"
 	"github.com/tigrisdata/tigris-client-go/filter"
 	"github.com/tigrisdata/tigris-client-go/tigris"
 )
@@ -23,10 +23,33 @@ const (
 	UserSignedUpAction          AuditAction = "user_signedup"
 	UserInvitedAction           AuditAction = "user_invited"
 	UserDeletedAction           AuditAction = "user_deleted"
+	UserBannedAction            AuditAction = "user_banned"
+	UserUnbannedAction          AuditAction = "user_unbanned"
+	UserLockedAction            AuditAction = "user_locked"
+	UserUnlockedAction          AuditAction = "user_unlocked"
 	UserModifiedAction          AuditAction = "user_modified"
 	UserRecoveryRequestedAction AuditAction = "user_recovery_requested"
 	TokenRevokedAction          AuditAction = "token_revoked"
 	TokenRefreshedAction        AuditAction = "token_refreshed"
+	SessionRevokedAction        AuditAction = "session_revoked"
+	TokenExchangedAction        AuditAction = "token_exchanged"
+	DeviceApprovedAction        AuditAction = "device_approved"
+	DeviceDeniedAction          AuditAction = "device_denied"
+	DeviceRejectedAction        AuditAction = "device_rejected"
+	UserImpersonatedAction      AuditAction = "user_impersonated"
+	IdentityLinkedAction        AuditAction = "identity_linked"
+	IdentityUnlinkedAction      AuditAction = "identity_unlinked"
+	SSOProviderCreatedAction    AuditAction = "sso_provider_created"
+	SSOProviderModifiedAction   AuditAction = "sso_provider_modified"
+	SSOProviderDeletedAction    AuditAction = "sso_provider_deleted"
+	SCIMTokenCreatedAction      AuditAction = "scim_token_created"
+	SCIMTokenDeletedAction      AuditAction = "scim_token_deleted"
+	SCIMUserCreatedAction       AuditAction = "scim_user_created"
+	SCIMUserModifiedAction      AuditAction = "scim_user_modified"
+	SCIMUserDeletedAction       AuditAction = "scim_user_deleted"
+	SCIMGroupCreatedAction      AuditAction = "scim_group_created"
+	SCIMGroupModifiedAction     AuditAction = "scim_group_modified"
+	SCIMGroupDeletedAction      AuditAction = "scim_group_deleted"
 
 	account auditLogType = "account"
 	team    auditLogType = "team"
@@ -41,8 +64,31 @@ var actionLogTypeMap = map[AuditAction]auditLogType{
 	UserSignedUpAction:          team,
 	UserInvitedAction:           team,
 	UserDeletedAction:           team,
+	UserBannedAction:            team,
+	UserUnbannedAction:          team,
+	UserLockedAction:            account,
+	UserUnlockedAction:          team,
+	UserImpersonatedAction:      team,
+	SSOProviderCreatedAction:    team,
+	SSOProviderModifiedAction:   team,
+	SSOProviderDeletedAction:    team,
+	SCIMTokenCreatedAction:      team,
+	SCIMTokenDeletedAction:      team,
+	SCIMUserCreatedAction:       team,
+	SCIMUserModifiedAction:      team,
+	SCIMUserDeletedAction:       team,
+	SCIMGroupCreatedAction:      team,
+	SCIMGroupModifiedAction:     team,
+	SCIMGroupDeletedAction:      team,
 	TokenRevokedAction:          token,
 	TokenRefreshedAction:        token,
+	SessionRevokedAction:        token,
+	TokenExchangedAction:        token,
+	DeviceApprovedAction:        account,
+	DeviceDeniedAction:          account,
+	DeviceRejectedAction:        account,
+	IdentityLinkedAction:        account,
+	IdentityUnlinkedAction:      account,
 	UserModifiedAction:          user,
 	UserRecoveryRequestedAction: user,
 }
@@ -48,5 +48,41 @@ func TruncateAll(database *tigris.Database) error {
 	if _, err := tigris.GetCollection[Invitation](database).DeleteAll(ctx); err != nil {
 		return err
 	}
+	if _, err := tigris.GetCollection[RevokedToken](database).DeleteAll(ctx); err != nil {
+		return err
+	}
+	if _, err := tigris.GetCollection[DeviceCode](database).DeleteAll(ctx); err != nil {
+		return err
+	}
+	if _, err := tigris.GetCollection[OAuthState](database).DeleteAll(ctx); err != nil {
+		return err
+	}
+	if _, err := tigris.GetCollection[Identity](database).DeleteAll(ctx); err != nil {
+		return err
+	}
+	if _, err := tigris.GetCollection[ProviderToken](database).DeleteAll(ctx); err != nil {
+		return err
+	}
+	if _, err := tigris.GetCollection[SSOProvider](database).DeleteAll(ctx); err != nil {
+		return err
+	}
+	if _, err := tigris.GetCollection[SAMLAssertion](database).DeleteAll(ctx); err != nil {
+		return err
+	}
+	if _, err := tigris.GetCollection[SCIMToken](database).DeleteAll(ctx); err != nil {
+		return err
+	}
+	if _, err := tigris.GetCollection[SCIMGroup](database).DeleteAll(ctx); err != nil {
+		return err
+	}
+	if _, err := tigris.GetCollection[LoginFailure](database).DeleteAll(ctx); err != nil {
+		return err
+	}
+	if _, err := tigris.GetCollection[PasswordHistoryEntry](database).DeleteAll(ctx); err != nil {
+		return err
+	}
+	if _, err := tigris.GetCollection[KnownDevice](database).DeleteAll(ctx); err != nil {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,205 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/tigrisdata/gotrue/crypto"
+	"github.com/tigrisdata/gotrue/storage/namespace"
+	"github.com/tigrisdata/tigris-client-go/fields"
+	"github.com/tigrisdata/tigris-client-go/filter"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+// userCodeCharset has no vowels to avoid spelling words, and no characters that
+// are easily confused with each other (RFC 8628 section 6.1).
+const userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+const userCodeLength = 8
+
+// DeviceCode is a pending device authorization (RFC 8628). The device polls
+// with DeviceCode until a signed in user approves or denies UserCode.
+type DeviceCode struct {
+	ID         uuid.UUID `json:"id" db:"id" tigris:"primaryKey"`
+	InstanceID uuid.UUID `json:"instance_id" db:"instance_id" tigris:"index"`
+
+	DeviceCode string `json:"device_code" db:"device_code" tigris:"index"`
+	UserCode   string `json:"user_code" db:"user_code" tigris:"index"`
+	ClientID   string `json:"client_id" db:"client_id"`
+	Aud        string `json:"aud" db:"aud"`
+
+	// UserID is set once a user approved the device
+	UserID     uuid.UUID  `json:"user_id" db:"user_id"`
+	ApprovedAt *time.Time `json:"approved_at,omitempty" db:"approved_at"`
+	DeniedAt   *time.Time `json:"denied_at,omitempty" db:"denied_at"`
+
+	// Interval is the minimum number of seconds between two polls
+	Interval     int        `json:"interval" db:"interval"`
+	LastPolledAt *time.Time `json:"last_polled_at,omitempty" db:"last_polled_at"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at" tigris:"index"`
+}
+
+func (DeviceCode) TableName() string {
+	tableName := "device_codes"
+
+	if namespace.GetNamespace() != "" {
+		return namespace.GetNamespace() + "_" + tableName
+	}
+
+	return tableName
+}
+
+// DeviceCodeNotFoundError represents when a device code is not found.
+type DeviceCodeNotFoundError struct{}
+
+func (e DeviceCodeNotFoundError) Error() string {
+	return "Device code not found"
+}
+
+// NewDeviceCode creates a device authorization that expires after exp.
+func NewDeviceCode(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, clientID string, aud string, interval int, exp time.Duration) (*DeviceCode, error) {
+	userCode, err := generateUserCode()
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating user code")
+	}
+
+	now := time.Now().UTC()
+	code := &DeviceCode{
+		ID:         uuid.New(),
+		InstanceID: instanceID,
+		DeviceCode: crypto.SecureToken(),
+		UserCode:   userCode,
+		ClientID:   clientID,
+		Aud:        aud,
+		Interval:   interval,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(exp),
+	}
+
+	c := tigris.GetCollection[DeviceCode](database)
+	if _, err := c.Insert(ctx, code); err != nil {
+		return nil, errors.Wrap(err, "error creating device code")
+	}
+
+	_, err = c.Delete(ctx, filter.Lt("expires_at", now))
+	return code, errors.Wrap(err, "Database error purging expired device codes")
+}
+
+// FormattedUserCode returns the user code the way it is shown to users.
+func (d *DeviceCode) FormattedUserCode() string {
+	return d.UserCode[:userCodeLength/2] + "-" + d.UserCode[userCodeLength/2:]
+}
+
+// IsExpired checks whether the device code can no longer be used.
+func (d *DeviceCode) IsExpired() bool {
+	return time.Now().After(d.ExpiresAt)
+}
+
+// IsPending checks whether the device code is still waiting for the user.
+func (d *DeviceCode) IsPending() bool {
+	return d.ApprovedAt == nil && d.DeniedAt == nil
+}
+
+// Approve grants the device access on behalf of the user.
+func (d *DeviceCode) Approve(ctx context.Context, database *tigris.Database, user *User) error {
+	now := time.Now().UTC()
+	d.ApprovedAt = &now
+	d.UserID = user.ID
+
+	update, err := fields.UpdateBuilder().Set("approved_at", d.ApprovedAt).Set("user_id", d.UserID).Build()
+	if err != nil {
+		return err
+	}
+	_, err = tigris.GetCollection[DeviceCode](database).Update(ctx, filter.Eq("id", d.ID), update)
+	return err
+}
+
+// Deny rejects the device authorization.
+func (d *DeviceCode) Deny(ctx context.Context, database *tigris.Database) error {
+	now := time.Now().UTC()
+	d.DeniedAt = &now
+
+	_, err := tigris.GetCollection[DeviceCode](database).Update(ctx, filter.Eq("id", d.ID), fields.Set("denied_at", d.DeniedAt))
+	return err
+}
+
+// Poll records a poll by the device, returning false if it polled faster than
+// its interval. Polling too fast increases the interval by 5 seconds as
+// required by RFC 8628.
+func (d *DeviceCode) Poll(ctx context.Context, database *tigris.Database) (bool, error) {
+	now := time.Now().UTC()
+	tooFast := d.LastPolledAt != nil && now.Before(d.LastPolledAt.Add(time.Second*time.Duration(d.Interval)))
+	if tooFast {
+		d.Interval += 5
+	}
+	d.LastPolledAt = &now
+
+	update, err := fields.UpdateBuilder().Set("last_polled_at", d.LastPolledAt).Set("interval", d.Interval).Build()
+	if err != nil {
+		return false, err
+	}
+	if _, err = tigris.GetCollection[DeviceCode](database).Update(ctx, filter.Eq("id", d.ID), update); err != nil {
+		return false, err
+	}
+	return !tooFast, nil
+}
+
+// Delete removes the device code so it cannot be used again.
+func (d *DeviceCode) Delete(ctx context.Context, database *tigris.Database) error {
+	_, err := tigris.GetCollection[DeviceCode](database).Delete(ctx, filter.Eq("id", d.ID))
+	return err
+}
+
+// FindDeviceCodeByDeviceCode finds the device authorization a device polls for.
+func FindDeviceCodeByDeviceCode(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, deviceCode string) (*DeviceCode, error) {
+	return findDeviceCode(ctx, database, filter.And(filter.EqUUID("instance_id", instanceID), filter.Eq("device_code", deviceCode)))
+}
+
+// FindDeviceCodeByUserCode finds the device authorization for a user code as
+// typed by the user, ignoring case and separators.
+func FindDeviceCodeByUserCode(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, userCode string) (*DeviceCode, error) {
+	return findDeviceCode(ctx, database, filter.And(filter.EqUUID("instance_id", instanceID), filter.Eq("user_code", normalizeUserCode(userCode))))
+}
+
+func findDeviceCode(ctx context.Context, database *tigris.Database, f filter.Filter) (*DeviceCode, error) {
+	code, err := tigris.GetCollection[DeviceCode](database).ReadOne(ctx, f)
+	if err != nil {
+		if IsNotFoundError(err) {
+			return nil, DeviceCodeNotFoundError{}
+		}
+		return nil, err
+	}
+	if code == nil {
+		return nil, DeviceCodeNotFoundError{}
+	}
+	return code, nil
+}
+
+func generateUserCode() (string, error) {
+	var b strings.Builder
+	max := big.NewInt(int64(len(userCodeCharset)))
+	for i := 0; i < userCodeLength; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(userCodeCharset[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+func normalizeUserCode(userCode string) string {
+	userCode = strings.ToUpper(userCode)
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(userCodeCharset, r) {
+			return r
+		}
+		return -1
+	}, userCode)
+}
@@ -11,6 +11,24 @@ func IsNotFoundError(err error) bool {
 		return true
 	case InstanceNotFoundError:
 		return true
+	case DeviceCodeNotFoundError:
+		return true
+	case OAuthStateNotFoundError:
+		return true
+	case IdentityNotFoundError:
+		return true
+	case ProviderTokenNotFoundError:
+		return true
+	case SSOProviderNotFoundError:
+		return true
+	case SCIMTokenNotFoundError:
+		return true
+	case SCIMGroupNotFoundError:
+		return true
+	case LoginFailureNotFoundError:
+		return true
+	case KnownDeviceNotFoundError:
+		return true
 	}
 
 	return err.Error() == "document not found"
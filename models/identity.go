@@ -0,0 +1,153 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/tigrisdata/gotrue/storage/namespace"
+	"github.com/tigrisdata/tigris-client-go/fields"
+	"github.com/tigrisdata/tigris-client-go/filter"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+// Identity is an account at an external provider a user signs in with. A user
+// has at most one identity per provider.
+type Identity struct {
+	ID         uuid.UUID `json:"id" db:"id" tigris:"primaryKey"`
+	InstanceID uuid.UUID `json:"instance_id" db:"instance_id" tigris:"index"`
+	UserID     uuid.UUID `json:"user_id" db:"user_id" tigris:"index"`
+
+	Provider string `json:"provider" db:"provider" tigris:"index"`
+	// ProviderID is the ID of the user at the provider, e.g. the OIDC subject
+	ProviderID string `json:"provider_id" db:"provider_id" tigris:"index"`
+	Email      string `json:"email" db:"email"`
+	// Claims are the user attributes the provider returned on the last sign in
+	Claims JSONMap `json:"claims,omitempty" db:"claims"`
+
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+	LastSignInAt *time.Time `json:"last_sign_in_at,omitempty" db:"last_sign_in_at"`
+}
+
+func (Identity) TableName() string {
+	tableName := "identities"
+
+	if namespace.GetNamespace() != "" {
+		return namespace.GetNamespace() + "_" + tableName
+	}
+
+	return tableName
+}
+
+// IdentityNotFoundError represents when an identity is not found.
+type IdentityNotFoundError struct{}
+
+func (e IdentityNotFoundError) Error() string {
+	return "Identity not found"
+}
+
+// NewIdentity links the account at a provider to the user.
+func NewIdentity(ctx context.Context, database *tigris.Database, user *User, provider, providerID, email string, claims map[string]interface{}) (*Identity, error) {
+	now := time.Now().UTC()
+	identity := &Identity{
+		ID:           uuid.New(),
+		InstanceID:   user.InstanceID,
+		UserID:       user.ID,
+		Provider:     provider,
+		ProviderID:   providerID,
+		Email:        email,
+		Claims:       claims,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		LastSignInAt: &now,
+	}
+
+	if _, err := tigris.GetCollection[Identity](database).Insert(ctx, identity); err != nil {
+		return nil, errors.Wrap(err, "error creating identity")
+	}
+	return identity, nil
+}
+
+// UpdateSignIn records a sign in with the identity, keeping the email and claims
+// the provider returned.
+func (i *Identity) UpdateSignIn(ctx context.Context, database *tigris.Database, email string, claims map[string]interface{}) error {
+	now := time.Now().UTC()
+	i.Email = email
+	i.Claims = claims
+	i.UpdatedAt = now
+	i.LastSignInAt = &now
+
+	update, err := fields.UpdateBuilder().
+		Set("email", i.Email).
+		Set("claims", i.Claims).
+		Set("updated_at", i.UpdatedAt).
+		Set("last_sign_in_at", i.LastSignInAt).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = tigris.GetCollection[Identity](database).Update(ctx, filter.Eq("id", i.ID), update)
+	return err
+}
+
+// Delete unlinks the identity from its user, along with the provider token
+// stored for it.
+func (i *Identity) Delete(ctx context.Context, database *tigris.Database) error {
+	if _, err := tigris.GetCollection[ProviderToken](database).Delete(ctx, filter.Eq("id", i.ID)); err != nil {
+		return err
+	}
+	_, err := tigris.GetCollection[Identity](database).Delete(ctx, filter.Eq("id", i.ID))
+	return err
+}
+
+// FindIdentityByProviderID finds the identity of a user at a provider.
+func FindIdentityByProviderID(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, provider, providerID string) (*Identity, error) {
+	return findIdentity(ctx, database, filter.And(filter.EqUUID("instance_id", instanceID), filter.Eq("provider", provider), filter.Eq("provider_id", providerID)))
+}
+
+// FindIdentityByUserAndProvider finds the identity a user linked for a provider.
+func FindIdentityByUserAndProvider(ctx context.Context, database *tigris.Database, user *User, provider string) (*Identity, error) {
+	return findIdentity(ctx, database, filter.And(filter.EqUUID("user_id", user.ID), filter.Eq("provider", provider)))
+}
+
+// FindIdentitiesByUser lists all identities linked to a user.
+func FindIdentitiesByUser(ctx context.Context, database *tigris.Database, user *User) ([]*Identity, error) {
+	it, err := tigris.GetCollection[Identity](database).Read(ctx, filter.EqUUID("user_id", user.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	identities := []*Identity{}
+	var identity Identity
+	for it.Next(&identity) {
+		i := identity
+		identities = append(identities, &i)
+	}
+	return identities, it.Err()
+}
+
+// DeleteIdentitiesByUser unlinks all identities of a deleted user.
+func DeleteIdentitiesByUser(ctx context.Context, database *tigris.Database, user *User) error {
+	if _, err := tigris.GetCollection[ProviderToken](database).Delete(ctx, filter.EqUUID("user_id", user.ID)); err != nil {
+		return err
+	}
+	_, err := tigris.GetCollection[Identity](database).Delete(ctx, filter.EqUUID("user_id", user.ID))
+	return err
+}
+
+func findIdentity(ctx context.Context, database *tigris.Database, f filter.Filter) (*Identity, error) {
+	identity, err := tigris.GetCollection[Identity](database).ReadOne(ctx, f)
+	if err != nil {
+		if IsNotFoundError(err) {
+			return nil, IdentityNotFoundError{}
+		}
+		return nil, err
+	}
+	if identity == nil {
+		return nil, IdentityNotFoundError{}
+	}
+	return identity, nil
+}
@@ -93,6 +93,66 @@ func DeleteInstance(ctx context.Context, database *tigris.Database, instance *In
 			return errors.Wrap(err, "Error deleting refresh token record")
 		}
 
+		_, err = tigris.GetCollection[RevokedToken](database).Delete(ctx, filter.Eq("instance_id", instance.ID))
+		if err != nil {
+			return errors.Wrap(err, "Error deleting revoked token record")
+		}
+
+		_, err = tigris.GetCollection[DeviceCode](database).Delete(ctx, filter.Eq("instance_id", instance.ID))
+		if err != nil {
+			return errors.Wrap(err, "Error deleting device code record")
+		}
+
+		_, err = tigris.GetCollection[OAuthState](database).Delete(ctx, filter.Eq("instance_id", instance.ID))
+		if err != nil {
+			return errors.Wrap(err, "Error deleting oauth state record")
+		}
+
+		_, err = tigris.GetCollection[Identity](database).Delete(ctx, filter.Eq("instance_id", instance.ID))
+		if err != nil {
+			return errors.Wrap(err, "Error deleting identity record")
+		}
+
+		_, err = tigris.GetCollection[ProviderToken](database).Delete(ctx, filter.Eq("instance_id", instance.ID))
+		if err != nil {
+			return errors.Wrap(err, "Error deleting provider token record")
+		}
+
+		_, err = tigris.GetCollection[SSOProvider](database).Delete(ctx, filter.Eq("instance_id", instance.ID))
+		if err != nil {
+			return errors.Wrap(err, "Error deleting SSO provider record")
+		}
+
+		_, err = tigris.GetCollection[SAMLAssertion](database).Delete(ctx, filter.Eq("instance_id", instance.ID))
+		if err != nil {
+			return errors.Wrap(err, "Error deleting SAML assertion record")
+		}
+
+		_, err = tigris.GetCollection[SCIMToken](database).Delete(ctx, filter.Eq("instance_id", instance.ID))
+		if err != nil {
+			return errors.Wrap(err, "Error deleting SCIM token record")
+		}
+
+		_, err = tigris.GetCollection[SCIMGroup](database).Delete(ctx, filter.Eq("instance_id", instance.ID))
+		if err != nil {
+			return errors.Wrap(err, "Error deleting SCIM group record")
+		}
+
+		_, err = tigris.GetCollection[LoginFailure](database).Delete(ctx, filter.Eq("instance_id", instance.ID))
+		if err != nil {
+			return errors.Wrap(err, "Error deleting login failure record")
+		}
+
+		_, err = tigris.GetCollection[PasswordHistoryEntry](database).Delete(ctx, filter.Eq("instance_id", instance.ID))
+		if err != nil {
+			return errors.Wrap(err, "Error deleting password history record")
+		}
+
+		_, err = tigris.GetCollection[KnownDevice](database).Delete(ctx, filter.Eq("instance_id", instance.ID))
+		if err != nil {
+			return errors.Wrap(err, "Error deleting known device record")
+		}
+
 		_, err = tigris.GetCollection[Instance](database).Delete(ctx, filter.Eq("id", instance.ID))
 		if err != nil {
 			return errors.Wrap(err, "Error deleting instance record")
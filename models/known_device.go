@@ -0,0 +1,175 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/tigrisdata/gotrue/crypto"
+	"github.com/tigrisdata/gotrue/storage/namespace"
+	"github.com/tigrisdata/tigris-client-go/fields"
+	"github.com/tigrisdata/tigris-client-go/filter"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+// KnownDevice is a device a user signed in from before, told apart by the
+// prefix of its IP address and its user agent.
+type KnownDevice struct {
+	ID         uuid.UUID `json:"id" db:"id" tigris:"primaryKey"`
+	InstanceID uuid.UUID `json:"instance_id" db:"instance_id" tigris:"index"`
+	UserID     uuid.UUID `json:"user_id" db:"user_id" tigris:"index"`
+
+	// Fingerprint is the hash of the IP prefix and user agent
+	Fingerprint string `json:"fingerprint" db:"fingerprint" tigris:"index"`
+	IPPrefix    string `json:"ip_prefix" db:"ip_prefix"`
+	UserAgent   string `json:"user_agent" db:"user_agent"`
+
+	// RejectTokenHash is the hash of the token sent in the notification of
+	// the first sign in, to reject the device if the user did not sign in
+	RejectTokenHash   string    `json:"reject_token_hash" db:"reject_token_hash" tigris:"index"`
+	RejectTokenSentAt time.Time `json:"reject_token_sent_at" db:"reject_token_sent_at"`
+
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at" db:"last_seen_at"`
+}
+
+func (KnownDevice) TableName() string {
+	tableName := "known_devices"
+
+	if namespace.GetNamespace() != "" {
+		return namespace.GetNamespace() + "_" + tableName
+	}
+
+	return tableName
+}
+
+// rejectTokenExpiry is how long the notification of a new device can reject it
+const rejectTokenExpiry = 24 * time.Hour
+
+// KnownDeviceNotFoundError represents when a device is not known.
+type KnownDeviceNotFoundError struct{}
+
+func (e KnownDeviceNotFoundError) Error() string {
+	return "Device not found"
+}
+
+// FindKnownDevice finds the device of a user with the given fingerprint.
+func FindKnownDevice(ctx context.Context, database *tigris.Database, user *User, fingerprint string) (*KnownDevice, error) {
+	return findKnownDevice(ctx, database, filter.And(filter.EqUUID("user_id", user.ID), filter.Eq("fingerprint", fingerprint)))
+}
+
+// FindKnownDeviceByRejectToken finds the device a notification was sent for.
+func FindKnownDeviceByRejectToken(ctx context.Context, database *tigris.Database, token string) (*KnownDevice, error) {
+	return findKnownDevice(ctx, database, filter.Eq("reject_token_hash", hashRejectToken(token)))
+}
+
+func hashRejectToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func findKnownDevice(ctx context.Context, database *tigris.Database, f filter.Filter) (*KnownDevice, error) {
+	device, err := tigris.GetCollection[KnownDevice](database).ReadOne(ctx, f)
+	if err != nil {
+		if IsNotFoundError(err) {
+			return nil, KnownDeviceNotFoundError{}
+		}
+		return nil, err
+	}
+	if device == nil {
+		return nil, KnownDeviceNotFoundError{}
+	}
+	return device, nil
+}
+
+// HasKnownDevices reports whether any device of the user is known.
+func HasKnownDevices(ctx context.Context, database *tigris.Database, user *User) (bool, error) {
+	it, err := tigris.GetCollection[KnownDevice](database).Read(ctx, filter.EqUUID("user_id", user.ID))
+	if err != nil {
+		return false, err
+	}
+	defer it.Close()
+
+	var device KnownDevice
+	found := it.Next(&device)
+	return found, it.Err()
+}
+
+// NewKnownDevice remembers a device the user signed in from.
+func NewKnownDevice(ctx context.Context, database *tigris.Database, user *User, fingerprint, ipPrefix, userAgent string) (*KnownDevice, error) {
+	now := time.Now().UTC()
+	device := &KnownDevice{
+		ID:          uuid.New(),
+		InstanceID:  user.InstanceID,
+		UserID:      user.ID,
+		Fingerprint: fingerprint,
+		IPPrefix:    ipPrefix,
+		UserAgent:   userAgent,
+		CreatedAt:   now,
+		LastSeenAt:  now,
+	}
+	if _, err := tigris.GetCollection[KnownDevice](database).Insert(ctx, device); err != nil {
+		return nil, errors.Wrap(err, "error saving known device")
+	}
+	return device, nil
+}
+
+// NewRejectToken creates the token the notification of the device is sent
+// with. Only its hash is stored, the token itself is only returned here.
+func (d *KnownDevice) NewRejectToken(ctx context.Context, database *tigris.Database) (string, error) {
+	token := crypto.SecureToken()
+	d.RejectTokenHash = hashRejectToken(token)
+	d.RejectTokenSentAt = time.Now().UTC()
+	update, err := fields.UpdateBuilder().
+		Set("reject_token_hash", d.RejectTokenHash).
+		Set("reject_token_sent_at", d.RejectTokenSentAt).
+		Build()
+	if err != nil {
+		return "", err
+	}
+	if _, err := tigris.GetCollection[KnownDevice](database).Update(ctx, filter.EqUUID("id", d.ID), update); err != nil {
+		return "", errors.Wrap(err, "error saving reject token")
+	}
+	return token, nil
+}
+
+// RejectTokenExpired reports whether the device can no longer be rejected
+// with the token of its notification.
+func (d *KnownDevice) RejectTokenExpired() bool {
+	return time.Since(d.RejectTokenSentAt) > rejectTokenExpiry
+}
+
+// Seen records another sign in from the device. Once the reject token has
+// expired, the device is confirmed and the token is cleared. Sign ins before
+// keep it, so that whoever signed in cannot take the user's link away.
+func (d *KnownDevice) Seen(ctx context.Context, database *tigris.Database) error {
+	d.LastSeenAt = time.Now().UTC()
+	builder := fields.UpdateBuilder().Set("last_seen_at", d.LastSeenAt)
+	if d.RejectTokenHash != "" && d.RejectTokenExpired() {
+		d.RejectTokenHash = ""
+		builder = builder.Set("reject_token_hash", d.RejectTokenHash)
+	}
+	update, err := builder.Build()
+	if err != nil {
+		return err
+	}
+	_, err = tigris.GetCollection[KnownDevice](database).Update(ctx, filter.EqUUID("id", d.ID), update)
+	return err
+}
+
+// Delete forgets the device, so that the next sign in from it is notified
+// again.
+func (d *KnownDevice) Delete(ctx context.Context, database *tigris.Database) error {
+	_, err := tigris.GetCollection[KnownDevice](database).Delete(ctx, filter.EqUUID("id", d.ID))
+	return err
+}
+
+// DeleteKnownDevices forgets all devices of the user, when the user is
+// removed.
+func DeleteKnownDevices(ctx context.Context, database *tigris.Database, user *User) error {
+	_, err := tigris.GetCollection[KnownDevice](database).Delete(ctx, filter.EqUUID("user_id", user.ID))
+	return err
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/tigrisdata/gotrue/storage/namespace"
+	"github.com/tigrisdata/tigris-client-go/filter"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+const (
+	// LoginFailureEmailKind counts the failed sign ins for an email
+	LoginFailureEmailKind = "email"
+	// LoginFailureIPKind counts the failed sign ins from an IP address
+	LoginFailureIPKind = "ip"
+)
+
+// LoginFailure counts the consecutive failed password sign ins for an email
+// or from an IP address of an instance.
+type LoginFailure struct {
+	ID         uuid.UUID `json:"id" db:"id" tigris:"primaryKey"`
+	InstanceID uuid.UUID `json:"instance_id" db:"instance_id" tigris:"index"`
+
+	Kind  string `json:"kind" db:"kind"`
+	Value string `json:"value" db:"value"`
+	// Key is the kind and value, which the failures are looked up by
+	Key string `json:"key" db:"key" tigris:"index"`
+
+	Count        int        `json:"failed_attempts" db:"count"`
+	LastFailedAt time.Time  `json:"last_failed_at" db:"last_failed_at"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty" db:"locked_until"`
+}
+
+func (LoginFailure) TableName() string {
+	tableName := "login_failures"
+
+	if namespace.GetNamespace() != "" {
+		return namespace.GetNamespace() + "_" + tableName
+	}
+
+	return tableName
+}
+
+// LoginFailureNotFoundError represents when no failures are recorded.
+type LoginFailureNotFoundError struct{}
+
+func (e LoginFailureNotFoundError) Error() string {
+	return "Login failure not found"
+}
+
+func loginFailureKey(kind, value string) string {
+	return kind + ":" + value
+}
+
+// IsLocked reports whether sign ins are locked now.
+func (f *LoginFailure) IsLocked() bool {
+	return f.LockedUntil != nil && time.Now().Before(*f.LockedUntil)
+}
+
+// RetryAt is when the next sign in may be attempted. The wait after the first
+// failure is delay and doubles with every further one, up to the lock duration.
+func (f *LoginFailure) RetryAt(delay, duration time.Duration) time.Time {
+	if f.IsLocked() {
+		return *f.LockedUntil
+	}
+	wait := delay
+	for i := 1; i < f.Count && wait < duration; i++ {
+		wait *= 2
+	}
+	if wait > duration {
+		wait = duration
+	}
+	return f.LastFailedAt.Add(wait)
+}
+
+// FindLoginFailure finds the failures recorded for an email or IP address.
+func FindLoginFailure(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, kind, value string) (*LoginFailure, error) {
+	return findLoginFailure(ctx, database, filter.And(filter.EqUUID("instance_id", instanceID), filter.Eq("key", loginFailureKey(kind, value))))
+}
+
+// FindLoginFailureByID finds failures of the instance by their ID.
+func FindLoginFailureByID(ctx context.Context, database *tigris.Database, instanceID, id uuid.UUID) (*LoginFailure, error) {
+	return findLoginFailure(ctx, database, filter.And(filter.EqUUID("instance_id", instanceID), filter.EqUUID("id", id)))
+}
+
+func findLoginFailure(ctx context.Context, database *tigris.Database, f filter.Filter) (*LoginFailure, error) {
+	failure, err := tigris.GetCollection[LoginFailure](database).ReadOne(ctx, f)
+	if err != nil {
+		if IsNotFoundError(err) {
+			return nil, LoginFailureNotFoundError{}
+		}
+		return nil, err
+	}
+	if failure == nil {
+		return nil, LoginFailureNotFoundError{}
+	}
+	return failure, nil
+}
+
+// FindLockedLoginFailures lists the emails and IP addresses of the instance
+// that are locked now.
+func FindLockedLoginFailures(ctx context.Context, database *tigris.Database, instanceID uuid.UUID) ([]*LoginFailure, error) {
+	it, err := tigris.GetCollection[LoginFailure](database).Read(ctx, filter.EqUUID("instance_id", instanceID))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	failures := []*LoginFailure{}
+	var failure LoginFailure
+	for it.Next(&failure) {
+		f := failure
+		if f.IsLocked() {
+			failures = append(failures, &f)
+		}
+	}
+	return failures, it.Err()
+}
+
+// RecordLoginFailure counts a failed sign in for an email or IP address and
+// locks it for duration once maxAttempts consecutive failures are reached.
+// Failures older than duration are forgotten. It reports whether the failure
+// locked the email or IP address.
+func RecordLoginFailure(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, kind, value string, maxAttempts int, duration time.Duration) (*LoginFailure, bool, error) {
+	now := time.Now().UTC()
+	failure, err := FindLoginFailure(ctx, database, instanceID, kind, value)
+	if err != nil {
+		if !IsNotFoundError(err) {
+			return nil, false, err
+		}
+		failure = &LoginFailure{
+			ID:         uuid.New(),
+			InstanceID: instanceID,
+			Kind:       kind,
+			Value:      value,
+			Key:        loginFailureKey(kind, value),
+		}
+	}
+
+	wasLocked := failure.IsLocked()
+	if !wasLocked && now.Sub(failure.LastFailedAt) > duration {
+		failure.Count = 0
+		failure.LockedUntil = nil
+	}
+	failure.Count++
+	failure.LastFailedAt = now
+
+	locked := false
+	if !wasLocked && failure.Count >= maxAttempts {
+		lockedUntil := now.Add(duration)
+		failure.LockedUntil = &lockedUntil
+		locked = true
+	}
+
+	if _, err := tigris.GetCollection[LoginFailure](database).InsertOrReplace(ctx, failure); err != nil {
+		return nil, false, errors.Wrap(err, "error recording login failure")
+	}
+	return failure, locked, nil
+}
+
+// ClearLoginFailure forgets the failures of an email or IP address, which
+// also unlocks it.
+func ClearLoginFailure(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, kind, value string) error {
+	_, err := tigris.GetCollection[LoginFailure](database).Delete(ctx, filter.And(filter.EqUUID("instance_id", instanceID), filter.Eq("key", loginFailureKey(kind, value))))
+	return err
+}
+
+// Delete forgets the failures, which also unlocks the email or IP address.
+func (f *LoginFailure) Delete(ctx context.Context, database *tigris.Database) error {
+	_, err := tigris.GetCollection[LoginFailure](database).Delete(ctx, filter.EqUUID("id", f.ID))
+	return err
+}
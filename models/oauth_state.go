@@ -0,0 +1,101 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/tigrisdata/gotrue/crypto"
+	"github.com/tigrisdata/gotrue/storage/namespace"
+	"github.com/tigrisdata/tigris-client-go/filter"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+// OAuthState keeps the secrets of an external provider redirect on the server,
+// so they never travel in the state parameter. It is deleted on the callback,
+// which makes every state single-use.
+type OAuthState struct {
+	ID         uuid.UUID `json:"id" db:"id" tigris:"primaryKey"`
+	InstanceID uuid.UUID `json:"instance_id" db:"instance_id" tigris:"index"`
+
+	// CodeVerifier is the PKCE verifier (RFC 7636) of the authorization request
+	CodeVerifier string `json:"code_verifier" db:"code_verifier"`
+	// Nonce is the OpenID Connect nonce ID tokens must carry
+	Nonce string `json:"nonce" db:"nonce"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at" tigris:"index"`
+}
+
+func (OAuthState) TableName() string {
+	tableName := "oauth_states"
+
+	if namespace.GetNamespace() != "" {
+		return namespace.GetNamespace() + "_" + tableName
+	}
+
+	return tableName
+}
+
+// OAuthStateNotFoundError represents when a state is unknown, expired or already used.
+type OAuthStateNotFoundError struct{}
+
+func (e OAuthStateNotFoundError) Error() string {
+	return "OAuth state not found"
+}
+
+// NewOAuthState stores a new state with fresh PKCE verifier and nonce.
+func NewOAuthState(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, ttl time.Duration) (*OAuthState, error) {
+	now := time.Now().UTC()
+	state := &OAuthState{
+		ID:         uuid.New(),
+		InstanceID: instanceID,
+		// verifiers must be at least 43 characters long
+		CodeVerifier: crypto.SecureToken() + crypto.SecureToken(),
+		Nonce:        crypto.SecureToken(),
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(ttl),
+	}
+
+	c := tigris.GetCollection[OAuthState](database)
+	if _, err := c.Insert(ctx, state); err != nil {
+		return nil, errors.Wrap(err, "error creating oauth state")
+	}
+
+	_, err := c.Delete(ctx, filter.Lt("expires_at", now))
+	return state, errors.Wrap(err, "Database error purging expired oauth states")
+}
+
+// ConsumeOAuthState loads a state and deletes it, so it cannot be used again.
+func ConsumeOAuthState(ctx context.Context, database *tigris.Database, id uuid.UUID) (*OAuthState, error) {
+	var state *OAuthState
+	// reading and deleting in one transaction lets only one of two concurrent
+	// callbacks with the same state succeed
+	err := database.Tx(ctx, func(ctx context.Context) error {
+		c := tigris.GetCollection[OAuthState](database)
+
+		var terr error
+		state, terr = c.ReadOne(ctx, filter.Eq("id", id))
+		if terr != nil {
+			if IsNotFoundError(terr) {
+				return OAuthStateNotFoundError{}
+			}
+			return terr
+		}
+		if state == nil {
+			return OAuthStateNotFoundError{}
+		}
+
+		_, terr = c.Delete(ctx, filter.Eq("id", id))
+		return terr
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(state.ExpiresAt) {
+		return nil, OAuthStateNotFoundError{}
+	}
+	return state, nil
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/tigrisdata/gotrue/storage/namespace"
+	"github.com/tigrisdata/tigris-client-go/filter"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHistoryEntry is a bcrypt hash of a password a user set, kept to
+// refuse passwords they used recently.
+type PasswordHistoryEntry struct {
+	ID         uuid.UUID `json:"id" db:"id" tigris:"primaryKey"`
+	InstanceID uuid.UUID `json:"instance_id" db:"instance_id" tigris:"index"`
+	UserID     uuid.UUID `json:"user_id" db:"user_id" tigris:"index"`
+
+	Hash      string    `json:"hash" db:"hash"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+func (PasswordHistoryEntry) TableName() string {
+	tableName := "password_history"
+
+	if namespace.GetNamespace() != "" {
+		return namespace.GetNamespace() + "_" + tableName
+	}
+
+	return tableName
+}
+
+// findPasswordHistory returns the password history of a user, newest first.
+func findPasswordHistory(ctx context.Context, database *tigris.Database, user *User) ([]*PasswordHistoryEntry, error) {
+	it, err := tigris.GetCollection[PasswordHistoryEntry](database).Read(ctx, filter.EqUUID("user_id", user.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	entries := []*PasswordHistoryEntry{}
+	var entry PasswordHistoryEntry
+	for it.Next(&entry) {
+		e := entry
+		entries = append(entries, &e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.After(entries[j].CreatedAt)
+	})
+	return entries, it.Err()
+}
+
+// AddPasswordHistory records a password the user set and forgets all but the
+// last keep passwords.
+func AddPasswordHistory(ctx context.Context, database *tigris.Database, user *User, password string, keep int) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.Wrap(err, "error hashing password")
+	}
+
+	entry := &PasswordHistoryEntry{
+		ID:         uuid.New(),
+		InstanceID: user.InstanceID,
+		UserID:     user.ID,
+		Hash:       string(hash),
+		CreatedAt:  time.Now().UTC(),
+	}
+	if _, err := tigris.GetCollection[PasswordHistoryEntry](database).Insert(ctx, entry); err != nil {
+		return errors.Wrap(err, "error recording password history")
+	}
+
+	entries, err := findPasswordHistory(ctx, database, user)
+	if err != nil {
+		return errors.Wrap(err, "error reading password history")
+	}
+	for i, e := range entries {
+		if i < keep || e.ID == entry.ID {
+			continue
+		}
+		if _, err := tigris.GetCollection[PasswordHistoryEntry](database).Delete(ctx, filter.EqUUID("id", e.ID)); err != nil {
+			return errors.Wrap(err, "error pruning password history")
+		}
+	}
+	return nil
+}
+
+// PasswordUsedBefore reports whether the password is one of the last n
+// passwords the user set.
+func PasswordUsedBefore(ctx context.Context, database *tigris.Database, user *User, password string, n int) (bool, error) {
+	entries, err := findPasswordHistory(ctx, database, user)
+	if err != nil {
+		return false, err
+	}
+	for i, e := range entries {
+		if i >= n {
+			break
+		}
+		if bcrypt.CompareHashAndPassword([]byte(e.Hash), []byte(password)) == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DeletePasswordHistory forgets the password history of a user.
+func DeletePasswordHistory(ctx context.Context, database *tigris.Database, user *User) error {
+	_, err := tigris.GetCollection[PasswordHistoryEntry](database).Delete(ctx, filter.EqUUID("user_id", user.ID))
+	return err
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/tigrisdata/gotrue/crypto"
+	"github.com/tigrisdata/gotrue/storage/namespace"
+	"github.com/tigrisdata/tigris-client-go/filter"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+	"golang.org/x/oauth2"
+)
+
+// ProviderToken is the token an external provider issued on the last sign in
+// with an identity, kept to call the provider on behalf of the user. The access
+// and refresh tokens are stored encrypted.
+type ProviderToken struct {
+	// ID is the ID of the identity the token was issued for
+	ID         uuid.UUID `json:"id" db:"id" tigris:"primaryKey"`
+	InstanceID uuid.UUID `json:"instance_id" db:"instance_id" tigris:"index"`
+	UserID     uuid.UUID `json:"user_id" db:"user_id" tigris:"index"`
+	Provider   string    `json:"provider" db:"provider"`
+
+	AccessToken    string     `json:"access_token" db:"access_token"`
+	AccessTokenIV  string     `json:"access_token_iv" db:"access_token_iv"`
+	RefreshToken   string     `json:"refresh_token,omitempty" db:"refresh_token"`
+	RefreshTokenIV string     `json:"refresh_token_iv,omitempty" db:"refresh_token_iv"`
+	TokenType      string     `json:"token_type" db:"token_type"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+func (ProviderToken) TableName() string {
+	tableName := "provider_tokens"
+
+	if namespace.GetNamespace() != "" {
+		return namespace.GetNamespace() + "_" + tableName
+	}
+
+	return tableName
+}
+
+// ProviderTokenNotFoundError represents when a provider token is not found.
+type ProviderTokenNotFoundError struct{}
+
+func (e ProviderTokenNotFoundError) Error() string {
+	return "Provider token not found"
+}
+
+// SaveProviderToken stores the token issued for the identity, replacing the
+// previous one. Providers that do not rotate refresh tokens omit them when
+// refreshing, in which case the stored refresh token is kept.
+func SaveProviderToken(ctx context.Context, database *tigris.Database, encrypter *crypto.AESBlockEncrypter, identity *Identity, tok *oauth2.Token) (*ProviderToken, error) {
+	now := time.Now().UTC()
+	token := &ProviderToken{
+		ID:         identity.ID,
+		InstanceID: identity.InstanceID,
+		UserID:     identity.UserID,
+		Provider:   identity.Provider,
+		TokenType:  tok.Type(),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	previous, err := FindProviderTokenByIdentity(ctx, database, identity)
+	if err == nil {
+		token.CreatedAt = previous.CreatedAt
+	} else if !IsNotFoundError(err) {
+		return nil, err
+	}
+
+	token.AccessToken, token.AccessTokenIV = encrypter.Encrypt(tok.AccessToken)
+	if tok.RefreshToken != "" {
+		token.RefreshToken, token.RefreshTokenIV = encrypter.Encrypt(tok.RefreshToken)
+	} else if previous != nil {
+		token.RefreshToken, token.RefreshTokenIV = previous.RefreshToken, previous.RefreshTokenIV
+	}
+	if !tok.Expiry.IsZero() {
+		expiresAt := tok.Expiry.UTC()
+		token.ExpiresAt = &expiresAt
+	}
+
+	if _, err := tigris.GetCollection[ProviderToken](database).InsertOrReplace(ctx, token); err != nil {
+		return nil, errors.Wrap(err, "error saving provider token")
+	}
+	return token, nil
+}
+
+// OAuth2Token decrypts the stored token.
+func (t *ProviderToken) OAuth2Token(encrypter *crypto.AESBlockEncrypter) *oauth2.Token {
+	tok := &oauth2.Token{
+		AccessToken: encrypter.Decrypt(t.AccessToken, t.AccessTokenIV),
+		TokenType:   t.TokenType,
+	}
+	if t.RefreshToken != "" {
+		tok.RefreshToken = encrypter.Decrypt(t.RefreshToken, t.RefreshTokenIV)
+	}
+	if t.ExpiresAt != nil {
+		tok.Expiry = *t.ExpiresAt
+	}
+	return tok
+}
+
+// FindProviderTokenByIdentity finds the token stored for an identity.
+func FindProviderTokenByIdentity(ctx context.Context, database *tigris.Database, identity *Identity) (*ProviderToken, error) {
+	token, err := tigris.GetCollection[ProviderToken](database).ReadOne(ctx, filter.EqUUID("id", identity.ID))
+	if err != nil {
+		if IsNotFoundError(err) {
+			return nil, ProviderTokenNotFoundError{}
+		}
+		return nil, err
+	}
+	if token == nil {
+		return nil, ProviderTokenNotFoundError{}
+	}
+	return token, nil
+}
@@ -23,6 +23,9 @@ type RefreshToken struct {
 
 	UserID uuid.UUID `json:"user_id" db:"user_id" tigris:"index"`
 
+	// SessionID stays the same across refresh token swaps of a single sign in.
+	SessionID uuid.UUID `json:"session_id" db:"session_id" tigris:"index"`
+
 	Revoked   bool      `json:"revoked" db:"revoked"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
@@ -40,7 +43,7 @@ func (RefreshToken) TableName() string {
 
 // GrantAuthenticatedUser creates a refresh token for the provided user.
 func GrantAuthenticatedUser(ctx context.Context, database *tigris.Database, user *User) (*RefreshToken, error) {
-	return createRefreshToken(ctx, database, user)
+	return createRefreshToken(ctx, database, user, uuid.New())
 }
 
 // GrantRefreshTokenSwap swaps a refresh token for a new one, revoking the provided token.
@@ -56,7 +59,12 @@ func GrantRefreshTokenSwap(ctx context.Context, database *tigris.Database, user
 		if _, terr = tigris.GetCollection[RefreshToken](database).Update(ctx, filter.Eq("id", token.ID), fields.Set("revoked", token.Revoked)); terr != nil {
 			return terr
 		}
-		newToken, terr = createRefreshToken(ctx, database, user)
+		sessionID := token.SessionID
+		if sessionID == uuid.Nil {
+			// refresh tokens issued before sessions were tracked start a new session
+			sessionID = uuid.New()
+		}
+		newToken, terr = createRefreshToken(ctx, database, user, sessionID)
 		return terr
 	})
 	return newToken, err
@@ -68,10 +76,37 @@ func Logout(ctx context.Context, database *tigris.Database, instanceID uuid.UUID
 	return err
 }
 
-func createRefreshToken(ctx context.Context, database *tigris.Database, user *User) (*RefreshToken, error) {
+// FindUserSessionIDs lists the sessions a user is signed in with.
+func FindUserSessionIDs(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, userID uuid.UUID) ([]uuid.UUID, error) {
+	it, err := tigris.GetCollection[RefreshToken](database).Read(ctx, filter.And(filter.Eq("instance_id", instanceID), filter.Eq("user_id", userID)))
+	if err != nil {
+		return nil, errors.Wrap(err, "error finding refresh tokens")
+	}
+	defer it.Close()
+
+	seen := map[uuid.UUID]bool{}
+	var sessionIDs []uuid.UUID
+	var token RefreshToken
+	for it.Next(&token) {
+		if token.SessionID != uuid.Nil && !seen[token.SessionID] {
+			seen[token.SessionID] = true
+			sessionIDs = append(sessionIDs, token.SessionID)
+		}
+	}
+	return sessionIDs, it.Err()
+}
+
+// RevokeSession deletes the refresh tokens of a single session of a user.
+func RevokeSession(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, userID uuid.UUID, sessionID uuid.UUID) error {
+	_, err := tigris.GetCollection[RefreshToken](database).Delete(ctx, filter.And(filter.Eq("instance_id", instanceID), filter.Eq("user_id", userID), filter.Eq("session_id", sessionID)))
+	return err
+}
+
+func createRefreshToken(ctx context.Context, database *tigris.Database, user *User, sessionID uuid.UUID) (*RefreshToken, error) {
 	token := &RefreshToken{
 		InstanceID: user.InstanceID,
 		UserID:     user.ID,
+		SessionID:  sessionID,
 		Token:      crypto.SecureToken(),
 		ID:         uuid.New(),
 	}
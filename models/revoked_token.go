@@ -0,0 +1,140 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/tigrisdata/gotrue/storage/namespace"
+	"github.com/tigrisdata/tigris-client-go/filter"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+const (
+	revokedJTIPrefix     = "jti:"
+	revokedSessionPrefix = "session:"
+	revokedUserPrefix    = "user:"
+)
+
+// RevokedToken is an entry of the access token revocation list. An entry
+// revokes a single access token by its JWT ID, every access token of a session,
+// or every access token of a user that was issued up to RevokedAt.
+type RevokedToken struct {
+	Key        string    `json:"key" db:"key" tigris:"primaryKey"`
+	InstanceID uuid.UUID `json:"instance_id" db:"instance_id" tigris:"index"`
+	RevokedAt  time.Time `json:"revoked_at" db:"revoked_at"`
+	ExpiresAt  time.Time `json:"expires_at" db:"expires_at" tigris:"index"`
+}
+
+func (RevokedToken) TableName() string {
+	tableName := "revoked_tokens"
+
+	if namespace.GetNamespace() != "" {
+		return namespace.GetNamespace() + "_" + tableName
+	}
+
+	return tableName
+}
+
+// RevokeAccessToken revokes a single access token until it expires.
+func RevokeAccessToken(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, jti string, ttl time.Duration) error {
+	if jti == "" {
+		return nil
+	}
+	return revoke(ctx, database, instanceID, revokedJTIPrefix+jti, ttl)
+}
+
+// RevokeSessionAccessTokens revokes all access tokens issued for a session. The
+// session must have ended, as it covers tokens issued later too.
+func RevokeSessionAccessTokens(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, sessionID uuid.UUID, ttl time.Duration) error {
+	if sessionID == uuid.Nil {
+		return nil
+	}
+	return revoke(ctx, database, instanceID, revokedSessionPrefix+sessionID.String(), ttl)
+}
+
+// RevokeUserAccessTokens revokes all access tokens issued to a user so far. It
+// has to be called before the sessions of the user end, as the tokens of those
+// sessions are revoked by session: issued at has second precision, which does
+// not tell tokens issued right before the revocation from ones of sessions
+// started right after.
+func RevokeUserAccessTokens(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, userID uuid.UUID, ttl time.Duration) error {
+	sessionIDs, err := FindUserSessionIDs(ctx, database, instanceID, userID)
+	if err != nil {
+		return err
+	}
+	for _, sessionID := range sessionIDs {
+		if err := RevokeSessionAccessTokens(ctx, database, instanceID, sessionID, ttl); err != nil {
+			return err
+		}
+	}
+	return revoke(ctx, database, instanceID, revokedUserPrefix+userID.String(), ttl)
+}
+
+func revoke(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, key string, ttl time.Duration) error {
+	now := time.Now().UTC()
+	entry := &RevokedToken{
+		Key:        key,
+		InstanceID: instanceID,
+		RevokedAt:  now,
+		// entries are only needed while any token they cover can still be valid
+		ExpiresAt: now.Add(ttl),
+	}
+
+	c := tigris.GetCollection[RevokedToken](database)
+	if _, err := c.InsertOrReplace(ctx, entry); err != nil {
+		return errors.Wrap(err, "Database error revoking access token")
+	}
+
+	_, err := c.Delete(ctx, filter.Lt("expires_at", now))
+	return errors.Wrap(err, "Database error purging expired revocations")
+}
+
+// IsAccessTokenRevoked checks the revocation list for the JWT ID, session and
+// user of an access token issued at issuedAt.
+func IsAccessTokenRevoked(ctx context.Context, database *tigris.Database, jti string, sessionID string, userID string, issuedAt time.Time) (bool, error) {
+	var keys []filter.Expr
+	if jti != "" {
+		keys = append(keys, filter.Eq("key", revokedJTIPrefix+jti))
+	}
+	if sessionID != "" {
+		keys = append(keys, filter.Eq("key", revokedSessionPrefix+sessionID))
+	}
+	if userID != "" {
+		keys = append(keys, filter.Eq("key", revokedUserPrefix+userID))
+	}
+	if len(keys) == 0 {
+		return false, nil
+	}
+
+	f := keys[0]
+	if len(keys) > 1 {
+		f = filter.Or(keys...)
+	}
+
+	it, err := tigris.GetCollection[RevokedToken](database).Read(ctx, f)
+	if err != nil {
+		return false, errors.Wrap(err, "reading revoked tokens failed")
+	}
+	defer it.Close()
+
+	now := time.Now()
+	var entry RevokedToken
+	for it.Next(&entry) {
+		if entry.ExpiresAt.Before(now) {
+			continue
+		}
+		if entry.Key == revokedJTIPrefix+jti || entry.Key == revokedSessionPrefix+sessionID {
+			return true, nil
+		}
+		// issued at has second precision. Tokens of sessions issued within the
+		// second of the revocation are revoked by their session if they were
+		// issued before it, while tokens without a session are treated as revoked.
+		revokedAt := entry.RevokedAt.Truncate(time.Second)
+		if issuedAt.Before(revokedAt) || (sessionID == "" && issuedAt.Equal(revokedAt)) {
+			return true, nil
+		}
+	}
+	return false, it.Err()
+}
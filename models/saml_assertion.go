@@ -0,0 +1,84 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/tigrisdata/gotrue/storage/namespace"
+	"github.com/tigrisdata/tigris-client-go/filter"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+// SAMLAssertion remembers a consumed SAML assertion until it expires, so a
+// captured SAML response cannot be posted a second time.
+type SAMLAssertion struct {
+	ID         uuid.UUID `json:"id" db:"id" tigris:"primaryKey"`
+	InstanceID uuid.UUID `json:"instance_id" db:"instance_id" tigris:"index"`
+
+	// AssertionID is the ID the identity provider gave the assertion
+	AssertionID string `json:"assertion_id" db:"assertion_id"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at" tigris:"index"`
+}
+
+func (SAMLAssertion) TableName() string {
+	tableName := "saml_assertions"
+
+	if namespace.GetNamespace() != "" {
+		return namespace.GetNamespace() + "_" + tableName
+	}
+
+	return tableName
+}
+
+// SAMLAssertionReplayedError represents when an assertion was already consumed.
+type SAMLAssertionReplayedError struct{}
+
+func (e SAMLAssertionReplayedError) Error() string {
+	return "SAML assertion was already used"
+}
+
+// IsSAMLAssertionReplayedError checks if the error is a SAMLAssertionReplayedError.
+func IsSAMLAssertionReplayedError(err error) bool {
+	_, ok := errors.Cause(err).(SAMLAssertionReplayedError)
+	return ok
+}
+
+// ConsumeSAMLAssertion records an assertion as consumed. It fails with a
+// SAMLAssertionReplayedError if the assertion was consumed before.
+func ConsumeSAMLAssertion(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, assertionID string, expiresAt time.Time) error {
+	now := time.Now().UTC()
+	assertion := &SAMLAssertion{
+		// the ID is derived from the assertion, so it is only stored once
+		ID:          uuid.NewSHA1(instanceID, []byte(assertionID)),
+		InstanceID:  instanceID,
+		AssertionID: assertionID,
+		CreatedAt:   now,
+		ExpiresAt:   expiresAt,
+	}
+
+	// reading and inserting in one transaction lets only one of two concurrent
+	// callbacks with the same assertion succeed
+	return database.Tx(ctx, func(ctx context.Context) error {
+		c := tigris.GetCollection[SAMLAssertion](database)
+
+		existing, terr := c.ReadOne(ctx, filter.Eq("id", assertion.ID))
+		if terr != nil && !IsNotFoundError(terr) {
+			return terr
+		}
+		if existing != nil && now.Before(existing.ExpiresAt) {
+			return SAMLAssertionReplayedError{}
+		}
+
+		if _, terr = c.Delete(ctx, filter.Lt("expires_at", now)); terr != nil {
+			return errors.Wrap(terr, "Database error purging expired SAML assertions")
+		}
+		if _, terr = c.InsertOrReplace(ctx, assertion); terr != nil {
+			return errors.Wrap(terr, "error recording SAML assertion")
+		}
+		return nil
+	})
+}
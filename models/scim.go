@@ -0,0 +1,226 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/tigrisdata/gotrue/crypto"
+	"github.com/tigrisdata/gotrue/storage/namespace"
+	"github.com/tigrisdata/tigris-client-go/fields"
+	"github.com/tigrisdata/tigris-client-go/filter"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+// SCIMToken is a bearer token an identity provider provisions users of an
+// instance with. Only a hash of the token is stored.
+type SCIMToken struct {
+	ID         uuid.UUID `json:"id" db:"id" tigris:"primaryKey"`
+	InstanceID uuid.UUID `json:"instance_id" db:"instance_id" tigris:"index"`
+
+	Name      string `json:"name" db:"name"`
+	TokenHash string `json:"token_hash" db:"token_hash" tigris:"index"`
+
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+}
+
+func (SCIMToken) TableName() string {
+	tableName := "scim_tokens"
+
+	if namespace.GetNamespace() != "" {
+		return namespace.GetNamespace() + "_" + tableName
+	}
+
+	return tableName
+}
+
+// SCIMTokenNotFoundError represents when a SCIM token is not found.
+type SCIMTokenNotFoundError struct{}
+
+func (e SCIMTokenNotFoundError) Error() string {
+	return "SCIM token not found"
+}
+
+func hashSCIMToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSCIMToken creates a token for the instance. The token itself is only
+// returned here.
+func NewSCIMToken(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, name string) (*SCIMToken, string, error) {
+	token := crypto.SecureToken() + crypto.SecureToken()
+	t := &SCIMToken{
+		ID:         uuid.New(),
+		InstanceID: instanceID,
+		Name:       name,
+		TokenHash:  hashSCIMToken(token),
+		CreatedAt:  time.Now().UTC(),
+	}
+	if _, err := tigris.GetCollection[SCIMToken](database).Insert(ctx, t); err != nil {
+		return nil, "", errors.Wrap(err, "error creating SCIM token")
+	}
+	return t, token, nil
+}
+
+// FindSCIMToken finds the token of the instance.
+func FindSCIMToken(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, token string) (*SCIMToken, error) {
+	return findSCIMToken(ctx, database, filter.And(filter.EqUUID("instance_id", instanceID), filter.Eq("token_hash", hashSCIMToken(token))))
+}
+
+// FindSCIMTokenByID finds a token of the instance by its ID.
+func FindSCIMTokenByID(ctx context.Context, database *tigris.Database, instanceID, id uuid.UUID) (*SCIMToken, error) {
+	return findSCIMToken(ctx, database, filter.And(filter.EqUUID("instance_id", instanceID), filter.EqUUID("id", id)))
+}
+
+func findSCIMToken(ctx context.Context, database *tigris.Database, f filter.Filter) (*SCIMToken, error) {
+	t, err := tigris.GetCollection[SCIMToken](database).ReadOne(ctx, f)
+	if err != nil {
+		if IsNotFoundError(err) {
+			return nil, SCIMTokenNotFoundError{}
+		}
+		return nil, err
+	}
+	if t == nil {
+		return nil, SCIMTokenNotFoundError{}
+	}
+	return t, nil
+}
+
+// FindSCIMTokensByInstance lists all tokens of the instance.
+func FindSCIMTokensByInstance(ctx context.Context, database *tigris.Database, instanceID uuid.UUID) ([]*SCIMToken, error) {
+	it, err := tigris.GetCollection[SCIMToken](database).Read(ctx, filter.EqUUID("instance_id", instanceID))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	tokens := []*SCIMToken{}
+	var token SCIMToken
+	for it.Next(&token) {
+		t := token
+		tokens = append(tokens, &t)
+	}
+	return tokens, it.Err()
+}
+
+// Touch records that the token was used.
+func (t *SCIMToken) Touch(ctx context.Context, database *tigris.Database) error {
+	now := time.Now().UTC()
+	t.LastUsedAt = &now
+	_, err := tigris.GetCollection[SCIMToken](database).Update(ctx, filter.EqUUID("id", t.ID), fields.Set("last_used_at", t.LastUsedAt))
+	return err
+}
+
+// Delete removes the token.
+func (t *SCIMToken) Delete(ctx context.Context, database *tigris.Database) error {
+	_, err := tigris.GetCollection[SCIMToken](database).Delete(ctx, filter.EqUUID("id", t.ID))
+	return err
+}
+
+// SCIMGroup is a group provisioned through SCIM. Its display name is the role
+// its members hold in their app metadata.
+type SCIMGroup struct {
+	ID         uuid.UUID `json:"id" db:"id" tigris:"primaryKey"`
+	InstanceID uuid.UUID `json:"instance_id" db:"instance_id" tigris:"index"`
+
+	DisplayName string `json:"display_name" db:"display_name"`
+	ExternalID  string `json:"external_id,omitempty" db:"external_id"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+func (SCIMGroup) TableName() string {
+	tableName := "scim_groups"
+
+	if namespace.GetNamespace() != "" {
+		return namespace.GetNamespace() + "_" + tableName
+	}
+
+	return tableName
+}
+
+// SCIMGroupNotFoundError represents when a SCIM group is not found.
+type SCIMGroupNotFoundError struct{}
+
+func (e SCIMGroupNotFoundError) Error() string {
+	return "SCIM group not found"
+}
+
+// NewSCIMGroup creates a group for the instance.
+func NewSCIMGroup(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, displayName, externalID string) (*SCIMGroup, error) {
+	now := time.Now().UTC()
+	g := &SCIMGroup{
+		ID:          uuid.New(),
+		InstanceID:  instanceID,
+		DisplayName: strings.TrimSpace(displayName),
+		ExternalID:  externalID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if _, err := tigris.GetCollection[SCIMGroup](database).Insert(ctx, g); err != nil {
+		return nil, errors.Wrap(err, "error creating SCIM group")
+	}
+	return g, nil
+}
+
+// Update saves the display name and external ID of the group.
+func (g *SCIMGroup) Update(ctx context.Context, database *tigris.Database) error {
+	g.DisplayName = strings.TrimSpace(g.DisplayName)
+	g.UpdatedAt = time.Now().UTC()
+
+	update, err := fields.UpdateBuilder().
+		Set("display_name", g.DisplayName).
+		Set("external_id", g.ExternalID).
+		Set("updated_at", g.UpdatedAt).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = tigris.GetCollection[SCIMGroup](database).Update(ctx, filter.EqUUID("id", g.ID), update)
+	return err
+}
+
+// Delete removes the group.
+func (g *SCIMGroup) Delete(ctx context.Context, database *tigris.Database) error {
+	_, err := tigris.GetCollection[SCIMGroup](database).Delete(ctx, filter.EqUUID("id", g.ID))
+	return err
+}
+
+// FindSCIMGroupByID finds a group of the instance.
+func FindSCIMGroupByID(ctx context.Context, database *tigris.Database, instanceID, id uuid.UUID) (*SCIMGroup, error) {
+	g, err := tigris.GetCollection[SCIMGroup](database).ReadOne(ctx, filter.And(filter.EqUUID("instance_id", instanceID), filter.EqUUID("id", id)))
+	if err != nil {
+		if IsNotFoundError(err) {
+			return nil, SCIMGroupNotFoundError{}
+		}
+		return nil, err
+	}
+	if g == nil {
+		return nil, SCIMGroupNotFoundError{}
+	}
+	return g, nil
+}
+
+// FindSCIMGroupsByInstance lists all groups of the instance.
+func FindSCIMGroupsByInstance(ctx context.Context, database *tigris.Database, instanceID uuid.UUID) ([]*SCIMGroup, error) {
+	it, err := tigris.GetCollection[SCIMGroup](database).Read(ctx, filter.EqUUID("instance_id", instanceID))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	groups := []*SCIMGroup{}
+	var group SCIMGroup
+	for it.Next(&group) {
+		g := group
+		groups = append(groups, &g)
+	}
+	return groups, it.Err()
+}
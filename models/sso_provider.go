@@ -0,0 +1,173 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/tigrisdata/gotrue/conf"
+	"github.com/tigrisdata/gotrue/storage/namespace"
+	"github.com/tigrisdata/tigris-client-go/fields"
+	"github.com/tigrisdata/tigris-client-go/filter"
+	"github.com/tigrisdata/tigris-client-go/tigris"
+)
+
+// SSOProvider is a SAML identity provider of an instance. Users sign in with
+// the provider bound to the domain of their email.
+type SSOProvider struct {
+	ID         uuid.UUID `json:"id" db:"id" tigris:"primaryKey"`
+	InstanceID uuid.UUID `json:"instance_id" db:"instance_id" tigris:"index"`
+
+	Name        string   `json:"name" db:"name"`
+	MetadataURL string   `json:"metadata_url" db:"metadata_url"`
+	Domains     []string `json:"domains" db:"domains"`
+
+	// MetadataXML pins the metadata of the identity provider instead of
+	// fetching it from MetadataURL
+	MetadataXML         string `json:"metadata_xml,omitempty" db:"metadata_xml"`
+	MetadataSigningCert string `json:"metadata_signing_cert,omitempty" db:"metadata_signing_cert"`
+
+	AttributeMapping  conf.SamlAttributeMapping `json:"attribute_mapping" db:"attribute_mapping"`
+	AllowIdPInitiated bool                      `json:"allow_idp_initiated" db:"allow_idp_initiated"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+func (SSOProvider) TableName() string {
+	tableName := "sso_providers"
+
+	if namespace.GetNamespace() != "" {
+		return namespace.GetNamespace() + "_" + tableName
+	}
+
+	return tableName
+}
+
+// SSOProviderNotFoundError represents when an SSO provider is not found.
+type SSOProviderNotFoundError struct{}
+
+func (e SSOProviderNotFoundError) Error() string {
+	return "SSO provider not found"
+}
+
+// NewSSOProvider creates a SAML identity provider for the instance from the
+// settings of the given provider.
+func NewSSOProvider(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, settings SSOProvider) (*SSOProvider, error) {
+	now := time.Now().UTC()
+	provider := &settings
+	provider.ID = uuid.New()
+	provider.InstanceID = instanceID
+	provider.Domains = NormalizeDomains(settings.Domains)
+	provider.CreatedAt = now
+	provider.UpdatedAt = now
+
+	if _, err := tigris.GetCollection[SSOProvider](database).Insert(ctx, provider); err != nil {
+		return nil, errors.Wrap(err, "error creating SSO provider")
+	}
+	return provider, nil
+}
+
+// Update saves the name, metadata, domains and assertion handling of the
+// provider.
+func (p *SSOProvider) Update(ctx context.Context, database *tigris.Database) error {
+	p.Domains = NormalizeDomains(p.Domains)
+	p.UpdatedAt = time.Now().UTC()
+
+	update, err := fields.UpdateBuilder().
+		Set("name", p.Name).
+		Set("metadata_url", p.MetadataURL).
+		Set("metadata_xml", p.MetadataXML).
+		Set("metadata_signing_cert", p.MetadataSigningCert).
+		Set("domains", p.Domains).
+		Set("attribute_mapping", p.AttributeMapping).
+		Set("allow_idp_initiated", p.AllowIdPInitiated).
+		Set("updated_at", p.UpdatedAt).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = tigris.GetCollection[SSOProvider](database).Update(ctx, filter.Eq("id", p.ID), update)
+	return err
+}
+
+// Delete removes the provider. Users who signed in with it keep their accounts.
+func (p *SSOProvider) Delete(ctx context.Context, database *tigris.Database) error {
+	_, err := tigris.GetCollection[SSOProvider](database).Delete(ctx, filter.Eq("id", p.ID))
+	return err
+}
+
+// HasDomain reports whether the provider is bound to the domain.
+func (p *SSOProvider) HasDomain(domain string) bool {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	for _, d := range p.Domains {
+		if d == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// FindSSOProviderByID finds a provider of the instance.
+func FindSSOProviderByID(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, id uuid.UUID) (*SSOProvider, error) {
+	provider, err := tigris.GetCollection[SSOProvider](database).ReadOne(ctx, filter.And(filter.EqUUID("id", id), filter.EqUUID("instance_id", instanceID)))
+	if err != nil {
+		if IsNotFoundError(err) {
+			return nil, SSOProviderNotFoundError{}
+		}
+		return nil, err
+	}
+	if provider == nil {
+		return nil, SSOProviderNotFoundError{}
+	}
+	return provider, nil
+}
+
+// FindSSOProvidersByInstance lists all providers of the instance.
+func FindSSOProvidersByInstance(ctx context.Context, database *tigris.Database, instanceID uuid.UUID) ([]*SSOProvider, error) {
+	it, err := tigris.GetCollection[SSOProvider](database).Read(ctx, filter.EqUUID("instance_id", instanceID))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	providers := []*SSOProvider{}
+	var provider SSOProvider
+	for it.Next(&provider) {
+		p := provider
+		providers = append(providers, &p)
+	}
+	return providers, it.Err()
+}
+
+// FindSSOProviderByDomain finds the provider of the instance bound to the
+// domain. Instances have few providers, so they are matched in memory.
+func FindSSOProviderByDomain(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, domain string) (*SSOProvider, error) {
+	providers, err := FindSSOProvidersByInstance(ctx, database, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range providers {
+		if p.HasDomain(domain) {
+			return p, nil
+		}
+	}
+	return nil, SSOProviderNotFoundError{}
+}
+
+// NormalizeDomains lower cases the domains and drops blank and duplicate ones.
+func NormalizeDomains(domains []string) []string {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d == "" || seen[d] {
+			continue
+		}
+		seen[d] = true
+		normalized = append(normalized, d)
+	}
+	return normalized
+}
@@ -21,6 +21,9 @@ const SystemUserID = "0"
 
 var SystemUserUUID = uuid.Nil
 
+// BannedForever is the end of bans without an expiry
+var BannedForever = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
 const (
 	CredentialsKeyType = "credentials"
 	ApiKeyKeyType      = "api_key"
@@ -35,6 +38,7 @@ type UserAppMetadata struct {
 	Provider        string   `json:"provider,omitempty"`
 	Roles           []string `json:"roles,omitempty"`
 	KeyType         string   `json:"key_type,omitempty"`
+	ExternalID      string   `json:"external_id,omitempty"`
 	Custom          JSONMap  `json:"custom,omitempty"`
 }
 
@@ -47,6 +51,9 @@ type User struct {
 	Email             string    `json:"email" db:"email" tigris:"primaryKey:2"`
 	EncryptedPassword string    `json:"encrypted_password" db:"encrypted_password"`
 	EncryptionIV      string    `json:"encryption_iv" db:"encryption_iv"`
+	// PasswordChangedAt is when the password was last set, the creation of the
+	// user if never
+	PasswordChangedAt *time.Time `json:"password_changed_at,omitempty" db:"password_changed_at"`
 
 	ConfirmedAt *time.Time `json:"confirmed_at,omitempty" db:"confirmed_at"`
 	InvitedAt   *time.Time `json:"invited_at,omitempty" db:"invited_at"`
@@ -60,9 +67,21 @@ type User struct {
 	EmailChangeToken  string     `json:"email_change_token" db:"email_change_token"`
 	EmailChange       string     `json:"new_email,omitempty" db:"email_change"`
 	EmailChangeSentAt *time.Time `json:"email_change_sent_at,omitempty" db:"email_change_sent_at"`
+	// EmailChangeTokenCurrent is sent to the current address in secure email
+	// changes, which also have to be confirmed from there
+	EmailChangeTokenCurrent     string `json:"email_change_token_current" db:"email_change_token_current"`
+	EmailChangeConfirmedNew     bool   `json:"email_change_confirmed_new" db:"email_change_confirmed_new"`
+	EmailChangeConfirmedCurrent bool   `json:"email_change_confirmed_current" db:"email_change_confirmed_current"`
 
 	LastSignInAt *time.Time `json:"last_sign_in_at,omitempty" db:"last_sign_in_at"`
 
+	// DeactivatedAt is set while the identity provider of the user has them
+	// deactivated
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty" db:"deactivated_at"`
+
+	BannedUntil *time.Time `json:"banned_until,omitempty" db:"banned_until"`
+	BanReason   string     `json:"ban_reason,omitempty" db:"ban_reason"`
+
 	AppMetaData  *UserAppMetadata `json:"app_metadata" db:"app_metadata"`
 	UserMetaData JSONMap          `json:"user_metadata" db:"user_metadata"`
 
@@ -169,6 +188,15 @@ func (u *User) BeforeSave() error {
 	if u.LastSignInAt != nil && u.LastSignInAt.IsZero() {
 		u.LastSignInAt = nil
 	}
+	if u.DeactivatedAt != nil && u.DeactivatedAt.IsZero() {
+		u.DeactivatedAt = nil
+	}
+	if u.BannedUntil != nil && u.BannedUntil.IsZero() {
+		u.BannedUntil = nil
+	}
+	if u.PasswordChangedAt != nil && u.PasswordChangedAt.IsZero() {
+		u.PasswordChangedAt = nil
+	}
 	return nil
 }
 
@@ -178,6 +206,60 @@ func (u *User) IsConfirmed() bool {
 	return u.ConfirmedAt != nil
 }
 
+// IsDeactivated reports whether the user is deactivated and cannot sign in.
+func (u *User) IsDeactivated() bool {
+	return u.DeactivatedAt != nil
+}
+
+// SetDeactivated deactivates or reactivates the user.
+func (u *User) SetDeactivated(ctx context.Context, database *tigris.Database, deactivated bool) error {
+	if deactivated == u.IsDeactivated() {
+		return nil
+	}
+	if deactivated {
+		now := time.Now().UTC()
+		u.DeactivatedAt = &now
+	} else {
+		u.DeactivatedAt = nil
+	}
+
+	_, err := tigris.GetCollection[User](database).Update(ctx, filter.EqUUID("id", u.ID), fields.Set("deactivated_at", u.DeactivatedAt))
+	return err
+}
+
+// IsBanned reports whether the user is banned now.
+func (u *User) IsBanned() bool {
+	return u.BannedUntil != nil && time.Now().Before(*u.BannedUntil)
+}
+
+// Ban bans the user until the given time, BannedForever for bans without an
+// expiry.
+func (u *User) Ban(ctx context.Context, database *tigris.Database, until time.Time, reason string) error {
+	until = until.UTC()
+	u.BannedUntil = &until
+	u.BanReason = reason
+	return u.saveBan(ctx, database)
+}
+
+// Unban lifts the ban of the user.
+func (u *User) Unban(ctx context.Context, database *tigris.Database) error {
+	u.BannedUntil = nil
+	u.BanReason = ""
+	return u.saveBan(ctx, database)
+}
+
+func (u *User) saveBan(ctx context.Context, database *tigris.Database) error {
+	fieldsToSet, err := fields.UpdateBuilder().
+		Set("banned_until", u.BannedUntil).
+		Set("ban_reason", u.BanReason).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = tigris.GetCollection[User](database).Update(ctx, filter.EqUUID("id", u.ID), fieldsToSet)
+	return err
+}
+
 // SetRole sets the users Role to roleName
 func (u *User) SetRole(ctx context.Context, database *tigris.Database, roleName string) error {
 	u.Role = strings.TrimSpace(roleName)
@@ -257,6 +339,9 @@ func (u *User) PatchAppMetaData(ctx context.Context, database *tigris.Database,
 		if updates.Custom != nil {
 			u.AppMetaData.Custom = updates.Custom
 		}
+		if updates.ExternalID != "" {
+			u.AppMetaData.ExternalID = updates.ExternalID
+		}
 	}
 
 	_, err := tigris.GetCollection[User](database).Update(ctx, filter.Eq("id", u.ID.String()), fields.Set("app_metadata", u.AppMetaData))
@@ -273,11 +358,25 @@ func (u *User) UpdatePassword(ctx context.Context, database *tigris.Database, en
 	pw, iv := encrypter.Encrypt(password)
 	u.EncryptedPassword = pw
 	u.EncryptionIV = iv
+	now := time.Now().UTC()
+	u.PasswordChangedAt = &now
 
-	_, err := tigris.GetCollection[User](database).Update(ctx, filter.EqUUID("id", u.ID), fields.Set("encrypted_password", u.EncryptedPassword).Set("encryption_iv", u.EncryptionIV))
+	_, err := tigris.GetCollection[User](database).Update(ctx, filter.EqUUID("id", u.ID), fields.Set("encrypted_password", u.EncryptedPassword).Set("encryption_iv", u.EncryptionIV).Set("password_changed_at", u.PasswordChangedAt))
 	return err
 }
 
+// PasswordExpired reports whether the password was set longer than maxAge ago.
+func (u *User) PasswordExpired(maxAge time.Duration) bool {
+	if maxAge <= 0 {
+		return false
+	}
+	changedAt := u.PasswordChangedAt
+	if changedAt == nil {
+		changedAt = u.CreatedAt
+	}
+	return changedAt != nil && time.Since(*changedAt) > maxAge
+}
+
 // Authenticate a user from a password
 func (u *User) Authenticate(password string, encrypter *crypto.AESBlockEncrypter) bool {
 	ivBytes, err := base64.StdEncoding.DecodeString(u.EncryptionIV)
@@ -309,10 +408,42 @@ func (u *User) Confirm(ctx context.Context, database *tigris.Database) error {
 
 // ConfirmEmailChange confirm the change of email for a user
 func (u *User) ConfirmEmailChange(ctx context.Context, database *tigris.Database) error {
+	u.Email = u.EmailChange
+	u.EmailChange = ""
+	u.EmailChangeToken = ""
+	u.EmailChangeTokenCurrent = ""
+	u.EmailChangeConfirmedNew = false
+	u.EmailChangeConfirmedCurrent = false
+
 	fieldsToSet, err := fields.UpdateBuilder().
 		Set("email", u.Email).
 		Set("email_change", u.EmailChange).
 		Set("email_change_token", u.EmailChangeToken).
+		Set("email_change_token_current", u.EmailChangeTokenCurrent).
+		Set("email_change_confirmed_new", u.EmailChangeConfirmedNew).
+		Set("email_change_confirmed_current", u.EmailChangeConfirmedCurrent).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = tigris.GetCollection[User](database).Update(ctx, filter.EqUUID("id", u.ID), fieldsToSet)
+	return err
+}
+
+// EmailChangeConfirmed reports whether every address a token was sent to
+// confirmed the change of email.
+func (u *User) EmailChangeConfirmed() bool {
+	return u.EmailChangeConfirmedNew && (u.EmailChangeConfirmedCurrent || u.EmailChangeTokenCurrent == "")
+}
+
+// UpdateEmailChangeConfirmation saves which addresses confirmed a secure
+// change of email so far.
+func (u *User) UpdateEmailChangeConfirmation(ctx context.Context, database *tigris.Database) error {
+	fieldsToSet, err := fields.UpdateBuilder().
+		Set("email_change_token", u.EmailChangeToken).
+		Set("email_change_token_current", u.EmailChangeTokenCurrent).
+		Set("email_change_confirmed_new", u.EmailChangeConfirmedNew).
+		Set("email_change_confirmed_current", u.EmailChangeConfirmedCurrent).
 		Build()
 	if err != nil {
 		return err
@@ -490,6 +621,24 @@ func FindUsersInAudience(ctx context.Context, database *tigris.Database, instanc
 	return users, err
 }
 
+// FindUsersByInstanceAndAudience finds all users of the instance with the
+// matching audience.
+func FindUsersByInstanceAndAudience(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, aud string) ([]*User, error) {
+	it, err := tigris.GetCollection[User](database).Read(ctx, filter.And(filter.EqUUID("instance_id", instanceID), filter.Eq("aud", aud)))
+	if err != nil {
+		return nil, errors.Wrap(err, "reading user failed")
+	}
+	defer it.Close()
+
+	users := []*User{}
+	var user User
+	for it.Next(&user) {
+		u := user
+		users = append(users, &u)
+	}
+	return users, it.Err()
+}
+
 // IsDuplicatedEmail returns whether a user exists with a matching email and audience.
 func IsDuplicatedEmail(ctx context.Context, database *tigris.Database, instanceID uuid.UUID, email, aud string) (bool, error) {
 	_, err := FindUserByEmailAndAudience(ctx, database, instanceID, email, aud)
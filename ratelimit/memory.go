@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often ended windows are removed from memory stores
+const sweepInterval = time.Minute
+
+type memoryWindow struct {
+	count int
+	reset time.Time
+}
+
+// MemoryStore counts requests in process memory, so every process has its
+// own counts.
+type MemoryStore struct {
+	mu        sync.Mutex
+	windows   map[string]*memoryWindow
+	lastSweep time.Time
+}
+
+// NewMemoryStore returns an empty memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		windows:   map[string]*memoryWindow{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Increment implements Store.
+func (s *MemoryStore) Increment(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > sweepInterval {
+		for k, w := range s.windows {
+			if !now.Before(w.reset) {
+				delete(s.windows, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	w, ok := s.windows[key]
+	if !ok || !now.Before(w.reset) {
+		w = &memoryWindow{reset: now.Add(window)}
+		s.windows[key] = w
+	}
+	w.count++
+	return w.count, w.reset, nil
+}
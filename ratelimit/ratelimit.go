@@ -0,0 +1,35 @@
+// Package ratelimit counts requests in fixed time windows, in process memory
+// or in a Redis compatible server shared between processes.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	MemoryStoreType = "memory"
+	RedisStoreType  = "redis"
+)
+
+// Store counts requests per key.
+type Store interface {
+	// Increment counts a request for key in its current window of the given
+	// length, returning the requests counted in the window so far and when
+	// the window ends.
+	Increment(ctx context.Context, key string, window time.Duration) (int, time.Time, error)
+}
+
+// NewStore returns a store of the given type, memory if empty. The URL is the
+// redis:// or rediss:// URL of the server for redis stores.
+func NewStore(storeType, url string) (Store, error) {
+	switch storeType {
+	case "", MemoryStoreType:
+		return NewMemoryStore(), nil
+	case RedisStoreType:
+		return NewRedisStore(url)
+	default:
+		return nil, fmt.Errorf("unsupported rate limit store type: %s", storeType)
+	}
+}
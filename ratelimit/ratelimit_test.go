@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStore checks the counts of a store, calling wait to let time pass
+func testStore(t *testing.T, store Store, wait func(time.Duration)) {
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		count, reset, err := store.Increment(ctx, "a", 100*time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, i, count)
+		assert.WithinDuration(t, time.Now().Add(100*time.Millisecond), reset, 50*time.Millisecond)
+	}
+
+	// keys are counted apart
+	count, _, err := store.Increment(ctx, "b", 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// a new window starts once the last one ended
+	wait(150 * time.Millisecond)
+	count, _, err = store.Increment(ctx, "a", 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore(), time.Sleep)
+}
+
+func TestRedisStore(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	store, err := NewStore(RedisStoreType, "redis://"+server.Addr())
+	require.NoError(t, err)
+	// the server only expires keys when its clock is moved
+	testStore(t, store, server.FastForward)
+
+	for _, key := range server.Keys() {
+		assert.True(t, strings.HasPrefix(key, redisKeyPrefix), key)
+	}
+}
+
+func TestRedisStoreAuth(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireUserAuth("default", "secret")
+
+	store, err := NewRedisStore("redis://" + server.Addr())
+	require.NoError(t, err)
+	_, _, err = store.Increment(context.Background(), "a", time.Second)
+	assert.Error(t, err)
+
+	store, err = NewRedisStore("redis://default:secret@" + server.Addr() + "/1")
+	require.NoError(t, err)
+	count, _, err := store.Increment(context.Background(), "a", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, []string{redisKeyPrefix + "a"}, server.DB(1).Keys())
+}
+
+func TestNewStore(t *testing.T) {
+	store, err := NewStore("", "")
+	require.NoError(t, err)
+	assert.IsType(t, &MemoryStore{}, store)
+
+	_, err = NewStore("memcached", "")
+	assert.Error(t, err)
+
+	_, err = NewStore(RedisStoreType, "http://localhost")
+	assert.Error(t, err)
+}